@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/pbabilas/pinpoint/internal"
+	"github.com/sirupsen/logrus"
+)
+
+// app tworzy zależności poleceń dopiero wtedy, gdy są potrzebne -
+// np. list i show nie wymagają połączenia z Vault ani konfiguracji SMTP.
+// Każda zależność dostaje wspólny executor, który w trybie --dry-run zamienia operacje zapisu w plan.
+type app struct {
+	logger     *logrus.Logger
+	certDBPath string
+	// policies to polityki odnawiania grup i serwerów z pliku konfiguracji
+	policies internal.PolicyConfig
+	// mounts to nazwane montowania PKI z pliku konfiguracji (sekcja pki.mounts)
+	mounts   map[string]internal.PKIMount
+	executor *internal.Executor
+
+	certDB   *internal.CertificateDB
+	mailer   *internal.Mailer
+	notifier internal.Notifier
+	vault    *internal.VaultClient
+}
+
+func newApp(logger *logrus.Logger, certDBPath string, policies internal.PolicyConfig, mounts map[string]internal.PKIMount, dryRun bool) *app {
+	return &app{logger: logger, certDBPath: certDBPath, policies: policies, mounts: mounts, executor: internal.NewExecutor(dryRun, logger)}
+}
+
+// writePlan wypisuje na stderr operacje pominięte w trybie --dry-run (stdout pozostaje dla raportów)
+func (a *app) writePlan() {
+	if !a.executor.DryRun() {
+		return
+	}
+	if err := internal.WritePlan(os.Stderr, a.executor.Steps()); err != nil {
+		a.logger.Warnf("Błąd podczas wypisywania planu: %v", err)
+	}
+}
+
+// finish zapisuje metryki uruchomienia polecenia (METRICS_TEXTFILE) i zwraca jego wynik bez zmian.
+// Błąd zapisu metryk jest tylko logowany - nie zmienia kodu wyjścia polecenia.
+func (a *app) finish(command string, err error) error {
+	metricsConfig := internal.LoadMetricsConfigFromEnv()
+	if metricsConfig.TextfilePath == "" {
+		return err
+	}
+
+	// Daty wygaśnięcia pochodzą z bazy; bez niej zostają wartości z poprzedniego pliku
+	certDB, _ := a.database()
+	run := internal.MetricsRun{Command: command, Success: err == nil, Finished: time.Now()}
+	if metricsErr := internal.WriteMetricsTextfile(metricsConfig.TextfilePath, certDB, run, a.executor); metricsErr != nil {
+		a.logger.Warnf("Błąd podczas zapisywania metryk: %v", metricsErr)
+	}
+	return err
+}
+
+// finishCACheck zapisuje metryki sprawdzenia CA wykonanego w tle przez serve i zwraca jego wynik bez zmian.
+// Rejestr metryk serve jest sumowany od startu procesu, więc - inaczej niż finish - nie dodaje liczników do pliku.
+func (a *app) finishCACheck(err error) error {
+	metricsConfig := internal.LoadMetricsConfigFromEnv()
+	if metricsConfig.TextfilePath == "" {
+		return err
+	}
+
+	certDB, _ := a.database()
+	run := internal.MetricsRun{Command: "check", Success: err == nil, Finished: time.Now()}
+	if metricsErr := internal.WriteCACheckMetricsTextfile(metricsConfig.TextfilePath, certDB, run, a.executor); metricsErr != nil {
+		a.logger.Warnf("Błąd podczas zapisywania metryk: %v", metricsErr)
+	}
+	return err
+}
+
+// database wczytuje bazę danych certyfikatów
+func (a *app) database() (*internal.CertificateDB, error) {
+	if a.certDB != nil {
+		return a.certDB, nil
+	}
+	certDB, err := internal.LoadCertificateDB(a.certDBPath, a.logger)
+	if err != nil {
+		return nil, fmt.Errorf("błąd podczas wczytywania bazy danych certyfikatów: %w", err)
+	}
+	certDB.SetExecutor(a.executor)
+	a.certDB = certDB
+	return certDB, nil
+}
+
+// mailerAndNotifier tworzy mailer i kanały powiadomień; konfiguracja SMTP jest walidowana od razu
+func (a *app) mailerAndNotifier() (*internal.Mailer, internal.Notifier, error) {
+	if a.mailer != nil {
+		return a.mailer, a.notifier, nil
+	}
+
+	mailerConfig, err := internal.LoadMailerConfigFromEnv()
+	if err != nil {
+		return nil, nil, configError("błąd konfiguracji SMTP", err)
+	}
+	templatesFS, err := fs.Sub(config, "templates")
+	if err != nil {
+		return nil, nil, fmt.Errorf("błąd podczas odczytu wbudowanych szablonów: %w", err)
+	}
+	templates, err := internal.NewTemplateStore(templatesFS, mailerConfig.TemplatesDir, mailerConfig.DefaultLocale, mailerConfig.Subject)
+	if err != nil {
+		return nil, nil, configError("błąd podczas wczytywania szablonów email", err)
+	}
+	mailer, err := internal.NewMailer(mailerConfig, templates, a.logger)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Kanały powiadomień administracyjnych (email, webhook, Slack, Teams)
+	notifier, err := internal.LoadNotifiersFromEnv(mailer, a.logger)
+	if err != nil {
+		return nil, nil, configError("błąd konfiguracji powiadomień", err)
+	}
+	mailer.SetExecutor(a.executor)
+	notifier.SetExecutor(a.executor)
+
+	a.mailer = mailer
+	a.notifier = notifier
+	return mailer, notifier, nil
+}
+
+// vaultClient loguje się do Vault przez AppRole
+func (a *app) vaultClient() (*internal.VaultClient, error) {
+	if a.vault != nil {
+		return a.vault, nil
+	}
+	vaultConfig, err := internal.LoadVaultConfigFromEnv(a.mounts)
+	if err != nil {
+		return nil, err
+	}
+	vaultClient, err := internal.NewVaultClient(vaultConfig, a.logger)
+	if err != nil {
+		return nil, fmt.Errorf("błąd podczas tworzenia klienta Vault: %w", err)
+	}
+	a.logger.Infof("Połączono z Vault: %s", vaultConfig.Address)
+	vaultClient.SetExecutor(a.executor)
+	a.vault = vaultClient
+	return vaultClient, nil
+}
+
+// service tworzy serwis certyfikatów ze wszystkimi zależnościami
+func (a *app) service(outputDir string) (*internal.CertService, error) {
+	if outputDir != "" {
+		info, err := os.Stat(outputDir)
+		if err != nil {
+			return nil, configError("nieprawidłowy katalog wyjściowy", err)
+		}
+		if !info.IsDir() {
+			return nil, configError(fmt.Sprintf("katalog wyjściowy '%s' nie jest katalogiem", outputDir), nil)
+		}
+	}
+
+	mailer, notifier, err := a.mailerAndNotifier()
+	if err != nil {
+		return nil, err
+	}
+	vaultClient, err := a.vaultClient()
+	if err != nil {
+		return nil, err
+	}
+	certDB, err := a.database()
+	if err != nil {
+		return nil, err
+	}
+
+	ovpnTemplate, err := config.ReadFile("user.ovpn.template")
+	if err != nil {
+		return nil, fmt.Errorf("błąd podczas odczytu pliku szablonu: %w", err)
+	}
+
+	serviceConfig, err := internal.LoadServiceConfigFromEnv(a.policies)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.policies.CheckMounts(a.mounts); err != nil {
+		return nil, err
+	}
+	if serviceConfig.Mikrotik, err = internal.LoadMikrotikConfigFromEnv(); err != nil {
+		return nil, err
+	}
+	serviceConfig.OvpnTemplate = string(ovpnTemplate)
+	serviceConfig.OutputDir = outputDir
+
+	service := internal.NewCertService(certDB, vaultClient, mailer, notifier, serviceConfig, a.logger)
+	service.SetExecutor(a.executor)
+	return service, nil
+}
+
+// mikrotik łączy się z routerem przy użyciu MIKROTIK_USERNAME i MIKROTIK_PASSWORD
+func (a *app) mikrotik(ip string) (*internal.MikrotikIntegration, error) {
+	mikrotikConfig, err := internal.LoadMikrotikConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if !mikrotikConfig.HasCredentials() {
+		return nil, configError("brak danych dostępowych Mikrotika (MIKROTIK_USERNAME, MIKROTIK_PASSWORD)", nil)
+	}
+	mikrotik, err := internal.NewMikrotikIntegration(ip, mikrotikConfig, a.logger)
+	if err != nil {
+		return nil, err
+	}
+	mikrotik.SetExecutor(a.executor)
+	return mikrotik, nil
+}
+
+// serveBackend dostarcza zależności żądaniom API polecenia serve. Baza jest wczytywana przy każdym żądaniu,
+// aby uwzględnić zmiany wprowadzone przez polecenia CLI (np. renew-all z crona), a operacje zapisu logują się
+// do Vault od nowa, bo token AppRole wygasa. Serwer API wykonuje żądania po kolei, więc stan app nie jest współdzielony.
+type serveBackend struct {
+	app       *app
+	outputDir string
+}
+
+func (b serveBackend) Database() (*internal.CertificateDB, error) {
+	b.app.certDB = nil
+	return b.app.database()
+}
+
+func (b serveBackend) Service() (*internal.CertService, error) {
+	b.app.certDB = nil
+	b.app.vault = nil
+	return b.app.service(b.outputDir)
+}
+
+func (b serveBackend) Mailer() (*internal.Mailer, error) {
+	mailer, _, err := b.app.mailerAndNotifier()
+	return mailer, err
+}
+
+func (b serveBackend) Mikrotik(ip string) (*internal.MikrotikIntegration, error) {
+	return b.app.mikrotik(ip)
+}
@@ -0,0 +1,809 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pbabilas/pinpoint/internal"
+)
+
+// clientIssue obsługuje polecenie client issue
+func (a *app) clientIssue(outputDir string, req internal.ClientRequest) error {
+	mailer, _, err := a.mailerAndNotifier()
+	if err != nil {
+		return err
+	}
+	if req.Locale != "" && !mailer.HasLocale(req.Locale) {
+		return fmt.Errorf("%w: brak szablonów email dla języka %q", errUsage, req.Locale)
+	}
+
+	service, err := a.service(outputDir)
+	if err != nil {
+		return err
+	}
+	_, err = service.IssueClient(req)
+	return err
+}
+
+// clientResend obsługuje polecenie client resend
+func (a *app) clientResend(outputDir, commonName, email, locale string) error {
+	service, err := a.service(outputDir)
+	if err != nil {
+		return err
+	}
+	return service.ResendClient(commonName, email, locale)
+}
+
+// serverDeploy obsługuje polecenie server deploy
+func (a *app) serverDeploy(req internal.ServerRequest) error {
+	service, err := a.service("")
+	if err != nil {
+		return err
+	}
+	_, err = service.DeployServer(req)
+	return err
+}
+
+// renewAll obsługuje polecenie renew-all
+func (a *app) renewAll(outputDir string) error {
+	service, err := a.service(outputDir)
+	if err != nil {
+		return err
+	}
+	summary, err := service.RenewAll()
+	if err != nil {
+		return err
+	}
+	if summary.Failed > 0 {
+		return fmt.Errorf("nie udało się odnowić %d certyfikatów: %v", summary.Failed, summary.FailedUsers)
+	}
+	return nil
+}
+
+// clientConnected obsługuje polecenie client connected wywoływane przez skrypt client-connect serwera OpenVPN.
+// Nie łączy się z Vault - odwołanie poprzedniego certyfikatu wykonuje revoke-pending.
+// Zawsze kończy się sukcesem, a błędy tylko loguje: niezerowy kod skryptu client-connect odrzuca połączenie VPN.
+func (a *app) clientConnected(commonName, serialNumber string) error {
+	if err := a.recordConnection(commonName, serialNumber); err != nil {
+		a.logger.WithField(internal.FieldCommonName, commonName).Errorf("Nie udało się zapisać połączenia %s: %v", commonName, err)
+	}
+	return nil
+}
+
+// recordConnection zapisuje połączenie certyfikatu pod blokadą bazy, aby równoległe połączenia nie nadpisały swoich zmian
+func (a *app) recordConnection(commonName, serialNumber string) error {
+	unlock, err := internal.LockCertificateDB(a.certDBPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Baza jest wczytywana ponownie dopiero pod blokadą - zawiera zmiany zapisane w międzyczasie przez inne procesy
+	a.certDB = nil
+	certDB, err := a.database()
+	if err != nil {
+		return err
+	}
+	due, err := certDB.MarkSerialSeen(commonName, serialNumber, time.Now())
+	if err != nil {
+		return err
+	}
+	if due == 0 {
+		return nil
+	}
+	a.logger.WithField(internal.FieldCommonName, commonName).Infof("Nowy certyfikat %s połączył się - poprzednie certyfikaty (%d) zostaną odwołane przez revoke-pending", commonName, due)
+	return certDB.Save()
+}
+
+// deviceAdd obsługuje polecenie device add
+func (a *app) deviceAdd(outputDir string, req internal.DeviceRequest) error {
+	service, err := a.service(outputDir)
+	if err != nil {
+		return err
+	}
+	_, err = service.AddDevice(req)
+	return err
+}
+
+// deviceRenew obsługuje polecenie device renew
+func (a *app) deviceRenew(outputDir string, req internal.DeviceRequest) error {
+	service, err := a.service(outputDir)
+	if err != nil {
+		return err
+	}
+	_, err = service.RenewDevice(req)
+	return err
+}
+
+// deviceRevoke obsługuje polecenie device revoke
+func (a *app) deviceRevoke(commonName, device string) error {
+	service, err := a.service("")
+	if err != nil {
+		return err
+	}
+	return service.RevokeDevice(commonName, device)
+}
+
+// deviceList obsługuje polecenie device list
+func (a *app) deviceList(commonName, format string) error {
+	certDB, err := a.database()
+	if err != nil {
+		return err
+	}
+	entries, err := internal.DeviceInventory(certDB, commonName, time.Now())
+	if err != nil {
+		return err
+	}
+	return internal.WriteInventory(os.Stdout, entries, format)
+}
+
+// revokePending obsługuje polecenie revoke-pending
+func (a *app) revokePending() error {
+	service, err := a.service("")
+	if err != nil {
+		return err
+	}
+	summary, err := service.RevokePending(time.Now())
+	if err != nil {
+		return err
+	}
+	if summary.Failed > 0 {
+		return fmt.Errorf("nie udało się odwołać %d certyfikatów: %v", summary.Failed, summary.FailedSerials)
+	}
+	return nil
+}
+
+// revoke obsługuje polecenie revoke
+func (a *app) revoke(commonName string, server bool) error {
+	service, err := a.service("")
+	if err != nil {
+		return err
+	}
+	if server {
+		return service.RevokeServer(commonName)
+	}
+	return service.RevokeClient(commonName)
+}
+
+// reminders wysyła przypomnienia użytkownikom, których certyfikaty nie zostaną odnowione automatycznie
+func (a *app) reminders() error {
+	mailer, notifier, err := a.mailerAndNotifier()
+	if err != nil {
+		return err
+	}
+	certDB, err := a.database()
+	if err != nil {
+		return err
+	}
+
+	reminderConfig, err := internal.LoadReminderConfigFromEnv()
+	if err != nil {
+		return configError("błąd konfiguracji przypomnień", err)
+	}
+	reminderConfig.Policies = a.policies
+
+	a.logger.Infof("Uruchomiono wysyłkę przypomnień o wygasających certyfikatach")
+	reminderService := internal.NewReminderService(certDB, mailer, notifier, reminderConfig, a.logger)
+	summary := reminderService.Run(time.Now())
+
+	// Zapis historii przypomnień zapobiega ich powtarzaniu przy kolejnym uruchomieniu
+	if err := certDB.Save(); err != nil {
+		return fmt.Errorf("błąd podczas zapisywania bazy danych: %w", err)
+	}
+
+	if summary.Failed > 0 {
+		return &internal.Error{Kind: internal.ErrEmail, Msg: fmt.Sprintf("nie udało się wysłać %d przypomnień: %v", summary.Failed, summary.FailedUsers)}
+	}
+	return nil
+}
+
+// list wypisuje certyfikaty użytkowników i serwerów spełniające kryteria filtra
+func (a *app) list(filter internal.InventoryFilter, format string) error {
+	certDB, err := a.database()
+	if err != nil {
+		return err
+	}
+	entries := internal.BuildInventory(certDB, filter, time.Now())
+	return internal.WriteInventory(os.Stdout, entries, format)
+}
+
+// show wypisuje szczegóły certyfikatu użytkownika lub serwera
+func (a *app) show(commonName, format string) error {
+	certDB, err := a.database()
+	if err != nil {
+		return err
+	}
+	entry, err := internal.FindInventoryEntry(certDB, commonName, time.Now())
+	if err != nil {
+		return err
+	}
+	return internal.WriteInventoryEntry(os.Stdout, entry, format)
+}
+
+// reconcile porównuje bazę danych z certyfikatami wydanymi przez Vault i opcjonalnie ją naprawia
+func (a *app) reconcile(fix bool, format string) error {
+	vaultClient, err := a.vaultClient()
+	if err != nil {
+		return err
+	}
+	certDB, err := a.database()
+	if err != nil {
+		return err
+	}
+	serviceConfig, err := internal.LoadServiceConfigFromEnv(a.policies)
+	if err != nil {
+		return err
+	}
+	report, err := internal.NewReconciler(certDB, vaultClient, serviceConfig.GracePeriod, a.logger).Run(fix, time.Now())
+	if err != nil {
+		return err
+	}
+	return internal.WriteReconcileReport(os.Stdout, report, format)
+}
+
+// usersImport synchronizuje bazę z listą użytkowników z pliku CSV lub LDIF
+func (a *app) usersImport(path, outputDir string, options internal.SyncOptions, format string) error {
+	if path == "" {
+		return fmt.Errorf("%w: users import wymaga ścieżki do pliku CSV lub LDIF", errUsage)
+	}
+	return a.syncUsers(internal.FileSource{Path: path, Mapping: internal.LoadDirectoryMappingFromEnv()}, outputDir, options, format)
+}
+
+// usersSync synchronizuje bazę z katalogiem LDAP - nieobecni w katalogu tracą dostęp
+func (a *app) usersSync(outputDir string, force bool, format string) error {
+	ldapConfig, err := internal.LoadLDAPConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	source := internal.NewLDAPSource(ldapConfig, a.logger)
+	return a.syncUsers(source, outputDir, internal.SyncOptions{RevokeMissing: true, Force: force}, format)
+}
+
+// syncUsers pobiera listę uprawnionych użytkowników ze źródła i uzgadnia z nią bazę
+func (a *app) syncUsers(source internal.DirectorySource, outputDir string, options internal.SyncOptions, format string) error {
+	maxRevocations, err := internal.LoadSyncMaxRevocationsFromEnv()
+	if err != nil {
+		return err
+	}
+	options.Source = source.Name()
+	options.MaxRevocations = maxRevocations
+
+	users, err := source.Users()
+	if err != nil {
+		return err
+	}
+
+	service, err := a.service(outputDir)
+	if err != nil {
+		return err
+	}
+	report, err := service.SyncUsers(users, options)
+	if report != nil {
+		if writeErr := internal.WriteSyncReport(os.Stdout, report, format); writeErr != nil && err == nil {
+			err = writeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if failed := report.FailedUsers(); len(failed) > 0 {
+		return fmt.Errorf("synchronizacja nie powiodła się dla %d użytkowników: %v", len(failed), failed)
+	}
+	return nil
+}
+
+// importCertificates zakłada wpisy w bazie dla istniejących plików .ovpn i PEM
+func (a *app) importCertificates(paths []string, emailsPath, format string) error {
+	emails := map[string]string{}
+	if emailsPath != "" {
+		var err error
+		if emails, err = internal.LoadEmailMapping(emailsPath); err != nil {
+			return err
+		}
+	}
+
+	vaultClient, err := a.vaultClient()
+	if err != nil {
+		return err
+	}
+	certDB, err := a.database()
+	if err != nil {
+		return err
+	}
+
+	results, err := internal.NewImporter(certDB, vaultClient, a.logger).Import(paths, emails)
+	if writeErr := internal.WriteImportResults(os.Stdout, results, format); writeErr != nil && err == nil {
+		err = writeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, result := range results {
+		if result.Status == internal.ImportFailed {
+			failed = append(failed, result.File)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("nie udało się zaimportować %d plików: %v", len(failed), failed)
+	}
+	return nil
+}
+
+// dbInfo wypisuje statystyki bazy danych
+func (a *app) dbInfo() error {
+	certDB, err := a.database()
+	if err != nil {
+		return err
+	}
+
+	users := certDB.GetAllUsers()
+	var revoked, autoRenewOff, blocked, pending, devices int
+	for _, user := range users {
+		pending += len(user.PendingRevocations)
+		devices += len(user.Devices)
+		if user.IsRevoked() {
+			revoked++
+		}
+		if !a.policies.AutoRenew(user) {
+			autoRenewOff++
+		}
+		if user.RenewalBlocked != "" {
+			blocked++
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "File:\t%s\n", a.certDBPath)
+	fmt.Fprintf(w, "Version:\t%s\n", certDB.Metadata.Version)
+	fmt.Fprintf(w, "Last updated:\t%s\n", certDB.Metadata.LastUpdated.Format(time.RFC3339))
+	fmt.Fprintf(w, "Users:\t%d\n", len(users))
+	fmt.Fprintf(w, "  revoked:\t%d\n", revoked)
+	fmt.Fprintf(w, "  auto-renew off:\t%d\n", autoRenewOff)
+	fmt.Fprintf(w, "  renewal blocked:\t%d\n", blocked)
+	fmt.Fprintf(w, "  pending revocations:\t%d\n", pending)
+	fmt.Fprintf(w, "  devices:\t%d\n", devices)
+	fmt.Fprintf(w, "Servers:\t%d\n", len(certDB.GetAllServers()))
+	return w.Flush()
+}
+
+// dbRemove usuwa wpis z bazy danych bez odwoływania certyfikatu w Vault
+func (a *app) dbRemove(commonName string, server bool) error {
+	certDB, err := a.database()
+	if err != nil {
+		return err
+	}
+	if server {
+		err = certDB.DeleteServerCertificate(commonName)
+	} else {
+		err = certDB.DeleteUser(commonName)
+	}
+	if err != nil {
+		return err
+	}
+	return certDB.Save()
+}
+
+// routerList wypisuje certyfikaty zainstalowane na routerze
+func (a *app) routerList(ip string) error {
+	mikrotik, err := a.mikrotik(ip)
+	if err != nil {
+		return err
+	}
+	defer mikrotik.Close()
+
+	certs, err := mikrotik.ListCertificates()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCOMMON NAME\tEXPIRES\tINVALID\tREVOKED")
+	for _, cert := range certs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", cert["name"], cert["common-name"], cert["invalid-after"], cert["invalid"], cert["revoked"])
+	}
+	return w.Flush()
+}
+
+// routerStatus wypisuje wszystkie właściwości certyfikatu na routerze
+func (a *app) routerStatus(ip, certName string) error {
+	mikrotik, err := a.mikrotik(ip)
+	if err != nil {
+		return err
+	}
+	defer mikrotik.Close()
+
+	status, err := mikrotik.GetCertificateStatus(certName)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, key := range sortedKeys(status) {
+		fmt.Fprintf(w, "%s:\t%s\n", key, status[key])
+	}
+	return w.Flush()
+}
+
+// routerAudit porównuje certyfikaty na routerach z bazą; bez adresu IP sprawdza wszystkie routery z bazy
+func (a *app) routerAudit(ip string, clean bool, format string) error {
+	certDB, err := a.database()
+	if err != nil {
+		return err
+	}
+
+	routers := make(map[string][]internal.ServerCertificate)
+	if ip != "" {
+		routers[ip] = nil
+	}
+	for _, server := range certDB.GetAllServers() {
+		if server.MikrotikIP == "" || (ip != "" && server.MikrotikIP != ip) {
+			continue
+		}
+		routers[server.MikrotikIP] = append(routers[server.MikrotikIP], server)
+	}
+	if len(routers) == 0 {
+		return &internal.Error{Kind: internal.ErrNotFound, Msg: "w bazie nie ma certyfikatów serwerów przypisanych do routerów (podaj --mikrotik-ip)"}
+	}
+
+	var audits []internal.RouterAudit
+	var unreachable []string
+	for _, router := range sortedKeys(routers) {
+		audit, err := a.auditRouter(router, routers[router], clean)
+		if err != nil {
+			a.logger.Warnf("Audyt routera %s nie powiódł się: %v", router, err)
+			audits = append(audits, internal.UnreachableRouterAudit(router, err))
+			unreachable = append(unreachable, router)
+			continue
+		}
+		audits = append(audits, *audit)
+	}
+
+	if err := internal.WriteRouterAudits(os.Stdout, audits, format); err != nil {
+		return err
+	}
+	if len(unreachable) > 0 {
+		return &internal.Error{Kind: internal.ErrRouterUnreachable, Msg: fmt.Sprintf("nie udało się sprawdzić routerów: %v", unreachable)}
+	}
+	return nil
+}
+
+// auditRouter łączy się z jednym routerem i wykonuje audyt
+func (a *app) auditRouter(ip string, servers []internal.ServerCertificate, clean bool) (*internal.RouterAudit, error) {
+	mikrotik, err := a.mikrotik(ip)
+	if err != nil {
+		return nil, err
+	}
+	defer mikrotik.Close()
+
+	return mikrotik.Audit(servers, clean)
+}
+
+// caRotate obsługuje polecenie ca rotate: rozpoczyna lub wznawia rotację CA i wypisuje jej postęp
+func (a *app) caRotate(outputDir string, req internal.RotationRequest, format string) error {
+	service, err := a.service(outputDir)
+	if err != nil {
+		return err
+	}
+	rotation, err := service.RotateCA(req)
+	if rotation != nil {
+		if writeErr := internal.WriteCARotations(os.Stdout, []internal.CARotationStatus{internal.NewCARotationStatus(a.certDB, *rotation)}, format); writeErr != nil {
+			return writeErr
+		}
+	}
+	return err
+}
+
+// caStatus wypisuje postęp rotacji CA zapisany w bazie; bez --mount - rotacje wszystkich montowań
+func (a *app) caStatus(mount, format string) error {
+	certDB, err := a.database()
+	if err != nil {
+		return err
+	}
+	rotations := certDB.GetAllCARotations()
+	if mount != "" {
+		rotation, exists := rotations[mount]
+		if !exists {
+			return &internal.Error{Kind: internal.ErrNotFound, Msg: fmt.Sprintf("montowanie %s nie ma rotacji CA w bazie", mount)}
+		}
+		rotations = map[string]internal.CARotation{mount: rotation}
+	}
+
+	var statuses []internal.CARotationStatus
+	for _, name := range sortedKeys(rotations) {
+		statuses = append(statuses, internal.NewCARotationStatus(certDB, rotations[name]))
+	}
+	return internal.WriteCARotations(os.Stdout, statuses, format)
+}
+
+// check sprawdza daty wygaśnięcia CA montowań PKI i łańcuchów certyfikatów z bazy
+func (a *app) check(format string) error {
+	caConfig, err := internal.LoadCACheckConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	service, err := a.service("")
+	if err != nil {
+		return err
+	}
+	report, err := service.CheckCAs(caConfig, time.Now())
+	if report != nil {
+		if writeErr := internal.WriteCACheck(os.Stdout, report, format); writeErr != nil {
+			return writeErr
+		}
+	}
+	return err
+}
+
+// checkCAsPeriodically wykonuje sprawdzenie CA przy starcie serve i co CA_CHECK_INTERVAL do zatrzymania serwera.
+// Każde sprawdzenie ma własny app - wczytuje bazę i loguje się do Vault od nowa, nie współdzieląc stanu z żądaniami API.
+func (a *app) checkCAsPeriodically(ctx context.Context, caConfig internal.CACheckConfig) {
+	ticker := time.NewTicker(caConfig.Interval)
+	defer ticker.Stop()
+	for {
+		checker := newApp(a.logger, a.certDBPath, a.policies, a.mounts, a.executor.DryRun())
+		service, err := checker.service("")
+		if err == nil {
+			var report *internal.CACheckReport
+			report, err = service.CheckCAs(caConfig, time.Now())
+			if report != nil {
+				a.logger.Infof("Sprawdzono certyfikaty CA: %d, problemy: %d", len(report.CAs), report.Problems())
+			}
+		}
+		if err := checker.finishCACheck(err); err != nil {
+			a.logger.Warnf("Sprawdzenie certyfikatów CA nie powiodło się: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// serve uruchamia REST API i panel WWW do czasu otrzymania SIGINT lub SIGTERM
+func (a *app) serve(listen, outputDir, tlsCert, tlsKey string) error {
+	if (tlsCert == "") != (tlsKey == "") {
+		return fmt.Errorf("%w: --tls-cert i --tls-key muszą być podane razem", errUsage)
+	}
+	apiConfig, err := internal.LoadAPIConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	// Konfiguracja SMTP, Vault i katalog wyjściowy są sprawdzane przy starcie, a nie przy pierwszym żądaniu
+	if _, err := a.service(outputDir); err != nil {
+		return err
+	}
+	ui, err := fs.Sub(config, "web")
+	if err != nil {
+		return fmt.Errorf("błąd podczas odczytu wbudowanego panelu: %w", err)
+	}
+
+	portalConfig, err := internal.LoadPortalConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	caConfig, err := internal.LoadCACheckConfigFromEnv()
+	if err != nil {
+		return err
+	}
+
+	apiServer := internal.NewAPIServer(serveBackend{app: a, outputDir: outputDir}, apiConfig, ui, a.logger)
+	if portalConfig.Enabled() {
+		apiServer.EnablePortal(portalConfig)
+		a.logger.Infof("Portal samoobsługowy dostępny pod %s/portal/", portalConfig.URL)
+	}
+	server := &http.Server{Addr: listen, Handler: apiServer.Handler(), ReadHeaderTimeout: 10 * time.Second}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		a.logger.Infof("Serwer API nasłuchuje na %s", listen)
+		if tlsCert != "" {
+			serveErr <- server.ListenAndServeTLS(tlsCert, tlsKey)
+		} else {
+			serveErr <- server.ListenAndServe()
+		}
+	}()
+
+	if caConfig.Interval > 0 {
+		go a.checkCAsPeriodically(ctx, caConfig)
+	}
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("błąd serwera API: %w", err)
+	case <-ctx.Done():
+	}
+
+	a.logger.Infof("Zatrzymywanie serwera API")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("błąd podczas zatrzymywania serwera API: %w", err)
+	}
+	return nil
+}
+
+// sortedKeys zwraca klucze mapy w kolejności alfabetycznej
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// configValidate sprawdza konfigurację bez łączenia się z Vault, SMTP ani routerami
+func (a *app) configValidate(fileConfig *internal.FileConfig, format string) error {
+	var checks []internal.ConfigCheck
+
+	if fileConfig == nil {
+		checks = append(checks, internal.ConfigCheck{Section: "file", Status: internal.CheckSkipped, Detail: "brak pliku konfiguracji - tylko zmienne środowiskowe"})
+	} else {
+		detail := fileConfig.Path
+		if fileConfig.Environment != "" {
+			detail += ", środowisko " + fileConfig.Environment
+		}
+		checks = append(checks, internal.ConfigCheck{Section: "file", Status: internal.CheckOK, Detail: detail})
+	}
+
+	vaultConfig, err := internal.LoadVaultConfigFromEnv(a.mounts)
+	vaultDetail := fmt.Sprintf("%s, PKI %s, role %s/%s", vaultConfig.Address, vaultConfig.PKIPath, vaultConfig.Role, vaultConfig.ServerRole)
+	if vaultConfig.Namespace != "" {
+		vaultDetail += ", przestrzeń nazw " + vaultConfig.Namespace
+	}
+	if len(vaultConfig.Mounts) > 0 {
+		vaultDetail += fmt.Sprintf(", dodatkowe montowania: %s", strings.Join(sortedKeys(vaultConfig.Mounts), ", "))
+	}
+	checks = append(checks, internal.NewConfigCheck("vault", err, vaultDetail))
+
+	// Polityki są sprawdzane osobno, aby błąd w polityce grupy nie był przypisany ustawieniom domyślnym
+	serviceConfig, err := internal.LoadServiceConfigFromEnv(internal.PolicyConfig{})
+	maxTTL := "bez limitu"
+	if serviceConfig.MaxTTL > 0 {
+		maxTTL = fmt.Sprintf("%.0fh", serviceConfig.MaxTTL.Hours())
+	}
+	checks = append(checks, internal.NewConfigCheck("pki", err, fmt.Sprintf("TTL klienta %s, TTL serwera %s, maks. TTL %s, próg odnawiania: %s", serviceConfig.ClientTTL, serviceConfig.ServerTTL, maxTTL, serviceConfig.RenewalThreshold)))
+	if err == nil {
+		_, err = internal.LoadServiceConfigFromEnv(a.policies)
+		if err == nil {
+			err = a.policies.CheckMounts(a.mounts)
+		}
+		checks = append(checks, internal.NewConfigCheck("policies", err, fmt.Sprintf("grupy: %d, serwery: %d", len(a.policies.Groups), len(a.policies.Servers))))
+	}
+
+	mikrotikConfig, err := internal.LoadMikrotikConfigFromEnv()
+	if err == nil && !mikrotikConfig.HasCredentials() {
+		checks = append(checks, internal.ConfigCheck{Section: "routers", Status: internal.CheckWarning, Detail: "brak MIKROTIK_USERNAME/MIKROTIK_PASSWORD - certyfikaty serwerów trzeba wdrażać ręcznie"})
+	} else {
+		checks = append(checks, internal.NewConfigCheck("routers", err, fmt.Sprintf("użytkownik %s, API %d, FTP %d", mikrotikConfig.Username, mikrotikConfig.APIPort, mikrotikConfig.FTPPort)))
+	}
+
+	mailerConfig, err := internal.LoadMailerConfigFromEnv()
+	var mailer *internal.Mailer
+	if err == nil {
+		var templates *internal.TemplateStore
+		templatesFS, fsErr := fs.Sub(config, "templates")
+		if fsErr != nil {
+			return fmt.Errorf("błąd podczas odczytu wbudowanych szablonów: %w", fsErr)
+		}
+		templatesDir := "(wbudowane)"
+		if mailerConfig.TemplatesDir != "" {
+			templatesDir = mailerConfig.TemplatesDir
+		}
+		templates, err = internal.NewTemplateStore(templatesFS, mailerConfig.TemplatesDir, mailerConfig.DefaultLocale, mailerConfig.Subject)
+		checks = append(checks, internal.NewConfigCheck("templates", err, fmt.Sprintf("język domyślny %s, katalog %s", mailerConfig.DefaultLocale, templatesDir)))
+		if err == nil {
+			mailer, err = internal.NewMailer(mailerConfig, templates, a.logger)
+		}
+	}
+	switch {
+	case err == nil && mailerConfig.Disabled:
+		checks = append(checks, internal.ConfigCheck{Section: "smtp", Status: internal.CheckWarning, Detail: "wysyłka emaili wyłączona (DISABLE_EMAIL)"})
+	default:
+		checks = append(checks, internal.NewConfigCheck("smtp", err, fmt.Sprintf("%s:%d (%s), nadawca %s", mailerConfig.Host, mailerConfig.Port, mailerConfig.TLSMode, mailerConfig.From)))
+	}
+
+	if mailer != nil {
+		_, err = internal.LoadNotifiersFromEnv(mailer, a.logger)
+		checks = append(checks, internal.NewConfigCheck("notifications", err, ""))
+	}
+
+	reminderConfig, err := internal.LoadReminderConfigFromEnv()
+	checks = append(checks, internal.NewConfigCheck("thresholds", err, fmt.Sprintf("przypomnienia %v dni przed wygaśnięciem", reminderConfig.Offsets)))
+
+	caConfig, err := internal.LoadCACheckConfigFromEnv()
+	caDetail := fmt.Sprintf("ostrzeżenie %.0f dni przed wygaśnięciem CA, serve sprawdza co %s", caConfig.Warning.Hours()/24, caConfig.Interval)
+	if caConfig.Interval == 0 {
+		caDetail = fmt.Sprintf("ostrzeżenie %.0f dni przed wygaśnięciem CA, serve nie sprawdza CA", caConfig.Warning.Hours()/24)
+	}
+	checks = append(checks, internal.NewConfigCheck("ca_check", err, caDetail))
+
+	checks = append(checks, a.storageChecks()...)
+
+	if os.Getenv("LDAP_URL") == "" {
+		checks = append(checks, internal.ConfigCheck{Section: "ldap", Status: internal.CheckSkipped, Detail: "brak LDAP_URL"})
+	} else {
+		ldapConfig, err := internal.LoadLDAPConfigFromEnv()
+		checks = append(checks, internal.NewConfigCheck("ldap", err, ldapConfig.URL))
+	}
+
+	if os.Getenv("API_TOKEN") == "" && os.Getenv("OIDC_ISSUER") == "" {
+		checks = append(checks, internal.ConfigCheck{Section: "api", Status: internal.CheckSkipped, Detail: "brak API_TOKEN i OIDC_ISSUER - serve nie uruchomi się"})
+	} else {
+		_, err := internal.LoadAPIConfigFromEnv()
+		checks = append(checks, internal.NewConfigCheck("api", err, ""))
+	}
+
+	portalConfig, err := internal.LoadPortalConfigFromEnv()
+	if err == nil && !portalConfig.Enabled() {
+		checks = append(checks, internal.ConfigCheck{Section: "portal", Status: internal.CheckSkipped, Detail: "brak PORTAL_SECRET"})
+	} else {
+		checks = append(checks, internal.NewConfigCheck("portal", err, portalConfig.URL))
+	}
+
+	if err := internal.WriteConfigChecks(os.Stdout, checks, format); err != nil {
+		return err
+	}
+	var failed []string
+	for _, check := range checks {
+		if check.Status == internal.CheckError {
+			failed = append(failed, check.Section)
+		}
+	}
+	if len(failed) > 0 {
+		return configError(fmt.Sprintf("konfiguracja zawiera błędy w sekcjach: %v", failed), nil)
+	}
+	return nil
+}
+
+// storageChecks sprawdza bazę certyfikatów, katalog profili i plik metryk bez ich tworzenia
+func (a *app) storageChecks() []internal.ConfigCheck {
+	var checks []internal.ConfigCheck
+
+	if _, err := os.Stat(a.certDBPath); errors.Is(err, fs.ErrNotExist) {
+		checks = append(checks, internal.ConfigCheck{Section: "storage", Status: internal.CheckWarning, Detail: fmt.Sprintf("baza %s nie istnieje - zostanie utworzona", a.certDBPath)})
+	} else {
+		certDB, err := a.database()
+		detail := ""
+		if err == nil {
+			detail = fmt.Sprintf("baza %s: użytkowników %d, serwerów %d", a.certDBPath, len(certDB.GetAllUsers()), len(certDB.GetAllServers()))
+		}
+		checks = append(checks, internal.NewConfigCheck("storage", err, detail))
+	}
+
+	outputDir := internal.LoadStorageConfigFromEnv().OutputDir
+	if info, err := os.Stat(outputDir); err != nil || !info.IsDir() {
+		checks = append(checks, internal.ConfigCheck{Section: "storage", Status: internal.CheckWarning, Detail: fmt.Sprintf("katalog profili %s nie istnieje", outputDir)})
+	} else {
+		checks = append(checks, internal.NewConfigCheck("storage", nil, "katalog profili "+outputDir))
+	}
+
+	if path := internal.LoadMetricsConfigFromEnv().TextfilePath; path != "" {
+		dir := filepath.Dir(path)
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			checks = append(checks, internal.ConfigCheck{Section: "metrics", Status: internal.CheckError, Detail: fmt.Sprintf("katalog %s pliku METRICS_TEXTFILE nie istnieje", dir)})
+		} else {
+			checks = append(checks, internal.NewConfigCheck("metrics", nil, path))
+		}
+	}
+
+	return checks
+}
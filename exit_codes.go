@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/pbabilas/pinpoint/internal"
+)
+
+// Kody wyjścia procesu - pozwalają skryptom i harmonogramom rozróżnić przyczynę błędu
+const (
+	exitOK                = 0
+	exitFailure           = 1
+	exitUsage             = 2
+	exitConfig            = 3
+	exitVaultAuth         = 4
+	exitVault             = 5
+	exitRouterUnreachable = 6
+	exitRouterCommand     = 7
+	exitEmail             = 8
+	exitNotFound          = 9
+	exitDatabase          = 10
+	exitDirectory         = 11
+)
+
+// errUsage oznacza nieprawidłowe parametry wywołania
+var errUsage = errors.New("nieprawidłowe wywołanie")
+
+// exitCodes przypisuje rodzajom błędów kody wyjścia; kolejność ma znaczenie, gdy błąd ma kilka rodzajów
+var exitCodes = []struct {
+	kind error
+	code int
+}{
+	{errUsage, exitUsage},
+	{internal.ErrInvalidConfig, exitConfig},
+	{internal.ErrVaultAuth, exitVaultAuth},
+	{internal.ErrDatabase, exitDatabase},
+	{internal.ErrNotFound, exitNotFound},
+	{internal.ErrVault, exitVault},
+	{internal.ErrRouterUnreachable, exitRouterUnreachable},
+	{internal.ErrRouterCommand, exitRouterCommand},
+	{internal.ErrEmail, exitEmail},
+	{internal.ErrDirectory, exitDirectory},
+}
+
+// exitCode zwraca kod wyjścia odpowiadający rodzajowi błędu
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	for _, entry := range exitCodes {
+		if errors.Is(err, entry.kind) {
+			return entry.code
+		}
+	}
+	return exitFailure
+}
+
+// configError oznacza błąd jako błąd konfiguracji
+func configError(msg string, err error) error {
+	return &internal.Error{Kind: internal.ErrInvalidConfig, Msg: msg, Err: err}
+}
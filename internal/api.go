@@ -0,0 +1,530 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// apiMaxBodySize ogranicza rozmiar treści żądania JSON
+const apiMaxBodySize = 1 << 20
+
+// apiTokenActor to identyfikator osoby posługującej się statycznym tokenem API (administrator)
+const apiTokenActor = "api-token"
+
+// errAPIForbidden to operacja dostępna tylko ze statycznym tokenem API (HTTP 403)
+var errAPIForbidden = errors.New("operacja wymaga tokena API_TOKEN")
+
+// APIBackend dostarcza zależności dla żądań API - te same, których używają polecenia CLI.
+// Każde wywołanie może zwrócić świeży stan (np. bazę zmienioną przez renew-all z crona).
+type APIBackend interface {
+	Database() (*CertificateDB, error)
+	Service() (*CertService, error)
+	Mailer() (*Mailer, error)
+	Mikrotik(ip string) (*MikrotikIntegration, error)
+}
+
+// APIServer udostępnia REST API (/api/...), panel HTML (/), metryki (/metrics) i opcjonalnie portal (/portal/)
+type APIServer struct {
+	backend APIBackend
+	auth    *apiAuthenticator
+	ui      fs.FS
+	logger  *logrus.Logger
+	portal  *portal
+	// mutex szereguje żądania - baza i klient Vault są współdzielone, a operacje trwają krótko
+	mutex sync.Mutex
+}
+
+// NewAPIServer tworzy serwer API; ui to katalog z plikiem index.html
+func NewAPIServer(backend APIBackend, config APIConfig, ui fs.FS, logger *logrus.Logger) *APIServer {
+	return &APIServer{
+		backend: backend,
+		auth:    newAPIAuthenticator(config),
+		ui:      ui,
+		logger:  logger,
+	}
+}
+
+// apiIssueRequest to treść żądania wydania certyfikatu użytkownika
+type apiIssueRequest struct {
+	CommonName  string `json:"common_name"`
+	Email       string `json:"email"`
+	TTL         string `json:"ttl"`
+	RenewBefore string `json:"renew_before"`
+	Locale      string `json:"locale"`
+	Group       string `json:"group"`
+	AutoRenew   string `json:"auto_renew"`
+	Force       bool   `json:"force"`
+	Mount       string `json:"mount"`
+	// RevokeImmediately odwołuje zastąpiony certyfikat bez okresu przejściowego
+	RevokeImmediately bool `json:"revoke_immediately"`
+}
+
+// apiRenewRequest to opcjonalna treść żądania odnowienia certyfikatu użytkownika
+type apiRenewRequest struct {
+	RevokeImmediately bool `json:"revoke_immediately"`
+}
+
+// apiResendRequest to treść żądania ponownej wysyłki profilu
+type apiResendRequest struct {
+	Email  string `json:"email"`
+	Locale string `json:"locale"`
+}
+
+// apiDeployRequest to treść żądania wdrożenia certyfikatu serwera
+type apiDeployRequest struct {
+	MikrotikIP string `json:"mikrotik_ip"`
+	Email      string `json:"email"`
+	TTL        string `json:"ttl"`
+	Force      bool   `json:"force"`
+	Resend     bool   `json:"resend"`
+	Mount      string `json:"mount"`
+	// NewRouter pozwala wdrożyć certyfikat na router spoza bazy lub przenieść serwer na inny router (tylko API_TOKEN)
+	NewRouter bool `json:"new_router"`
+}
+
+// apiClientResult to wynik wydania lub odnowienia certyfikatu użytkownika
+type apiClientResult struct {
+	CommonName   string    `json:"common_name"`
+	SerialNumber string    `json:"serial_number"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	Renewed      bool      `json:"renewed"`
+	Emailed      bool      `json:"emailed"`
+}
+
+// apiDeployment to wynik wdrożenia certyfikatu na jeden router
+type apiDeployment struct {
+	Router string `json:"router"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// apiServerResult to wynik wdrożenia certyfikatu serwera
+type apiServerResult struct {
+	CommonName   string          `json:"common_name"`
+	SerialNumber string          `json:"serial_number"`
+	ExpiresAt    time.Time       `json:"expires_at"`
+	Renewed      bool            `json:"renewed"`
+	Deployments  []apiDeployment `json:"deployments"`
+}
+
+// apiRouter to router znany z bazy wraz z przypisanymi certyfikatami serwerów
+type apiRouter struct {
+	Router  string   `json:"router"`
+	Servers []string `json:"servers"`
+}
+
+// Handler zwraca obsługę wszystkich ścieżek serwera
+func (s *APIServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.Handle("GET /", http.FileServerFS(s.ui))
+
+	s.handle(mux, "GET /metrics", s.metrics)
+	s.handle(mux, "GET /api/users", s.listUsers)
+	s.handle(mux, "POST /api/users", s.issueUser)
+	s.handle(mux, "GET /api/users/{name}", s.showUser)
+	s.handle(mux, "POST /api/users/{name}/renew", s.renewUser)
+	s.handle(mux, "POST /api/users/{name}/revoke", s.revokeUser)
+	s.handle(mux, "POST /api/users/{name}/resend", s.resendUser)
+	s.handle(mux, "GET /api/servers", s.listServers)
+	s.handle(mux, "GET /api/servers/{name}", s.showServer)
+	s.handle(mux, "POST /api/servers/{name}/deploy", s.deployServer)
+	s.handle(mux, "GET /api/routers", s.listRouters)
+	s.handle(mux, "GET /api/routers/{ip}/certificates", s.routerCertificates)
+	if s.portal != nil {
+		s.registerPortal(mux)
+	}
+	return mux
+}
+
+// apiHandler obsługuje uwierzytelnione żądanie; actor to token API lub email użytkownika OIDC
+type apiHandler func(w http.ResponseWriter, r *http.Request, actor string) error
+
+// handle rejestruje ścieżkę wymagającą uwierzytelnienia; błędy są zamieniane na odpowiedź JSON
+func (s *APIServer) handle(mux *http.ServeMux, pattern string, handler apiHandler) {
+	mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		actor, err := s.auth.authenticate(r)
+		if err != nil {
+			s.logger.Warnf("API: odrzucono żądanie %s %s z %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			writeAPIJSON(w, http.StatusUnauthorized, map[string]string{"error": "brak dostępu"})
+			return
+		}
+		if r.Method != http.MethodGet {
+			s.logger.Infof("API: %s %s (%s)", r.Method, r.URL.Path, actor)
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, apiMaxBodySize)
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if err := handler(w, r, actor); err != nil {
+			s.writeError(w, r, actor, err)
+		}
+	})
+}
+
+// writeError zapisuje błąd jako odpowiedź JSON; błędy serwera i systemów zewnętrznych trafiają do logu
+func (s *APIServer) writeError(w http.ResponseWriter, r *http.Request, actor string, err error) {
+	status := apiStatus(err)
+	if status >= http.StatusInternalServerError {
+		s.logger.Errorf("API: %s %s (%s): %v", r.Method, r.URL.Path, actor, err)
+	}
+	writeAPIJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// apiStatus dobiera kod HTTP do rodzaju błędu
+func apiStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidConfig):
+		return http.StatusBadRequest
+	case errors.Is(err, errAPIForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, errPortalRateLimit):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrVault), errors.Is(err, ErrVaultAuth), errors.Is(err, ErrRouterUnreachable),
+		errors.Is(err, ErrRouterCommand), errors.Is(err, ErrEmail):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeAPIJSON zapisuje odpowiedź JSON z podanym kodem HTTP
+func writeAPIJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+// decodeAPIRequest wczytuje treść żądania JSON; pusta treść zostawia wartości domyślne
+func decodeAPIRequest(r *http.Request, value interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(value); err != nil && !errors.Is(err, io.EOF) {
+		return newError(ErrInvalidConfig, err, "nieprawidłowa treść żądania")
+	}
+	return nil
+}
+
+func (s *APIServer) metrics(w http.ResponseWriter, r *http.Request, actor string) error {
+	certDB, err := s.backend.Database()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	return WriteMetrics(w, certDB)
+}
+
+// inventory zwraca wpisy danego typu (user lub server) z filtrami z parametrów zapytania
+func (s *APIServer) inventory(r *http.Request, entryType string) ([]InventoryEntry, error) {
+	certDB, err := s.backend.Database()
+	if err != nil {
+		return nil, err
+	}
+
+	query := r.URL.Query()
+	filter := InventoryFilter{
+		Expired:     query.Get("expired") == "true",
+		NoEmail:     query.Get("no_email") == "true",
+		ServersOnly: entryType == EntryServer,
+	}
+	if within := query.Get("expiring_within"); within != "" {
+		if filter.ExpiringWithin, err = ParseDays(within); err != nil {
+			return nil, newError(ErrInvalidConfig, err, "nieprawidłowy parametr expiring_within")
+		}
+	}
+
+	entries := []InventoryEntry{}
+	for _, entry := range BuildInventory(certDB, filter, time.Now()) {
+		if entry.Type == entryType {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// entry zwraca wpis danego typu o podanym CN
+func (s *APIServer) entry(commonName, entryType string) (InventoryEntry, error) {
+	certDB, err := s.backend.Database()
+	if err != nil {
+		return InventoryEntry{}, err
+	}
+	entry, err := FindInventoryEntry(certDB, commonName, time.Now())
+	if err != nil {
+		return entry, err
+	}
+	if entry.Type != entryType {
+		return entry, newError(ErrNotFound, nil, "certyfikat %s nie jest typu %s", commonName, entryType)
+	}
+	return entry, nil
+}
+
+func (s *APIServer) listUsers(w http.ResponseWriter, r *http.Request, actor string) error {
+	entries, err := s.inventory(r, EntryUser)
+	if err != nil {
+		return err
+	}
+	writeAPIJSON(w, http.StatusOK, entries)
+	return nil
+}
+
+func (s *APIServer) showUser(w http.ResponseWriter, r *http.Request, actor string) error {
+	entry, err := s.entry(r.PathValue("name"), EntryUser)
+	if err != nil {
+		return err
+	}
+	writeAPIJSON(w, http.StatusOK, entry)
+	return nil
+}
+
+func (s *APIServer) issueUser(w http.ResponseWriter, r *http.Request, actor string) error {
+	var req apiIssueRequest
+	if err := decodeAPIRequest(r, &req); err != nil {
+		return err
+	}
+	if req.CommonName == "" {
+		return newError(ErrInvalidConfig, nil, "wymagane pole common_name")
+	}
+	return s.issue(w, ClientRequest{
+		CommonName:        req.CommonName,
+		Email:             req.Email,
+		TTL:               req.TTL,
+		RenewBefore:       req.RenewBefore,
+		Locale:            req.Locale,
+		Group:             req.Group,
+		AutoRenew:         req.AutoRenew,
+		Force:             req.Force,
+		Mount:             req.Mount,
+		RevokeImmediately: req.RevokeImmediately,
+	})
+}
+
+// renewUser wymusza odnowienie certyfikatu istniejącego użytkownika i wysyła nowy profil
+func (s *APIServer) renewUser(w http.ResponseWriter, r *http.Request, actor string) error {
+	var req apiRenewRequest
+	if err := decodeAPIRequest(r, &req); err != nil {
+		return err
+	}
+	entry, err := s.entry(r.PathValue("name"), EntryUser)
+	if err != nil {
+		return err
+	}
+	if entry.RevokedAt != nil {
+		return newError(ErrInvalidConfig, nil, "certyfikat użytkownika %s został odwołany", entry.CommonName)
+	}
+	return s.issue(w, ClientRequest{CommonName: entry.CommonName, Force: true, RevokeImmediately: req.RevokeImmediately})
+}
+
+// issue wykonuje client issue i zwraca jego wynik
+func (s *APIServer) issue(w http.ResponseWriter, req ClientRequest) error {
+	if req.AutoRenew != "" && req.AutoRenew != "on" && req.AutoRenew != "off" {
+		return newError(ErrInvalidConfig, nil, "auto_renew musi mieć wartość on lub off")
+	}
+	service, err := s.backend.Service()
+	if err != nil {
+		return err
+	}
+	if req.Locale != "" && !service.HasLocale(req.Locale) {
+		return newError(ErrInvalidConfig, nil, "brak szablonów email dla języka %q", req.Locale)
+	}
+
+	result, err := service.IssueClient(req)
+	if err != nil {
+		return err
+	}
+	writeAPIJSON(w, http.StatusOK, apiClientResult{
+		CommonName:   req.CommonName,
+		SerialNumber: result.Certificate.SerialNumber,
+		ExpiresAt:    result.Certificate.ExpiresAt,
+		Renewed:      result.Renewed,
+		Emailed:      result.Emailed,
+	})
+	return nil
+}
+
+func (s *APIServer) revokeUser(w http.ResponseWriter, r *http.Request, actor string) error {
+	service, err := s.backend.Service()
+	if err != nil {
+		return err
+	}
+	if err := service.RevokeClient(r.PathValue("name")); err != nil {
+		return err
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]string{"status": "revoked"})
+	return nil
+}
+
+func (s *APIServer) resendUser(w http.ResponseWriter, r *http.Request, actor string) error {
+	var req apiResendRequest
+	if err := decodeAPIRequest(r, &req); err != nil {
+		return err
+	}
+	service, err := s.backend.Service()
+	if err != nil {
+		return err
+	}
+	if err := service.ResendClient(r.PathValue("name"), req.Email, req.Locale); err != nil {
+		return err
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]string{"status": "sent"})
+	return nil
+}
+
+func (s *APIServer) listServers(w http.ResponseWriter, r *http.Request, actor string) error {
+	entries, err := s.inventory(r, EntryServer)
+	if err != nil {
+		return err
+	}
+	writeAPIJSON(w, http.StatusOK, entries)
+	return nil
+}
+
+func (s *APIServer) showServer(w http.ResponseWriter, r *http.Request, actor string) error {
+	entry, err := s.entry(r.PathValue("name"), EntryServer)
+	if err != nil {
+		return err
+	}
+	writeAPIJSON(w, http.StatusOK, entry)
+	return nil
+}
+
+// deployServer wykonuje server deploy na routerze zapisanym w bazie. Inny router (spoza bazy lub inny niż
+// zapisany dla serwera) wymaga new_router i statycznego tokena - API nie łączy się z dowolnymi adresami.
+func (s *APIServer) deployServer(w http.ResponseWriter, r *http.Request, actor string) error {
+	var req apiDeployRequest
+	if err := decodeAPIRequest(r, &req); err != nil {
+		return err
+	}
+	commonName := r.PathValue("name")
+	certDB, err := s.backend.Database()
+	if err != nil {
+		return err
+	}
+
+	server, exists := certDB.GetServerCertificate(commonName)
+	stored := ""
+	if exists {
+		stored = server.MikrotikIP
+	}
+	if req.MikrotikIP == "" {
+		req.MikrotikIP = stored
+	}
+	if req.MikrotikIP == "" {
+		return newError(ErrInvalidConfig, nil, "wymagane pole mikrotik_ip")
+	}
+	// Nowy serwer może trafić na router znany z bazy; zapisany serwer - tylko na swój router
+	known := req.MikrotikIP == stored || (!exists && knownRouter(certDB, req.MikrotikIP))
+	if !known {
+		if !req.NewRouter {
+			return newError(ErrInvalidConfig, nil, "router %s nie jest przypisany do serwera %s - użyj new_router, aby go zmienić", req.MikrotikIP, commonName)
+		}
+		if actor != apiTokenActor {
+			return fmt.Errorf("%w: nowy router %s dla serwera %s", errAPIForbidden, req.MikrotikIP, commonName)
+		}
+		s.logger.Warnf("API: wdrożenie %s na nowy router %s (%s)", commonName, req.MikrotikIP, actor)
+	}
+
+	service, err := s.backend.Service()
+	if err != nil {
+		return err
+	}
+	result, err := service.DeployServer(ServerRequest{
+		CommonName: commonName,
+		Email:      req.Email,
+		TTL:        req.TTL,
+		MikrotikIP: req.MikrotikIP,
+		Force:      req.Force,
+		Resend:     req.Resend,
+		Mount:      req.Mount,
+	})
+	if err != nil {
+		return err
+	}
+
+	response := apiServerResult{
+		CommonName:   result.Certificate.CommonName,
+		SerialNumber: result.Certificate.SerialNumber,
+		ExpiresAt:    result.Certificate.ExpiresAt,
+		Renewed:      result.Renewed,
+		Deployments:  []apiDeployment{},
+	}
+	for _, deployment := range result.Deployments {
+		response.Deployments = append(response.Deployments, apiDeployment(deployment))
+	}
+	writeAPIJSON(w, http.StatusOK, response)
+	return nil
+}
+
+// listRouters zwraca routery przypisane do certyfikatów serwerów w bazie
+func (s *APIServer) listRouters(w http.ResponseWriter, r *http.Request, actor string) error {
+	certDB, err := s.backend.Database()
+	if err != nil {
+		return err
+	}
+
+	servers := make(map[string][]string)
+	for commonName, server := range certDB.GetAllServers() {
+		if server.MikrotikIP != "" {
+			servers[server.MikrotikIP] = append(servers[server.MikrotikIP], commonName)
+		}
+	}
+	routers := []apiRouter{}
+	for router, names := range servers {
+		sort.Strings(names)
+		routers = append(routers, apiRouter{Router: router, Servers: names})
+	}
+	sort.Slice(routers, func(i, j int) bool { return routers[i].Router < routers[j].Router })
+	writeAPIJSON(w, http.StatusOK, routers)
+	return nil
+}
+
+// routerCertificates zwraca certyfikaty zainstalowane na routerze (router list); tylko routery przypisane do serwerów w bazie
+func (s *APIServer) routerCertificates(w http.ResponseWriter, r *http.Request, actor string) error {
+	ip := r.PathValue("ip")
+	certDB, err := s.backend.Database()
+	if err != nil {
+		return err
+	}
+	if !knownRouter(certDB, ip) {
+		return newError(ErrNotFound, nil, "router %s nie jest przypisany do żadnego serwera w bazie", ip)
+	}
+	mikrotik, err := s.backend.Mikrotik(ip)
+	if err != nil {
+		return err
+	}
+	defer mikrotik.Close()
+
+	certs, err := mikrotik.ListCertificates()
+	if err != nil {
+		return fmt.Errorf("błąd podczas pobierania certyfikatów z routera %s: %w", ip, err)
+	}
+	if certs == nil {
+		certs = []map[string]string{}
+	}
+	writeAPIJSON(w, http.StatusOK, certs)
+	return nil
+}
+
+// knownRouter sprawdza, czy adres jest routerem przypisanym do certyfikatu serwera w bazie
+func knownRouter(certDB *CertificateDB, ip string) bool {
+	for _, server := range certDB.GetAllServers() {
+		if server.MikrotikIP != "" && server.MikrotikIP == ip {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oidcCacheTTL określa, jak długo wynik sprawdzenia tokena OIDC jest ważny bez ponownego pytania dostawcy
+const oidcCacheTTL = time.Minute
+
+// APIConfig przechowuje ustawienia uwierzytelniania API (polecenie serve)
+type APIConfig struct {
+	// Token to statyczny token API (API_TOKEN) przekazywany w nagłówku Authorization: Bearer
+	Token string
+	// OIDCIssuer to adres dostawcy OIDC (OIDC_ISSUER); tokeny dostępu są sprawdzane przez jego endpoint userinfo
+	OIDCIssuer string
+	// OIDCAllowed to dozwolone adresy email lub domeny w postaci @example.com (OIDC_ALLOWED_EMAILS)
+	OIDCAllowed []string
+}
+
+// LoadAPIConfigFromEnv wczytuje konfigurację uwierzytelniania API; wymagany jest token lub OIDC
+func LoadAPIConfigFromEnv() (APIConfig, error) {
+	config := APIConfig{
+		Token:       os.Getenv("API_TOKEN"),
+		OIDCIssuer:  strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/"),
+		OIDCAllowed: splitList(strings.ToLower(os.Getenv("OIDC_ALLOWED_EMAILS"))),
+	}
+	if config.Token == "" && config.OIDCIssuer == "" {
+		return config, newError(ErrInvalidConfig, nil, "API wymaga uwierzytelniania - ustaw API_TOKEN lub OIDC_ISSUER")
+	}
+	if config.OIDCIssuer != "" && len(config.OIDCAllowed) == 0 {
+		return config, newError(ErrInvalidConfig, nil, "OIDC_ISSUER wymaga listy dozwolonych użytkowników OIDC_ALLOWED_EMAILS")
+	}
+	return config, nil
+}
+
+// apiAuthenticator sprawdza nagłówek Authorization i zwraca identyfikator osoby wykonującej żądanie
+type apiAuthenticator struct {
+	config APIConfig
+	client *http.Client
+
+	mutex       sync.Mutex
+	userinfoURL string
+	cache       map[[sha256.Size]byte]oidcIdentity
+}
+
+// oidcIdentity to zapamiętany wynik sprawdzenia tokena OIDC
+type oidcIdentity struct {
+	email   string
+	expires time.Time
+}
+
+func newAPIAuthenticator(config APIConfig) *apiAuthenticator {
+	return &apiAuthenticator{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+		cache:  make(map[[sha256.Size]byte]oidcIdentity),
+	}
+}
+
+// authenticate zwraca "api-token" dla statycznego tokena lub email użytkownika OIDC
+func (a *apiAuthenticator) authenticate(r *http.Request) (string, error) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return "", fmt.Errorf("brak nagłówka Authorization: Bearer")
+	}
+
+	if a.config.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.config.Token)) == 1 {
+		return apiTokenActor, nil
+	}
+	if a.config.OIDCIssuer == "" {
+		return "", fmt.Errorf("nieprawidłowy token")
+	}
+
+	email, err := a.oidcEmail(token)
+	if err != nil {
+		return "", err
+	}
+	if !a.allowed(email) {
+		return "", fmt.Errorf("użytkownik %s nie ma dostępu do API", email)
+	}
+	return email, nil
+}
+
+// allowed sprawdza adres na liście OIDC_ALLOWED_EMAILS (pełne adresy lub domeny @example.com)
+func (a *apiAuthenticator) allowed(email string) bool {
+	email = strings.ToLower(email)
+	for _, entry := range a.config.OIDCAllowed {
+		if entry == email || (strings.HasPrefix(entry, "@") && strings.HasSuffix(email, entry)) {
+			return true
+		}
+	}
+	return false
+}
+
+// oidcEmail sprawdza token dostępu w endpoincie userinfo dostawcy i zwraca zweryfikowany adres email
+func (a *apiAuthenticator) oidcEmail(token string) (string, error) {
+	key := sha256.Sum256([]byte(token))
+	a.mutex.Lock()
+	identity, cached := a.cache[key]
+	a.mutex.Unlock()
+	if cached && time.Now().Before(identity.expires) {
+		return identity.email, nil
+	}
+
+	userinfoURL, err := a.discoverUserinfo()
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, userinfoURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("nie udało się sprawdzić tokena OIDC: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("dostawca OIDC odrzucił token (HTTP %d)", resp.StatusCode)
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified *bool  `json:"email_verified"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return "", fmt.Errorf("nieprawidłowa odpowiedź userinfo: %w", err)
+	}
+	// Brak email_verified traktujemy jak niezweryfikowany adres - inaczej dostawca pozwalający ustawić dowolny
+	// adres w profilu dawałby dostęp do cudzych certyfikatów
+	if claims.Email == "" || claims.EmailVerified == nil || !*claims.EmailVerified {
+		return "", fmt.Errorf("token OIDC nie zawiera zweryfikowanego adresu email")
+	}
+
+	a.mutex.Lock()
+	a.cache[key] = oidcIdentity{email: claims.Email, expires: time.Now().Add(oidcCacheTTL)}
+	a.mutex.Unlock()
+	return claims.Email, nil
+}
+
+// discoverUserinfo odczytuje adres endpointu userinfo z /.well-known/openid-configuration
+func (a *apiAuthenticator) discoverUserinfo() (string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.userinfoURL != "" {
+		return a.userinfoURL, nil
+	}
+
+	resp, err := a.client.Get(a.config.OIDCIssuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", fmt.Errorf("nie udało się pobrać konfiguracji OIDC: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("nie udało się pobrać konfiguracji OIDC (HTTP %d)", resp.StatusCode)
+	}
+
+	var discovery struct {
+		UserinfoEndpoint string `json:"userinfo_endpoint"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil || discovery.UserinfoEndpoint == "" {
+		return "", fmt.Errorf("konfiguracja OIDC %s nie zawiera userinfo_endpoint", a.config.OIDCIssuer)
+	}
+	a.userinfoURL = discovery.UserinfoEndpoint
+	return a.userinfoURL, nil
+}
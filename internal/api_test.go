@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// testAPIBackend zwraca bazę testową i zapamiętuje adresy routerów, z którymi API próbowało się połączyć
+type testAPIBackend struct {
+	certDB  *CertificateDB
+	routers []string
+}
+
+func (b *testAPIBackend) Database() (*CertificateDB, error) { return b.certDB, nil }
+
+func (b *testAPIBackend) Service() (*CertService, error) {
+	return nil, newError(ErrRouterUnreachable, nil, "serwis niedostępny w teście")
+}
+
+func (b *testAPIBackend) Mailer() (*Mailer, error) { return nil, nil }
+
+func (b *testAPIBackend) Mikrotik(ip string) (*MikrotikIntegration, error) {
+	b.routers = append(b.routers, ip)
+	return nil, newError(ErrRouterUnreachable, nil, "router %s niedostępny w teście", ip)
+}
+
+func newTestAPI(t *testing.T) (http.Handler, *testAPIBackend) {
+	t.Helper()
+	certDB := NewCertificateDB(filepath.Join(t.TempDir(), "certificates.json"), testLogger())
+	if err := certDB.AddOrUpdateServerCertificate(ServerCertificate{CommonName: "vpn1", MikrotikIP: "192.0.2.1"}); err != nil {
+		t.Fatal(err)
+	}
+	backend := &testAPIBackend{certDB: certDB}
+	server := NewAPIServer(backend, APIConfig{Token: "admin-token"}, fstest.MapFS{}, testLogger())
+	return server.Handler(), backend
+}
+
+func apiRequest(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestRouterCertificatesOnlyKnownRouters(t *testing.T) {
+	handler, backend := newTestAPI(t)
+
+	for _, ip := range []string{"169.254.169.254", "127.0.0.1:8200", "localhost"} {
+		if resp := apiRequest(handler, http.MethodGet, "/api/routers/"+ip+"/certificates", ""); resp.Code != http.StatusNotFound {
+			t.Errorf("%s: HTTP %d, want 404", ip, resp.Code)
+		}
+	}
+	if resp := apiRequest(handler, http.MethodGet, "/api/routers/192.0.2.1/certificates", ""); resp.Code != http.StatusBadGateway {
+		t.Errorf("known router: HTTP %d, want 502 from the test backend", resp.Code)
+	}
+	if strings.Join(backend.routers, ",") != "192.0.2.1" {
+		t.Errorf("connected to %v, want only the known router", backend.routers)
+	}
+}
+
+func TestDeployServerRouterCheck(t *testing.T) {
+	handler, _ := newTestAPI(t)
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		// Sprawdzenie routera przechodzi - test kończy się na niedostępnym serwisie (502)
+		{"stored router", "/api/servers/vpn1/deploy", `{}`, http.StatusBadGateway},
+		{"same router", "/api/servers/vpn1/deploy", `{"mikrotik_ip": "192.0.2.1"}`, http.StatusBadGateway},
+		{"new server on known router", "/api/servers/vpn2/deploy", `{"mikrotik_ip": "192.0.2.1"}`, http.StatusBadGateway},
+		{"other router", "/api/servers/vpn1/deploy", `{"mikrotik_ip": "10.0.0.1"}`, http.StatusBadRequest},
+		{"new server on unknown router", "/api/servers/vpn2/deploy", `{"mikrotik_ip": "10.0.0.1"}`, http.StatusBadRequest},
+		{"other router with new_router", "/api/servers/vpn1/deploy", `{"mikrotik_ip": "10.0.0.1", "new_router": true}`, http.StatusBadGateway},
+		{"new server without router", "/api/servers/vpn2/deploy", `{}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if resp := apiRequest(handler, http.MethodPost, tt.path, tt.body); resp.Code != tt.status {
+			t.Errorf("%s: HTTP %d, want %d (%s)", tt.name, resp.Code, tt.status, resp.Body.String())
+		}
+	}
+}
+
+func TestDeployServerNewRouterRequiresToken(t *testing.T) {
+	certDB := NewCertificateDB(filepath.Join(t.TempDir(), "certificates.json"), testLogger())
+	server := NewAPIServer(&testAPIBackend{certDB: certDB}, APIConfig{Token: "admin-token"}, fstest.MapFS{}, testLogger())
+	req := httptest.NewRequest(http.MethodPost, "/api/servers/vpn1/deploy", strings.NewReader(`{"mikrotik_ip": "10.0.0.1", "new_router": true}`))
+	recorder := httptest.NewRecorder()
+	// Użytkownik OIDC (dowolny identyfikator inny niż statyczny token) nie może wskazać nowego routera
+	if err := server.deployServer(recorder, req, "jan@example.com"); apiStatus(err) != http.StatusForbidden {
+		t.Fatalf("err = %v, want 403", err)
+	}
+}
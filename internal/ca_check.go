@@ -0,0 +1,424 @@
+package internal
+
+import (
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Zdarzenia sprawdzania łańcucha CA
+const (
+	// EventCAExpiring - certyfikat CA wygasa w oknie CA_EXPIRY_WARNING albo już wygasł
+	EventCAExpiring EventType = "ca_expiring"
+	// EventLeafOutlivesCA - certyfikaty z bazy są ważne dłużej niż CA z ich łańcucha
+	EventLeafOutlivesCA EventType = "leaf_outlives_ca"
+)
+
+// Domyślne ustawienia sprawdzania CA
+const (
+	DefaultCAExpiryWarning = 90 * 24 * time.Hour
+	DefaultCACheckInterval = 24 * time.Hour
+)
+
+// Stany certyfikatu CA w raporcie check
+const (
+	CAStatusOK       = "ok"
+	CAStatusExpiring = "expiring"
+	CAStatusExpired  = "expired"
+)
+
+// CACheckConfig przechowuje okno ostrzeżeń o wygasających CA i częstotliwość sprawdzania w poleceniu serve
+type CACheckConfig struct {
+	// Warning to okno, w którym wygasające CA jest zgłaszane (CA_EXPIRY_WARNING)
+	Warning time.Duration
+	// Interval to odstęp między sprawdzeniami w serve (CA_CHECK_INTERVAL); 0 wyłącza sprawdzanie w tle
+	Interval time.Duration
+}
+
+// LoadCACheckConfigFromEnv wczytuje CA_EXPIRY_WARNING i CA_CHECK_INTERVAL
+func LoadCACheckConfigFromEnv() (CACheckConfig, error) {
+	config := CACheckConfig{Warning: DefaultCAExpiryWarning, Interval: DefaultCACheckInterval}
+	if value := os.Getenv("CA_EXPIRY_WARNING"); value != "" {
+		warning, err := ParseDays(value)
+		if err != nil || warning == 0 {
+			return config, newError(ErrInvalidConfig, err, "nieprawidłowa wartość CA_EXPIRY_WARNING %q", value)
+		}
+		config.Warning = warning
+	}
+	if value := os.Getenv("CA_CHECK_INTERVAL"); value != "" {
+		interval, err := ParseDays(value)
+		if err != nil {
+			return config, newError(ErrInvalidConfig, err, "nieprawidłowa wartość CA_CHECK_INTERVAL")
+		}
+		config.Interval = interval
+	}
+	return config, nil
+}
+
+// CACheck to certyfikat CA znaleziony w łańcuchu montowania PKI lub certyfikatu z bazy
+type CACheck struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	Fingerprint string    `json:"fingerprint"`
+	NotAfter    time.Time `json:"not_after"`
+	DaysLeft    int       `json:"days_left"`
+	Status      string    `json:"status"`
+	// Mounts to montowania PKI, których łańcuch zawiera certyfikat
+	Mounts []string `json:"mounts"`
+	// Certificates to liczba certyfikatów z bazy, w których łańcuchu jest ten CA
+	Certificates int `json:"certificates"`
+	// Outliving to certyfikaty z bazy ważne dłużej niż ten CA (wymagają wcześniejszego odnowienia CA)
+	Outliving []string `json:"outliving"`
+}
+
+// CACheckReport to wynik sprawdzenia CA montowań PKI i certyfikatów z bazy
+type CACheckReport struct {
+	CheckedAt time.Time `json:"checked_at"`
+	CAs       []CACheck `json:"cas"`
+	// Errors to montowania (ścieżka z przestrzenią nazw), których łańcucha CA nie udało się pobrać z Vault
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// Problems zwraca liczbę CA wygasających, wygasłych lub krótszych niż podpisane nimi certyfikaty
+func (r *CACheckReport) Problems() int {
+	problems := 0
+	for _, ca := range r.CAs {
+		if ca.Status != CAStatusOK || len(ca.Outliving) > 0 {
+			problems++
+		}
+	}
+	return problems
+}
+
+// caChecker zbiera certyfikaty CA z łańcuchów montowań i certyfikatów (według odcisku)
+type caChecker struct {
+	cas    map[string]*CACheck
+	mounts map[string]*mountCAs
+	errors map[string]string
+}
+
+// mountCAs to certyfikaty CA montowania PKI pobrane z Vault
+type mountCAs struct {
+	// chain to certyfikat CA montowania i łańcuch wystawcy domyślnego
+	chain []*x509.Certificate
+	// issuers to łańcuchy wszystkich wystawców montowania według SubjectKeyId (hex) certyfikatu wystawcy
+	issuers map[string][]*x509.Certificate
+}
+
+// chainFor zwraca łańcuch wystawcy, który podpisał certyfikat o podanym AuthorityKeyId (hex).
+// Bez dopasowania (Vault starszy niż 1.11, nieznany wystawca) zwracany jest łańcuch domyślny montowania.
+func (m *mountCAs) chainFor(authorityKeyID string) []*x509.Certificate {
+	if chain, ok := m.issuers[authorityKeyID]; ok && authorityKeyID != "" {
+		return chain
+	}
+	return m.chain
+}
+
+// add dodaje certyfikaty łańcucha; mount jest pusty dla łańcuchów certyfikatów z bazy
+func (c *caChecker) add(chain []*x509.Certificate, mount string) {
+	for _, cert := range chain {
+		fingerprint := CertificateFingerprint(cert)
+		ca, exists := c.cas[fingerprint]
+		if !exists {
+			ca = &CACheck{
+				Subject:     cert.Subject.CommonName,
+				Issuer:      cert.Issuer.CommonName,
+				Fingerprint: fingerprint,
+				NotAfter:    cert.NotAfter,
+				Mounts:      []string{},
+				Outliving:   []string{},
+			}
+			c.cas[fingerprint] = ca
+		}
+		if mount != "" && !slices.Contains(ca.Mounts, mount) {
+			ca.Mounts = append(ca.Mounts, mount)
+		}
+	}
+}
+
+// leaf porównuje datę wygaśnięcia certyfikatu z bazy z każdym CA jego łańcucha
+func (c *caChecker) leaf(commonName string, expiresAt time.Time, chain []*x509.Certificate) {
+	c.add(chain, "")
+	for _, cert := range chain {
+		ca := c.cas[CertificateFingerprint(cert)]
+		ca.Certificates++
+		if expiresAt.After(cert.NotAfter) {
+			ca.Outliving = append(ca.Outliving, commonName)
+		}
+	}
+}
+
+// CheckCAs sprawdza daty wygaśnięcia CA z łańcuchów wszystkich montowań PKI (certyfikat CA i łańcuchy
+// wszystkich wystawców) oraz z łańcuchów certyfikatów w bazie. Zgłasza CA wygasające w oknie config.Warning
+// i CA, które wygasają przed podpisanymi nimi certyfikatami; wynik trafia do powiadomień i metryk.
+// Błąd jest zwracany, gdy łańcucha któregoś montowania nie udało się pobrać - raport zawiera pozostałe.
+func (s *CertService) CheckCAs(config CACheckConfig, now time.Time) (*CACheckReport, error) {
+	checker := &caChecker{
+		cas:    make(map[string]*CACheck),
+		mounts: make(map[string]*mountCAs),
+		errors: make(map[string]string),
+	}
+
+	for _, vault := range s.vault.Mounts() {
+		// Nieudane pobranie też jest zapisywane, aby certyfikaty montowania nie ponawiały zapytania
+		cas, err := s.mountCAs(vault)
+		checker.mounts[vault.Location()] = cas
+		if err != nil {
+			s.logger.Warnf("Nie udało się pobrać łańcucha CA montowania %s: %v", vault.Location(), err)
+			checker.errors[vault.Location()] = err.Error()
+			continue
+		}
+		// Raport obejmuje wszystkich wystawców montowania, także poprzednich po rotacji CA
+		checker.add(cas.chain, vault.MountName())
+		for _, keyID := range slices.Sorted(maps.Keys(cas.issuers)) {
+			checker.add(cas.issuers[keyID], vault.MountName())
+		}
+	}
+
+	// Certyfikaty użytkowników i urządzeń nie mają zapisanego łańcucha - sprawdzamy je z łańcuchem wystawcy, który je
+	// podpisał, w montowaniu, które je wydało (ForIssuer uwzględnia ścieżkę i przestrzeń nazw zapisane w bazie)
+	leafChain := func(issuer *Issuer, serialNumber, certPEM string) []*x509.Certificate {
+		vault := s.vault.ForIssuer(issuer)
+		location := vault.Location()
+		cas, fetched := checker.mounts[location]
+		if !fetched {
+			var err error
+			if cas, err = s.mountCAs(vault); err != nil {
+				s.logger.Warnf("Nie udało się pobrać łańcucha CA montowania %s: %v", location, err)
+				checker.errors[location] = err.Error()
+			}
+			checker.mounts[location] = cas
+		}
+		if cas == nil {
+			// Łańcucha montowania nie udało się pobrać (błąd jest już w raporcie) - certyfikat nie ma z czym porównać
+			return nil
+		}
+		if len(cas.issuers) < 2 {
+			// Montowanie z jednym wystawcą - nie trzeba ustalać, który podpisał certyfikat
+			return cas.chain
+		}
+		return cas.chainFor(s.authorityKeyID(vault, issuer, serialNumber, certPEM))
+	}
+	for _, user := range s.certDB.GetAllUsers() {
+		if user.IsRevoked() {
+			continue
+		}
+		checker.leaf(user.CommonName, user.ExpiresAt, leafChain(user.Issuer, user.SerialNumber, ""))
+		for _, device := range user.Devices {
+			if !device.IsRevoked() {
+				checker.leaf(device.CommonName, device.ExpiresAt, leafChain(device.Issuer, device.SerialNumber, ""))
+			}
+		}
+	}
+	for _, server := range s.certDB.GetAllServers() {
+		chain, err := ParseCertificateChainPEM(server.IssuingCA)
+		if err != nil || len(chain) == 0 {
+			chain = leafChain(server.Issuer, server.SerialNumber, server.Certificate)
+		}
+		checker.leaf(server.CommonName, server.ExpiresAt, chain)
+	}
+
+	report := &CACheckReport{CheckedAt: now, CAs: []CACheck{}}
+	if len(checker.errors) > 0 {
+		report.Errors = checker.errors
+	}
+	for _, ca := range checker.cas {
+		ca.DaysLeft = daysBetween(now, ca.NotAfter)
+		switch {
+		case !now.Before(ca.NotAfter):
+			ca.Status = CAStatusExpired
+		case ca.NotAfter.Sub(now) <= config.Warning:
+			ca.Status = CAStatusExpiring
+		default:
+			ca.Status = CAStatusOK
+		}
+		sort.Strings(ca.Outliving)
+		report.CAs = append(report.CAs, *ca)
+	}
+	sort.Slice(report.CAs, func(i, j int) bool {
+		if !report.CAs[i].NotAfter.Equal(report.CAs[j].NotAfter) {
+			return report.CAs[i].NotAfter.Before(report.CAs[j].NotAfter)
+		}
+		return report.CAs[i].Fingerprint < report.CAs[j].Fingerprint
+	})
+
+	s.reportCAs(report)
+	recordCAMetrics(report)
+
+	if len(checker.errors) > 0 {
+		return report, newError(ErrVault, nil, "nie udało się pobrać łańcucha CA montowań: %s", strings.Join(slices.Sorted(maps.Keys(checker.errors)), ", "))
+	}
+	return report, nil
+}
+
+// mountCAs pobiera certyfikat CA montowania oraz łańcuchy wszystkich jego wystawców; łańcuch domyślny to certyfikat CA
+// i łańcuch wystawcy domyślnego (bez powtórzeń). Lista wystawców wymaga Vault 1.11 i uprawnień do <pki>/issuers -
+// bez nich sprawdzany jest tylko certyfikat CA.
+func (s *CertService) mountCAs(vault *VaultClient) (*mountCAs, error) {
+	caPEM, err := vault.GetCACertificate()
+	if err != nil {
+		return nil, err
+	}
+	chain, err := ParseCertificateChainPEM(caPEM)
+	if err != nil {
+		return nil, newError(ErrVault, err, "nieprawidłowy certyfikat CA montowania %s", vault.Location())
+	}
+	cas := &mountCAs{chain: chain, issuers: make(map[string][]*x509.Certificate)}
+
+	issuers, err := vault.ListIssuers()
+	if err != nil {
+		s.logger.Debugf("Pominięto łańcuch wystawców montowania %s: %v", vault.Location(), err)
+	}
+	for _, issuer := range issuers {
+		certs, err := ParseCertificateChainPEM(issuer.Certificate + "\n" + issuer.CAChain)
+		if err != nil {
+			return nil, newError(ErrVault, err, "nieprawidłowy łańcuch wystawcy %s", issuer.Label())
+		}
+		// ca_chain zaczyna się od certyfikatu samego wystawcy
+		var issuerChain []*x509.Certificate
+		for _, cert := range certs {
+			if !slices.ContainsFunc(issuerChain, cert.Equal) {
+				issuerChain = append(issuerChain, cert)
+			}
+		}
+		if len(issuer.SubjectKeyID) > 0 {
+			cas.issuers[hex.EncodeToString(issuer.SubjectKeyID)] = issuerChain
+		}
+		if !issuer.Default {
+			continue
+		}
+		for _, cert := range issuerChain {
+			if !slices.ContainsFunc(cas.chain, cert.Equal) {
+				cas.chain = append(cas.chain, cert)
+			}
+		}
+	}
+	return cas, nil
+}
+
+// authorityKeyID ustala AuthorityKeyId certyfikatu z bazy: z wpisu wystawcy, z zapisanego certyfikatu (serwery),
+// a dla wpisów sprzed zapisywania AuthorityKeyId - z certyfikatu odczytanego z Vault. Pusty wynik oznacza łańcuch domyślny.
+func (s *CertService) authorityKeyID(vault *VaultClient, issuer *Issuer, serialNumber, certPEM string) string {
+	if issuer != nil && issuer.AuthorityKeyID != "" {
+		return issuer.AuthorityKeyID
+	}
+	if cert, err := ParseCertificatePEM(certPEM); err == nil {
+		return hex.EncodeToString(cert.AuthorityKeyId)
+	}
+	info, err := vault.GetCertificateInfo(serialNumber)
+	if err != nil {
+		s.logger.Debugf("Nie udało się ustalić wystawcy certyfikatu %s: %v", serialNumber, err)
+		return ""
+	}
+	return info.Issuer.AuthorityKeyID
+}
+
+// reportCAs loguje i zgłasza administratorom wygasające CA oraz CA krótsze niż podpisane nimi certyfikaty
+func (s *CertService) reportCAs(report *CACheckReport) {
+	for _, ca := range report.CAs {
+		switch ca.Status {
+		case CAStatusExpired:
+			s.logger.Errorf("Certyfikat CA %s wygasł %s", ca.Subject, ca.NotAfter.Format("2006-01-02"))
+			s.notify(NewEvent(EventCAExpiring, SeverityCritical, ca.Subject,
+				"Certyfikat CA wygasł",
+				fmt.Sprintf("Certyfikat CA %s wygasł %s - certyfikaty podpisane tym CA nie są już akceptowane", ca.Subject, ca.NotAfter.Format("2006-01-02"))).
+				WithField("fingerprint", ca.Fingerprint).
+				WithField("mounts", strings.Join(ca.Mounts, ", ")))
+		case CAStatusExpiring:
+			s.logger.Warnf("Certyfikat CA %s wygasa za %d dni (%s)", ca.Subject, ca.DaysLeft, ca.NotAfter.Format("2006-01-02"))
+			s.notify(NewEvent(EventCAExpiring, SeverityWarning, ca.Subject,
+				"Certyfikat CA wkrótce wygaśnie",
+				fmt.Sprintf("Certyfikat CA %s wygasa za %d dni (%s) - zaplanuj rotację CA (ca rotate)", ca.Subject, ca.DaysLeft, ca.NotAfter.Format("2006-01-02"))).
+				WithField("fingerprint", ca.Fingerprint).
+				WithField("mounts", strings.Join(ca.Mounts, ", ")))
+		}
+
+		if len(ca.Outliving) > 0 {
+			s.logger.Warnf("Certyfikaty (%d) są ważne dłużej niż CA %s (do %s): %s", len(ca.Outliving), ca.Subject, ca.NotAfter.Format("2006-01-02"), strings.Join(ca.Outliving, ", "))
+			s.notify(NewEvent(EventLeafOutlivesCA, SeverityWarning, ca.Subject,
+				"Certyfikaty ważne dłużej niż CA",
+				fmt.Sprintf("Certyfikaty (%d) są ważne dłużej niż CA %s (do %s) i przestaną działać wraz z nim: %s", len(ca.Outliving), ca.Subject, ca.NotAfter.Format("2006-01-02"), strings.Join(ca.Outliving, ", "))).
+				WithField("fingerprint", ca.Fingerprint).
+				WithField("certificates", strconv.Itoa(len(ca.Outliving))))
+		}
+	}
+}
+
+// recordCAMetrics ustawia daty wygaśnięcia CA i liczbę certyfikatów ważnych dłużej niż ich CA
+func recordCAMetrics(report *CACheckReport) {
+	expiry := make(map[string]float64, len(report.CAs))
+	outliving := make(map[string]float64)
+	for _, ca := range report.CAs {
+		expiry[formatLabels("subject", ca.Subject, "fingerprint", ca.Fingerprint)] = float64(ca.NotAfter.Unix())
+		if len(ca.Outliving) > 0 {
+			outliving[formatLabels("subject", ca.Subject, "fingerprint", ca.Fingerprint)] = float64(len(ca.Outliving))
+		}
+	}
+	metrics.replace(metricCAExpiry, expiry)
+	metrics.replace(metricOutlivingCA, outliving)
+}
+
+// caCheckColumns to kolumny raportu check w formacie tabeli i CSV
+var caCheckColumns = []string{"subject", "issuer", "not_after", "days_left", "status", "mounts", "certificates", "outliving"}
+
+func (ca CACheck) row() []string {
+	return []string{
+		ca.Subject,
+		ca.Issuer,
+		ca.NotAfter.Format("2006-01-02"),
+		strconv.Itoa(ca.DaysLeft),
+		ca.Status,
+		strings.Join(ca.Mounts, " "),
+		strconv.Itoa(ca.Certificates),
+		strconv.Itoa(len(ca.Outliving)),
+	}
+}
+
+// WriteCACheck zapisuje raport sprawdzenia CA w wybranym formacie
+func WriteCACheck(w io.Writer, report *CACheckReport, format string) error {
+	switch format {
+	case FormatJSON:
+		return writeJSON(w, report)
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(caCheckColumns); err != nil {
+			return err
+		}
+		for _, ca := range report.CAs {
+			if err := writer.Write(ca.row()); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case FormatTable, "":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(caCheckColumns, "\t")))
+		for _, ca := range report.CAs {
+			fmt.Fprintln(tw, strings.Join(ca.row(), "\t"))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		for _, ca := range report.CAs {
+			if len(ca.Outliving) > 0 {
+				fmt.Fprintf(w, "\nOutliving %s (%s): %s\n", ca.Subject, ca.NotAfter.Format("2006-01-02"), strings.Join(ca.Outliving, ", "))
+			}
+		}
+		for _, mount := range slices.Sorted(maps.Keys(report.Errors)) {
+			fmt.Fprintf(w, "\nMount %s: %s\n", mount, report.Errors[mount])
+		}
+		return nil
+	}
+	return newError(ErrInvalidConfig, nil, "nieznany format raportu: %s", format)
+}
@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testCA to certyfikat CA z kluczem do podpisywania certyfikatów testowych
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  string
+}
+
+func newTestCA(t *testing.T, commonName string, notAfter time.Time) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))}
+}
+
+// sign wystawia certyfikat klienta ważny rok i zwraca go w postaci PEM
+func (ca *testCA) sign(t *testing.T, commonName string) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+// TestCheckCAsReportsEveryIssuer symuluje montowanie po rotacji CA: nowy wystawca jest domyślny, a stary
+// (wygasający za 30 dni) nadal podpisuje wydane wcześniej certyfikaty
+func TestCheckCAsReportsEveryIssuer(t *testing.T) {
+	oldCA := newTestCA(t, "Old CA", time.Now().Add(30*24*time.Hour))
+	newCA := newTestCA(t, "New CA", time.Now().Add(10*365*24*time.Hour))
+	legacyPEM := oldCA.sign(t, "legacy.client.vpn")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/auth/approle/login":
+			writeVaultJSON(w, map[string]interface{}{"auth": map[string]interface{}{"client_token": "s.ca-check-test-token", "lease_duration": 3600}})
+		case r.URL.Path == "/v1/pki/ca/pem":
+			w.Write([]byte(newCA.pem))
+		case r.URL.Path == "/v1/pki/issuers":
+			writeVaultJSON(w, map[string]interface{}{"data": map[string]interface{}{"keys": []string{"old", "new"}}})
+		case r.URL.Path == "/v1/pki/config/issuers":
+			writeVaultJSON(w, map[string]interface{}{"data": map[string]interface{}{"default": "new"}})
+		case r.URL.Path == "/v1/pki/issuer/old":
+			writeVaultJSON(w, map[string]interface{}{"data": map[string]interface{}{"issuer_name": "old", "certificate": oldCA.pem, "ca_chain": []string{oldCA.pem}}})
+		case r.URL.Path == "/v1/pki/issuer/new":
+			writeVaultJSON(w, map[string]interface{}{"data": map[string]interface{}{"issuer_name": "new", "certificate": newCA.pem, "ca_chain": []string{newCA.pem}}})
+		case r.URL.Path == "/v1/pki/cert/aa:legacyclientvpn":
+			writeVaultJSON(w, map[string]interface{}{"data": map[string]interface{}{"certificate": legacyPEM}})
+		default:
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	vc, err := NewVaultClient(VaultConfig{Address: server.URL, RoleID: "role", SecretID: "secret", PKIPath: "pki", Role: "client"}, testLogger())
+	if err != nil {
+		t.Fatalf("NewVaultClient: %v", err)
+	}
+
+	service, certDB, _ := newTestService(t)
+	service.vault = vc
+	oldKeyID := hex.EncodeToString(oldCA.cert.SubjectKeyId)
+	newKeyID := hex.EncodeToString(newCA.cert.SubjectKeyId)
+	addTestUser(t, certDB, UserCertificate{CommonName: "old.client.vpn", Issuer: &Issuer{Path: "pki", AuthorityKeyID: oldKeyID}})
+	addTestUser(t, certDB, UserCertificate{CommonName: "new.client.vpn", Issuer: &Issuer{Path: "pki", AuthorityKeyID: newKeyID}})
+	// Wpis sprzed zapisywania AuthorityKeyId - wystawca jest ustalany z certyfikatu w Vault
+	addTestUser(t, certDB, UserCertificate{CommonName: "legacy.client.vpn"})
+
+	report, err := service.CheckCAs(CACheckConfig{Warning: DefaultCAExpiryWarning}, time.Now())
+	if err != nil {
+		t.Fatalf("CheckCAs: %v", err)
+	}
+	cas := make(map[string]CACheck)
+	for _, ca := range report.CAs {
+		cas[ca.Subject] = ca
+	}
+
+	old, ok := cas["Old CA"]
+	if !ok {
+		t.Fatalf("report %+v does not include the non-default issuer", report.CAs)
+	}
+	if old.Status != CAStatusExpiring || !slices.Equal(old.Mounts, []string{DefaultMountName}) {
+		t.Errorf("Old CA: status %s, mounts %v - want expiring in mount %s", old.Status, old.Mounts, DefaultMountName)
+	}
+	if old.Certificates != 2 || !slices.Equal(old.Outliving, []string{"legacy.client.vpn", "old.client.vpn"}) {
+		t.Errorf("Old CA: certificates %d, outliving %v - want the two certificates it signed", old.Certificates, old.Outliving)
+	}
+	if current := cas["New CA"]; current.Certificates != 1 || len(current.Outliving) != 0 {
+		t.Errorf("New CA: certificates %d, outliving %v - want only new.client.vpn", current.Certificates, current.Outliving)
+	}
+}
+
+// TestWriteCACheckMetricsTextfile sprawdza, że kolejne sprawdzenia CA w serve nie dodają liczników procesu do pliku
+func TestWriteCACheckMetricsTextfile(t *testing.T) {
+	saved := metrics.snapshot()
+	t.Cleanup(func() {
+		metrics.mutex.Lock()
+		metrics.values = saved
+		metrics.mutex.Unlock()
+	})
+	metrics.mutex.Lock()
+	metrics.values = make(map[metricSample]float64)
+	metrics.mutex.Unlock()
+
+	path := filepath.Join(t.TempDir(), "pinpoint.prom")
+	if err := os.WriteFile(path, []byte("pinpoint_certificate_operations_total{operation=\"issue\",result=\"success\",type=\"client\"} 5\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	countOperation("issue", "client", nil)
+	metrics.replace(metricCAExpiry, map[string]float64{formatLabels("subject", "Old CA"): 1000})
+
+	for range 3 {
+		run := MetricsRun{Command: "check", Success: true, Finished: time.Now()}
+		if err := WriteCACheckMetricsTextfile(path, nil, run, NewExecutor(false, testLogger())); err != nil {
+			t.Fatalf("WriteCACheckMetricsTextfile: %v", err)
+		}
+	}
+
+	values, err := readMetricsTextfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var operations, expiry float64
+	for sample, value := range values {
+		switch {
+		case sample.name == metricOperations:
+			operations += value
+		case sample.name == metricCAExpiry:
+			expiry = value
+		}
+	}
+	if operations != 5 {
+		t.Errorf("operations total = %v, want 5 from the previous file - counters must not be re-added", operations)
+	}
+	if expiry != 1000 {
+		t.Errorf("%s = %v, want 1000", metricCAExpiry, expiry)
+	}
+	if values[metricSample{metricLastRunOK, formatLabels("command", "check")}] != 1 {
+		t.Errorf("last run of check not recorded: %v", values)
+	}
+}
+
+// TestCheckCAsErrorsByLocation sprawdza, że błąd montowania jest zgłaszany raz, pod ścieżką montowania
+func TestCheckCAsErrorsByLocation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/auth/approle/login" {
+			writeVaultJSON(w, map[string]interface{}{"auth": map[string]interface{}{"client_token": "s.ca-check-test-token", "lease_duration": 3600}})
+			return
+		}
+		http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+	}))
+	t.Cleanup(server.Close)
+	vc, err := NewVaultClient(VaultConfig{Address: server.URL, RoleID: "role", SecretID: "secret", PKIPath: "pki", Role: "client"}, testLogger())
+	if err != nil {
+		t.Fatalf("NewVaultClient: %v", err)
+	}
+
+	service, certDB, _ := newTestService(t)
+	service.vault = vc
+	addTestUser(t, certDB, UserCertificate{CommonName: "jan.client.vpn", Issuer: &Issuer{Path: "pki"}})
+
+	report, err := service.CheckCAs(CACheckConfig{Warning: DefaultCAExpiryWarning}, time.Now())
+	if err == nil {
+		t.Fatal("CheckCAs succeeded without the CA certificate")
+	}
+	if len(report.Errors) != 1 || report.Errors["pki"] == "" {
+		t.Errorf("errors = %v, want a single entry for pki", report.Errors)
+	}
+}
@@ -0,0 +1,635 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Fazy rotacji CA montowania PKI (ca rotate). Kolejność chroni połączenia: routery ufają nowemu CA, zanim klienci
+// dostaną certyfikaty od nowego wystawcy, a klienci dostają profile z oboma CA, zanim serwery przejdą na certyfikaty
+// od nowego wystawcy. Stare CA znika z routerów dopiero po przeniesieniu wszystkich certyfikatów.
+const (
+	RotationRouters = "routers"
+	RotationClients = "clients"
+	RotationServers = "servers"
+	RotationCleanup = "cleanup"
+	RotationDone    = "done"
+)
+
+// rotationPhases to fazy rotacji w kolejności wykonywania
+var rotationPhases = []string{RotationRouters, RotationClients, RotationServers, RotationCleanup, RotationDone}
+
+// Stan rotacji CA na routerze
+const (
+	RouterNewCAImported = "new_ca_imported"
+	RouterOldCARemoved  = "old_ca_removed"
+)
+
+// EventCARotation informuje o rozpoczęciu, wstrzymaniu i zakończeniu rotacji CA
+const EventCARotation EventType = "ca_rotation"
+
+// routerCANamePattern wykrywa znaki, których nie używamy w nazwach certyfikatów na routerze
+var routerCANamePattern = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// RotationIssuer to wystawca biorący udział w rotacji CA zapisany w bazie
+type RotationIssuer struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name,omitempty"`
+	Certificate string    `json:"certificate"`
+	NotAfter    time.Time `json:"not_after"`
+}
+
+// Label zwraca nazwę wystawcy, a bez nazwy jego identyfikator
+func (i RotationIssuer) Label() string {
+	return firstNonEmpty(i.Name, i.ID)
+}
+
+// CARotation to postęp rotacji CA jednego montowania PKI zapisany w bazie; kolejne uruchomienia ca rotate
+// wznawiają ją od bieżącej fazy. Certyfikat klienta jest przeniesiony, gdy wydano go po rozpoczęciu rotacji -
+// nowy wystawca jest wtedy już wystawcą domyślnym montowania.
+type CARotation struct {
+	Mount     string         `json:"mount"`
+	Phase     string         `json:"phase"`
+	OldIssuer RotationIssuer `json:"old_issuer"`
+	NewIssuer RotationIssuer `json:"new_issuer"`
+	// RouterCAName to nazwa, pod którą nowe CA jest importowane na routery
+	RouterCAName string     `json:"router_ca_name"`
+	StartedAt    time.Time  `json:"started_at"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+	// Routers to stan rotacji na routerach według adresu IP
+	Routers map[string]string `json:"routers,omitempty"`
+	// Servers to numery seryjne certyfikatów serwerów od nowego wystawcy wdrożonych na router
+	Servers map[string]string `json:"servers,omitempty"`
+	// Failures to błędy ostatniego uruchomienia według common name lub adresu routera
+	Failures map[string]string `json:"failures,omitempty"`
+}
+
+// RotationRequest opisuje uruchomienie lub wznowienie rotacji CA
+type RotationRequest struct {
+	// Mount to nazwa montowania PKI; pusta - montowanie domyślne
+	Mount string
+	// OldIssuer to ID lub nazwa wystawcy, od którego następuje rotacja; pusty - wykrywany z certyfikatów serwerów
+	OldIssuer string
+	// Force przechodzi do kolejnych faz mimo nieprzeniesionych certyfikatów i routerów
+	Force bool
+}
+
+// RotateCA prowadzi rotację CA montowania PKI: nowy domyślny wystawca z <pki>/issuers trafia na routery obok starego,
+// klienci dostają nowe certyfikaty i profile z oboma CA, serwery - nowe certyfikaty, a na końcu stare CA jest usuwane
+// z routerów. Postęp jest zapisywany w bazie po każdym kroku; faza z błędami zatrzymuje rotację do ponownego uruchomienia.
+func (s *CertService) RotateCA(req RotationRequest) (*CARotation, error) {
+	vc, err := s.vault.Mount(req.Mount)
+	if err != nil {
+		return nil, err
+	}
+	log := s.logger.WithFields(logrus.Fields{FieldOperation: "rotate", FieldMount: vc.MountName()})
+
+	rotation, exists := s.certDB.GetCARotation(vc.MountName())
+	if !exists || rotation.Phase == RotationDone {
+		if rotation, err = s.startRotation(log, vc, req.OldIssuer, rotation); err != nil {
+			return nil, err
+		}
+	} else {
+		log.Infof("Wznawianie rotacji CA %s -> %s od fazy %s", rotation.OldIssuer.Label(), rotation.NewIssuer.Label(), rotation.Phase)
+	}
+	if rotation.Routers == nil {
+		rotation.Routers = make(map[string]string)
+	}
+	if rotation.Servers == nil {
+		rotation.Servers = make(map[string]string)
+	}
+	rotation.Failures = make(map[string]string)
+
+	for rotation.Phase != RotationDone {
+		phase := rotation.Phase
+		var pending []string
+		switch phase {
+		case RotationRouters:
+			pending, err = s.rotateRouters(log, rotation)
+		case RotationClients:
+			pending, err = s.rotateClients(rotation)
+		case RotationServers:
+			pending, err = s.rotateServers(log, rotation)
+		case RotationCleanup:
+			pending, err = s.rotateCleanup(log, rotation, req.Force)
+		default:
+			return rotation, newError(ErrDatabase, nil, "nieznana faza rotacji CA %q", phase)
+		}
+		if err == nil && len(pending) > 0 {
+			if !req.Force {
+				err = fmt.Errorf("faza %s rotacji CA montowania %s nie została zakończona (%d: %s) - uruchom ca rotate ponownie lub użyj --force",
+					phase, rotation.Mount, len(pending), strings.Join(pending, ", "))
+			} else {
+				log.Warnf("Rotacja CA: faza %s zakończona z pominięciem %d: %s (--force)", phase, len(pending), strings.Join(pending, ", "))
+			}
+		}
+		if err == nil {
+			rotation.Phase = rotationPhases[slices.Index(rotationPhases, phase)+1]
+			log.Infof("Rotacja CA: faza %s zakończona", phase)
+			if rotation.Phase == RotationDone {
+				completedAt := time.Now()
+				rotation.CompletedAt = &completedAt
+			}
+		}
+		if saveErr := s.saveRotation(*rotation); saveErr != nil {
+			return rotation, saveErr
+		}
+		if err != nil {
+			s.notify(NewEvent(EventCARotation, SeverityWarning, "",
+				"Rotacja CA została wstrzymana",
+				fmt.Sprintf("Rotacja CA montowania %s zatrzymała się w fazie %s: %v", rotation.Mount, phase, err)).
+				WithField("mount", rotation.Mount).
+				WithField("phase", phase))
+			return rotation, err
+		}
+	}
+
+	log.Infof("Rotacja CA montowania %s zakończona: %s -> %s", rotation.Mount, rotation.OldIssuer.Label(), rotation.NewIssuer.Label())
+	s.notify(NewEvent(EventCARotation, SeverityInfo, "",
+		"Rotacja CA zakończona",
+		fmt.Sprintf("Certyfikaty montowania %s przeniesiono z CA %s na %s, a stare CA usunięto z routerów", rotation.Mount, rotation.OldIssuer.Label(), rotation.NewIssuer.Label())).
+		WithField("mount", rotation.Mount).
+		WithField("issuer", rotation.NewIssuer.ID))
+	return rotation, nil
+}
+
+// startRotation wykrywa wystawców w <pki>/issuers i rozpoczyna nową rotację: nowym wystawcą jest wystawca domyślny
+func (s *CertService) startRotation(log *logrus.Entry, vc *VaultClient, oldRef string, previous *CARotation) (*CARotation, error) {
+	issuers, err := vc.ListIssuers()
+	if err != nil {
+		return nil, err
+	}
+	var current *CAIssuer
+	for i := range issuers {
+		if issuers[i].Default {
+			current = &issuers[i]
+		}
+	}
+	if current == nil {
+		return nil, newError(ErrNotFound, nil, "montowanie %s nie ma wystawcy domyślnego", vc.Location())
+	}
+
+	old, err := s.previousIssuer(vc.MountName(), issuers, *current, oldRef, previous)
+	if err != nil {
+		return nil, err
+	}
+	if old == nil {
+		return nil, newError(ErrNotFound, nil, "certyfikaty montowania %s wydaje już wystawca domyślny %s - ustaw nowego wystawcę jako domyślnego (%s/config/issuers), aby rozpocząć rotację",
+			vc.MountName(), current.Label(), vc.Location())
+	}
+	if !current.NotAfter.After(old.NotAfter) {
+		log.Warnf("Nowy wystawca %s wygasa %s, nie później niż poprzedni %s", current.Label(), current.NotAfter.Format("2006-01-02"), old.Label())
+	}
+
+	rotation := &CARotation{
+		Mount:        vc.MountName(),
+		Phase:        RotationRouters,
+		OldIssuer:    RotationIssuer{ID: old.ID, Name: old.Name, Certificate: old.Certificate, NotAfter: old.NotAfter},
+		NewIssuer:    RotationIssuer{ID: current.ID, Name: current.Name, Certificate: current.Certificate, NotAfter: current.NotAfter},
+		RouterCAName: routerCAName(*current),
+		StartedAt:    time.Now(),
+	}
+	log.Infof("Rozpoczęto rotację CA montowania %s: %s (wygasa %s) -> %s (wygasa %s)", rotation.Mount,
+		old.Label(), old.NotAfter.Format("2006-01-02"), current.Label(), current.NotAfter.Format("2006-01-02"))
+	s.notify(NewEvent(EventCARotation, SeverityInfo, "",
+		"Rozpoczęto rotację CA",
+		fmt.Sprintf("Certyfikaty montowania %s zostaną przeniesione z CA %s (wygasa %s) na %s", rotation.Mount, old.Label(), old.NotAfter.Format("2006-01-02"), current.Label())).
+		WithField("mount", rotation.Mount).
+		WithField("issuer", current.ID))
+	return rotation, nil
+}
+
+// previousIssuer ustala wystawcę, od którego następuje rotacja: wskazanego przez --old-issuer, a bez niego wystawcę
+// spoza domyślnego, który podpisał certyfikaty serwerów montowania. Montowanie bez serwerów przyjmuje ważnego wystawcę
+// spoza domyślnego z najpóźniejszą datą wygaśnięcia, chyba że poprzednia rotacja przeniosła je już na wystawcę domyślnego.
+func (s *CertService) previousIssuer(mount string, issuers []CAIssuer, current CAIssuer, ref string, previous *CARotation) (*CAIssuer, error) {
+	if ref != "" {
+		for i := range issuers {
+			if issuers[i].ID != ref && issuers[i].Name != ref {
+				continue
+			}
+			if issuers[i].ID == current.ID {
+				return nil, newError(ErrInvalidConfig, nil, "wystawca %s jest wystawcą domyślnym montowania %s - rotacja wymaga nowego wystawcy domyślnego", ref, mount)
+			}
+			return &issuers[i], nil
+		}
+		return nil, newError(ErrNotFound, nil, "wystawca %q nie istnieje w montowaniu %s", ref, mount)
+	}
+
+	servers := s.certDB.GetAllServers()
+	signed := 0
+	for _, name := range sortedServerNames(servers) {
+		server := servers[name]
+		if issuerMountName(server.Issuer) != mount {
+			continue
+		}
+		cert, err := ParseCertificatePEM(server.Certificate)
+		if err != nil {
+			continue
+		}
+		signed++
+		for i := range issuers {
+			if !issuers[i].Default && len(issuers[i].SubjectKeyID) > 0 && bytes.Equal(issuers[i].SubjectKeyID, cert.AuthorityKeyId) {
+				return &issuers[i], nil
+			}
+		}
+	}
+	if signed > 0 || (previous != nil && previous.NewIssuer.ID == current.ID) {
+		return nil, nil
+	}
+
+	var candidate *CAIssuer
+	now := time.Now()
+	for i := range issuers {
+		if issuers[i].Default || !issuers[i].NotAfter.After(now) {
+			continue
+		}
+		if candidate == nil || issuers[i].NotAfter.After(candidate.NotAfter) {
+			candidate = &issuers[i]
+		}
+	}
+	return candidate, nil
+}
+
+// rotateRouters importuje nowe CA na routery serwerów montowania; zwraca routery, na które import się nie udał
+func (s *CertService) rotateRouters(log *logrus.Entry, rotation *CARotation) ([]string, error) {
+	routers := s.rotationRouters(rotation.Mount)
+	if len(routers) > 0 && !s.config.Mikrotik.HasCredentials() {
+		return nil, newError(ErrInvalidConfig, nil, "rotacja CA wymaga danych dostępowych Mikrotika (MIKROTIK_USERNAME, MIKROTIK_PASSWORD)")
+	}
+
+	var failed []string
+	for _, router := range routers {
+		if rotation.Routers[router] != "" {
+			continue
+		}
+		log.WithField(FieldRouter, router).Infof("Rotacja CA: import nowego CA %s na router %s", rotation.RouterCAName, router)
+		err := s.withRouter(router, func(mi *MikrotikIntegration) error {
+			return mi.ImportCA(rotation.RouterCAName, rotation.NewIssuer.Certificate)
+		})
+		if rotationFailed(log, rotation, router, err) {
+			failed = append(failed, router)
+			continue
+		}
+		rotation.Routers[router] = RouterNewCAImported
+		if err := s.saveRotation(*rotation); err != nil {
+			return failed, err
+		}
+	}
+	return failed, nil
+}
+
+// rotateClients wydaje nowe certyfikaty użytkownikom i urządzeniom montowania, które nie zostały jeszcze przeniesione.
+// Profile wygenerowane w trakcie rotacji zawierają oba CA (profileCAChain) i są wysyłane użytkownikom.
+func (s *CertService) rotateClients(rotation *CARotation) ([]string, error) {
+	users := s.certDB.GetAllUsers()
+	names := make([]string, 0, len(users))
+	for commonName := range users {
+		names = append(names, commonName)
+	}
+	sort.Strings(names)
+
+	var failed []string
+	for _, commonName := range names {
+		user := users[commonName]
+		if user.IsRevoked() {
+			continue
+		}
+		if rotation.pendingClient(user.Issuer, user.LastRenewed) {
+			log := s.log(commonName, "rotate")
+			log.Infof("Rotacja CA: nowy certyfikat i profil dla %s", commonName)
+			_, err := s.IssueClient(ClientRequest{CommonName: commonName, Force: true})
+			if IsFatal(err) {
+				return failed, fmt.Errorf("przerwano rotację CA na użytkowniku %s: %w", commonName, err)
+			}
+			if rotationFailed(log, rotation, commonName, err) {
+				failed = append(failed, commonName)
+			}
+		}
+
+		for _, name := range user.DeviceNames() {
+			device := user.Devices[name]
+			if device.IsRevoked() || !rotation.pendingClient(device.Issuer, device.LastRenewed) {
+				continue
+			}
+			log := s.log(device.CommonName, "rotate")
+			log.Infof("Rotacja CA: nowy certyfikat i profil dla %s", device.CommonName)
+			_, err := s.RenewDevice(DeviceRequest{CommonName: commonName, Device: name})
+			if IsFatal(err) {
+				return failed, fmt.Errorf("przerwano rotację CA na urządzeniu %s: %w", device.CommonName, err)
+			}
+			if rotationFailed(log, rotation, device.CommonName, err) {
+				failed = append(failed, device.CommonName)
+			}
+		}
+	}
+	return failed, nil
+}
+
+// rotateServers wydaje nowe certyfikaty serwerom montowania i wdraża je na routery
+func (s *CertService) rotateServers(log *logrus.Entry, rotation *CARotation) ([]string, error) {
+	servers := s.certDB.GetAllServers()
+	var failed []string
+	for _, commonName := range sortedServerNames(servers) {
+		server := servers[commonName]
+		if issuerMountName(server.Issuer) != rotation.Mount || rotation.Servers[commonName] != "" {
+			continue
+		}
+		if server.MikrotikIP == "" {
+			log.Warnf("Certyfikat serwera %s nie jest przypisany do routera - wymień go ręcznie (server deploy)", commonName)
+			continue
+		}
+
+		s.log(commonName, "rotate").Infof("Rotacja CA: nowy certyfikat serwera %s", commonName)
+		result, err := s.DeployServer(ServerRequest{CommonName: commonName, MikrotikIP: server.MikrotikIP, Force: true})
+		if err == nil {
+			for _, deployment := range result.Deployments {
+				if deployment.Status != DeploymentOK {
+					err = newError(ErrRouterCommand, nil, "wdrożenie na router %s nie powiodło się: %s", deployment.Router, deployment.Error)
+				}
+			}
+		}
+		if IsFatal(err) {
+			return failed, fmt.Errorf("przerwano rotację CA na serwerze %s: %w", commonName, err)
+		}
+		if rotationFailed(log, rotation, commonName, err) {
+			failed = append(failed, commonName)
+			continue
+		}
+		rotation.Servers[commonName] = result.Certificate.SerialNumber
+		if err := s.saveRotation(*rotation); err != nil {
+			return failed, err
+		}
+	}
+	return failed, nil
+}
+
+// rotateCleanup usuwa stare CA z routerów, gdy wszystkie certyfikaty montowania zostały przeniesione (lub przy --force)
+func (s *CertService) rotateCleanup(log *logrus.Entry, rotation *CARotation, force bool) ([]string, error) {
+	if pending := NewCARotationStatus(s.certDB, *rotation).Pending; len(pending) > 0 && !force {
+		return pending, nil
+	}
+
+	var failed []string
+	for _, router := range slices.Sorted(maps.Keys(rotation.Routers)) {
+		if rotation.Routers[router] == RouterOldCARemoved {
+			continue
+		}
+		var removed string
+		err := s.withRouter(router, func(mi *MikrotikIntegration) error {
+			var err error
+			removed, err = mi.RemoveCA(rotation.OldIssuer.Certificate)
+			return err
+		})
+		if rotationFailed(log, rotation, router, err) {
+			failed = append(failed, router)
+			continue
+		}
+		if removed == "" {
+			log.WithField(FieldRouter, router).Warnf("Nie znaleziono starego CA na routerze %s - pomijam", router)
+		}
+		rotation.Routers[router] = RouterOldCARemoved
+		if err := s.saveRotation(*rotation); err != nil {
+			return failed, err
+		}
+	}
+	return failed, nil
+}
+
+// profileCAChain zwraca łańcuch CA profilu klienta; w trakcie rotacji CA montowania dołącza stare CA,
+// aby profil ufał serwerom z certyfikatami od starego i nowego wystawcy
+func (s *CertService) profileCAChain(certInfo *CertificateInfo) string {
+	rotation, exists := s.certDB.GetCARotation(issuerMountName(certInfo.Issuer))
+	if !exists || rotation.Phase == RotationDone {
+		return certInfo.CAChain
+	}
+	oldCA := strings.TrimSpace(rotation.OldIssuer.Certificate)
+	if oldCA == "" || strings.Contains(certInfo.CAChain, oldCA) {
+		return certInfo.CAChain
+	}
+	return strings.TrimRight(certInfo.CAChain, "\n") + "\n" + oldCA + "\n"
+}
+
+// rotationRouters zwraca routery serwerów montowania w kolejności alfabetycznej
+func (s *CertService) rotationRouters(mount string) []string {
+	var routers []string
+	for _, server := range s.certDB.GetAllServers() {
+		if server.MikrotikIP != "" && issuerMountName(server.Issuer) == mount {
+			routers = append(routers, server.MikrotikIP)
+		}
+	}
+	routers = uniqueStrings(routers)
+	sort.Strings(routers)
+	return routers
+}
+
+// withRouter łączy się z routerem na czas jednej operacji
+func (s *CertService) withRouter(ip string, action func(*MikrotikIntegration) error) error {
+	mikrotik, err := NewMikrotikIntegration(ip, s.config.Mikrotik, s.logger)
+	if err != nil {
+		return err
+	}
+	defer mikrotik.Close()
+	mikrotik.SetExecutor(s.executor)
+	return action(mikrotik)
+}
+
+// saveRotation zapisuje postęp rotacji w bazie
+func (s *CertService) saveRotation(rotation CARotation) error {
+	s.certDB.SaveCARotation(rotation)
+	if err := s.certDB.Save(); err != nil {
+		return fmt.Errorf("błąd podczas zapisywania bazy danych: %w", err)
+	}
+	return nil
+}
+
+// rotationFailed zapisuje wynik kroku rotacji dla certyfikatu lub routera key; zwraca true, gdy krok się nie udał
+func rotationFailed(log *logrus.Entry, rotation *CARotation, key string, err error) bool {
+	if err == nil {
+		delete(rotation.Failures, key)
+		return false
+	}
+	rotation.Failures[key] = err.Error()
+	log.Warnf("Rotacja CA: krok dla %s nie powiódł się: %v", key, err)
+	return true
+}
+
+// pendingClient sprawdza, czy certyfikat klienta z montowania rotacji czeka na przeniesienie do nowego wystawcy
+func (r CARotation) pendingClient(issuer *Issuer, lastRenewed time.Time) bool {
+	return issuerMountName(issuer) == r.Mount && lastRenewed.Before(r.StartedAt)
+}
+
+// issuerMountName zwraca nazwę montowania zapisaną przy certyfikacie ("default" bez wpisu wystawcy)
+func issuerMountName(issuer *Issuer) string {
+	if issuer == nil {
+		return DefaultMountName
+	}
+	return firstNonEmpty(issuer.Mount, DefaultMountName)
+}
+
+// routerCAName zwraca nazwę certyfikatu nowego CA na routerze (ca-<nazwa wystawcy>)
+func routerCAName(issuer CAIssuer) string {
+	name := issuer.Name
+	if name == "" {
+		name = issuer.ID
+		if len(name) > 8 {
+			name = name[:8]
+		}
+	}
+	return "ca-" + routerCANamePattern.ReplaceAllString(name, "-")
+}
+
+// CARotationStatus to postęp rotacji CA w raporcie ca status
+type CARotationStatus struct {
+	Mount            string            `json:"mount"`
+	Phase            string            `json:"phase"`
+	OldIssuer        string            `json:"old_issuer"`
+	OldIssuerExpires time.Time         `json:"old_issuer_expires"`
+	NewIssuer        string            `json:"new_issuer"`
+	NewIssuerExpires time.Time         `json:"new_issuer_expires"`
+	StartedAt        time.Time         `json:"started_at"`
+	CompletedAt      *time.Time        `json:"completed_at,omitempty"`
+	Routers          map[string]string `json:"routers"`
+	Clients          int               `json:"clients"`
+	ClientsMigrated  int               `json:"clients_migrated"`
+	Servers          int               `json:"servers"`
+	ServersMigrated  int               `json:"servers_migrated"`
+	// Pending to common name certyfikatów montowania, które nie przeszły jeszcze na nowego wystawcę
+	Pending  []string          `json:"pending"`
+	Failures map[string]string `json:"failures,omitempty"`
+}
+
+// NewCARotationStatus wylicza postęp rotacji z certyfikatów zapisanych w bazie
+func NewCARotationStatus(certDB *CertificateDB, rotation CARotation) CARotationStatus {
+	status := CARotationStatus{
+		Mount:            rotation.Mount,
+		Phase:            rotation.Phase,
+		OldIssuer:        rotation.OldIssuer.Label(),
+		OldIssuerExpires: rotation.OldIssuer.NotAfter,
+		NewIssuer:        rotation.NewIssuer.Label(),
+		NewIssuerExpires: rotation.NewIssuer.NotAfter,
+		StartedAt:        rotation.StartedAt,
+		CompletedAt:      rotation.CompletedAt,
+		Routers:          rotation.Routers,
+		Pending:          []string{},
+		Failures:         rotation.Failures,
+	}
+	if status.Routers == nil {
+		status.Routers = map[string]string{}
+	}
+
+	client := func(commonName string, issuer *Issuer, lastRenewed time.Time) {
+		if issuerMountName(issuer) != rotation.Mount {
+			return
+		}
+		status.Clients++
+		if rotation.pendingClient(issuer, lastRenewed) {
+			status.Pending = append(status.Pending, commonName)
+		} else {
+			status.ClientsMigrated++
+		}
+	}
+	for _, user := range certDB.GetAllUsers() {
+		if user.IsRevoked() {
+			continue
+		}
+		client(user.CommonName, user.Issuer, user.LastRenewed)
+		for _, device := range user.Devices {
+			if !device.IsRevoked() {
+				client(device.CommonName, device.Issuer, device.LastRenewed)
+			}
+		}
+	}
+	for _, server := range certDB.GetAllServers() {
+		if server.MikrotikIP == "" || issuerMountName(server.Issuer) != rotation.Mount {
+			continue
+		}
+		status.Servers++
+		if rotation.Servers[server.CommonName] != "" {
+			status.ServersMigrated++
+		} else {
+			status.Pending = append(status.Pending, server.CommonName)
+		}
+	}
+	sort.Strings(status.Pending)
+	return status
+}
+
+// caRotationColumns to kolumny raportu rotacji CA w formacie CSV
+var caRotationColumns = []string{"mount", "phase", "old_issuer", "old_issuer_expires", "new_issuer", "new_issuer_expires",
+	"started_at", "completed_at", "clients", "clients_migrated", "servers", "servers_migrated", "pending"}
+
+// WriteCARotations zapisuje postęp rotacji CA w wybranym formacie
+func WriteCARotations(w io.Writer, statuses []CARotationStatus, format string) error {
+	switch format {
+	case FormatJSON:
+		if statuses == nil {
+			statuses = []CARotationStatus{}
+		}
+		return writeJSON(w, statuses)
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(caRotationColumns); err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			completedAt := ""
+			if status.CompletedAt != nil {
+				completedAt = status.CompletedAt.Format(time.RFC3339)
+			}
+			if err := writer.Write([]string{
+				status.Mount, status.Phase,
+				status.OldIssuer, status.OldIssuerExpires.Format("2006-01-02"),
+				status.NewIssuer, status.NewIssuerExpires.Format("2006-01-02"),
+				status.StartedAt.Format(time.RFC3339), completedAt,
+				strconv.Itoa(status.Clients), strconv.Itoa(status.ClientsMigrated),
+				strconv.Itoa(status.Servers), strconv.Itoa(status.ServersMigrated),
+				strings.Join(status.Pending, " "),
+			}); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case FormatTable, "":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for i, status := range statuses {
+			if i > 0 {
+				fmt.Fprintln(tw)
+			}
+			fmt.Fprintf(tw, "Mount:\t%s\n", status.Mount)
+			fmt.Fprintf(tw, "Phase:\t%s\n", status.Phase)
+			fmt.Fprintf(tw, "Old CA:\t%s (expires %s)\n", status.OldIssuer, status.OldIssuerExpires.Format("2006-01-02"))
+			fmt.Fprintf(tw, "New CA:\t%s (expires %s)\n", status.NewIssuer, status.NewIssuerExpires.Format("2006-01-02"))
+			fmt.Fprintf(tw, "Started:\t%s\n", status.StartedAt.Format(time.RFC3339))
+			if status.CompletedAt != nil {
+				fmt.Fprintf(tw, "Completed:\t%s\n", status.CompletedAt.Format(time.RFC3339))
+			}
+			for _, router := range slices.Sorted(maps.Keys(status.Routers)) {
+				fmt.Fprintf(tw, "Router %s:\t%s\n", router, status.Routers[router])
+			}
+			fmt.Fprintf(tw, "Clients migrated:\t%d/%d\n", status.ClientsMigrated, status.Clients)
+			fmt.Fprintf(tw, "Servers migrated:\t%d/%d\n", status.ServersMigrated, status.Servers)
+			if len(status.Pending) > 0 {
+				fmt.Fprintf(tw, "Pending:\t%s\n", strings.Join(status.Pending, ", "))
+			}
+			for _, key := range slices.Sorted(maps.Keys(status.Failures)) {
+				fmt.Fprintf(tw, "Failed %s:\t%s\n", key, status.Failures[key])
+			}
+		}
+		return tw.Flush()
+	}
+	return newError(ErrInvalidConfig, nil, "nieznany format raportu: %s", format)
+}
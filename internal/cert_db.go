@@ -2,9 +2,10 @@ package internal
 
 import (
 	"encoding/json"
-	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 
@@ -13,40 +14,141 @@ import (
 
 // UserCertificate przechowuje informacje o certyfikacie użytkownika
 type UserCertificate struct {
-	CommonName  string    `json:"common_name"`
-	SerialNumber string   `json:"serial_number"`
-	Email       string    `json:"email,omitempty"`
-	CreatedAt   time.Time `json:"created_at"`
-	LastRenewed time.Time `json:"last_renewed"`
-	ExpiresAt   time.Time `json:"expires_at"`
-	TTL         string    `json:"ttl"`
+	CommonName   string    `json:"common_name"`
+	SerialNumber string    `json:"serial_number"`
+	Email        string    `json:"email,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastRenewed  time.Time `json:"last_renewed"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	TTL          string    `json:"ttl"`
+	// Locale to język wiadomości email (np. "pl", "en"); pusty oznacza język domyślny
+	Locale string `json:"locale,omitempty"`
+	// Group to grupa lub profil użytkownika ze źródła (plik CSV/LDIF, katalog)
+	Group string `json:"group,omitempty"`
+	// Source to źródło synchronizacji, z którego pochodzi użytkownik (np. "ldap", "file:users.csv");
+	// puste - użytkownik dodany ręcznie, którego users import --sync ani users sync nie odwołują
+	Source string `json:"source,omitempty"`
+	// RenewBefore to próg odnawiania użytkownika (np. 30d lub 2/3); pusty - z polityki grupy lub domyślny
+	RenewBefore string `json:"renew_before,omitempty"`
+	// AutoRenewDisabled wyłącza automatyczne odnawianie - użytkownik dostaje tylko przypomnienia
+	AutoRenewDisabled bool `json:"auto_renew_disabled,omitempty"`
+	// RenewalBlocked zawiera powód ostatniego nieudanego odnowienia (czyszczony po udanym odnowieniu)
+	RenewalBlocked string           `json:"renewal_blocked,omitempty"`
+	Reminders      []ReminderRecord `json:"reminders,omitempty"`
+	// RevokedAt to data odwołania certyfikatu; odwołany użytkownik nie jest odnawiany ani przypominany
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	// PendingRevocations to poprzednie certyfikaty, które zostaną odwołane po okresie przejściowym (revoke-pending)
+	PendingRevocations []PendingRevocation `json:"pending_revocations,omitempty"`
+	// Devices to dodatkowe certyfikaty urządzeń użytkownika według nazwy urządzenia
+	Devices map[string]DeviceCertificate `json:"devices,omitempty"`
+	// Issuer to montowanie PKI, rola i przestrzeń nazw, które wydały bieżący certyfikat
+	Issuer *Issuer `json:"issuer,omitempty"`
+}
+
+// DeviceCertificate to certyfikat jednego urządzenia użytkownika (np. laptop, telefon);
+// email, język, grupa i polityka odnawiania pochodzą z użytkownika
+type DeviceCertificate struct {
+	Name         string    `json:"name"`
+	CommonName   string    `json:"common_name"`
+	SerialNumber string    `json:"serial_number"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastRenewed  time.Time `json:"last_renewed"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	TTL          string    `json:"ttl"`
+	// RevokedAt to data odwołania certyfikatu urządzenia
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Issuer    *Issuer    `json:"issuer,omitempty"`
+}
+
+// IsRevoked sprawdza, czy certyfikat urządzenia został odwołany
+func (d *DeviceCertificate) IsRevoked() bool {
+	return d.RevokedAt != nil
+}
+
+// PendingRevocation to certyfikat zastąpiony przy odnowieniu, który nadal działa do czasu odwołania
+type PendingRevocation struct {
+	SerialNumber string `json:"serial_number"`
+	// Device to nazwa urządzenia; pusta dla głównego certyfikatu użytkownika
+	Device string `json:"device,omitempty"`
+	// ReplacedBy to numer seryjny certyfikatu, który go zastąpił
+	ReplacedBy string `json:"replaced_by"`
+	// RevokeAfter to koniec okresu przejściowego; przesuwany na moment pierwszego połączenia z nowym certyfikatem
+	RevokeAfter time.Time `json:"revoke_after"`
+	// LastError to błąd ostatniej próby odwołania (ponawianej przez revoke-pending)
+	LastError string `json:"last_error,omitempty"`
+	// Issuer to montowanie PKI, które wydało zastąpiony certyfikat - tam trafia odwołanie
+	Issuer *Issuer `json:"issuer,omitempty"`
+}
+
+// IsDue sprawdza, czy certyfikat powinien już zostać odwołany
+func (p PendingRevocation) IsDue(now time.Time) bool {
+	return !now.Before(p.RevokeAfter)
+}
+
+// IsRevoked sprawdza, czy bieżący certyfikat użytkownika został odwołany
+func (u *UserCertificate) IsRevoked() bool {
+	return u.RevokedAt != nil
+}
+
+// Rodzaje wpisów w historii przypomnień
+const (
+	ReminderKindUser       = "reminder"
+	ReminderKindEscalation = "escalation"
+)
+
+// ReminderRecord zapisuje wysłane przypomnienie, aby nie powtarzać go przy kolejnych uruchomieniach
+type ReminderRecord struct {
+	Kind         string    `json:"kind"`
+	OffsetDays   int       `json:"offset_days"`
+	SerialNumber string    `json:"serial_number"`
+	SentAt       time.Time `json:"sent_at"`
+}
+
+// ReminderSent sprawdza, czy przypomnienie danego rodzaju zostało już wysłane dla bieżącego certyfikatu
+func (u *UserCertificate) ReminderSent(kind string, offsetDays int) bool {
+	_, sent := u.ReminderSentAt(kind, offsetDays)
+	return sent
+}
+
+// ReminderSentAt zwraca czas wysłania przypomnienia danego rodzaju dla bieżącego certyfikatu
+func (u *UserCertificate) ReminderSentAt(kind string, offsetDays int) (time.Time, bool) {
+	for _, record := range u.Reminders {
+		if record.Kind == kind && record.OffsetDays == offsetDays && record.SerialNumber == u.SerialNumber {
+			return record.SentAt, true
+		}
+	}
+	return time.Time{}, false
 }
 
 // ServerCertificate przechowuje informacje o certyfikacie serwera
 type ServerCertificate struct {
-	CommonName    string    `json:"common_name"`
-	SerialNumber  string    `json:"serial_number"`
-	Certificate   string    `json:"certificate"`
-	PrivateKey    string    `json:"private_key,omitempty"`
+	CommonName   string    `json:"common_name"`
+	SerialNumber string    `json:"serial_number"`
+	Certificate  string    `json:"certificate"`
+	PrivateKey   string    `json:"private_key,omitempty"`
 	IssuingCA    string    `json:"issuing_ca"`
-	CreatedAt     time.Time `json:"created_at"`
-	LastRenewed   time.Time `json:"last_renewed"`
-	ExpiresAt     time.Time `json:"expires_at"`
-	TTL           string    `json:"ttl"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastRenewed  time.Time `json:"last_renewed"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	TTL          string    `json:"ttl"`
 	MikrotikIP   string    `json:"mikrotik_ip,omitempty"`
+	Issuer       *Issuer   `json:"issuer,omitempty"`
 }
 
 // CertificateDB reprezentuje bazę danych certyfikatów
 type CertificateDB struct {
-	Users    map[string]UserCertificate `json:"users"`
-	Servers  map[string]ServerCertificate `json:"servers"`
-	Metadata struct {
+	Users   map[string]UserCertificate   `json:"users"`
+	Servers map[string]ServerCertificate `json:"servers"`
+	// CARotations to postęp rotacji CA według nazwy montowania PKI (ca rotate)
+	CARotations map[string]CARotation `json:"ca_rotations,omitempty"`
+	Metadata    struct {
 		Version     string    `json:"version"`
 		LastUpdated time.Time `json:"last_updated"`
 	} `json:"metadata"`
 	filePath string
 	mutex    sync.RWMutex
 	logger   *logrus.Logger
+	executor *Executor
 }
 
 // NewCertificateDB tworzy nową instancję bazy danych certyfikatów
@@ -71,7 +173,7 @@ func LoadCertificateDB(filePath string, logger *logrus.Logger) (*CertificateDB,
 		// Utwórz pustą bazę danych
 		db.Metadata.LastUpdated = time.Now()
 		if err := db.Save(); err != nil {
-			return nil, fmt.Errorf("nie udało się utworzyć nowej bazy danych: %w", err)
+			return nil, newError(ErrDatabase, err, "nie udało się utworzyć nowej bazy danych")
 		}
 		return db, nil
 	}
@@ -79,29 +181,58 @@ func LoadCertificateDB(filePath string, logger *logrus.Logger) (*CertificateDB,
 	// Wczytaj istniejący plik
 	data, err := os.ReadFile(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("nie udało się wczytać pliku bazy danych: %w", err)
+		return nil, newError(ErrDatabase, err, "nie udało się wczytać pliku bazy danych")
 	}
 
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
 
 	if err := json.Unmarshal(data, db); err != nil {
-		return nil, fmt.Errorf("nie udało się sparsować pliku bazy danych: %w", err)
+		return nil, newError(ErrDatabase, err, "nie udało się sparsować pliku bazy danych")
 	}
 
 	logger.Infof("Wczytano bazę danych z %s, użytkowników: %d", filePath, len(db.Users))
 	return db, nil
 }
 
+// LockCertificateDB zakłada wyłączną blokadę bazy dla równoległych procesów (np. skryptów client-connect kilku połączeń naraz).
+// Blokowany jest plik <baza>.lock, bo Save podmienia plik bazy przez rename i blokada na nim samym nie obejmowałaby nowego pliku.
+// Bazę trzeba wczytać dopiero po założeniu blokady; zwrócona funkcja zdejmuje blokadę.
+func LockCertificateDB(filePath string) (func(), error) {
+	dir := filepath.Dir(filePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, newError(ErrDatabase, err, "nie udało się utworzyć katalogu %s", dir)
+	}
+	file, err := os.OpenFile(filePath+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, newError(ErrDatabase, err, "nie udało się otworzyć blokady bazy danych")
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, newError(ErrDatabase, err, "nie udało się zablokować bazy danych %s", filePath)
+	}
+	// Zamknięcie pliku zdejmuje blokadę
+	return func() { file.Close() }, nil
+}
+
+// SetExecutor kieruje zapis bazy przez executor trybu dry-run - zmiany zostają wtedy tylko w pamięci
+func (db *CertificateDB) SetExecutor(executor *Executor) {
+	db.executor = executor
+}
+
 // Save zapisuje bazę danych do pliku
 func (db *CertificateDB) Save() error {
+	if db.executor.Skip(PlanStep{Target: PlanDatabase, Operation: "save", Detail: db.filePath}) {
+		return nil
+	}
+
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
 
 	// Upewnij się, że katalog istnieje
 	dir := filepath.Dir(db.filePath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("nie udało się utworzyć katalogu %s: %w", dir, err)
+		return newError(ErrDatabase, err, "nie udało się utworzyć katalogu %s", dir)
 	}
 
 	// Zaktualizuj metadane
@@ -111,15 +242,15 @@ func (db *CertificateDB) Save() error {
 	tempFile := db.filePath + ".tmp"
 	data, err := json.MarshalIndent(db, "", "  ")
 	if err != nil {
-		return fmt.Errorf("nie udało się zserializować bazy danych: %w", err)
+		return newError(ErrDatabase, err, "nie udało się zserializować bazy danych")
 	}
 
 	if err := os.WriteFile(tempFile, data, 0644); err != nil {
-		return fmt.Errorf("nie udało się zapisać pliku tymczasowego: %w", err)
+		return newError(ErrDatabase, err, "nie udało się zapisać pliku tymczasowego")
 	}
 
 	if err := os.Rename(tempFile, db.filePath); err != nil {
-		return fmt.Errorf("nie udało się zmienić nazwy pliku: %w", err)
+		return newError(ErrDatabase, err, "nie udało się zmienić nazwy pliku")
 	}
 
 	db.logger.Debugf("Baza danych zapisana do %s", db.filePath)
@@ -144,39 +275,222 @@ func (db *CertificateDB) AddOrUpdateUser(userCert UserCertificate) error {
 	defer db.mutex.Unlock()
 
 	db.Users[userCert.CommonName] = userCert
-	db.logger.Infof("Dodano/zaktualizowano użytkownika: %s, serial: %s", userCert.CommonName, userCert.SerialNumber)
+	db.logger.WithFields(logrus.Fields{FieldCommonName: userCert.CommonName, FieldSerial: userCert.SerialNumber}).Infof("Dodano/zaktualizowano użytkownika: %s, serial: %s", userCert.CommonName, userCert.SerialNumber)
 	return nil
 }
 
-// CheckCertificateExpiry sprawdza czy certyfikat wymaga odnowienia
-func (db *CertificateDB) CheckCertificateExpiry(commonName string, daysThreshold int) (bool, float64, error) {
+// CheckCertificateExpiry sprawdza czy certyfikat wymaga odnowienia według progu (od ostatniego odnowienia do wygaśnięcia)
+func (db *CertificateDB) CheckCertificateExpiry(commonName string, threshold RenewalThreshold) (bool, float64, error) {
 	user, exists := db.GetUser(commonName)
 	if !exists {
-		return false, 0, fmt.Errorf("użytkownik %s nie istnieje w bazie danych", commonName)
+		return false, 0, newError(ErrNotFound, nil, "użytkownik %s nie istnieje w bazie danych", commonName)
 	}
 
 	daysUntilExpiry := time.Until(user.ExpiresAt).Hours() / 24
-	needsRenewal := daysUntilExpiry < float64(daysThreshold)
+	needsRenewal := threshold.NeedsRenewal(user.LastRenewed, user.ExpiresAt, time.Now())
 
 	return needsRenewal, daysUntilExpiry, nil
 }
 
-// UpdateCertificateInfo aktualizuje informacje o certyfikacie po odnowieniu
-func (db *CertificateDB) UpdateCertificateInfo(commonName, serialNumber string, expiresAt time.Time) error {
+// UpdateCertificateInfo aktualizuje informacje o certyfikacie po odnowieniu; issuer to montowanie PKI nowego certyfikatu
+func (db *CertificateDB) UpdateCertificateInfo(commonName, serialNumber string, expiresAt time.Time, issuer *Issuer) error {
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
 
 	user, exists := db.Users[commonName]
 	if !exists {
-		return fmt.Errorf("użytkownik %s nie istnieje w bazie danych", commonName)
+		return newError(ErrNotFound, nil, "użytkownik %s nie istnieje w bazie danych", commonName)
 	}
 
 	user.SerialNumber = serialNumber
 	user.ExpiresAt = expiresAt
+	user.Issuer = issuer
 	user.LastRenewed = time.Now()
+	// Nowy certyfikat - historia przypomnień dotyczy już poprzedniego numeru seryjnego
+	user.RenewalBlocked = ""
+	user.Reminders = nil
+	user.RevokedAt = nil
+	db.Users[commonName] = user
+
+	db.logger.WithFields(logrus.Fields{FieldCommonName: commonName, FieldSerial: serialNumber}).Infof("Zaktualizowano informacje o certyfikacie dla %s, nowy serial: %s", commonName, serialNumber)
+	return nil
+}
+
+// RecordReminder zapisuje wysłanie przypomnienia dla bieżącego certyfikatu użytkownika
+func (db *CertificateDB) RecordReminder(commonName, kind string, offsetDays int) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	user, exists := db.Users[commonName]
+	if !exists {
+		return newError(ErrNotFound, nil, "użytkownik %s nie istnieje w bazie danych", commonName)
+	}
+
+	user.Reminders = append(user.Reminders, ReminderRecord{
+		Kind:         kind,
+		OffsetDays:   offsetDays,
+		SerialNumber: user.SerialNumber,
+		SentAt:       time.Now(),
+	})
+	db.Users[commonName] = user
+	return nil
+}
+
+// MarkRenewalBlocked zapisuje powód nieudanego odnowienia certyfikatu
+func (db *CertificateDB) MarkRenewalBlocked(commonName, reason string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	user, exists := db.Users[commonName]
+	if !exists {
+		return newError(ErrNotFound, nil, "użytkownik %s nie istnieje w bazie danych", commonName)
+	}
+
+	user.RenewalBlocked = reason
+	db.Users[commonName] = user
+	db.logger.WithField(FieldCommonName, commonName).Infof("Zablokowano automatyczne odnawianie dla %s: %s", commonName, reason)
+	return nil
+}
+
+// MarkRevoked oznacza certyfikat użytkownika jako odwołany
+func (db *CertificateDB) MarkRevoked(commonName string, revokedAt time.Time) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	user, exists := db.Users[commonName]
+	if !exists {
+		return newError(ErrNotFound, nil, "użytkownik %s nie istnieje w bazie danych", commonName)
+	}
+
+	user.RevokedAt = &revokedAt
+	db.Users[commonName] = user
+	db.logger.WithFields(logrus.Fields{FieldCommonName: commonName, FieldSerial: user.SerialNumber}).Infof("Oznaczono certyfikat %s (serial: %s) jako odwołany", commonName, user.SerialNumber)
+	return nil
+}
+
+// AddPendingRevocation zapisuje zastąpiony certyfikat użytkownika do późniejszego odwołania
+func (db *CertificateDB) AddPendingRevocation(commonName string, pending PendingRevocation) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	user, exists := db.Users[commonName]
+	if !exists {
+		return newError(ErrNotFound, nil, "użytkownik %s nie istnieje w bazie danych", commonName)
+	}
+
+	user.PendingRevocations = append(user.PendingRevocations, pending)
 	db.Users[commonName] = user
+	db.logger.WithFields(logrus.Fields{FieldCommonName: commonName, FieldSerial: pending.SerialNumber}).Infof("Certyfikat %s (serial: %s) zostanie odwołany po %s", commonName, pending.SerialNumber, pending.RevokeAfter.Format(time.RFC3339))
+	return nil
+}
+
+// ResolvePendingRevocation usuwa odwołany certyfikat z listy oczekujących albo zapisuje błąd nieudanej próby
+func (db *CertificateDB) ResolvePendingRevocation(commonName, serialNumber string, revokeErr error) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	user, exists := db.Users[commonName]
+	if !exists {
+		return newError(ErrNotFound, nil, "użytkownik %s nie istnieje w bazie danych", commonName)
+	}
+
+	// Nowa lista - kopie z GetUser i GetAllUsers współdzielą tablicę, po której iterują wywołujący
+	var pending []PendingRevocation
+	for _, entry := range user.PendingRevocations {
+		if NormalizeSerial(entry.SerialNumber) == NormalizeSerial(serialNumber) {
+			if revokeErr == nil {
+				continue
+			}
+			entry.LastError = revokeErr.Error()
+		}
+		pending = append(pending, entry)
+	}
+	user.PendingRevocations = pending
+	db.Users[commonName] = user
+	return nil
+}
+
+// MarkSerialSeen zapisuje połączenie certyfikatem użytkownika lub urządzenia (common name z certyfikatu) o podanym numerze
+// seryjnym. Połączenie bieżącym certyfikatem kończy okres przejściowy poprzednich - zwraca liczbę certyfikatów,
+// które można od razu odwołać.
+func (db *CertificateDB) MarkSerialSeen(commonName, serialNumber string, seenAt time.Time) (int, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	user, exists := db.Users[commonName]
+	device, current := "", user.SerialNumber
+	if !exists {
+		owner, found, ok := db.findDevice(commonName)
+		if !ok {
+			return 0, newError(ErrNotFound, nil, "certyfikat %s nie istnieje w bazie danych", commonName)
+		}
+		user, device, current = db.Users[owner], found.Name, found.SerialNumber
+	}
+	if NormalizeSerial(current) != NormalizeSerial(serialNumber) {
+		// Połączenie starym certyfikatem - okres przejściowy trwa dalej
+		return 0, nil
+	}
+
+	due := 0
+	user.PendingRevocations = slices.Clone(user.PendingRevocations)
+	for i, pending := range user.PendingRevocations {
+		if pending.Device == device && pending.RevokeAfter.After(seenAt) {
+			user.PendingRevocations[i].RevokeAfter = seenAt
+			due++
+		}
+	}
+	db.Users[user.CommonName] = user
+	return due, nil
+}
+
+// GetDevice pobiera certyfikat urządzenia użytkownika
+func (db *CertificateDB) GetDevice(commonName, deviceName string) (*DeviceCertificate, bool) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	device, exists := db.Users[commonName].Devices[deviceName]
+	if !exists {
+		return nil, false
+	}
+	return &device, true
+}
+
+// FindDevice wyszukuje certyfikat urządzenia po jego common name i zwraca go razem z common name właściciela
+func (db *CertificateDB) FindDevice(deviceCommonName string) (string, *DeviceCertificate, bool) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.findDevice(deviceCommonName)
+}
 
-	db.logger.Infof("Zaktualizowano informacje o certyfikacie dla %s, nowy serial: %s", commonName, serialNumber)
+// findDevice to FindDevice bez blokady - wywołujący trzyma mutex
+func (db *CertificateDB) findDevice(deviceCommonName string) (string, *DeviceCertificate, bool) {
+	for owner, user := range db.Users {
+		for _, device := range user.Devices {
+			if device.CommonName == deviceCommonName {
+				return owner, &device, true
+			}
+		}
+	}
+	return "", nil, false
+}
+
+// AddOrUpdateDevice dodaje lub aktualizuje certyfikat urządzenia istniejącego użytkownika
+func (db *CertificateDB) AddOrUpdateDevice(commonName string, device DeviceCertificate) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	user, exists := db.Users[commonName]
+	if !exists {
+		return newError(ErrNotFound, nil, "użytkownik %s nie istnieje w bazie danych", commonName)
+	}
+
+	// Nowa mapa - kopie z GetAllUsers nie mogą widzieć zmian wprowadzanych pod blokadą
+	devices := make(map[string]DeviceCertificate, len(user.Devices)+1)
+	maps.Copy(devices, user.Devices)
+	devices[device.Name] = device
+	user.Devices = devices
+	db.Users[commonName] = user
+	db.logger.WithFields(logrus.Fields{FieldCommonName: device.CommonName, FieldSerial: device.SerialNumber}).Infof("Dodano/zaktualizowano urządzenie %s użytkownika %s, serial: %s", device.Name, commonName, device.SerialNumber)
 	return nil
 }
 
@@ -199,11 +513,11 @@ func (db *CertificateDB) DeleteUser(commonName string) error {
 	defer db.mutex.Unlock()
 
 	if _, exists := db.Users[commonName]; !exists {
-		return fmt.Errorf("użytkownik %s nie istnieje w bazie danych", commonName)
+		return newError(ErrNotFound, nil, "użytkownik %s nie istnieje w bazie danych", commonName)
 	}
 
 	delete(db.Users, commonName)
-	db.logger.Infof("Usunięto użytkownika: %s", commonName)
+	db.logger.WithField(FieldCommonName, commonName).Infof("Usunięto użytkownika: %s", commonName)
 	return nil
 }
 
@@ -225,7 +539,7 @@ func (db *CertificateDB) AddOrUpdateServerCertificate(serverCert ServerCertifica
 	defer db.mutex.Unlock()
 
 	db.Servers[serverCert.CommonName] = serverCert
-	db.logger.Infof("Dodano/zaktualizowano certyfikat serwera: %s", serverCert.CommonName)
+	db.logger.WithFields(logrus.Fields{FieldCommonName: serverCert.CommonName, FieldSerial: serverCert.SerialNumber}).Infof("Dodano/zaktualizowano certyfikat serwera: %s", serverCert.CommonName)
 	return nil
 }
 
@@ -248,10 +562,44 @@ func (db *CertificateDB) DeleteServerCertificate(commonName string) error {
 	defer db.mutex.Unlock()
 
 	if _, exists := db.Servers[commonName]; !exists {
-		return fmt.Errorf("certyfikat serwera %s nie istnieje w bazie danych", commonName)
+		return newError(ErrNotFound, nil, "certyfikat serwera %s nie istnieje w bazie danych", commonName)
 	}
 
 	delete(db.Servers, commonName)
-	db.logger.Infof("Usunięto certyfikat serwera: %s", commonName)
+	db.logger.WithField(FieldCommonName, commonName).Infof("Usunięto certyfikat serwera: %s", commonName)
 	return nil
-}
\ No newline at end of file
+}
+
+// GetCARotation zwraca rotację CA montowania PKI (ostatnią, także zakończoną)
+func (db *CertificateDB) GetCARotation(mount string) (*CARotation, bool) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	rotation, exists := db.CARotations[mount]
+	if !exists {
+		return nil, false
+	}
+	rotation.Routers = maps.Clone(rotation.Routers)
+	rotation.Servers = maps.Clone(rotation.Servers)
+	rotation.Failures = maps.Clone(rotation.Failures)
+	return &rotation, true
+}
+
+// SaveCARotation zapisuje postęp rotacji CA montowania PKI
+func (db *CertificateDB) SaveCARotation(rotation CARotation) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.CARotations == nil {
+		db.CARotations = make(map[string]CARotation)
+	}
+	db.CARotations[rotation.Mount] = rotation
+}
+
+// GetAllCARotations zwraca rotacje CA wszystkich montowań
+func (db *CertificateDB) GetAllCARotations() map[string]CARotation {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	return maps.Clone(db.CARotations)
+}
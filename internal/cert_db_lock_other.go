@@ -0,0 +1,10 @@
+//go:build !unix
+
+package internal
+
+import "os"
+
+// lockFile nie blokuje pliku poza systemami uniksowymi - skrypty client-connect OpenVPN działają na serwerach Linux
+func lockFile(file *os.File) error {
+	return nil
+}
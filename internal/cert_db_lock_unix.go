@@ -0,0 +1,13 @@
+//go:build unix
+
+package internal
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile czeka na wyłączną blokadę flock pliku
+func lockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"github.com/go-routeros/routeros/v3"
 	"github.com/sirupsen/logrus"
-	"log"
 	"regexp"
 	"strconv"
 	"time"
@@ -48,13 +47,13 @@ func (cm *CertManager) ParseDuration(input string) (float64, error) {
 func (cm *CertManager) RenewCert(routerOs RouterOS, certVal map[string]string) (err error) {
 	certName := certVal["name"]
 	if _, err := routerOs.Cmd([]string{"/certificate/issued-revoke", "=numbers=" + certName}); err != nil {
-		log.Fatalf("Błąd podczas wykonywania komendy: %v", err)
+		return newError(ErrRouterCommand, err, "błąd podczas odwoływania certyfikatu %s", certName)
 	}
 	systemDate := time.Now().Format("2006-01-02")
 	newCertName := certName + "-revoked-" + systemDate
 	_, err = routerOs.Cmd([]string{"/certificate/set", "=name=" + newCertName, "=.id=" + certName})
 	if err != nil {
-		return err
+		return newError(ErrRouterCommand, err, "błąd podczas zmiany nazwy certyfikatu %s", certName)
 	}
 	cm.logger.Infof("Certificate %s revoked with name %s", certName, newCertName)
 
@@ -70,14 +69,14 @@ func (cm *CertManager) RenewCert(routerOs RouterOS, certVal map[string]string) (
 		"=subject-alt-name=" + subjectAltName,
 	})
 	if err != nil {
-		return err
+		return newError(ErrRouterCommand, err, "błąd podczas tworzenia certyfikatu %s", certName)
 	}
 	cm.logger.Infof("New certificate %s created", certName)
 
 	caCert := certVal["ca"]
 
 	if certName == "" || caCert == "" {
-		return fmt.Errorf("Nie udało się pobrać wymaganych danych z certyfikatu.")
+		return newError(ErrNotFound, nil, "nie udało się pobrać wymaganych danych z certyfikatu")
 	}
 
 	_, err = routerOs.Cmd([]string{
@@ -87,42 +86,48 @@ func (cm *CertManager) RenewCert(routerOs RouterOS, certVal map[string]string) (
 		"=.id=" + certName,
 	})
 	if err != nil {
-		log.Fatalf("Błąd podczas podpisywania certyfikatu: %v", err)
+		return newError(ErrRouterCommand, err, "błąd podczas podpisywania certyfikatu %s", certName)
 	}
 	cm.logger.Infof("Certificate %s was signed with CA", certName)
 
 	return nil
 }
 
-func (cm *CertManager) GetCert(client RouterOS, certName string) map[string]string {
+func (cm *CertManager) GetCert(client RouterOS, certName string) (map[string]string, error) {
 	res, err := client.Cmd([]string{"/certificate/print", "?name=" + certName})
 	if err != nil {
-		log.Fatalf("Błąd podczas wykonywania komendy: %v", err)
+		return nil, newError(ErrRouterCommand, err, "błąd podczas pobierania certyfikatu %s", certName)
 	}
-	return res[0]
+	if len(res) == 0 {
+		return nil, newError(ErrNotFound, nil, "certyfikat %s nie został znaleziony", certName)
+	}
+	return res[0], nil
 }
 
-func (cm *CertManager) ReadCert(client *routeros.Client, fileName string) string {
+func (cm *CertManager) ReadCert(client *routeros.Client, fileName string) (string, error) {
 	cm.logger.Infof("Reading cert file %s", fileName)
 	reply, err := client.Run("/file/print", "?name="+fileName)
 	if err != nil {
-		log.Fatalf("Błąd podczas pobierania szczegółów pliku: %v", err)
+		return "", newError(ErrRouterCommand, err, "błąd podczas pobierania szczegółów pliku %s", fileName)
 	}
 
 	if len(reply.Re) == 0 {
-		log.Fatalf("Plik %s nie został znaleziony", fileName)
+		return "", newError(ErrNotFound, nil, "plik %s nie został znaleziony", fileName)
 	}
 
 	fileSize, err := strconv.Atoi(reply.Re[0].Map["size"])
 	if err != nil {
-		log.Fatalf("Błąd podczas konwersji rozmiaru pliku: %v", err)
+		return "", newError(ErrRouterCommand, err, "błąd podczas konwersji rozmiaru pliku %s", fileName)
 	}
 
 	chunkSize := fileSize // Możesz dostosować chunk-size w zależności od potrzeb
 	chunkReply, err := client.Run("/file/read", "=file="+fileName, "=chunk-size="+strconv.Itoa(chunkSize))
 	if err != nil {
-		log.Fatalf("Błąd podczas odczytu pliku: %v", err)
+		return "", newError(ErrRouterCommand, err, "błąd podczas odczytu pliku %s", fileName)
+	}
+	if len(chunkReply.Re) == 0 {
+		return "", newError(ErrRouterCommand, nil, "router nie zwrócił zawartości pliku %s", fileName)
 	}
 
-	return chunkReply.Re[0].Map["data"]
+	return chunkReply.Re[0].Map["data"], nil
 }
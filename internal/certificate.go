@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// ParseCertificatePEM dekoduje pierwszy certyfikat z bloku PEM
+func ParseCertificatePEM(certPEM string) (*x509.Certificate, error) {
+	rest := []byte(certPEM)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, fmt.Errorf("nie udało się zdekodować certyfikatu PEM")
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("nie udało się sparsować certyfikatu: %w", err)
+		}
+		return cert, nil
+	}
+}
+
+// CertificateFingerprint zwraca odcisk SHA-256 certyfikatu w postaci szesnastkowej (małe litery, bez separatorów)
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// FormatFingerprint formatuje odcisk do czytelnej postaci AA:BB:CC:...
+func FormatFingerprint(fingerprint string) string {
+	fingerprint = strings.ToUpper(fingerprint)
+	var parts []string
+	for i := 0; i+2 <= len(fingerprint); i += 2 {
+		parts = append(parts, fingerprint[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+// FormatSerial formatuje numer seryjny tak jak Vault: małe litery, bajty oddzielone dwukropkami
+func FormatSerial(serial *big.Int) string {
+	raw := hex.EncodeToString(serial.Bytes())
+	var parts []string
+	for i := 0; i+2 <= len(raw); i += 2 {
+		parts = append(parts, raw[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+// certificateTTL wylicza TTL certyfikatu w godzinach (w formacie flagi --ttl) z okresu ważności
+func certificateTTL(notBefore, notAfter time.Time) string {
+	return fmt.Sprintf("%dh", int(notAfter.Sub(notBefore).Round(time.Hour).Hours()))
+}
+
+// containsPrivateKey sprawdza, czy tekst PEM zawiera jakikolwiek klucz prywatny
+func containsPrivateKey(data string) bool {
+	rest := []byte(data)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return strings.Contains(data, "PRIVATE KEY")
+		}
+		if strings.Contains(block.Type, "PRIVATE KEY") {
+			return true
+		}
+	}
+}
+
+// ParseCertificateChainPEM dekoduje wszystkie certyfikaty z bloku PEM (np. łańcuch CA) w kolejności wystąpienia
+func ParseCertificateChainPEM(chainPEM string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := []byte(chainPEM)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("nie udało się sparsować certyfikatu łańcucha: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+}
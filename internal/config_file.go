@@ -0,0 +1,332 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigFile to plik konfiguracji wczytywany, jeśli istnieje, a nie podano --config ani PINPOINT_CONFIG
+const DefaultConfigFile = "pinpoint.yaml"
+
+// Domyślne ścieżki przechowywania danych
+const (
+	DefaultCertDBPath = "certificates.json"
+	DefaultOutputDir  = "conf"
+)
+
+// envNamePattern opisuje poprawną nazwę zmiennej w sekcji env pliku konfiguracji
+var envNamePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// FileConfig to zawartość pliku konfiguracji YAML. Każde pole odpowiada zmiennej środowiskowej (tag env):
+// wartości z pliku są ustawiane tylko dla zmiennych, których nie ustawiono w środowisku ani w .env,
+// dzięki czemu zmienne środowiskowe i flagi zawsze mają pierwszeństwo przed plikiem.
+type FileConfig struct {
+	Vault      vaultSection      `yaml:"vault"`
+	PKI        pkiSection        `yaml:"pki"`
+	SMTP       smtpSection       `yaml:"smtp"`
+	Routers    routersSection    `yaml:"routers"`
+	Templates  templatesSection  `yaml:"templates"`
+	Thresholds thresholdsSection `yaml:"thresholds"`
+	Storage    storageSection    `yaml:"storage"`
+	// Policies to polityki odnawiania grup użytkowników i serwerów (nie mają odpowiedników w zmiennych)
+	Policies PolicyConfig `yaml:"policies"`
+	// Env to pozostałe zmienne (powiadomienia, LDAP, API, portal, logi) w postaci NAZWA: wartość
+	Env map[string]string `yaml:"env"`
+	// Environments to nazwane środowiska (np. prod, staging) wybierane przez --env;
+	// sekcje środowiska nadpisują pola konfiguracji bazowej
+	Environments map[string]yaml.Node `yaml:"environments"`
+
+	// Path to ścieżka wczytanego pliku, Environment to wybrane środowisko
+	Path        string `yaml:"-"`
+	Environment string `yaml:"-"`
+}
+
+// vaultSection to sekcja vault pliku konfiguracji: adres, przestrzeń nazw i dane logowania AppRole do Vault
+type vaultSection struct {
+	Address   string `yaml:"address" env:"VAULT_ADDR"`
+	Namespace string `yaml:"namespace" env:"VAULT_NAMESPACE"`
+	RoleID    string `yaml:"role_id" env:"VAULT_ROLE_ID"`
+	SecretID  string `yaml:"secret_id" env:"VAULT_SECRET_ID"`
+}
+
+// pkiSection to sekcja pki pliku konfiguracji: domyślne montowanie PKI, role, domyślne czasy ważności
+// i dodatkowe nazwane montowania (np. pośrednie CA innych lokalizacji)
+type pkiSection struct {
+	Path       string `yaml:"path" env:"VAULT_PKI_PATH"`
+	ClientRole string `yaml:"client_role" env:"VAULT_ROLE"`
+	ServerRole string `yaml:"server_role" env:"VAULT_SERVER_ROLE"`
+	ClientTTL  string `yaml:"client_ttl" env:"CLIENT_TTL"`
+	ServerTTL  string `yaml:"server_ttl" env:"SERVER_TTL"`
+	MaxTTL     string `yaml:"max_ttl" env:"MAX_TTL"`
+	// Mounts nie ma odpowiednika w zmiennych środowiskowych
+	Mounts map[string]PKIMount `yaml:"mounts"`
+}
+
+// smtpSection to sekcja smtp pliku konfiguracji: ustawienia serwera SMTP
+type smtpSection struct {
+	Host     string `yaml:"host" env:"SMTP_HOST"`
+	Port     string `yaml:"port" env:"SMTP_PORT"`
+	User     string `yaml:"user" env:"SMTP_USER"`
+	Password string `yaml:"password" env:"SMTP_PASSWORD"`
+	From     string `yaml:"from" env:"SMTP_FROM"`
+	TLS      string `yaml:"tls" env:"SMTP_TLS"`
+	CAFile   string `yaml:"ca_file" env:"SMTP_CA_FILE"`
+	Timeout  string `yaml:"timeout" env:"SMTP_TIMEOUT"`
+	DryRun   string `yaml:"dry_run" env:"SMTP_DRY_RUN"`
+	Disabled string `yaml:"disabled" env:"DISABLE_EMAIL"`
+}
+
+// routersSection to sekcja routers pliku konfiguracji: dane logowania i porty routerów Mikrotik
+type routersSection struct {
+	Username string `yaml:"username" env:"MIKROTIK_USERNAME"`
+	Password string `yaml:"password" env:"MIKROTIK_PASSWORD"`
+	APIPort  string `yaml:"api_port" env:"MIKROTIK_API_PORT"`
+	FTPPort  string `yaml:"ftp_port" env:"MIKROTIK_FTP_PORT"`
+}
+
+// templatesSection to sekcja templates pliku konfiguracji: szablony i treść wiadomości email
+type templatesSection struct {
+	Dir           string `yaml:"dir" env:"MAIL_TEMPLATES_DIR"`
+	DefaultLocale string `yaml:"default_locale" env:"MAIL_DEFAULT_LOCALE"`
+	Organization  string `yaml:"organization" env:"MAIL_ORGANIZATION"`
+	ServerName    string `yaml:"server_name" env:"MAIL_SERVER_NAME"`
+	Subject       string `yaml:"subject" env:"MAIL_SUBJECT"`
+}
+
+// thresholdsSection to sekcja thresholds pliku konfiguracji: progi odnawiania, okres przejściowy, przypomnienia
+// i ostrzeżenia o wygasających CA
+type thresholdsSection struct {
+	Renewal         string `yaml:"renewal" env:"RENEWAL_THRESHOLD"`
+	GracePeriod     string `yaml:"grace_period" env:"RENEWAL_GRACE_PERIOD"`
+	ReminderOffsets string `yaml:"reminder_offsets" env:"REMINDER_OFFSETS"`
+	EscalationEmail string `yaml:"escalation_email" env:"REMINDER_ESCALATION_EMAIL"`
+	EscalationDelay string `yaml:"escalation_delay" env:"REMINDER_ESCALATION_DELAY"`
+	CAExpiryWarning string `yaml:"ca_expiry_warning" env:"CA_EXPIRY_WARNING"`
+	CACheckInterval string `yaml:"ca_check_interval" env:"CA_CHECK_INTERVAL"`
+}
+
+// storageSection to sekcja storage pliku konfiguracji: ścieżki bazy certyfikatów, profili i metryk
+type storageSection struct {
+	CertDB          string `yaml:"cert_db" env:"CERT_DB"`
+	OutputDir       string `yaml:"output_dir" env:"OUTPUT_DIR"`
+	MetricsTextfile string `yaml:"metrics_textfile" env:"METRICS_TEXTFILE"`
+}
+
+// LoadConfigFile wczytuje plik konfiguracji i nakłada na niego wybrane środowisko.
+// Pusta ścieżka oznacza PINPOINT_CONFIG, a gdy i ta jest pusta - opcjonalny plik pinpoint.yaml;
+// jeśli opcjonalny plik nie istnieje, zwracane jest nil bez błędu.
+func LoadConfigFile(path, environment string) (*FileConfig, error) {
+	if path == "" {
+		path = os.Getenv("PINPOINT_CONFIG")
+	}
+	optional := path == ""
+	if optional {
+		path = DefaultConfigFile
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if optional && errors.Is(err, fs.ErrNotExist) {
+			if environment != "" {
+				return nil, newError(ErrInvalidConfig, nil, "wybrano środowisko %q, ale nie znaleziono pliku konfiguracji %s", environment, path)
+			}
+			return nil, nil
+		}
+		return nil, newError(ErrInvalidConfig, err, "nie udało się odczytać pliku konfiguracji")
+	}
+
+	config := &FileConfig{Path: path, Environment: environment}
+	if err := decodeConfig(data, config); err != nil {
+		return nil, newError(ErrInvalidConfig, err, "nieprawidłowy plik konfiguracji %s", path)
+	}
+
+	if environment != "" {
+		node, ok := config.Environments[environment]
+		if !ok {
+			return nil, newError(ErrInvalidConfig, nil, "plik %s nie zawiera środowiska %q (dostępne: %s)", path, environment, strings.Join(config.EnvironmentNames(), ", "))
+		}
+		overlay, err := yaml.Marshal(&node)
+		if err != nil {
+			return nil, newError(ErrInvalidConfig, err, "nieprawidłowe środowisko %q", environment)
+		}
+		// yaml dekoduje mapy do istniejącej mapy, dlatego środowiska trzeba odłączyć na czas nakładania
+		environments := config.Environments
+		config.Environments = nil
+		if err := decodeConfig(overlay, config); err != nil {
+			return nil, newError(ErrInvalidConfig, err, "nieprawidłowe środowisko %q w pliku %s", environment, path)
+		}
+		// Środowisko nie może definiować kolejnych środowisk
+		config.Environments = environments
+	}
+
+	for name := range config.Env {
+		if !envNamePattern.MatchString(name) {
+			return nil, newError(ErrInvalidConfig, nil, "nieprawidłowa nazwa zmiennej %q w sekcji env pliku %s", name, path)
+		}
+	}
+	return config, nil
+}
+
+// decodeConfig dekoduje YAML do istniejącej konfiguracji, odrzucając nieznane klucze (literówki w nazwach pól)
+func decodeConfig(data []byte, config *FileConfig) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// EnvironmentNames zwraca posortowane nazwy środowisk zdefiniowanych w pliku
+func (c *FileConfig) EnvironmentNames() []string {
+	names := make([]string, 0, len(c.Environments))
+	for name := range c.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RenewalPolicies zwraca polityki odnawiania z pliku; bez pliku konfiguracji - puste polityki
+func (c *FileConfig) RenewalPolicies() PolicyConfig {
+	if c == nil {
+		return PolicyConfig{}
+	}
+	return c.Policies
+}
+
+// PKIMounts zwraca nazwane montowania PKI z pliku; bez pliku konfiguracji - tylko montowanie domyślne ze zmiennych
+func (c *FileConfig) PKIMounts() map[string]PKIMount {
+	if c == nil {
+		return nil
+	}
+	return c.PKI.Mounts
+}
+
+// Variables zwraca zmienne środowiskowe zdefiniowane w pliku (po nałożeniu środowiska)
+func (c *FileConfig) Variables() map[string]string {
+	variables := make(map[string]string)
+	if c == nil {
+		return variables
+	}
+	sections := reflect.ValueOf(c).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		if section.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < section.NumField(); j++ {
+			name := section.Type().Field(j).Tag.Get("env")
+			if value := section.Field(j).String(); name != "" && value != "" {
+				variables[name] = value
+			}
+		}
+	}
+	for name, value := range c.Env {
+		variables[name] = value
+	}
+	return variables
+}
+
+// Apply ustawia zmienne z pliku, które w środowisku są puste lub nieustawione, i zwraca ich posortowane nazwy
+func (c *FileConfig) Apply() ([]string, error) {
+	var applied []string
+	for name, value := range c.Variables() {
+		if os.Getenv(name) != "" {
+			continue
+		}
+		if err := os.Setenv(name, value); err != nil {
+			return nil, newError(ErrInvalidConfig, err, "nie udało się ustawić zmiennej %s", name)
+		}
+		applied = append(applied, name)
+	}
+	sort.Strings(applied)
+	return applied, nil
+}
+
+// StorageConfig przechowuje ścieżki bazy certyfikatów i katalogu profili .ovpn
+type StorageConfig struct {
+	// CertDB to ścieżka bazy certyfikatów (CERT_DB, flaga --cert-db)
+	CertDB string
+	// OutputDir to katalog profili .ovpn (OUTPUT_DIR, flaga --output-dir)
+	OutputDir string
+}
+
+// LoadStorageConfigFromEnv wczytuje ścieżki przechowywania danych z wartościami domyślnymi
+func LoadStorageConfigFromEnv() StorageConfig {
+	return StorageConfig{
+		CertDB:    firstNonEmpty(os.Getenv("CERT_DB"), DefaultCertDBPath),
+		OutputDir: firstNonEmpty(os.Getenv("OUTPUT_DIR"), DefaultOutputDir),
+	}
+}
+
+// Statusy sprawdzeń polecenia config validate
+const (
+	CheckOK      = "ok"
+	CheckWarning = "warning"
+	CheckError   = "error"
+	CheckSkipped = "skipped"
+)
+
+// ConfigCheck to wynik sprawdzenia jednej sekcji konfiguracji
+type ConfigCheck struct {
+	Section string `json:"section"`
+	Status  string `json:"status"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// NewConfigCheck tworzy wynik sprawdzenia: błąd daje status error, brak błędu - ok ze szczegółami
+func NewConfigCheck(section string, err error, detail string) ConfigCheck {
+	if err != nil {
+		return ConfigCheck{Section: section, Status: CheckError, Detail: err.Error()}
+	}
+	return ConfigCheck{Section: section, Status: CheckOK, Detail: detail}
+}
+
+// configCheckColumns to kolumny raportu config validate w formacie tabeli i CSV
+var configCheckColumns = []string{"section", "status", "detail"}
+
+// WriteConfigChecks zapisuje wyniki sprawdzenia konfiguracji w wybranym formacie
+func WriteConfigChecks(w io.Writer, checks []ConfigCheck, format string) error {
+	rows := make([][]string, 0, len(checks))
+	for _, check := range checks {
+		rows = append(rows, []string{check.Section, check.Status, check.Detail})
+	}
+
+	switch format {
+	case FormatJSON:
+		if checks == nil {
+			checks = []ConfigCheck{}
+		}
+		return writeJSON(w, checks)
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(configCheckColumns); err != nil {
+			return err
+		}
+		if err := writer.WriteAll(rows); err != nil {
+			return err
+		}
+		return writer.Error()
+	case FormatTable, "":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(configCheckColumns, "\t")))
+		for _, row := range rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	}
+	return newError(ErrInvalidConfig, nil, "nieznany format raportu: %s", format)
+}
@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConfigYAML = `
+vault:
+  address: https://vault.example.com:8200
+  role_id: base-role
+pki:
+  path: pki
+  client_ttl: 8760h
+  mounts:
+    site-b:
+      path: pki-site-b
+thresholds:
+  renewal: 30d
+env:
+  LOG_LEVEL: info
+  API_LISTEN: ":8080"
+policies:
+  groups:
+    dev:
+      ttl: 720h
+environments:
+  staging:
+    vault:
+      address: https://vault-staging.example.com:8200
+    pki:
+      path: pki-staging
+    env:
+      LOG_LEVEL: debug
+    policies:
+      groups:
+        ops:
+          ttl: 168h
+`
+
+func writeTestConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pinpoint.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFileEnvironmentOverlay(t *testing.T) {
+	path := writeTestConfig(t, testConfigYAML)
+
+	config, err := LoadConfigFile(path, "staging")
+	if err != nil {
+		t.Fatalf("LoadConfigFile: %v", err)
+	}
+	want := map[string]string{
+		// Nadpisane przez środowisko
+		"VAULT_ADDR":     "https://vault-staging.example.com:8200",
+		"VAULT_PKI_PATH": "pki-staging",
+		"LOG_LEVEL":      "debug",
+		// Niezmienione pola konfiguracji bazowej
+		"VAULT_ROLE_ID":     "base-role",
+		"CLIENT_TTL":        "8760h",
+		"RENEWAL_THRESHOLD": "30d",
+		"API_LISTEN":        ":8080",
+	}
+	if got := config.Variables(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Variables() = %v, want %v", got, want)
+	}
+	if config.PKIMounts()["site-b"].Path != "pki-site-b" {
+		t.Errorf("mounts = %+v, want site-b from the base config", config.PKIMounts())
+	}
+	policies := config.RenewalPolicies()
+	if policies.Groups["dev"].TTL != "720h" || policies.Groups["ops"].TTL != "168h" {
+		t.Errorf("policies = %+v, want dev from the base config and ops from staging", policies.Groups)
+	}
+	if config.Environment != "staging" || !reflect.DeepEqual(config.EnvironmentNames(), []string{"staging"}) {
+		t.Errorf("environment %q, names %v", config.Environment, config.EnvironmentNames())
+	}
+
+	base, err := LoadConfigFile(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars := base.Variables(); vars["VAULT_ADDR"] != "https://vault.example.com:8200" || vars["LOG_LEVEL"] != "info" {
+		t.Errorf("base config picked up the environment: %v", vars)
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		environment string
+		want        string
+	}{
+		{"unknown environment", testConfigYAML, "prod", `"prod" (dostępne: staging)`},
+		{"typo in environment", testConfigYAML + "  broken:\n    vault:\n      adress: x\n", "broken", "adress"},
+		{"typo in base", "vault:\n  adress: x\n", "", "adress"},
+		{"invalid env name", "env:\n  log_level: debug\n", "", "log_level"},
+	}
+	for _, tt := range tests {
+		_, err := LoadConfigFile(writeTestConfig(t, tt.content), tt.environment)
+		if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want ErrInvalidConfig mentioning %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigFileEnvironmentCannotAddEnvironments(t *testing.T) {
+	path := writeTestConfig(t, testConfigYAML+"  nested:\n    environments:\n      other: {}\n")
+
+	config, err := LoadConfigFile(path, "nested")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := config.EnvironmentNames(); !reflect.DeepEqual(names, []string{"nested", "staging"}) {
+		t.Errorf("environments = %v, want only the ones from the base config", names)
+	}
+}
+
+func TestLoadConfigFileOptionalDefault(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("PINPOINT_CONFIG", "")
+
+	config, err := LoadConfigFile("", "")
+	if config != nil || err != nil {
+		t.Fatalf("missing optional file: config %v, err %v", config, err)
+	}
+	if _, err := LoadConfigFile("", "staging"); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("environment without file: err = %v, want ErrInvalidConfig", err)
+	}
+	if _, err := LoadConfigFile("missing.yaml", ""); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("explicit missing file: err = %v, want ErrInvalidConfig", err)
+	}
+
+	if err := os.WriteFile(DefaultConfigFile, []byte(testConfigYAML), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if config, err := LoadConfigFile("", "staging"); err != nil || config.Path != DefaultConfigFile {
+		t.Errorf("default file: config %+v, err %v", config, err)
+	}
+}
+
+func TestFileConfigApplyKeepsEnvironment(t *testing.T) {
+	config, err := LoadConfigFile(writeTestConfig(t, testConfigYAML), "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VAULT_ADDR", "https://vault-from-env.example.com")
+	for _, name := range []string{"VAULT_PKI_PATH", "LOG_LEVEL", "VAULT_ROLE_ID", "CLIENT_TTL", "RENEWAL_THRESHOLD", "API_LISTEN"} {
+		// Pusta zmienna jest traktowana jak nieustawiona; t.Setenv przywraca poprzednie wartości po teście
+		t.Setenv(name, "")
+	}
+
+	applied, err := config.Apply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.Getenv("VAULT_ADDR") != "https://vault-from-env.example.com" {
+		t.Errorf("file overrode VAULT_ADDR from the environment: %s", os.Getenv("VAULT_ADDR"))
+	}
+	if os.Getenv("LOG_LEVEL") != "debug" || os.Getenv("VAULT_PKI_PATH") != "pki-staging" {
+		t.Errorf("LOG_LEVEL %q, VAULT_PKI_PATH %q", os.Getenv("LOG_LEVEL"), os.Getenv("VAULT_PKI_PATH"))
+	}
+	want := []string{"API_LISTEN", "CLIENT_TTL", "LOG_LEVEL", "RENEWAL_THRESHOLD", "VAULT_PKI_PATH", "VAULT_ROLE_ID"}
+	if !reflect.DeepEqual(applied, want) {
+		t.Errorf("applied = %v, want %v", applied, want)
+	}
+}
@@ -0,0 +1,205 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// deviceNamePattern ogranicza nazwy urządzeń do etykiety DNS - nazwa jest częścią common name certyfikatu
+var deviceNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// DeviceCommonName zwraca common name certyfikatu urządzenia: nazwa urządzenia poprzedza common name użytkownika
+// (np. phone.jan.kowalski.client.vpn), dzięki czemu urządzenia mogą być połączone z serwerem OpenVPN jednocześnie
+func DeviceCommonName(commonName, deviceName string) string {
+	return deviceName + "." + commonName
+}
+
+// DeviceNames zwraca nazwy urządzeń użytkownika w kolejności alfabetycznej
+func (u UserCertificate) DeviceNames() []string {
+	names := make([]string, 0, len(u.Devices))
+	for name := range u.Devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DeviceRequest opisuje wydanie lub odnowienie certyfikatu urządzenia użytkownika
+type DeviceRequest struct {
+	// CommonName to common name użytkownika, do którego należy urządzenie
+	CommonName string
+	Device     string
+	// TTL nadpisuje TTL zapisany dla urządzenia i politykę użytkownika
+	TTL string
+	// Mount to nazwa montowania PKI; pusta - nowe urządzenie w montowaniu użytkownika, odnowienie w montowaniu zapisanym w bazie
+	Mount string
+	// RevokeImmediately odwołuje poprzedni certyfikat urządzenia od razu, z pominięciem RENEWAL_GRACE_PERIOD
+	RevokeImmediately bool
+}
+
+// AddDevice wydaje certyfikat nowemu urządzeniu użytkownika (lub urządzeniu wcześniej odwołanemu)
+// i wysyła profil na adres email użytkownika
+func (s *CertService) AddDevice(req DeviceRequest) (*ClientResult, error) {
+	if !deviceNamePattern.MatchString(req.Device) {
+		return nil, newError(ErrInvalidConfig, nil, "nieprawidłowa nazwa urządzenia %q (małe litery, cyfry i myślniki)", req.Device)
+	}
+	user, exists := s.certDB.GetUser(req.CommonName)
+	if !exists {
+		return nil, newError(ErrNotFound, nil, "użytkownik %s nie istnieje w bazie danych", req.CommonName)
+	}
+	if user.IsRevoked() {
+		return nil, newError(ErrInvalidConfig, nil, "certyfikat użytkownika %s został odwołany - wydaj go ponownie (client issue)", req.CommonName)
+	}
+	if device, exists := user.Devices[req.Device]; exists && !device.IsRevoked() {
+		return nil, newError(ErrInvalidConfig, nil, "urządzenie %s użytkownika %s już istnieje (użyj device renew)", req.Device, req.CommonName)
+	}
+	deviceCommonName := DeviceCommonName(req.CommonName, req.Device)
+	if _, exists := s.certDB.GetUser(deviceCommonName); exists {
+		return nil, newError(ErrInvalidConfig, nil, "common name %s jest już używany przez innego użytkownika", deviceCommonName)
+	}
+
+	vc := s.vault.ForIssuer(user.Issuer)
+	if req.Mount != "" {
+		var err error
+		if vc, err = s.vault.Mount(req.Mount); err != nil {
+			return nil, err
+		}
+	}
+	return s.issueDevice(vc, *user, DeviceCertificate{Name: req.Device, CommonName: deviceCommonName, CreatedAt: time.Now()}, req.TTL, req.RevokeImmediately)
+}
+
+// RenewDevice wydaje nowy certyfikat urządzenia; poprzedni jest odwoływany tak jak przy odnowieniu certyfikatu użytkownika
+func (s *CertService) RenewDevice(req DeviceRequest) (*ClientResult, error) {
+	user, exists := s.certDB.GetUser(req.CommonName)
+	if !exists {
+		return nil, newError(ErrNotFound, nil, "użytkownik %s nie istnieje w bazie danych", req.CommonName)
+	}
+	device, exists := user.Devices[req.Device]
+	if !exists {
+		return nil, newError(ErrNotFound, nil, "urządzenie %s użytkownika %s nie istnieje w bazie danych", req.Device, req.CommonName)
+	}
+	if user.IsRevoked() || device.IsRevoked() {
+		return nil, newError(ErrInvalidConfig, nil, "certyfikat urządzenia %s został odwołany - dodaj urządzenie ponownie (device add)", device.CommonName)
+	}
+
+	vc, err := s.renewalClient(req.Mount, device.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	return s.issueDevice(vc, *user, device, req.TTL, req.RevokeImmediately)
+}
+
+// issueDevice wydaje certyfikat urządzenia w montowaniu klienta vc, zapisuje go w bazie, generuje profil i wysyła go użytkownikowi
+func (s *CertService) issueDevice(vc *VaultClient, user UserCertificate, device DeviceCertificate, requestedTTL string, revokeImmediately bool) (*ClientResult, error) {
+	log := s.log(device.CommonName, "device")
+	if requestedTTL != "" {
+		if _, err := ParseDays(requestedTTL); err != nil {
+			return nil, newError(ErrInvalidConfig, err, "nieprawidłowy TTL")
+		}
+	}
+
+	policy := s.config.UserPolicy(user)
+	if device.TTL != "" {
+		policy.TTL = device.TTL
+	}
+	ttl, err := s.resolveTTL(log, vc, requestedTTL, policy, false)
+	if err != nil {
+		return nil, err
+	}
+
+	operation := "issue"
+	if device.SerialNumber != "" {
+		operation = "renew"
+	}
+	log.Infof("Generowanie certyfikatu urządzenia %s użytkownika %s", device.Name, user.CommonName)
+	certInfo, err := vc.IssueCertificate(device.CommonName, ttl)
+	countOperation(operation, "device", err)
+	if err != nil {
+		return nil, fmt.Errorf("błąd podczas generowania certyfikatu urządzenia: %w", err)
+	}
+
+	previous := device
+	device.SerialNumber = certInfo.SerialNumber
+	device.LastRenewed = time.Now()
+	device.ExpiresAt = certInfo.ExpiresAt
+	device.RevokedAt = nil
+	device.Issuer = certInfo.Issuer
+	if requestedTTL != "" || device.TTL == "" {
+		device.TTL = ttl
+	}
+	if err := s.certDB.AddOrUpdateDevice(user.CommonName, device); err != nil {
+		log.Warnf("Błąd podczas aktualizacji bazy danych: %v", err)
+	}
+	if previous.SerialNumber != "" && !previous.IsRevoked() {
+		s.retireCertificate(log, user.CommonName, device.Name, previous.SerialNumber, device.SerialNumber, previous.Issuer, revokeImmediately)
+	}
+
+	result := &ClientResult{Certificate: certInfo, Renewed: true, DaysLeft: time.Until(certInfo.ExpiresAt).Hours() / 24}
+	ovpnConfig, err := s.clientConfig(device.CommonName, result)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Email == "" {
+		log.Warnf("Brak adresu email użytkownika %s, konfiguracja urządzenia nie została wysłana", user.CommonName)
+	} else {
+		if err := s.mailer.SendProfile(device.CommonName, user.Email, user.Locale, certInfo.ExpiresAt, ovpnConfig); err != nil {
+			return result, fmt.Errorf("błąd podczas wysyłania e-maila: %w", err)
+		}
+		result.Emailed = true
+		log.Infof("Konfiguracja OpenVPN została wysłana na e-mail: %s", user.Email)
+	}
+
+	if err := s.certDB.Save(); err != nil {
+		return result, fmt.Errorf("błąd podczas zapisywania bazy danych: %w", err)
+	}
+
+	log.WithField(FieldSerial, certInfo.SerialNumber).Infof("Serial number certyfikatu: %s", certInfo.SerialNumber)
+	return result, nil
+}
+
+// RevokeDevice odwołuje certyfikat jednego urządzenia użytkownika razem z jego certyfikatami czekającymi na odwołanie
+func (s *CertService) RevokeDevice(commonName, deviceName string) error {
+	log := s.log(DeviceCommonName(commonName, deviceName), "revoke")
+	user, exists := s.certDB.GetUser(commonName)
+	if !exists {
+		return newError(ErrNotFound, nil, "użytkownik %s nie istnieje w bazie danych", commonName)
+	}
+	device, exists := user.Devices[deviceName]
+	if !exists {
+		return newError(ErrNotFound, nil, "urządzenie %s użytkownika %s nie istnieje w bazie danych", deviceName, commonName)
+	}
+	if device.IsRevoked() {
+		log.Infof("Certyfikat %s jest już odwołany", device.CommonName)
+		return nil
+	}
+
+	if err := s.revokeDeviceCertificate(commonName, device); err != nil {
+		return err
+	}
+	var pending []PendingRevocation
+	for _, entry := range user.PendingRevocations {
+		if entry.Device == deviceName {
+			pending = append(pending, entry)
+		}
+	}
+	pendingErr := s.revokePendingNow(log, commonName, pending)
+	if err := s.certDB.Save(); err != nil {
+		return err
+	}
+	return pendingErr
+}
+
+// revokeDeviceCertificate odwołuje bieżący certyfikat urządzenia w Vault i oznacza go w bazie
+func (s *CertService) revokeDeviceCertificate(commonName string, device DeviceCertificate) error {
+	err := s.vault.ForIssuer(device.Issuer).RevokeCertificate(device.SerialNumber)
+	countOperation("revoke", "device", err)
+	if err != nil {
+		return err
+	}
+	revokedAt := time.Now()
+	device.RevokedAt = &revokedAt
+	return s.certDB.AddOrUpdateDevice(commonName, device)
+}
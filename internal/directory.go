@@ -0,0 +1,225 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DirectoryUser to osoba uprawniona do dostępu VPN według źródła (plik CSV/LDIF)
+type DirectoryUser struct {
+	CommonName string `json:"common_name"`
+	Email      string `json:"email,omitempty"`
+	TTL        string `json:"ttl,omitempty"`
+	Group      string `json:"group,omitempty"`
+	Locale     string `json:"locale,omitempty"`
+}
+
+// DirectoryMapping wskazuje atrybuty katalogu, z których pochodzą pola użytkownika
+type DirectoryMapping struct {
+	CommonName string
+	Email      string
+	TTL        string
+	Group      string
+	Locale     string
+	// CNSuffix jest doklejany do wartości atrybutu CN, np. "jan.kowalski" + ".client.vpn"
+	CNSuffix string
+}
+
+// DefaultDirectoryMapping to mapowanie atrybutów typowego eksportu LDAP
+var DefaultDirectoryMapping = DirectoryMapping{
+	CommonName: "uid",
+	Email:      "mail",
+	Locale:     "preferredLanguage",
+}
+
+// LoadDirectoryMappingFromEnv wczytuje mapowanie atrybutów (DIRECTORY_ATTR_*, DIRECTORY_CN_SUFFIX)
+func LoadDirectoryMappingFromEnv() DirectoryMapping {
+	mapping := DefaultDirectoryMapping
+	mapping.CommonName = firstNonEmpty(os.Getenv("DIRECTORY_ATTR_CN"), mapping.CommonName)
+	mapping.Email = firstNonEmpty(os.Getenv("DIRECTORY_ATTR_EMAIL"), mapping.Email)
+	mapping.TTL = firstNonEmpty(os.Getenv("DIRECTORY_ATTR_TTL"), mapping.TTL)
+	mapping.Group = firstNonEmpty(os.Getenv("DIRECTORY_ATTR_GROUP"), mapping.Group)
+	mapping.Locale = firstNonEmpty(os.Getenv("DIRECTORY_ATTR_LOCALE"), mapping.Locale)
+	mapping.CNSuffix = os.Getenv("DIRECTORY_CN_SUFFIX")
+	return mapping
+}
+
+// user buduje użytkownika z atrybutów wpisu katalogu; zwraca false, jeśli brak atrybutu CN
+func (m DirectoryMapping) user(attributes map[string]string) (DirectoryUser, bool) {
+	get := func(name string) string {
+		if name == "" {
+			return ""
+		}
+		return strings.TrimSpace(attributes[strings.ToLower(name)])
+	}
+
+	commonName := get(m.CommonName)
+	if commonName == "" {
+		return DirectoryUser{}, false
+	}
+	return DirectoryUser{
+		CommonName: commonName + m.CNSuffix,
+		Email:      get(m.Email),
+		TTL:        get(m.TTL),
+		Group:      get(m.Group),
+		Locale:     get(m.Locale),
+	}, true
+}
+
+// csvColumns mapuje nazwy kolumn pliku CSV (wraz z aliasami) na pola użytkownika
+var csvColumns = map[string]string{
+	"cn":          "cn",
+	"common_name": "cn",
+	"email":       "email",
+	"mail":        "email",
+	"ttl":         "ttl",
+	"group":       "group",
+	"profile":     "group",
+	"locale":      "locale",
+	"language":    "locale",
+}
+
+// LoadUsersFile wczytuje listę użytkowników z pliku CSV lub eksportu LDIF (rozpoznawanego po rozszerzeniu .ldif)
+func LoadUsersFile(path string, mapping DirectoryMapping) ([]DirectoryUser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, newError(ErrInvalidConfig, err, "nie można otworzyć pliku %s", path)
+	}
+	defer file.Close()
+
+	var users []DirectoryUser
+	if strings.EqualFold(filepath.Ext(path), ".ldif") {
+		users, err = parseUsersLDIF(file, mapping)
+	} else {
+		users, err = parseUsersCSV(file)
+	}
+	if err != nil {
+		return nil, newError(ErrInvalidConfig, err, "nieprawidłowy plik %s", path)
+	}
+	return users, nil
+}
+
+// parseUsersCSV parsuje plik CSV z nagłówkiem; wymagana jest kolumna cn (lub common_name)
+func parseUsersCSV(r io.Reader) ([]DirectoryUser, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("brak nagłówka: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		field, known := csvColumns[strings.ToLower(strings.TrimSpace(name))]
+		if !known {
+			return nil, fmt.Errorf("nieznana kolumna %q", name)
+		}
+		columns[field] = i
+	}
+	if _, ok := columns["cn"]; !ok {
+		return nil, fmt.Errorf("brak kolumny cn")
+	}
+
+	var users []DirectoryUser
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		get := func(field string) string {
+			if i, ok := columns[field]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		user := DirectoryUser{CommonName: get("cn"), Email: get("email"), TTL: get("ttl"), Group: get("group"), Locale: get("locale")}
+		if user.CommonName == "" {
+			line, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("wiersz %d: pusta kolumna cn", line)
+		}
+		users = append(users, user)
+	}
+	return validateDirectoryUsers(users)
+}
+
+// parseUsersLDIF parsuje eksport LDIF; wpisy bez atrybutu CN (np. grupy, OU) są pomijane
+func parseUsersLDIF(r io.Reader, mapping DirectoryMapping) ([]DirectoryUser, error) {
+	// Rozwinięcie zawiniętych linii (kontynuacja zaczyna się od spacji); pusta linia kończy wpis
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(line, " ") && len(lines) > 0 && lines[len(lines)-1] != "" {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	lines = append(lines, "")
+
+	var users []DirectoryUser
+	attributes := make(map[string]string)
+	for i, line := range lines {
+		if line == "" {
+			if user, ok := mapping.user(attributes); ok {
+				users = append(users, user)
+			}
+			attributes = make(map[string]string)
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("linia %d: oczekiwano \"atrybut: wartość\"", i+1)
+		}
+		if strings.HasPrefix(value, ":") {
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("linia %d: nieprawidłowa wartość base64: %w", i+1, err)
+			}
+			value = string(decoded)
+		}
+		// Atrybuty wielowartościowe - liczy się pierwsza wartość
+		name = strings.ToLower(name)
+		if _, exists := attributes[name]; !exists {
+			attributes[name] = strings.TrimSpace(value)
+		}
+	}
+
+	return validateDirectoryUsers(users)
+}
+
+// validateDirectoryUsers odrzuca powtórzone CN i sortuje listę
+func validateDirectoryUsers(users []DirectoryUser) ([]DirectoryUser, error) {
+	seen := make(map[string]bool, len(users))
+	for _, user := range users {
+		if seen[user.CommonName] {
+			return nil, fmt.Errorf("powtórzony CN %s", user.CommonName)
+		}
+		seen[user.CommonName] = true
+		if user.TTL != "" {
+			if _, err := ParseDays(user.TTL); err != nil {
+				return nil, fmt.Errorf("%s: nieprawidłowy TTL: %w", user.CommonName, err)
+			}
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].CommonName < users[j].CommonName })
+	return users, nil
+}
@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// Rodzaje błędów zwracanych przez pakiet - do sprawdzania przez errors.Is
+var (
+	ErrNotFound          = errors.New("nie znaleziono")
+	ErrInvalidConfig     = errors.New("nieprawidłowa konfiguracja")
+	ErrVaultAuth         = errors.New("błąd uwierzytelniania w Vault")
+	ErrVault             = errors.New("błąd Vault")
+	ErrRouterUnreachable = errors.New("router niedostępny")
+	ErrRouterCommand     = errors.New("błąd polecenia RouterOS")
+	ErrEmail             = errors.New("błąd wysyłki email")
+	ErrDatabase          = errors.New("błąd bazy danych")
+	ErrDirectory         = errors.New("błąd katalogu LDAP")
+)
+
+// Error łączy rodzaj błędu z komunikatem i pierwotną przyczyną
+type Error struct {
+	Kind error
+	Msg  string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Msg + ": " + e.Err.Error()
+	}
+	return e.Msg
+}
+
+// Unwrap pozwala na errors.Is/As zarówno względem rodzaju, jak i przyczyny
+func (e *Error) Unwrap() []error {
+	if e.Err != nil {
+		return []error{e.Kind, e.Err}
+	}
+	return []error{e.Kind}
+}
+
+// newError tworzy błąd danego rodzaju; cause może być nil
+func newError(kind error, cause error, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Msg: fmt.Sprintf(format, args...), Err: cause}
+}
+
+// vaultError klasyfikuje błąd zwrócony przez API Vault (401/403 to błąd uwierzytelniania)
+func vaultError(cause error, format string, args ...interface{}) error {
+	var respErr *vault.ResponseError
+	if errors.As(cause, &respErr) && (respErr.StatusCode == 401 || respErr.StatusCode == 403) {
+		return newError(ErrVaultAuth, cause, format, args...)
+	}
+	return newError(ErrVault, cause, format, args...)
+}
+
+// IsFatal określa, czy błąd uniemożliwia dalszą pracę całej partii operacji.
+// Błędy konfiguracji, uwierzytelniania i bazy danych dotyczą wszystkich elementów, więc nie ma sensu kontynuować;
+// pozostałe (np. niedostępny router, brak certyfikatu, nieudany email) dotyczą tylko bieżącego elementu.
+func IsFatal(err error) bool {
+	return errors.Is(err, ErrInvalidConfig) || errors.Is(err, ErrVaultAuth) || errors.Is(err, ErrDatabase)
+}
@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Rodzaje kroków planu - systemy, których dotyczy operacja
+const (
+	PlanVault    = "vault"
+	PlanRouter   = "router"
+	PlanEmail    = "email"
+	PlanNotify   = "notify"
+	PlanFile     = "file"
+	PlanDatabase = "database"
+)
+
+// PlannedSerial to numer seryjny certyfikatu, który zostałby wydany w trybie dry-run
+const PlannedSerial = "dry-run"
+
+// PlanStep to pojedyncza operacja zmieniająca stan (wykonana albo zaplanowana w trybie dry-run)
+type PlanStep struct {
+	Target    string `json:"target"`
+	Operation string `json:"operation"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// Executor przepuszcza operacje zmieniające stan Vault, routerów, poczty, powiadomień, plików i bazy danych.
+// W trybie dry-run zapisuje je w planie zamiast wykonywać; operacje odczytu są wykonywane zawsze.
+// Pusty (nil) Executor wykonuje wszystko.
+type Executor struct {
+	dryRun bool
+	logger *logrus.Logger
+	mutex  sync.Mutex
+	steps  []PlanStep
+}
+
+// NewExecutor tworzy executor; dryRun włącza tryb planowania
+func NewExecutor(dryRun bool, logger *logrus.Logger) *Executor {
+	return &Executor{dryRun: dryRun, logger: logger}
+}
+
+// DryRun informuje, czy operacje są tylko planowane
+func (e *Executor) DryRun() bool {
+	return e != nil && e.dryRun
+}
+
+// Skip zapisuje krok w planie i zwraca true, jeśli operacja ma zostać pominięta (tryb dry-run).
+// Powtórzone identyczne kroki (np. kolejne zapisy bazy) trafiają do planu raz.
+func (e *Executor) Skip(step PlanStep) bool {
+	if !e.DryRun() {
+		return false
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, existing := range e.steps {
+		if existing == step {
+			return true
+		}
+	}
+	e.steps = append(e.steps, step)
+	e.logger.Infof("Dry-run: %s %s %s (nie wykonano)", step.Target, step.Operation, step.Detail)
+	return true
+}
+
+// Steps zwraca zaplanowane kroki w kolejności ich wystąpienia
+func (e *Executor) Steps() []PlanStep {
+	if e == nil {
+		return nil
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]PlanStep(nil), e.steps...)
+}
+
+// plannedCertificate generuje lokalny certyfikat zastępczy dla trybu dry-run, aby dalsze kroki
+// (profil .ovpn, wiadomości, wdrożenie na router) mogły zostać zaplanowane na realnych danych.
+// Certyfikat nie opuszcza procesu - zapis, wysyłka i import są pomijane przez executor.
+func plannedCertificate(commonName, ttl string) (certPEM, keyPEM string, expiresAt time.Time, err error) {
+	validity, err := ParseDays(ttl)
+	if err != nil {
+		return "", "", time.Time{}, newError(ErrInvalidConfig, err, "nieprawidłowy TTL %q", ttl)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("nie udało się wygenerować klucza zastępczego: %w", err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now,
+		NotAfter:     now.Add(validity),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("nie udało się wygenerować certyfikatu zastępczego: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("nie udało się zakodować klucza zastępczego: %w", err)
+	}
+
+	certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certPEM, keyPEM, template.NotAfter, nil
+}
+
+// WritePlan wypisuje zaplanowane operacje w formie tabeli
+func WritePlan(w io.Writer, steps []PlanStep) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "DRY-RUN: zaplanowano operacji: %d, żadne zmiany nie zostały wprowadzone\n", len(steps))
+	if len(steps) == 0 {
+		return tw.Flush()
+	}
+	fmt.Fprintln(tw, "TARGET\tOPERATION\tDETAIL")
+	for _, step := range steps {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", step.Target, step.Operation, step.Detail)
+	}
+	return tw.Flush()
+}
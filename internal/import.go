@@ -0,0 +1,327 @@
+package internal
+
+import (
+	"crypto/x509"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Wyniki importu pojedynczego pliku
+const (
+	ImportImported = "imported"
+	ImportUpdated  = "updated"
+	ImportSkipped  = "skipped"
+	ImportFailed   = "failed"
+)
+
+// importExtensions to rozszerzenia plików wyszukiwanych w katalogach
+var importExtensions = map[string]bool{".ovpn": true, ".pem": true, ".crt": true}
+
+// ImportResult opisuje wynik importu jednego pliku
+type ImportResult struct {
+	File         string     `json:"file"`
+	CommonName   string     `json:"common_name,omitempty"`
+	SerialNumber string     `json:"serial_number,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	Email        string     `json:"email,omitempty"`
+	Status       string     `json:"status"`
+	Detail       string     `json:"detail,omitempty"`
+}
+
+// Importer zakłada wpisy w bazie dla certyfikatów wydanych przed wdrożeniem narzędzia
+type Importer struct {
+	certDB *CertificateDB
+	vault  *VaultClient
+	logger *logrus.Logger
+}
+
+// NewImporter tworzy importer certyfikatów
+func NewImporter(certDB *CertificateDB, vault *VaultClient, logger *logrus.Logger) *Importer {
+	return &Importer{certDB: certDB, vault: vault, logger: logger}
+}
+
+// Import wczytuje certyfikaty z plików .ovpn lub PEM (pliki lub katalogi), weryfikuje je w Vault
+// i zakłada wpisy użytkowników; emails mapuje CN na adres email
+func (im *Importer) Import(paths []string, emails map[string]string) ([]ImportResult, error) {
+	files, err := collectImportFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []ImportResult
+	changed := false
+	for _, file := range files {
+		result, err := im.importFile(file, emails)
+		if err != nil {
+			// Błąd jednego pliku nie przerywa importu - poza błędami uwierzytelniania w Vault i bazy danych
+			if IsFatal(err) {
+				return results, err
+			}
+			result.Status = ImportFailed
+			result.Detail = err.Error()
+			im.logger.Warnf("Nie udało się zaimportować %s: %v", file, err)
+		}
+		if result.Status == ImportImported || result.Status == ImportUpdated {
+			changed = true
+		}
+		results = append(results, result)
+	}
+
+	if changed {
+		if err := im.certDB.Save(); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// importFile importuje certyfikat z jednego pliku
+func (im *Importer) importFile(file string, emails map[string]string) (ImportResult, error) {
+	result := ImportResult{File: file}
+
+	cert, err := readImportCertificate(file)
+	if err != nil {
+		return result, err
+	}
+	if cert == nil {
+		// Np. plik z samym kluczem prywatnym w importowanym katalogu
+		result.Status = ImportSkipped
+		result.Detail = "brak certyfikatu w pliku"
+		return result, nil
+	}
+	if cert.IsCA {
+		result.Status = ImportSkipped
+		result.Detail = "certyfikat CA"
+		return result, nil
+	}
+
+	result.CommonName = cert.Subject.CommonName
+	result.SerialNumber = FormatSerial(cert.SerialNumber)
+	result.ExpiresAt = &cert.NotAfter
+	result.Email = emails[result.CommonName]
+
+	// Plik musi pochodzić z jednego z montowań PKI - numer seryjny weryfikujemy w Vault
+	info, err := im.findCertificate(result.SerialNumber)
+	if err != nil {
+		return result, err
+	}
+	if info.CommonName != result.CommonName {
+		return result, fmt.Errorf("certyfikat %s w Vault ma CN %s zamiast %s", result.SerialNumber, info.CommonName, result.CommonName)
+	}
+	if info.IsRevoked() {
+		result.Status = ImportSkipped
+		result.Detail = fmt.Sprintf("odwołany w Vault %s", info.RevokedAt.UTC().Format(time.RFC3339))
+		return result, nil
+	}
+
+	if _, exists := im.certDB.GetServerCertificate(result.CommonName); exists {
+		result.Status = ImportSkipped
+		result.Detail = "CN należy do certyfikatu serwera"
+		return result, nil
+	}
+
+	user, exists := im.certDB.GetUser(result.CommonName)
+	if !exists {
+		err := im.certDB.AddOrUpdateUser(UserCertificate{
+			CommonName:   result.CommonName,
+			SerialNumber: result.SerialNumber,
+			Email:        result.Email,
+			CreatedAt:    cert.NotBefore,
+			LastRenewed:  cert.NotBefore,
+			ExpiresAt:    cert.NotAfter,
+			TTL:          certificateTTL(cert.NotBefore, cert.NotAfter),
+			Issuer:       info.Issuer,
+		})
+		if err != nil {
+			return result, err
+		}
+		result.Status = ImportImported
+		return result, nil
+	}
+
+	// Email z mapowania uzupełniamy także wtedy, gdy certyfikat nie wymaga zmian
+	emailAdded := false
+	if result.Email != "" && user.Email == "" {
+		user.Email = result.Email
+		if err := im.certDB.AddOrUpdateUser(*user); err != nil {
+			return result, err
+		}
+		emailAdded = true
+	}
+
+	detail := ""
+	if NormalizeSerial(user.SerialNumber) == NormalizeSerial(result.SerialNumber) {
+		detail = "certyfikat jest już w bazie"
+	} else if !cert.NotAfter.After(user.ExpiresAt) {
+		detail = "w bazie jest nowszy certyfikat"
+	}
+	if detail != "" {
+		result.Status = ImportSkipped
+		result.Detail = detail
+		if emailAdded {
+			result.Status = ImportUpdated
+			result.Detail = detail + "; uzupełniono email"
+		}
+		return result, nil
+	}
+
+	if err := im.certDB.UpdateCertificateInfo(result.CommonName, result.SerialNumber, cert.NotAfter, info.Issuer); err != nil {
+		return result, err
+	}
+	result.Status = ImportUpdated
+	result.Detail = "zastąpiono starszy certyfikat"
+	return result, nil
+}
+
+// collectImportFiles rozwija katalogi do listy plików .ovpn, .pem i .crt
+func collectImportFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, newError(ErrNotFound, err, "nie można odczytać %s", path)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && importExtensions[strings.ToLower(filepath.Ext(file))] {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, newError(ErrNotFound, err, "błąd podczas przeglądania katalogu %s", path)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// readImportCertificate odczytuje certyfikat klienta z pliku .ovpn (sekcja <cert>) lub PEM;
+// zwraca nil, jeśli plik nie zawiera żadnego certyfikatu
+func readImportCertificate(file string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, newError(ErrNotFound, err, "nie można odczytać pliku %s", file)
+	}
+
+	content := string(data)
+	// W profilu .ovpn pierwszy jest certyfikat CA - certyfikat klienta jest w sekcji <cert>
+	if start := strings.Index(content, "<cert>"); start >= 0 {
+		end := strings.Index(content, "</cert>")
+		if end < start {
+			return nil, fmt.Errorf("niezamknięta sekcja <cert> w pliku %s", file)
+		}
+		content = content[start+len("<cert>") : end]
+	}
+	if !strings.Contains(content, "-----BEGIN CERTIFICATE-----") {
+		return nil, nil
+	}
+
+	cert, err := ParseCertificatePEM(content)
+	if err != nil {
+		return nil, fmt.Errorf("plik %s nie zawiera certyfikatu: %w", file, err)
+	}
+	return cert, nil
+}
+
+// LoadEmailMapping wczytuje plik CSV "common_name,email" (nagłówek jest opcjonalny)
+func LoadEmailMapping(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, newError(ErrInvalidConfig, err, "nie można otworzyć pliku mapowania %s", path)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, newError(ErrInvalidConfig, err, "nieprawidłowy plik mapowania %s", path)
+	}
+
+	emails := make(map[string]string)
+	for i, record := range records {
+		if len(record) < 2 {
+			return nil, newError(ErrInvalidConfig, nil, "%s, wiersz %d: oczekiwano kolumn common_name,email", path, i+1)
+		}
+		commonName, email := strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+		if i == 0 && !strings.Contains(email, "@") {
+			continue // nagłówek
+		}
+		emails[commonName] = email
+	}
+	return emails, nil
+}
+
+// importColumns to kolumny raportu importu w formacie tabeli i CSV
+var importColumns = []string{"file", "common_name", "serial_number", "expires_at", "email", "status", "detail"}
+
+func (r ImportResult) row() []string {
+	expires := ""
+	if r.ExpiresAt != nil {
+		expires = r.ExpiresAt.Format("2006-01-02")
+	}
+	return []string{r.File, r.CommonName, r.SerialNumber, expires, r.Email, r.Status, r.Detail}
+}
+
+// WriteImportResults zapisuje wyniki importu w wybranym formacie
+func WriteImportResults(w io.Writer, results []ImportResult, format string) error {
+	switch format {
+	case FormatJSON:
+		if results == nil {
+			results = []ImportResult{}
+		}
+		return writeJSON(w, results)
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(importColumns); err != nil {
+			return err
+		}
+		for _, result := range results {
+			if err := writer.Write(result.row()); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case FormatTable, "":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(importColumns, "\t")))
+		for _, result := range results {
+			fmt.Fprintln(tw, strings.Join(result.row(), "\t"))
+		}
+		return tw.Flush()
+	}
+	return newError(ErrInvalidConfig, nil, "nieznany format raportu: %s", format)
+}
+
+// findCertificate szuka certyfikatu o podanym numerze seryjnym kolejno we wszystkich montowaniach PKI
+func (im *Importer) findCertificate(serialNumber string) (*CertificateInfo, error) {
+	var err error
+	for _, vc := range im.vault.Mounts() {
+		var info *CertificateInfo
+		if info, err = vc.GetCertificateInfo(serialNumber); !errors.Is(err, ErrNotFound) {
+			return info, err
+		}
+	}
+	return nil, err
+}
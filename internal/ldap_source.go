@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultLDAPFilter wybiera osoby; dla Active Directory warto wykluczyć wyłączone konta, np.
+// (&(objectClass=user)(memberOf=CN=VPN,OU=Groups,DC=example,DC=com)(!(userAccountControl:1.2.840.113556.1.4.803:=2)))
+const DefaultLDAPFilter = "(objectClass=person)"
+
+// ldapPageSize to rozmiar strony wyników (AD zwraca domyślnie najwyżej 1000 wpisów)
+const ldapPageSize = 500
+
+// DirectorySource dostarcza listę osób uprawnionych do VPN (plik, LDAP)
+type DirectorySource interface {
+	Users() ([]DirectoryUser, error)
+	// Name identyfikuje źródło w bazie - synchronizacja odwołuje tylko użytkowników z tego samego źródła
+	Name() string
+}
+
+// FileSource to lista użytkowników z pliku CSV lub LDIF
+type FileSource struct {
+	Path    string
+	Mapping DirectoryMapping
+}
+
+// Users wczytuje użytkowników z pliku
+func (f FileSource) Users() ([]DirectoryUser, error) {
+	return LoadUsersFile(f.Path, f.Mapping)
+}
+
+// Name zwraca "file:<nazwa pliku>" - ten sam plik może być importowany z różnych katalogów
+func (f FileSource) Name() string {
+	return "file:" + filepath.Base(f.Path)
+}
+
+// LDAPConfig przechowuje ustawienia połączenia z katalogiem LDAP/Active Directory
+type LDAPConfig struct {
+	URL          string
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	Filter       string
+	StartTLS     bool
+	CAFile       string
+	Mapping      DirectoryMapping
+}
+
+// LoadLDAPConfigFromEnv wczytuje konfigurację LDAP (LDAP_*) i mapowanie atrybutów (DIRECTORY_*)
+func LoadLDAPConfigFromEnv() (LDAPConfig, error) {
+	config := LDAPConfig{
+		URL:          os.Getenv("LDAP_URL"),
+		BindDN:       os.Getenv("LDAP_BIND_DN"),
+		BindPassword: os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:       os.Getenv("LDAP_BASE_DN"),
+		Filter:       firstNonEmpty(os.Getenv("LDAP_FILTER"), DefaultLDAPFilter),
+		CAFile:       os.Getenv("LDAP_CA_FILE"),
+		Mapping:      LoadDirectoryMappingFromEnv(),
+	}
+
+	startTLS, err := parseBoolEnv("LDAP_START_TLS")
+	if err != nil {
+		return config, newError(ErrInvalidConfig, err, "błąd konfiguracji LDAP")
+	}
+	config.StartTLS = startTLS
+
+	var missing []string
+	if config.URL == "" {
+		missing = append(missing, "LDAP_URL")
+	}
+	if config.BaseDN == "" {
+		missing = append(missing, "LDAP_BASE_DN")
+	}
+	if len(missing) > 0 {
+		return config, newError(ErrInvalidConfig, nil, "brak wymaganych zmiennych LDAP: %s", strings.Join(missing, ", "))
+	}
+	return config, nil
+}
+
+// LDAPSource pobiera listę użytkowników z katalogu LDAP
+type LDAPSource struct {
+	config LDAPConfig
+	logger *logrus.Logger
+}
+
+// NewLDAPSource tworzy źródło użytkowników LDAP
+func NewLDAPSource(config LDAPConfig, logger *logrus.Logger) *LDAPSource {
+	return &LDAPSource{config: config, logger: logger}
+}
+
+// Name zwraca "ldap" - zmiana serwera lub gałęzi nie odbiera synchronizacji dotychczasowych użytkowników
+func (s *LDAPSource) Name() string {
+	return "ldap"
+}
+
+// Users wyszukuje wpisy pasujące do filtra i mapuje je na użytkowników
+func (s *LDAPSource) Users() ([]DirectoryUser, error) {
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := ldap.DialURL(s.config.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, newError(ErrDirectory, err, "nie udało się połączyć z serwerem LDAP %s", s.config.URL)
+	}
+	defer conn.Close()
+
+	if s.config.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			return nil, newError(ErrDirectory, err, "nie udało się nawiązać StartTLS z serwerem LDAP")
+		}
+	}
+
+	if s.config.BindDN != "" {
+		if err := conn.Bind(s.config.BindDN, s.config.BindPassword); err != nil {
+			return nil, newError(ErrDirectory, err, "nie udało się zalogować do LDAP jako %s", s.config.BindDN)
+		}
+	}
+
+	mapping := s.config.Mapping
+	var attributes []string
+	for _, name := range []string{mapping.CommonName, mapping.Email, mapping.TTL, mapping.Group, mapping.Locale} {
+		if name != "" {
+			attributes = append(attributes, name)
+		}
+	}
+
+	request := ldap.NewSearchRequest(s.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		s.config.Filter, attributes, nil)
+	result, err := conn.SearchWithPaging(request, ldapPageSize)
+	if err != nil {
+		return nil, newError(ErrDirectory, err, "błąd wyszukiwania w LDAP (base: %s, filtr: %s)", s.config.BaseDN, s.config.Filter)
+	}
+
+	var users []DirectoryUser
+	for _, entry := range result.Entries {
+		values := make(map[string]string, len(entry.Attributes))
+		for _, attribute := range entry.Attributes {
+			if len(attribute.Values) > 0 {
+				values[strings.ToLower(attribute.Name)] = attribute.Values[0]
+			}
+		}
+		user, ok := mapping.user(values)
+		if !ok {
+			s.logger.Warnf("Pominięto wpis LDAP bez atrybutu %s: %s", mapping.CommonName, entry.DN)
+			continue
+		}
+		users = append(users, user)
+	}
+	s.logger.Infof("Pobrano z LDAP %d wpisów, użytkowników: %d", len(result.Entries), len(users))
+
+	users, err = validateDirectoryUsers(users)
+	if err != nil {
+		return nil, newError(ErrDirectory, err, "nieprawidłowe dane w katalogu LDAP")
+	}
+	return users, nil
+}
+
+// tlsConfig przygotowuje konfigurację TLS (ldaps:// i StartTLS), opcjonalnie z własnym CA
+func (s *LDAPSource) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	// StartTLS nie zna nazwy serwera z adresu połączenia - bez niej weryfikacja certyfikatu się nie powiedzie
+	if parsed, err := url.Parse(s.config.URL); err == nil {
+		tlsConfig.ServerName = parsed.Hostname()
+	}
+	if s.config.CAFile == "" {
+		return tlsConfig, nil
+	}
+
+	caPEM, err := os.ReadFile(s.config.CAFile)
+	if err != nil {
+		return nil, newError(ErrInvalidConfig, err, "nie udało się wczytać LDAP_CA_FILE")
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, newError(ErrInvalidConfig, nil, "plik LDAP_CA_FILE %s nie zawiera poprawnych certyfikatów PEM", s.config.CAFile)
+	}
+	tlsConfig.RootCAs = pool
+	return tlsConfig, nil
+}
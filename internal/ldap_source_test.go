@@ -0,0 +1,274 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// ldapStandIn to minimalny serwer LDAP w procesie testu: bind prostym hasłem i wyszukiwanie ze stronicowaniem
+type ldapStandIn struct {
+	bindDN   string
+	password string
+	entries  []ldapTestEntry
+
+	mutex      sync.Mutex
+	pages      []uint32 // rozmiary stron z kolejnych żądań wyszukiwania
+	baseDN     string
+	filter     string
+	attributes []string
+}
+
+type ldapTestEntry struct {
+	dn         string
+	attributes map[string]string
+}
+
+// start uruchamia serwer na losowym porcie i zwraca adres ldap://
+func (s *ldapStandIn) start(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return "ldap://" + listener.Addr().String()
+}
+
+func (s *ldapStandIn) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			var code uint16 = ldap.LDAPResultSuccess
+			if op.Children[1].Data.String() != s.bindDN || op.Children[2].Data.String() != s.password {
+				code = ldap.LDAPResultInvalidCredentials
+			}
+			s.reply(conn, id, ldapResult(ldap.ApplicationBindResponse, code), nil)
+		case ldap.ApplicationSearchRequest:
+			s.search(conn, id, op, packet)
+		default:
+			// Unbind i pozostałe operacje kończą połączenie
+			return
+		}
+	}
+}
+
+// search odsyła kolejną stronę wpisów; cookie to indeks pierwszego wpisu następnej strony
+func (s *ldapStandIn) search(conn net.Conn, id int64, op, packet *ber.Packet) {
+	var paging *ldap.ControlPaging
+	if len(packet.Children) > 2 {
+		for _, child := range packet.Children[2].Children {
+			if control, err := ldap.DecodeControl(child); err == nil {
+				if p, ok := control.(*ldap.ControlPaging); ok {
+					paging = p
+				}
+			}
+		}
+	}
+
+	filter, _ := ldap.DecompileFilter(op.Children[6])
+	var attributes []string
+	for _, attribute := range op.Children[7].Children {
+		attributes = append(attributes, attribute.Data.String())
+	}
+
+	start, size := 0, len(s.entries)
+	if paging != nil {
+		fmt.Sscan(string(paging.Cookie), &start)
+		size = int(paging.PagingSize)
+	}
+	s.mutex.Lock()
+	s.baseDN, s.filter, s.attributes = op.Children[0].Data.String(), filter, attributes
+	if paging != nil {
+		s.pages = append(s.pages, paging.PagingSize)
+	}
+	s.mutex.Unlock()
+
+	end := min(start+size, len(s.entries))
+	for _, entry := range s.entries[start:end] {
+		s.reply(conn, id, entryPacket(entry), nil)
+	}
+
+	var controls []ldap.Control
+	if paging != nil {
+		next := ldap.NewControlPaging(0)
+		if end < len(s.entries) {
+			next.SetCookie([]byte(fmt.Sprint(end)))
+		}
+		controls = append(controls, next)
+	}
+	s.reply(conn, id, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess), controls)
+}
+
+func (s *ldapStandIn) reply(w io.Writer, id int64, op *ber.Packet, controls []ldap.Control) {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	packet.AppendChild(op)
+	if len(controls) > 0 {
+		packet.AppendChild(ldapControls(controls))
+	}
+	w.Write(packet.Bytes())
+}
+
+func ldapControls(controls []ldap.Control) *ber.Packet {
+	packet := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "Controls")
+	for _, control := range controls {
+		packet.AppendChild(control.Encode())
+	}
+	return packet
+}
+
+func ldapResult(tag ber.Tag, code uint16) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "resultCode"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	return packet
+}
+
+func entryPacket(entry ldapTestEntry) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "objectName"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	for name, value := range entry.attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "value"))
+		attribute.AppendChild(values)
+		attributes.AppendChild(attribute)
+	}
+	packet.AppendChild(attributes)
+	return packet
+}
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func testLDAPConfig(url string) LDAPConfig {
+	return LDAPConfig{
+		URL:          url,
+		BindDN:       "cn=pinpoint,dc=example,dc=com",
+		BindPassword: "secret",
+		BaseDN:       "ou=people,dc=example,dc=com",
+		Filter:       DefaultLDAPFilter,
+		Mapping: DirectoryMapping{
+			CommonName: "uid",
+			Email:      "mail",
+			Group:      "departmentNumber",
+			Locale:     "preferredLanguage",
+			CNSuffix:   ".client.vpn",
+		},
+	}
+}
+
+func TestLDAPSourceUsersMapsAttributes(t *testing.T) {
+	server := &ldapStandIn{
+		bindDN:   "cn=pinpoint,dc=example,dc=com",
+		password: "secret",
+		entries: []ldapTestEntry{
+			{dn: "uid=jan,ou=people,dc=example,dc=com", attributes: map[string]string{
+				"uid": "jan", "Mail": " jan@example.com ", "departmentNumber": "dev", "preferredLanguage": "pl",
+			}},
+			{dn: "uid=anna,ou=people,dc=example,dc=com", attributes: map[string]string{"UID": "anna", "mail": "anna@example.com"}},
+			// Wpis bez atrybutu CN (np. grupa) jest pomijany
+			{dn: "cn=vpn,ou=groups,dc=example,dc=com", attributes: map[string]string{"cn": "vpn"}},
+		},
+	}
+	source := NewLDAPSource(testLDAPConfig(server.start(t)), testLogger())
+
+	users, err := source.Users()
+	if err != nil {
+		t.Fatalf("Users: %v", err)
+	}
+	want := map[string]DirectoryUser{
+		"jan.client.vpn":  {CommonName: "jan.client.vpn", Email: "jan@example.com", Group: "dev", Locale: "pl"},
+		"anna.client.vpn": {CommonName: "anna.client.vpn", Email: "anna@example.com"},
+	}
+	if len(users) != len(want) {
+		t.Fatalf("users = %+v, want %d entries", users, len(want))
+	}
+	for _, user := range users {
+		if user != want[user.CommonName] {
+			t.Errorf("user %s = %+v, want %+v", user.CommonName, user, want[user.CommonName])
+		}
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.baseDN != "ou=people,dc=example,dc=com" || server.filter != DefaultLDAPFilter {
+		t.Errorf("search base %q filter %q", server.baseDN, server.filter)
+	}
+	if fmt.Sprint(server.attributes) != "[uid mail departmentNumber preferredLanguage]" {
+		t.Errorf("requested attributes = %v", server.attributes)
+	}
+}
+
+func TestLDAPSourceUsersPages(t *testing.T) {
+	server := &ldapStandIn{bindDN: "cn=pinpoint,dc=example,dc=com", password: "secret"}
+	total := 2*ldapPageSize + 3
+	for i := range total {
+		uid := fmt.Sprintf("user%04d", i)
+		server.entries = append(server.entries, ldapTestEntry{
+			dn:         "uid=" + uid + ",ou=people,dc=example,dc=com",
+			attributes: map[string]string{"uid": uid, "mail": uid + "@example.com"},
+		})
+	}
+	source := NewLDAPSource(testLDAPConfig(server.start(t)), testLogger())
+
+	users, err := source.Users()
+	if err != nil {
+		t.Fatalf("Users: %v", err)
+	}
+	if len(users) != total {
+		t.Fatalf("got %d users, want %d", len(users), total)
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if len(server.pages) != 3 {
+		t.Errorf("got %d page requests, want 3", len(server.pages))
+	}
+	for _, size := range server.pages {
+		if size != ldapPageSize {
+			t.Errorf("page size %d, want %d", size, ldapPageSize)
+		}
+	}
+}
+
+func TestLDAPSourceUsersBindFailure(t *testing.T) {
+	server := &ldapStandIn{bindDN: "cn=pinpoint,dc=example,dc=com", password: "other"}
+	source := NewLDAPSource(testLDAPConfig(server.start(t)), testLogger())
+
+	_, err := source.Users()
+	if !errors.Is(err, ErrDirectory) {
+		t.Fatalf("err = %v, want ErrDirectory", err)
+	}
+}
@@ -0,0 +1,257 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Stałe nazwy pól logów strukturalnych - nie zmieniają się między wersjami i językami komunikatów
+const (
+	FieldCommonName = "cn"
+	FieldSerial     = "serial"
+	FieldRouter     = "router"
+	FieldOperation  = "operation"
+	FieldDuration   = "duration"
+	FieldSystem     = "system"
+	FieldMount      = "mount"
+)
+
+// Języki komunikatów logów
+const (
+	LogLanguagePolish  = "pl"
+	LogLanguageEnglish = "en"
+)
+
+// Formaty logów
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// LogLevels to poziomy akceptowane przez --log-level i LOG_LEVEL
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+// redacted zastępuje w logach ukryte wartości
+const redacted = "[REDACTED]"
+
+// secretEnvVars to zmienne środowiskowe, których wartości nigdy nie mogą trafić do logów
+var secretEnvVars = []string{
+	"VAULT_TOKEN", "VAULT_ROLE_ID", "VAULT_SECRET_ID",
+	"SMTP_PASSWORD", "MIKROTIK_PASSWORD", "LDAP_BIND_PASSWORD",
+	"API_TOKEN", "PORTAL_SECRET",
+	"NOTIFY_SLACK_WEBHOOK_URL", "NOTIFY_TEAMS_WEBHOOK_URL", "NOTIFY_WEBHOOK_URL",
+}
+
+var (
+	// privateKeyPattern wykrywa bloki PEM z kluczem prywatnym (także ucięte)
+	privateKeyPattern = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?(-----END [A-Z ]*PRIVATE KEY-----|$)`)
+	// secretAssignmentPattern wykrywa przypisania password=..., "secret_id": "..." i podobne
+	secretAssignmentPattern = regexp.MustCompile(`(?i)((?:password|passwd|secret|secret_id|token|private_key)["']?\s*[:=]\s*["']?)([^\s"',;&}\]]+)`)
+	// secretFieldPattern wykrywa nazwy pól logów, których wartości są zawsze ukrywane
+	secretFieldPattern = regexp.MustCompile(`(?i)(password|secret|token|private_key)`)
+)
+
+// LogConfig przechowuje ustawienia logowania
+type LogConfig struct {
+	// Level to minimalny poziom logów: debug, info, warn lub error (LOG_LEVEL)
+	Level string
+	// Format to json lub text (LOG_FORMAT)
+	Format string
+	// Language to język komunikatów: pl lub en (LOG_LANGUAGE); pola strukturalne są zawsze takie same
+	Language string
+}
+
+// LoadLogConfigFromEnv wczytuje ustawienia logowania ze zmiennych środowiskowych z wartościami domyślnymi
+func LoadLogConfigFromEnv() LogConfig {
+	config := LogConfig{
+		Level:    strings.ToLower(os.Getenv("LOG_LEVEL")),
+		Format:   strings.ToLower(os.Getenv("LOG_FORMAT")),
+		Language: strings.ToLower(os.Getenv("LOG_LANGUAGE")),
+	}
+	if config.Level == "" {
+		config.Level = "info"
+	}
+	if config.Format == "" {
+		config.Format = LogFormatJSON
+	}
+	if config.Language == "" {
+		config.Language = LogLanguagePolish
+	}
+	return config
+}
+
+// ConfigureLogger ustawia poziom, format i język logów oraz zawsze włącza ukrywanie sekretów
+func ConfigureLogger(logger *logrus.Logger, config LogConfig) error {
+	level, err := logrus.ParseLevel(config.Level)
+	if err != nil || !slices.Contains(LogLevels, config.Level) {
+		return newError(ErrInvalidConfig, nil, "nieprawidłowy poziom logów %q (dozwolone: %s)", config.Level, strings.Join(LogLevels, ", "))
+	}
+
+	var formatter logrus.Formatter
+	switch config.Format {
+	case LogFormatJSON:
+		formatter = new(logrus.JSONFormatter)
+	case LogFormatText:
+		formatter = &logrus.TextFormatter{FullTimestamp: true}
+	default:
+		return newError(ErrInvalidConfig, nil, "nieprawidłowy format logów %q (dozwolone: json, text)", config.Format)
+	}
+
+	hooks := make(logrus.LevelHooks)
+	switch config.Language {
+	case LogLanguagePolish:
+	case LogLanguageEnglish:
+		// Tłumaczenie przed ukrywaniem - sekret w argumencie komunikatu trafia do tłumaczenia bez zmian
+		catalog, err := compileLogCatalog(englishLogMessages)
+		if err != nil {
+			return err
+		}
+		hooks.Add(translationHook{catalog: catalog})
+	default:
+		return newError(ErrInvalidConfig, nil, "nieprawidłowy język logów %q (dozwolone: pl, en)", config.Language)
+	}
+	for _, name := range secretEnvVars {
+		RedactSecret(os.Getenv(name))
+	}
+	hooks.Add(redactionHook{})
+
+	logger.SetLevel(level)
+	logger.SetFormatter(formatter)
+	logger.ReplaceHooks(hooks)
+	return nil
+}
+
+// secrets to wartości zarejestrowane do ukrywania (np. token Vault uzyskany po zalogowaniu)
+var secrets struct {
+	sync.RWMutex
+	values []string
+}
+
+// RedactSecret rejestruje wartość, która będzie zastępowana w logach przez [REDACTED]
+func RedactSecret(value string) {
+	// Krótkich wartości nie ukrywamy - zamazałyby przypadkowe fragmenty komunikatów
+	if len(value) < 4 {
+		return
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, known := range secrets.values {
+		if known == value {
+			return
+		}
+	}
+	secrets.values = append(secrets.values, value)
+	// Dłuższe wartości najpierw, aby sekret zawierający inny sekret został ukryty w całości
+	sort.Slice(secrets.values, func(i, j int) bool { return len(secrets.values[i]) > len(secrets.values[j]) })
+}
+
+// Redact usuwa z tekstu klucze prywatne, wartości przypisań typu password= i zarejestrowane sekrety
+func Redact(text string) string {
+	text = privateKeyPattern.ReplaceAllString(text, "[REDACTED PRIVATE KEY]")
+	text = secretAssignmentPattern.ReplaceAllString(text, "${1}"+redacted)
+	secrets.RLock()
+	defer secrets.RUnlock()
+	for _, value := range secrets.values {
+		text = strings.ReplaceAll(text, value, redacted)
+	}
+	return text
+}
+
+// redactionHook ukrywa sekrety w komunikacie i polach każdego wpisu logu
+type redactionHook struct{}
+
+func (redactionHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (redactionHook) Fire(entry *logrus.Entry) error {
+	entry.Message = Redact(entry.Message)
+	for key, value := range entry.Data {
+		switch v := value.(type) {
+		case string:
+			if secretFieldPattern.MatchString(key) {
+				entry.Data[key] = redacted
+			} else {
+				entry.Data[key] = Redact(v)
+			}
+		case error:
+			entry.Data[key] = Redact(v.Error())
+		case fmt.Stringer:
+			entry.Data[key] = Redact(v.String())
+		}
+	}
+	return nil
+}
+
+// logTranslation to skompilowany wpis katalogu: wzorzec polskiego komunikatu i angielski format
+type logTranslation struct {
+	pattern *regexp.Regexp
+	format  string
+	literal int
+}
+
+// logVerbPattern wykrywa czasowniki formatujące używane w komunikatach logów
+var logVerbPattern = regexp.MustCompile(`%(\.\d+)?[sdvqf]`)
+
+// compileLogCatalog zamienia pary format polski - format angielski na wzorce dopasowujące gotowe komunikaty
+func compileLogCatalog(catalog map[string]string) ([]logTranslation, error) {
+	translations := make([]logTranslation, 0, len(catalog))
+	for polish, english := range catalog {
+		literals := logVerbPattern.Split(polish, -1)
+		if len(literals)-1 != len(logVerbPattern.FindAllString(english, -1)) {
+			return nil, fmt.Errorf("tłumaczenie %q ma inną liczbę argumentów niż %q", english, polish)
+		}
+		quoted := make([]string, len(literals))
+		literal := 0
+		for i, part := range literals {
+			quoted[i] = regexp.QuoteMeta(part)
+			literal += len(part)
+		}
+		translations = append(translations, logTranslation{
+			pattern: regexp.MustCompile(`(?s)^` + strings.Join(quoted, `(.*?)`) + `$`),
+			format:  english,
+			literal: literal,
+		})
+	}
+	// Najpierw najdłuższe stałe fragmenty - bardziej szczegółowy komunikat wygrywa z ogólniejszym
+	sort.Slice(translations, func(i, j int) bool {
+		if translations[i].literal != translations[j].literal {
+			return translations[i].literal > translations[j].literal
+		}
+		return translations[i].format < translations[j].format
+	})
+	return translations, nil
+}
+
+// translate zwraca angielską wersję komunikatu; komunikaty spoza katalogu zostają bez zmian
+func translate(catalog []logTranslation, message string) string {
+	for _, translation := range catalog {
+		args := translation.pattern.FindStringSubmatch(message)
+		if args == nil {
+			continue
+		}
+		i := 0
+		return logVerbPattern.ReplaceAllStringFunc(translation.format, func(string) string {
+			i++
+			return args[i]
+		})
+	}
+	return message
+}
+
+// translationHook zamienia polskie komunikaty na angielskie według katalogu englishLogMessages
+type translationHook struct {
+	catalog []logTranslation
+}
+
+func (translationHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (h translationHook) Fire(entry *logrus.Entry) error {
+	entry.Message = translate(h.catalog, entry.Message)
+	return nil
+}
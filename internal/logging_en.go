@@ -0,0 +1,206 @@
+package internal
+
+// englishLogMessages to katalog angielskich odpowiedników komunikatów logów (LOG_LANGUAGE=en).
+// Klucz to polski format przekazany do Infof/Warnf/..., wartość to angielski format z tymi samymi
+// argumentami w tej samej kolejności. Komunikaty spoza katalogu są logowane po polsku.
+var englishLogMessages = map[string]string{
+	// API i portal
+	"API: %s %s (%s)":                                    "API: %s %s (%s)",
+	"API: %s %s (%s): %v":                                "API: %s %s (%s): %v",
+	"API: odrzucono żądanie %s %s z %s: %v":              "API: rejected request %s %s from %s: %v",
+	"API: wdrożenie %s na nowy router %s (%s)":           "API: deploying %s to new router %s (%s)",
+	"Portal samoobsługowy dostępny pod %s/portal/":       "Self-service portal available at %s/portal/",
+	"Portal: %s %s %s z %s nie powiodło się: %v":         "Portal: %s %s %s from %s failed: %v",
+	"Portal: %s %s %s z %s":                              "Portal: %s %s %s from %s",
+	"Portal: nie udało się otworzyć dziennika %s: %v":    "Portal: failed to open audit log %s: %v",
+	"Portal: nie udało się wczytać strony %s: %v":        "Portal: failed to load page %s: %v",
+	"Portal: nie udało się zapisać dziennika %s: %v":     "Portal: failed to write audit log %s: %v",
+	"Portal: nie wysłano linku logowania dla %s: %v":     "Portal: login link for %s was not sent: %v",
+	"Serwer API nasłuchuje na %s":                        "API server listening on %s",
+	"Zatrzymywanie serwera API":                          "Stopping API server",
+	"Błąd podczas wypisywania planu: %v":                 "Failed to print plan: %v",
+	"Błąd podczas zapisywania metryk: %v":                "Failed to write metrics: %v",
+	"Dry-run: %s %s %s (nie wykonano)":                   "Dry-run: %s %s %s (not performed)",
+	"Wywołanie %s %s nie powiodło się: %v":               "Call %s %s failed: %v",
+	"Wywołanie %s %s zakończone":                         "Call %s %s completed",
+	"Włączono kanał powiadomień %s":                      "Enabled notification channel %s",
+	"Powiadomienie %s dostarczone do %d kanałów":         "Notification %s delivered to %d channels",
+	"Brak NOTIFY_EMAIL - pomijam powiadomienie email %s": "NOTIFY_EMAIL not set - skipping email notification %s",
+
+	// Vault
+	"Autoryzacja do Vault przez AppRole...":                                                           "Authenticating to Vault with AppRole...",
+	"Pomyślnie zalogowano do Vault (token expires in: %ds)":                                           "Logged in to Vault (token expires in: %ds)",
+	"Połączono z Vault: %s":                                                                           "Connected to Vault: %s",
+	"Generowanie nowego certyfikatu dla %s w Vault":                                                   "Issuing new certificate for %s in Vault",
+	"Generowanie nowego certyfikatu serwera dla %s w Vault":                                           "Issuing new server certificate for %s in Vault",
+	"Nowy certyfikat wygenerowany: serial=%s, expires=%s":                                             "New certificate issued: serial=%s, expires=%s",
+	"Nowy certyfikat serwera wygenerowany: serial=%s, expires=%s":                                     "New server certificate issued: serial=%s, expires=%s",
+	"Nowy certyfikat serwera wygenerowany: serial=%s":                                                 "New server certificate issued: serial=%s",
+	"Odwoływanie certyfikatu %s w Vault":                                                              "Revoking certificate %s in Vault",
+	"Certyfikat %s został odwołany":                                                                   "Certificate %s revoked",
+	"Nie udało się odwołać starego certyfikatu %s - odwołanie ponowi revoke-pending: %v":              "Failed to revoke the old certificate %s - revoke-pending will retry: %v",
+	"Nie udało się pobrać certyfikatu %s z Vault: %v":                                                 "Failed to fetch certificate %s from Vault: %v",
+	"Uzgadnianie z Vault: certyfikatów w Vault %d, wpisów w bazie %d, rozbieżności %d, naprawiono %d": "Vault reconciliation: %d certificates in Vault, %d database entries, %d discrepancies, %d fixed",
+	"Nie udało się oznaczyć %s jako odwołanego: %v":                                                   "Failed to mark %s as revoked: %v",
+	"Nie udało się poprawić daty wygaśnięcia %s: %v":                                                  "Failed to correct expiry date of %s: %v",
+	"Nie udało się zaimportować %s: %v":                                                               "Failed to import %s: %v",
+	"Nie udało się odczytać certyfikatu %s z bazy: %v":                                                "Failed to read certificate %s from the database: %v",
+
+	// Baza danych
+	"Plik bazy danych %s nie istnieje, tworzę nową bazę":               "Database file %s does not exist, creating a new database",
+	"Wczytano bazę danych z %s, użytkowników: %d":                      "Loaded database from %s, users: %d",
+	"Baza danych zapisana do %s":                                       "Database saved to %s",
+	"Dodano/zaktualizowano użytkownika: %s, serial: %s":                "Added/updated user: %s, serial: %s",
+	"Dodano/zaktualizowano urządzenie %s użytkownika %s, serial: %s":   "Added/updated device %s of user %s, serial: %s",
+	"Zaktualizowano informacje o certyfikacie dla %s, nowy serial: %s": "Updated certificate details for %s, new serial: %s",
+	"Zablokowano automatyczne odnawianie dla %s: %s":                   "Blocked automatic renewal for %s: %s",
+	"Oznaczono certyfikat %s (serial: %s) jako odwołany":               "Marked certificate %s (serial: %s) as revoked",
+	"Certyfikat %s (serial: %s) zostanie odwołany po %s":               "Certificate %s (serial: %s) will be revoked after %s",
+	"Usunięto użytkownika: %s":                                         "Removed user: %s",
+	"Dodano/zaktualizowano certyfikat serwera: %s":                     "Added/updated server certificate: %s",
+	"Usunięto certyfikat serwera: %s":                                  "Removed server certificate: %s",
+	"Błąd podczas aktualizacji bazy danych: %v":                        "Failed to update the database: %v",
+	"Błąd podczas zapisywania bazy danych: %v":                         "Failed to save the database: %v",
+	"Błąd podczas dodawania użytkownika do bazy danych: %v":            "Failed to add user to the database: %v",
+	"Błąd podczas zapisywania certyfikatu serwera w bazie danych: %v":  "Failed to save server certificate to the database: %v",
+	"Błąd podczas aktualizacji ustawień użytkownika: %v":               "Failed to update user settings: %v",
+	"Błąd podczas aktualizacji adresu IP Mikrotika: %v":                "Failed to update Mikrotik IP address: %v",
+
+	// Certyfikaty klientów
+	"Znaleziono użytkownika w bazie: %s, serial: %s":                                                           "Found user in the database: %s, serial: %s",
+	"Certyfikat wygasa za %.1f dni":                                                                            "Certificate expires in %.1f days",
+	"Certyfikat wymaga odnowienia, ale automatyczne odnawianie jest wyłączone dla %s":                          "Certificate needs renewal, but automatic renewal is disabled for %s",
+	"Certyfikat %s został odwołany - generuję nowy":                                                            "Certificate %s was revoked - issuing a new one",
+	"Wymuszono odnowienie certyfikatu (opcja --force-renew)":                                                   "Certificate renewal forced (--force-renew)",
+	"Certyfikat wymaga odnowienia (próg: %s)":                                                                  "Certificate needs renewal (threshold: %s)",
+	"Certyfikat nie wymaga odnowienia - jest jeszcze ważny przez %.1f dni":                                     "Certificate does not need renewal - still valid for %.1f days",
+	"Generowanie nowego certyfikatu dla nowego użytkownika %s":                                                 "Issuing new certificate for new user %s",
+	"Konfiguracja OpenVPN została wysłana na e-mail: %s":                                                       "OpenVPN profile sent to: %s",
+	"Konfiguracja OpenVPN została ponownie wysłana na e-mail: %s":                                              "OpenVPN profile resent to: %s",
+	"Nie wysyłano emaila - certyfikat nie został odnowiony (użyj client resend aby wysłać ponownie)":           "Email not sent - certificate was not renewed (use client resend to send it again)",
+	"Nie podano adresu e-mail, konfiguracja nie została wysłana":                                               "No email address given, profile was not sent",
+	"Serial number certyfikatu: %s":                                                                            "Certificate serial number: %s",
+	"Generowanie certyfikatu urządzenia %s użytkownika %s":                                                     "Generating certificate for device %s of user %s",
+	"Brak adresu email użytkownika %s, konfiguracja urządzenia nie została wysłana":                            "User %s has no email address, the device configuration was not sent",
+	"Zaktualizowano email dla użytkownika %s: %s":                                                              "Updated email for user %s: %s",
+	"Automatyczne odnawianie dla %s: %s":                                                                       "Automatic renewal for %s: %s",
+	"Wygenerowano nową konfigurację OpenVPN":                                                                   "Generated new OpenVPN profile",
+	"Nie można wygenerować konfiguracji OpenVPN - brak klucza prywatnego dla istniejącego certyfikatu":         "Cannot generate OpenVPN profile - no private key for the existing certificate",
+	"Aby wygenerować nową konfigurację, użyj opcji --force-renew":                                              "Use --force-renew to generate a new profile",
+	"Certyfikat jest jeszcze ważny przez %.1f dni":                                                             "Certificate is still valid for %.1f days",
+	"Użyto istniejącej konfiguracji OpenVPN z pliku: %s":                                                       "Using existing OpenVPN profile from file: %s",
+	"Odnawianie certyfikatu %s (wygasa za %.1f dni)":                                                           "Renewing certificate %s (expires in %.1f days)",
+	"Błąd podczas odnawiania certyfikatu %s: %v":                                                               "Failed to renew certificate %s: %v",
+	"Odnawianie: sprawdzono %d, odnowiono %d, pominięto %d, błędy %d":                                          "Renewal: %d checked, %d renewed, %d skipped, %d failed",
+	"Błąd podczas odwoływania certyfikatu %s: %v":                                                              "Failed to revoke certificate %s: %v",
+	"Odwoływanie zastąpionych certyfikatów: oczekujących %d, odwołano %d, w okresie przejściowym %d, błędy %d": "Revoking replaced certificates: %d pending, %d revoked, %d in grace period, %d failed",
+	"Nie udało się zapisać połączenia %s: %v":                                                                  "Failed to record connection of %s: %v",
+	"Nowy certyfikat %s połączył się - poprzednie certyfikaty (%d) zostaną odwołane przez revoke-pending":      "New certificate %s has connected - %d previous certificate(s) will be revoked by revoke-pending",
+	"Certyfikat %s jest już odwołany":                                                                          "Certificate %s is already revoked",
+	"Błąd podczas wydawania certyfikatu %s: %v":                                                                "Failed to issue certificate %s: %v",
+	"Synchronizacja użytkowników: wydano %d, zaktualizowano %d, odwołano %d, bez zmian %d, błędy %d":           "User sync: %d issued, %d updated, %d revoked, %d unchanged, %d failed",
+	"Pobrano z LDAP %d wpisów, użytkowników: %d":                                                               "Fetched %d LDAP entries, users: %d",
+	"Pominięto wpis LDAP bez atrybutu %s: %s":                                                                  "Skipped LDAP entry without attribute %s: %s",
+	// Komunikaty CertManager są już po angielsku
+	"Certificate %s revoked with name %s": "Certificate %s revoked with name %s",
+	"New certificate %s created":          "New certificate %s created",
+	"Certificate %s was signed with CA":   "Certificate %s was signed with CA",
+	"Reading cert file %s":                "Reading cert file %s",
+
+	// Certyfikaty serwerów
+	"Znaleziono certyfikat serwera dla %s":                                                                          "Found server certificate for %s",
+	"Certyfikat serwera wygasa za %.1f dni":                                                                         "Server certificate expires in %.1f days",
+	"Wymuszono odnowienie certyfikatu serwera (opcja --force-renew)":                                                "Server certificate renewal forced (--force-renew)",
+	"Certyfikat serwera wymaga odnowienia (próg: %s)":                                                               "Server certificate needs renewal (threshold: %s)",
+	"Certyfikat serwera wymaga odnowienia, ale automatyczne odnawianie jest wyłączone w polityce serwera %s":        "Server certificate needs renewal, but automatic renewal is disabled by the server policy for %s",
+	"Próg odnawiania dla %s: %s":                                                                                    "Renewal threshold for %s: %s",
+	"Nie udało się odczytać maksymalnego TTL roli Vault, pomijam sprawdzenie: %v":                                   "Could not read the Vault role max TTL, skipping the check: %v",
+	"TTL %s przekracza maksymalny TTL roli Vault - certyfikat zostanie wydany na %s":                                "TTL %s exceeds the Vault role max TTL - issuing the certificate for %s",
+	"TTL %s przekracza maksymalny TTL polityki - certyfikat zostanie wydany na %s":                                  "TTL %s exceeds the policy max TTL - issuing the certificate for %s",
+	"Certyfikat serwera odnowiony, serial: %s":                                                                      "Server certificate renewed, serial: %s",
+	"Certyfikat serwera nie wymaga odnowienia":                                                                      "Server certificate does not need renewal",
+	"Certyfikat serwera nie wymaga odnowienia - pomijam wysyłkę na Mikrotika":                                       "Server certificate does not need renewal - skipping Mikrotik upload",
+	"Generowanie nowego certyfikatu serwera dla %s":                                                                 "Issuing new server certificate for %s",
+	"Nowy certyfikat serwera wygenerowany, serial: %s":                                                              "New server certificate issued, serial: %s",
+	"Powiadomienie o wymianie certyfikatu serwera wysłane na: %v":                                                   "Server certificate change notification sent to: %v",
+	"Nie podano adresu e-mail (--email ani NOTIFY_EMAIL), powiadomienie o certyfikacie serwera nie zostało wysłane": "No email address (--email or NOTIFY_EMAIL), server certificate notification was not sent",
+	"Konfiguracja serwera zakończona":                                                                               "Server configuration completed",
+	"Certyfikat serwera dla %s już istnieje":                                                                        "Server certificate for %s already exists",
+	"Certyfikat serwera %s jest odwołany w Vault - uruchom server deploy --force-renew":                             "Server certificate %s is revoked in Vault - run server deploy --force-renew",
+	"Odnawianie certyfikatu serwera dla %s":                                                                         "Renewing server certificate for %s",
+	"Generowanie nowego certyfikatu serwera zamiast odnawiania (do zaimplementowania w przyszłości)":                "Issuing a new server certificate instead of renewing (to be implemented)",
+	"Konfiguracja nowego certyfikatu serwera dla %s":                                                                "Configuring new server certificate for %s",
+
+	// Routery Mikrotik
+	"Brak danych dostępowych Mikrotika (MIKROTIK_USERNAME, MIKROTIK_PASSWORD)":             "Mikrotik credentials missing (MIKROTIK_USERNAME, MIKROTIK_PASSWORD)",
+	"Certyfikat serwera wymaga ręcznej aktualizacji na routerze Mikrotik (%s)":             "Server certificate must be updated manually on Mikrotik router (%s)",
+	"Nie udało się połączyć z Mikrotikiem: %v":                                             "Failed to connect to Mikrotik: %v",
+	"Certyfikat zostanie zaktualizowany ręcznie na routerze":                               "Certificate will have to be updated manually on the router",
+	"Błąd podczas wysyłania certyfikatu na Mikrotik: %v":                                   "Failed to upload certificate to Mikrotik: %v",
+	"Certyfikat serwera został pomyślnie wysłany na router Mikrotik":                       "Server certificate uploaded to Mikrotik router",
+	"Połączono FTP z routerem Mikrotik: %s":                                                "FTP connected to Mikrotik router: %s",
+	"Nie udało się zalogować FTP: %v - certyfikat będzie musiał być zaimportowany ręcznie": "FTP login failed: %v - certificate will have to be imported manually",
+	"Nie udało się połączyć FTP: %v - certyfikat będzie musiał być zaimportowany ręcznie":  "FTP connection failed: %v - certificate will have to be imported manually",
+	"Połączono z routerem Mikrotik: %s":                                                    "Connected to Mikrotik router: %s",
+	"Aktualizowanie certyfikatu serwera na Mikrotiku: %s":                                  "Updating server certificate on Mikrotik: %s",
+	"Certyfikat %s został pomyślnie zaktualizowany na Mikrotiku":                           "Certificate %s updated on Mikrotik",
+	"Nie udało się skonfigurować serwera OpenVPN: %v":                                      "Failed to configure OpenVPN server: %v",
+	"Konfigurowanie serwera OpenVPN do użycia certyfikatu: %s":                             "Configuring OpenVPN server to use certificate: %s",
+	"Nie udało się odczytać konfiguracji OpenVPN: %v":                                      "Failed to read OpenVPN configuration: %v",
+	"Aktualny certyfikat serwera OpenVPN: %s":                                              "Current OpenVPN server certificate: %s",
+	"ID konfiguracji OpenVPN: %s":                                                          "OpenVPN configuration ID: %s",
+	"✓ Certyfikat pomyślnie zmieniony na: %s":                                              "✓ Certificate changed to: %s",
+	"Certyfikat nie zmienił się! Wciąż: %s":                                                "Certificate did not change! Still: %s",
+	"Serwer OpenVPN skonfigurowany do użycia certyfikatu: %s":                              "OpenVPN server configured to use certificate: %s",
+	"Certyfikat %s został usunięty":                                                        "Certificate %s removed",
+	"Plik wysłany na router FTP: %s":                                                       "File uploaded to router over FTP: %s",
+	"Wysyłanie certyfikatu (cert+key) do %s":                                               "Uploading certificate (cert+key) to %s",
+	"Błąd przy wysyłaniu certyfikatu przez FTP: %v":                                        "Failed to upload certificate over FTP: %v",
+	"Certyfikat wysłany na router przez FTP - importowanie":                                "Certificate uploaded to router over FTP - importing",
+	"Certyfikat %s został zaimportowany":                                                   "Certificate %s imported",
+	"Czyszczenie pliku tymczasowego: %s":                                                   "Cleaning up temporary file: %s",
+	"Nie udało się usunąć pliku tymczasowego %s: %v":                                       "Failed to remove temporary file %s: %v",
+	"Certyfikat został pomyślnie wysłany na Mikrotik: %s (%s)":                             "Certificate uploaded to Mikrotik: %s (%s)",
+	"Plik %s został usunięty":                                                              "File %s removed",
+	"Audyt routera %s nie powiódł się: %v":                                                 "Router audit %s failed: %v",
+	"Certyfikat CA jest już na routerze jako %s":                                           "CA certificate is already on the router as %s",
+	"Audyt routera %s: certyfikatów %d, problemów %d":                                      "Router audit %s: %d certificates, %d findings",
+	"Error closing FTP connection: %v":                                                     "Error closing FTP connection: %v",
+
+	// Rotacja CA
+	"Rozpoczęto rotację CA montowania %s: %s (wygasa %s) -> %s (wygasa %s)":                    "Started CA rotation of mount %s: %s (expires %s) -> %s (expires %s)",
+	"Wznawianie rotacji CA %s -> %s od fazy %s":                                                "Resuming CA rotation %s -> %s from phase %s",
+	"Nowy wystawca %s wygasa %s, nie później niż poprzedni %s":                                 "New issuer %s expires %s, not later than the previous issuer %s",
+	"Rotacja CA: import nowego CA %s na router %s":                                             "CA rotation: importing new CA %s to router %s",
+	"Rotacja CA: nowy certyfikat i profil dla %s":                                              "CA rotation: new certificate and profile for %s",
+	"Rotacja CA: nowy certyfikat serwera %s":                                                   "CA rotation: new server certificate %s",
+	"Certyfikat serwera %s nie jest przypisany do routera - wymień go ręcznie (server deploy)": "Server certificate %s is not assigned to a router - replace it manually (server deploy)",
+	"Nie znaleziono starego CA na routerze %s - pomijam":                                       "Old CA not found on router %s - skipping",
+	"Rotacja CA: krok dla %s nie powiódł się: %v":                                              "CA rotation: step for %s failed: %v",
+	"Rotacja CA: faza %s zakończona":                                                           "CA rotation: phase %s completed",
+	"Rotacja CA: faza %s zakończona z pominięciem %d: %s (--force)":                            "CA rotation: phase %s completed skipping %d: %s (--force)",
+	"Rotacja CA montowania %s zakończona: %s -> %s":                                            "CA rotation of mount %s completed: %s -> %s",
+
+	// Sprawdzanie CA
+	"Nie udało się pobrać łańcucha CA montowania %s: %v":     "Failed to fetch the CA chain of mount %s: %v",
+	"Pominięto łańcuch wystawców montowania %s: %v":          "Skipped the issuer chain of mount %s: %v",
+	"Nie udało się ustalić wystawcy certyfikatu %s: %v":      "Failed to determine the issuer of certificate %s: %v",
+	"Certyfikat CA %s wygasł %s":                             "CA certificate %s expired on %s",
+	"Certyfikat CA %s wygasa za %d dni (%s)":                 "CA certificate %s expires in %d days (%s)",
+	"Certyfikaty (%d) są ważne dłużej niż CA %s (do %s): %s": "Certificates (%d) are valid longer than CA %s (until %s): %s",
+	"Sprawdzono certyfikaty CA: %d, problemy: %d":            "Checked CA certificates: %d, problems: %d",
+	"Sprawdzenie certyfikatów CA nie powiodło się: %v":       "CA certificate check failed: %v",
+
+	// Email i przypomnienia
+	"Wysyłka emaili wyłączona (DISABLE_EMAIL) - pomijam wiadomość do %s":               "Email sending disabled (DISABLE_EMAIL) - skipping message to %s",
+	"SMTP dry-run: wiadomość do %s, temat: %q (nie wysłano)":                           "SMTP dry-run: message to %s, subject: %q (not sent)",
+	"Uruchomiono wysyłkę przypomnień o wygasających certyfikatach":                     "Started sending expiry reminders",
+	"Wysyłanie przypomnienia (%d dni) do %s <%s>":                                      "Sending reminder (%d days) to %s <%s>",
+	"Błąd podczas wysyłania przypomnienia do %s: %v":                                   "Failed to send reminder to %s: %v",
+	"Błąd podczas zapisywania przypomnienia: %v":                                       "Failed to record reminder: %v",
+	"Eskalacja: certyfikat %s wygasa za %.1f dni":                                      "Escalation: certificate %s expires in %.1f days",
+	"Błąd podczas eskalacji dla %s: %v":                                                "Escalation failed for %s: %v",
+	"Błąd podczas zapisywania eskalacji: %v":                                           "Failed to record escalation: %v",
+	"Przypomnienia: sprawdzono %d, wysłano %d, eskalowano %d, bez emaila %d, błędy %d": "Reminders: %d checked, %d sent, %d escalated, %d without email, %d failed",
+	"Błąd podczas wysyłania powiadomienia: %v":                                         "Failed to send notification: %v",
+}
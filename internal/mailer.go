@@ -1,37 +1,515 @@
 package internal
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
+	"net"
+	"net/mail"
+	"net/smtp"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"gopkg.in/gomail.v2"
 )
 
+// Tryby szyfrowania połączenia SMTP
+const (
+	SMTPTLSImplicit = "tls"      // TLS od początku połączenia (zwykle port 465)
+	SMTPTLSStartTLS = "starttls" // wymagane STARTTLS po nawiązaniu połączenia (zwykle port 587)
+	SMTPTLSNone     = "none"     // brak szyfrowania (np. lokalny relay)
+)
+
+// MailerConfig przechowuje konfigurację wysyłki emaili
+type MailerConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	TLSMode  string
+	CAFile   string
+	Timeout  time.Duration
+	// Treść wiadomości: nazwa organizacji, adres serwera VPN, język domyślny i katalog z własnymi szablonami
+	Organization  string
+	ServerName    string
+	DefaultLocale string
+	TemplatesDir  string
+	Subject       string // MAIL_SUBJECT - nadpisuje temat wiadomości z konfiguracją (szablon)
+	// AdminRecipients to adresy (NOTIFY_EMAIL) otrzymujące zdarzenia administracyjne
+	AdminRecipients []string
+	Disabled        bool // DISABLE_EMAIL - całkowite wyłączenie wysyłki
+	DryRun          bool // SMTP_DRY_RUN - logowanie wiadomości zamiast wysyłki
+}
+
+// LoadMailerConfigFromEnv wczytuje konfigurację SMTP ze zmiennych środowiskowych
+func LoadMailerConfigFromEnv() (MailerConfig, error) {
+	config := MailerConfig{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     587,
+		Username: os.Getenv("SMTP_USER"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+		CAFile:   os.Getenv("SMTP_CA_FILE"),
+		Timeout:  30 * time.Second,
+	}
+
+	// Zgodność wsteczna ze starą nazwą zmiennej
+	if config.Username == "" {
+		config.Username = os.Getenv("SMTP_USERNAME")
+	}
+
+	if port := os.Getenv("SMTP_PORT"); port != "" {
+		value, err := strconv.Atoi(port)
+		if err != nil {
+			return config, fmt.Errorf("nieprawidłowa wartość SMTP_PORT %q: %w", port, err)
+		}
+		config.Port = value
+	}
+
+	tlsMode, err := parseSMTPTLSMode(os.Getenv("SMTP_TLS"), config.Port)
+	if err != nil {
+		return config, err
+	}
+	config.TLSMode = tlsMode
+
+	if timeout := os.Getenv("SMTP_TIMEOUT"); timeout != "" {
+		value, err := time.ParseDuration(timeout)
+		if err != nil {
+			return config, fmt.Errorf("nieprawidłowa wartość SMTP_TIMEOUT %q: %w", timeout, err)
+		}
+		config.Timeout = value
+	}
+
+	config.AdminRecipients = splitList(os.Getenv("NOTIFY_EMAIL"))
+
+	config.Organization = os.Getenv("MAIL_ORGANIZATION")
+	if config.Organization == "" {
+		config.Organization = "B-Code"
+	}
+	config.ServerName = os.Getenv("MAIL_SERVER_NAME")
+	config.DefaultLocale = os.Getenv("MAIL_DEFAULT_LOCALE")
+	if config.DefaultLocale == "" {
+		config.DefaultLocale = DefaultLocale
+	}
+	config.TemplatesDir = os.Getenv("MAIL_TEMPLATES_DIR")
+	config.Subject = os.Getenv("MAIL_SUBJECT")
+
+	if config.Disabled, err = parseBoolEnv("DISABLE_EMAIL"); err != nil {
+		return config, err
+	}
+	if config.DryRun, err = parseBoolEnv("SMTP_DRY_RUN"); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+// parseSMTPTLSMode zamienia wartość SMTP_TLS na tryb szyfrowania.
+// Wartości true/false są akceptowane dla zgodności z dotychczasowym .env.
+func parseSMTPTLSMode(value string, port int) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "":
+		// Domyślnie: port 465 to implicit TLS, pozostałe STARTTLS
+		if port == 465 {
+			return SMTPTLSImplicit, nil
+		}
+		return SMTPTLSStartTLS, nil
+	case SMTPTLSStartTLS, "true", "yes", "1":
+		return SMTPTLSStartTLS, nil
+	case SMTPTLSImplicit, "ssl", "implicit":
+		return SMTPTLSImplicit, nil
+	case SMTPTLSNone, "false", "no", "0":
+		return SMTPTLSNone, nil
+	default:
+		return "", fmt.Errorf("nieprawidłowa wartość SMTP_TLS %q (dozwolone: tls, starttls, none)", value)
+	}
+}
+
+// parseBoolEnv odczytuje zmienną środowiskową typu bool (pusta oznacza false)
+func parseBoolEnv(name string) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return false, nil
+	}
+	result, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("nieprawidłowa wartość %s %q: %w", name, value, err)
+	}
+	return result, nil
+}
+
+// splitList dzieli listę rozdzieloną przecinkami, pomijając puste elementy
+func splitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// Validate sprawdza poprawność konfiguracji SMTP
+func (c MailerConfig) Validate() error {
+	if c.Disabled {
+		return nil
+	}
+
+	if c.Host == "" {
+		return fmt.Errorf("brak SMTP_HOST (ustaw DISABLE_EMAIL=true aby wyłączyć wysyłkę emaili)")
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("nieprawidłowy port SMTP: %d", c.Port)
+	}
+	if c.From == "" {
+		return fmt.Errorf("brak SMTP_FROM")
+	}
+	if _, err := mail.ParseAddress(c.From); err != nil {
+		return fmt.Errorf("nieprawidłowy adres SMTP_FROM %q: %w", c.From, err)
+	}
+
+	switch c.TLSMode {
+	case SMTPTLSImplicit, SMTPTLSStartTLS, SMTPTLSNone:
+	default:
+		return fmt.Errorf("nieprawidłowy tryb SMTP_TLS: %q", c.TLSMode)
+	}
+
+	if c.Password != "" && c.Username == "" {
+		return fmt.Errorf("podano SMTP_PASSWORD bez SMTP_USER")
+	}
+	// Hasło nie może iść otwartym tekstem do zdalnego serwera
+	if c.Username != "" && c.TLSMode == SMTPTLSNone && !isLocalhost(c.Host) {
+		return fmt.Errorf("uwierzytelnianie SMTP wymaga szyfrowania (SMTP_TLS=tls lub starttls)")
+	}
+	if c.CAFile != "" && c.TLSMode == SMTPTLSNone {
+		return fmt.Errorf("SMTP_CA_FILE nie ma zastosowania przy SMTP_TLS=none")
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("nieprawidłowy SMTP_TIMEOUT: %s", c.Timeout)
+	}
+	for _, address := range c.AdminRecipients {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return fmt.Errorf("nieprawidłowy adres NOTIFY_EMAIL %q: %w", address, err)
+		}
+	}
+
+	return nil
+}
+
+func isLocalhost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 type Mailer struct {
-	logger *logrus.Logger
+	config    MailerConfig
+	templates *TemplateStore
+	tlsConfig *tls.Config
+	logger    *logrus.Logger
+	executor  *Executor
+}
+
+// NewMailer tworzy mailer i waliduje jego konfigurację, aby błędy wyszły przy starcie, a nie przy wysyłce
+func NewMailer(config MailerConfig, templates *TemplateStore, logger *logrus.Logger) (*Mailer, error) {
+	if err := config.Validate(); err != nil {
+		return nil, newError(ErrInvalidConfig, err, "nieprawidłowa konfiguracja SMTP")
+	}
+
+	m := &Mailer{config: config, templates: templates, logger: logger}
+
+	if !config.Disabled && config.TLSMode != SMTPTLSNone {
+		tlsConfig, err := buildSMTPTLSConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		m.tlsConfig = tlsConfig
+	}
+
+	return m, nil
+}
+
+// buildSMTPTLSConfig przygotowuje konfigurację TLS, opcjonalnie z własnym CA dla wewnętrznych relayów
+func buildSMTPTLSConfig(config MailerConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: config.Host,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if config.CAFile != "" {
+		caPEM, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, newError(ErrInvalidConfig, err, "nie udało się wczytać SMTP_CA_FILE")
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, newError(ErrInvalidConfig, nil, "plik SMTP_CA_FILE %s nie zawiera poprawnych certyfikatów PEM", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// SetExecutor kieruje wysyłkę wiadomości przez executor trybu dry-run
+func (m *Mailer) SetExecutor(executor *Executor) {
+	m.executor = executor
+}
+
+// Enabled informuje, czy wysyłka emaili jest włączona
+func (m *Mailer) Enabled() bool {
+	return !m.config.Disabled
+}
+
+// HasLocale sprawdza, czy dostępne są szablony emaili w podanym języku
+func (m *Mailer) HasLocale(locale string) bool {
+	return m.templates.HasLocale(locale)
 }
 
-func NewMailer(logger *logrus.Logger) *Mailer {
-	return &Mailer{logger: logger}
+// templateData przygotowuje zmienne szablonu dla certyfikatu użytkownika
+func (m *Mailer) templateData(name string, expiresAt time.Time) TemplateData {
+	return TemplateData{
+		Name:         name,
+		Days:         int(time.Until(expiresAt).Hours() / 24),
+		ExpiresAt:    expiresAt,
+		ServerName:   m.config.ServerName,
+		Organization: m.config.Organization,
+		Year:         time.Now().Year(),
+	}
+}
+
+// newMessage tworzy wiadomość z wersją tekstową i alternatywną wersją HTML
+func (m *Mailer) newMessage(address string, email *RenderedEmail) *gomail.Message {
+	message := gomail.NewMessage()
+	message.SetHeader("From", m.config.From)
+	message.SetHeader("To", address)
+	message.SetHeader("Subject", email.Subject)
+	message.SetBody("text/plain", email.Text)
+	message.AddAlternative("text/html", email.HTML)
+	return message
 }
 
-func (m *Mailer) SendEmail(fileContent string, expirationDays int, emailTemplate string, name string, address string) (err error) {
-	mailer := gomail.NewMessage()
-	mailer.SetHeader("From", os.Getenv("SMTP_FROM"))
-	mailer.SetHeader("To", address)
-	mailer.SetHeader("Subject", "Nowa konfiguracja OpenVPN dla B-Code")
+// SendProfile wysyła użytkownikowi konfigurację OpenVPN jako załącznik <name>.ovpn
+func (m *Mailer) SendProfile(name, address, locale string, expiresAt time.Time, profile string) error {
+	email, err := m.templates.Render(TemplateProfile, locale, m.templateData(name, expiresAt))
+	if err != nil {
+		return err
+	}
 
-	mailer.SetBody("text/html", fmt.Sprintf(emailTemplate, expirationDays))
-	mailer.Attach(fmt.Sprintf("%s.ovpn", name), gomail.SetCopyFunc(func(w io.Writer) error {
-		_, err := w.Write([]byte(fileContent))
+	message := m.newMessage(address, email)
+	message.Attach(fmt.Sprintf("%s.ovpn", name), gomail.SetCopyFunc(func(w io.Writer) error {
+		_, err := w.Write([]byte(profile))
 		return err
 	}))
-	d := gomail.NewDialer(os.Getenv("SMTP_HOST"), 587, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
-	if err := d.DialAndSend(mailer); err != nil {
-		return fmt.Errorf("Błąd podczas wysyłania e-maila: %v", err)
+
+	return m.send(message)
+}
+
+// SendReminder wysyła przypomnienie o zbliżającym się wygaśnięciu certyfikatu
+func (m *Mailer) SendReminder(name, address, locale string, expiresAt time.Time) error {
+	email, err := m.templates.Render(TemplateReminder, locale, m.templateData(name, expiresAt))
+	if err != nil {
+		return err
+	}
+
+	return m.send(m.newMessage(address, email))
+}
+
+// SendLoginLink wysyła jednorazowy link logowania do portalu samoobsługowego ważny do expiresAt
+func (m *Mailer) SendLoginLink(address, locale, link string, expiresAt time.Time) error {
+	data := m.templateData(address, expiresAt)
+	data.Link = link
+	email, err := m.templates.Render(TemplateLogin, locale, data)
+	if err != nil {
+		return err
+	}
+
+	return m.send(m.newMessage(address, email))
+}
+
+// AdminRecipients zwraca adresy administratorów z NOTIFY_EMAIL
+func (m *Mailer) AdminRecipients() []string {
+	return m.config.AdminRecipients
+}
+
+// SendServerRotation wysyła administratorom powiadomienie o wymianie certyfikatu serwera.
+// Załącznikiem jest wyłącznie publiczny certyfikat (<cn>.crt) - nigdy klucz prywatny.
+func (m *Mailer) SendServerRotation(addresses []string, locale string, serverCert *ServerCertificate, routers []RouterDeployment) error {
+	if len(addresses) == 0 {
+		return fmt.Errorf("brak adresatów powiadomienia o wymianie certyfikatu serwera")
+	}
+	if containsPrivateKey(serverCert.Certificate) {
+		return fmt.Errorf("certyfikat serwera %s zawiera klucz prywatny - odmowa wysłania", serverCert.CommonName)
+	}
+
+	cert, err := ParseCertificatePEM(serverCert.Certificate)
+	if err != nil {
+		return err
+	}
+
+	email, err := m.templates.Render(TemplateServerRotated, locale, ServerRotationData{
+		CommonName:   serverCert.CommonName,
+		SerialNumber: serverCert.SerialNumber,
+		Fingerprint:  FormatFingerprint(CertificateFingerprint(cert)),
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+		Routers:      routers,
+		Organization: m.config.Organization,
+		Year:         time.Now().Year(),
+	})
+	if err != nil {
+		return err
+	}
+
+	message := m.newMessage(addresses[0], email)
+	message.SetHeader("To", addresses...)
+	message.Attach(fmt.Sprintf("%s.crt", serverCert.CommonName),
+		gomail.SetHeader(map[string][]string{"Content-Type": {"application/x-pem-file"}}),
+		gomail.SetCopyFunc(func(w io.Writer) error {
+			_, err := w.Write([]byte(serverCert.Certificate))
+			return err
+		}))
+
+	return m.send(message)
+}
+
+// Notify wysyła zdarzenie administracyjne emailem na adresy z NOTIFY_EMAIL
+func (m *Mailer) Notify(event Event) error {
+	if len(m.config.AdminRecipients) == 0 {
+		m.logger.Debugf("Brak NOTIFY_EMAIL - pomijam powiadomienie email %s", event.Type)
+		return nil
+	}
+	return m.NotifyAddresses(event, m.config.AdminRecipients)
+}
+
+// NotifyAddresses wysyła zdarzenie administracyjne na podane adresy
+func (m *Mailer) NotifyAddresses(event Event, recipients []string) error {
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s\n\n", event.Message)
+	if event.CommonName != "" {
+		fmt.Fprintf(&body, "CN: %s\n", event.CommonName)
+	}
+	for _, name := range event.sortedFieldNames() {
+		fmt.Fprintf(&body, "%s: %s\n", name, event.Fields[name])
+	}
+	fmt.Fprintf(&body, "\nZdarzenie: %s (%s), %s\n", event.Type, event.Severity, event.Time.Format(time.RFC3339))
+
+	message := gomail.NewMessage()
+	message.SetHeader("From", m.config.From)
+	message.SetHeader("To", recipients...)
+	message.SetHeader("Subject", fmt.Sprintf("[PinPoint][%s] %s", event.Severity, event.Title))
+	message.SetBody("text/plain", body.String())
+
+	if err := m.send(message); err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+	return nil
+}
+
+// send dostarcza wiadomość z uwzględnieniem przełączników DISABLE_EMAIL i SMTP_DRY_RUN
+func (m *Mailer) send(message *gomail.Message) error {
+	recipients := strings.Join(message.GetHeader("To"), ", ")
+
+	if m.config.Disabled {
+		m.logger.Infof("Wysyłka emaili wyłączona (DISABLE_EMAIL) - pomijam wiadomość do %s", recipients)
+		return nil
+	}
+
+	subject := strings.Join(message.GetHeader("Subject"), " ")
+	if m.executor.Skip(PlanStep{Target: PlanEmail, Operation: "send", Detail: fmt.Sprintf("%s: %q", recipients, subject)}) {
+		return nil
+	}
+
+	if m.config.DryRun {
+		m.logger.Infof("SMTP dry-run: wiadomość do %s, temat: %q (nie wysłano)", recipients, subject)
+		return nil
+	}
+
+	start := time.Now()
+	err := gomail.Send(gomail.SendFunc(m.deliver), message)
+	observeCall(m.logger, SystemSMTP, "send", start, err)
+	if err != nil {
+		return newError(ErrEmail, err, "błąd podczas wysyłania e-maila")
 	}
 
 	return nil
 }
+
+// deliver nawiązuje połączenie SMTP zgodnie z konfiguracją i przekazuje wiadomość
+func (m *Mailer) deliver(from string, to []string, message io.WriterTo) error {
+	address := net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port))
+
+	dialer := &net.Dialer{Timeout: m.config.Timeout}
+	conn, err := dialer.Dial("tcp", address)
+	if err != nil {
+		return fmt.Errorf("nie udało się połączyć z serwerem SMTP %s: %w", address, err)
+	}
+	if err := conn.SetDeadline(time.Now().Add(m.config.Timeout)); err != nil {
+		conn.Close()
+		return fmt.Errorf("nie udało się ustawić limitu czasu SMTP: %w", err)
+	}
+
+	if m.config.TLSMode == SMTPTLSImplicit {
+		conn = tls.Client(conn, m.tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("nie udało się rozpocząć sesji SMTP: %w", err)
+	}
+	defer client.Close()
+
+	if m.config.TLSMode == SMTPTLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("serwer SMTP %s nie obsługuje STARTTLS", address)
+		}
+		if err := client.StartTLS(m.tlsConfig); err != nil {
+			return fmt.Errorf("błąd STARTTLS: %w", err)
+		}
+	}
+
+	// Bez SMTP_USER korzystamy z relaya bez uwierzytelniania
+	if m.config.Username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("serwer SMTP %s nie obsługuje uwierzytelniania", address)
+		}
+		if err := client.Auth(smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)); err != nil {
+			return fmt.Errorf("błąd uwierzytelniania SMTP: %w", err)
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("serwer SMTP odrzucił nadawcę %s: %w", from, err)
+	}
+	for _, recipient := range to {
+		if err := client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("serwer SMTP odrzucił odbiorcę %s: %w", recipient, err)
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := message.WriteTo(writer); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
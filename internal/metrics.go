@@ -0,0 +1,407 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Systemy zewnętrzne, których wywołania są mierzone
+const (
+	SystemVault    = "vault"
+	SystemRouterOS = "routeros"
+	SystemSMTP     = "smtp"
+)
+
+// Wyniki operacji na certyfikatach w metrykach
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Rodziny metryk
+const (
+	metricOperations   = "pinpoint_certificate_operations_total"
+	metricCallDuration = "pinpoint_external_call_duration_seconds"
+	metricCallErrors   = "pinpoint_external_call_errors_total"
+	metricExpiry       = "pinpoint_certificate_expiry_timestamp_seconds"
+	metricPending      = "pinpoint_pending_revocations"
+	metricLastRun      = "pinpoint_last_run_timestamp_seconds"
+	metricLastRunOK    = "pinpoint_last_run_success"
+	metricLastSuccess  = "pinpoint_last_success_timestamp_seconds"
+	metricCAExpiry     = "pinpoint_ca_expiry_timestamp_seconds"
+	metricOutlivingCA  = "pinpoint_certificates_outliving_ca"
+)
+
+// metricFamily opisuje rodzinę metryk (nazwa, opis HELP, typ TYPE)
+type metricFamily struct {
+	name string
+	help string
+	kind string
+}
+
+// metricFamilies w kolejności zapisu do pliku
+var metricFamilies = []metricFamily{
+	{metricOperations, "Operacje na certyfikatach według rodzaju i wyniku", "counter"},
+	{metricCallDuration, "Czas wywołań Vault, RouterOS i SMTP", "summary"},
+	{metricCallErrors, "Błędy wywołań Vault, RouterOS i SMTP", "counter"},
+	{metricExpiry, "Data wygaśnięcia certyfikatu (unix)", "gauge"},
+	{metricPending, "Zastąpione certyfikaty użytkowników czekające na odwołanie", "gauge"},
+	{metricLastRun, "Czas ostatniego uruchomienia polecenia (unix)", "gauge"},
+	{metricLastRunOK, "Czy ostatnie uruchomienie polecenia zakończyło się sukcesem", "gauge"},
+	{metricLastSuccess, "Czas ostatniego udanego uruchomienia polecenia (unix)", "gauge"},
+	{metricCAExpiry, "Data wygaśnięcia certyfikatu CA z łańcucha montowań i certyfikatów (unix)", "gauge"},
+	{metricOutlivingCA, "Certyfikaty ważne dłużej niż CA z ich łańcucha", "gauge"},
+}
+
+// caCheckFamilies to rodziny ustawiane przez sprawdzenie CA (check) - zastępują wartości z poprzedniego pliku
+var caCheckFamilies = []string{metricCAExpiry, metricOutlivingCA}
+
+// metricSample identyfikuje próbkę: nazwa (z sufiksem _sum/_count dla summary) i etykiety w postaci {a="b"}
+type metricSample struct {
+	name   string
+	labels string
+}
+
+// metricsRegistry zbiera metryki bieżącego uruchomienia
+type metricsRegistry struct {
+	mutex  sync.Mutex
+	values map[metricSample]float64
+}
+
+// metrics to rejestr procesu - pojedyncze polecenie CLI to jedno uruchomienie
+var metrics = &metricsRegistry{values: make(map[metricSample]float64)}
+
+func (r *metricsRegistry) add(name string, value float64, labels ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.values[metricSample{name, formatLabels(labels...)}] += value
+}
+
+// replace ustawia wszystkie próbki rodziny (gauge), usuwając próbki z poprzedniego sprawdzenia;
+// klucze samples to etykiety w postaci {a="b"}
+func (r *metricsRegistry) replace(name string, samples map[string]float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for sample := range r.values {
+		if sample.name == name {
+			delete(r.values, sample)
+		}
+	}
+	for labels, value := range samples {
+		r.values[metricSample{name, labels}] = value
+	}
+}
+
+// recorded sprawdza, czy bieżące uruchomienie ustawiło próbki rodziny
+func (r *metricsRegistry) recorded(name string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for sample := range r.values {
+		if sample.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *metricsRegistry) snapshot() map[metricSample]float64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	values := make(map[metricSample]float64, len(r.values))
+	for sample, value := range r.values {
+		values[sample] = value
+	}
+	return values
+}
+
+// observeCall rejestruje czas i ewentualny błąd wywołania systemu zewnętrznego oraz loguje je na poziomie debug
+func observeCall(logger logrus.FieldLogger, system, operation string, start time.Time, err error) {
+	duration := time.Since(start).Seconds()
+	log := logger.WithFields(logrus.Fields{FieldSystem: system, FieldOperation: operation, FieldDuration: math.Round(duration*1000) / 1000})
+	if err != nil {
+		log.Debugf("Wywołanie %s %s nie powiodło się: %v", system, operation, err)
+	} else {
+		log.Debugf("Wywołanie %s %s zakończone", system, operation)
+	}
+
+	metrics.add(metricCallDuration+"_sum", duration, "system", system, "operation", operation)
+	metrics.add(metricCallDuration+"_count", 1, "system", system, "operation", operation)
+	if err != nil {
+		metrics.add(metricCallErrors, 1, "system", system, "operation", operation)
+	}
+}
+
+// countOperation zlicza operację na certyfikacie (issue, renew, revoke, deploy) dla typu user lub server
+func countOperation(operation, certType string, err error) {
+	result := ResultSuccess
+	if err != nil {
+		result = ResultFailure
+	}
+	metrics.add(metricOperations, 1, "operation", operation, "type", certType, "result", result)
+}
+
+// formatLabels buduje etykiety {a="b",c="d"} z par nazwa, wartość (posortowanych po nazwie)
+func formatLabels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	type label struct{ name, value string }
+	labels := make([]label, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, label{pairs[i], pairs[i+1]})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		parts[i] = fmt.Sprintf(`%s="%s"`, l.name, escaper.Replace(l.value))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// MetricsConfig przechowuje ustawienia eksportu metryk
+type MetricsConfig struct {
+	// TextfilePath to plik .prom w katalogu textfile collectora node_exportera (METRICS_TEXTFILE); pusty wyłącza eksport
+	TextfilePath string
+}
+
+// LoadMetricsConfigFromEnv wczytuje konfigurację metryk
+func LoadMetricsConfigFromEnv() MetricsConfig {
+	return MetricsConfig{TextfilePath: os.Getenv("METRICS_TEXTFILE")}
+}
+
+// MetricsRun opisuje zakończone uruchomienie polecenia
+type MetricsRun struct {
+	Command  string
+	Success  bool
+	Finished time.Time
+}
+
+// WriteMetricsTextfile zapisuje metryki w formacie tekstowym Prometheus. Liczniki i czasy wywołań są sumowane
+// z poprzednią zawartością pliku (uruchomienia z crona), daty wygaśnięcia pochodzą z bazy (nil - z poprzedniego pliku),
+// a wyniki sprawdzenia CA - z ostatniego polecenia check.
+func WriteMetricsTextfile(path string, certDB *CertificateDB, run MetricsRun, executor *Executor) error {
+	previous, err := readMetricsTextfile(path)
+	if err != nil {
+		return fmt.Errorf("nie udało się odczytać poprzednich metryk z %s: %w", path, err)
+	}
+
+	replaced := make(map[string]bool)
+	for _, family := range caCheckFamilies {
+		replaced[family] = metrics.recorded(family)
+	}
+
+	values := make(map[metricSample]float64)
+	for sample, value := range previous {
+		if (sample.name == metricExpiry || sample.name == metricPending) && certDB != nil {
+			continue
+		}
+		if replaced[sample.name] {
+			continue
+		}
+		values[sample] = value
+	}
+	for sample, value := range metrics.snapshot() {
+		values[sample] += value
+	}
+
+	if certDB != nil {
+		addExpiryMetrics(values, certDB)
+	}
+	addRunMetrics(values, run)
+	return writeMetricsTextfile(path, values, executor)
+}
+
+// WriteCACheckMetricsTextfile zapisuje do pliku metryk wyniki sprawdzenia CA wykonanego w tle przez serve.
+// Rejestr serve jest sumowany przez cały czas działania procesu, więc w odróżnieniu od WriteMetricsTextfile
+// liczniki nie są dodawane - zapisywane są tylko wartości bezwzględne: rodziny sprawdzenia CA, daty wygaśnięcia
+// z bazy (nil - z poprzedniego pliku) i metryki ostatniego uruchomienia.
+func WriteCACheckMetricsTextfile(path string, certDB *CertificateDB, run MetricsRun, executor *Executor) error {
+	previous, err := readMetricsTextfile(path)
+	if err != nil {
+		return fmt.Errorf("nie udało się odczytać poprzednich metryk z %s: %w", path, err)
+	}
+
+	replaced := make(map[string]bool)
+	for _, family := range caCheckFamilies {
+		replaced[family] = metrics.recorded(family)
+	}
+
+	values := make(map[metricSample]float64)
+	for sample, value := range previous {
+		if (sample.name == metricExpiry || sample.name == metricPending) && certDB != nil {
+			continue
+		}
+		if replaced[sample.name] {
+			continue
+		}
+		values[sample] = value
+	}
+	for sample, value := range metrics.snapshot() {
+		if replaced[sample.name] {
+			values[sample] = value
+		}
+	}
+
+	if certDB != nil {
+		addExpiryMetrics(values, certDB)
+	}
+	addRunMetrics(values, run)
+	return writeMetricsTextfile(path, values, executor)
+}
+
+// addRunMetrics ustawia czas i wynik ostatniego uruchomienia polecenia
+func addRunMetrics(values map[metricSample]float64, run MetricsRun) {
+	command := formatLabels("command", run.Command)
+	values[metricSample{metricLastRun, command}] = float64(run.Finished.Unix())
+	values[metricSample{metricLastRunOK, command}] = 0
+	if run.Success {
+		values[metricSample{metricLastRunOK, command}] = 1
+		values[metricSample{metricLastSuccess, command}] = float64(run.Finished.Unix())
+	}
+}
+
+// writeMetricsTextfile podmienia atomowo plik metryk, aby collector nie odczytał niepełnej zawartości
+func writeMetricsTextfile(path string, values map[metricSample]float64, executor *Executor) error {
+	if executor.Skip(PlanStep{Target: PlanFile, Operation: "write", Detail: path}) {
+		return nil
+	}
+
+	tempFile := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	file, err := os.Create(tempFile)
+	if err != nil {
+		return fmt.Errorf("nie udało się utworzyć pliku metryk: %w", err)
+	}
+	if err := writeMetrics(file, values); err != nil {
+		file.Close()
+		os.Remove(tempFile)
+		return fmt.Errorf("nie udało się zapisać metryk: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("nie udało się zapisać metryk: %w", err)
+	}
+	if err := os.Rename(tempFile, path); err != nil {
+		return fmt.Errorf("nie udało się podmienić pliku metryk %s: %w", path, err)
+	}
+	return nil
+}
+
+// WriteMetrics zapisuje metryki bieżącego procesu i daty wygaśnięcia z bazy (endpoint /metrics polecenia serve)
+func WriteMetrics(w io.Writer, certDB *CertificateDB) error {
+	values := metrics.snapshot()
+	addExpiryMetrics(values, certDB)
+	return writeMetrics(w, values)
+}
+
+// addExpiryMetrics dodaje daty wygaśnięcia certyfikatów serwerów oraz nieodwołanych użytkowników i ich urządzeń,
+// a także liczbę zastąpionych certyfikatów czekających na odwołanie
+func addExpiryMetrics(values map[metricSample]float64, certDB *CertificateDB) {
+	pending := 0
+	for commonName, user := range certDB.GetAllUsers() {
+		pending += len(user.PendingRevocations)
+		if user.IsRevoked() {
+			continue
+		}
+		values[metricSample{metricExpiry, formatLabels("type", "user", "common_name", commonName)}] = float64(user.ExpiresAt.Unix())
+		for _, device := range user.Devices {
+			if !device.IsRevoked() {
+				values[metricSample{metricExpiry, formatLabels("type", "device", "common_name", device.CommonName)}] = float64(device.ExpiresAt.Unix())
+			}
+		}
+	}
+	for commonName, server := range certDB.GetAllServers() {
+		values[metricSample{metricExpiry, formatLabels("type", "server", "common_name", commonName, "router", server.MikrotikIP)}] = float64(server.ExpiresAt.Unix())
+	}
+	values[metricSample{metricPending, ""}] = float64(pending)
+}
+
+// metricFamilyOf zwraca nazwę rodziny dla próbki (summary ma próbki z sufiksami _sum i _count)
+func metricFamilyOf(name string) string {
+	for _, suffix := range []string{"_sum", "_count"} {
+		if strings.TrimSuffix(name, suffix) == metricCallDuration {
+			return metricCallDuration
+		}
+	}
+	return name
+}
+
+// writeMetrics zapisuje próbki pogrupowane w rodziny z komentarzami HELP i TYPE
+func writeMetrics(w io.Writer, values map[metricSample]float64) error {
+	samples := make([]metricSample, 0, len(values))
+	for sample := range values {
+		samples = append(samples, sample)
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].name != samples[j].name {
+			return samples[i].name < samples[j].name
+		}
+		return samples[i].labels < samples[j].labels
+	})
+
+	buffered := bufio.NewWriter(w)
+	for _, family := range metricFamilies {
+		fmt.Fprintf(buffered, "# HELP %s %s\n# TYPE %s %s\n", family.name, family.help, family.name, family.kind)
+		for _, sample := range samples {
+			if metricFamilyOf(sample.name) == family.name {
+				fmt.Fprintf(buffered, "%s%s %s\n", sample.name, sample.labels, strconv.FormatFloat(values[sample], 'f', -1, 64))
+			}
+		}
+	}
+	return buffered.Flush()
+}
+
+// readMetricsTextfile wczytuje próbki rodzin PinPoint z poprzedniego pliku; brak pliku nie jest błędem
+func readMetricsTextfile(path string) (map[metricSample]float64, error) {
+	values := make(map[metricSample]float64)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return values, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	known := make(map[string]bool, len(metricFamilies))
+	for _, family := range metricFamilies {
+		known[family.name] = true
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		separator := strings.LastIndex(line, " ")
+		if separator < 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(line[separator+1:], 64)
+		if err != nil {
+			continue
+		}
+		name, labels := line[:separator], ""
+		if brace := strings.Index(name, "{"); brace >= 0 {
+			name, labels = name[:brace], name[brace:]
+		}
+		if known[metricFamilyOf(name)] {
+			values[metricSample{name, labels}] = value
+		}
+	}
+	return values, scanner.Err()
+}
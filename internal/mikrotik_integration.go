@@ -3,6 +3,9 @@ package internal
 import (
 	"bytes"
 	"fmt"
+	"net"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,52 +14,125 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// Domyślne porty usług routera Mikrotik
+const (
+	DefaultMikrotikAPIPort = 8728
+	DefaultMikrotikFTPPort = 21
+)
+
+// MikrotikConfig przechowuje dane dostępowe wspólne dla wszystkich routerów
+type MikrotikConfig struct {
+	Username string
+	Password string
+	// APIPort to port RouterOS API (MIKROTIK_API_PORT)
+	APIPort int
+	// FTPPort to port FTP używany do przesyłania certyfikatów (MIKROTIK_FTP_PORT)
+	FTPPort int
+}
+
+// LoadMikrotikConfigFromEnv wczytuje dane dostępowe i porty routerów; brak danych dostępowych nie jest błędem,
+// bo certyfikat serwera można wtedy wdrożyć ręcznie
+func LoadMikrotikConfigFromEnv() (MikrotikConfig, error) {
+	config := MikrotikConfig{
+		Username: os.Getenv("MIKROTIK_USERNAME"),
+		Password: os.Getenv("MIKROTIK_PASSWORD"),
+		APIPort:  DefaultMikrotikAPIPort,
+		FTPPort:  DefaultMikrotikFTPPort,
+	}
+	ports := []struct {
+		name  string
+		value *int
+	}{{"MIKROTIK_API_PORT", &config.APIPort}, {"MIKROTIK_FTP_PORT", &config.FTPPort}}
+	for _, port := range ports {
+		value := os.Getenv(port.name)
+		if value == "" {
+			continue
+		}
+		number, err := strconv.Atoi(value)
+		if err != nil || number <= 0 || number > 65535 {
+			return config, newError(ErrInvalidConfig, err, "nieprawidłowa wartość %s %q", port.name, value)
+		}
+		*port.value = number
+	}
+	return config, nil
+}
+
+// HasCredentials sprawdza, czy podano MIKROTIK_USERNAME i MIKROTIK_PASSWORD
+func (c MikrotikConfig) HasCredentials() bool {
+	return c.Username != "" && c.Password != ""
+}
+
 // MikrotikIntegration obsługuje komunikację z routerem Mikrotik
 type MikrotikIntegration struct {
 	client   *routeros.Client
 	ip       string
-	username string
-	password string
-	logger   *logrus.Logger
+	config   MikrotikConfig
+	logger   *logrus.Entry
+	executor *Executor
 }
 
 // NewMikrotikIntegration tworzy nowy klient integracji z Mikrotikiem
-func NewMikrotikIntegration(ip, username, password string, logger *logrus.Logger) (*MikrotikIntegration, error) {
+func NewMikrotikIntegration(ip string, config MikrotikConfig, logger *logrus.Logger) (*MikrotikIntegration, error) {
+	log := logger.WithField(FieldRouter, ip)
+	username, password := config.Username, config.Password
+
 	// Połączenie RouterOS API
-	client, err := routeros.Dial(ip+":8728", username, password)
+	start := time.Now()
+	client, err := routeros.Dial(net.JoinHostPort(ip, strconv.Itoa(config.APIPort)), username, password)
+	observeCall(log, SystemRouterOS, "connect", start, err)
 	if err != nil {
-		return nil, fmt.Errorf("nie udało się połączyć z Mikrotikiem (API): %w", err)
+		return nil, newError(ErrRouterUnreachable, err, "nie udało się połączyć z Mikrotikiem (API)")
 	}
 
 	// Test połączenia FTP
-	ftpClient, err := ftp.Dial(ip + ":21")
+	ftpClient, err := ftp.Dial(net.JoinHostPort(ip, strconv.Itoa(config.FTPPort)))
 	if err == nil {
 		defer func() {
 			if err := ftpClient.Quit(); err != nil {
-				logger.Debugf("Error closing FTP connection: %v", err)
+				log.Debugf("Error closing FTP connection: %v", err)
 			}
 		}()
 		err = ftpClient.Login(username, password)
 		if err == nil {
-			logger.Infof("Połączono FTP z routerem Mikrotik: %s", ip)
+			log.Infof("Połączono FTP z routerem Mikrotik: %s", ip)
 		} else {
-			logger.Warnf("Nie udało się zalogować FTP: %v - certyfikat będzie musiał być zaimportowany ręcznie", err)
+			log.Warnf("Nie udało się zalogować FTP: %v - certyfikat będzie musiał być zaimportowany ręcznie", err)
 		}
 	} else {
-		logger.Warnf("Nie udało się połączyć FTP: %v - certyfikat będzie musiał być zaimportowany ręcznie", err)
+		log.Warnf("Nie udało się połączyć FTP: %v - certyfikat będzie musiał być zaimportowany ręcznie", err)
 	}
 
-	logger.Infof("Połączono z routerem Mikrotik: %s", ip)
+	log.Infof("Połączono z routerem Mikrotik: %s", ip)
 
 	return &MikrotikIntegration{
-		client:   client,
-		ip:       ip,
-		username: username,
-		password: password,
-		logger:   logger,
+		client: client,
+		ip:     ip,
+		config: config,
+		logger: log,
 	}, nil
 }
 
+// SetExecutor kieruje polecenia zmieniające konfigurację routera przez executor trybu dry-run
+func (mi *MikrotikIntegration) SetExecutor(executor *Executor) {
+	mi.executor = executor
+}
+
+// routerWriteCommands to ostatnie człony poleceń RouterOS, które zmieniają konfigurację
+var routerWriteCommands = map[string]bool{"add": true, "set": true, "remove": true, "import": true}
+
+// run wykonuje polecenie RouterOS; polecenia zapisu w trybie dry-run trafiają tylko do planu
+func (mi *MikrotikIntegration) run(sentence ...string) (*routeros.Reply, error) {
+	command := sentence[0]
+	if routerWriteCommands[command[strings.LastIndex(command, "/")+1:]] &&
+		mi.executor.Skip(PlanStep{Target: PlanRouter, Operation: command, Detail: strings.TrimSpace(mi.ip + " " + strings.Join(sentence[1:], " "))}) {
+		return &routeros.Reply{}, nil
+	}
+	start := time.Now()
+	reply, err := mi.client.Run(sentence...)
+	observeCall(mi.logger, SystemRouterOS, command, start, err)
+	return reply, err
+}
+
 // UpdateServerCertificate aktualizuje certyfikat serwera na routerze Mikrotik
 func (mi *MikrotikIntegration) UpdateServerCertificate(certName, certPEM, keyPEM string) error {
 	mi.logger.Infof("Aktualizowanie certyfikatu serwera na Mikrotiku: %s", certName)
@@ -81,21 +157,22 @@ func (mi *MikrotikIntegration) UpdateServerCertificate(certName, certPEM, keyPEM
 	return nil
 }
 
-// configureOpenVPNServer ustawia certyfikat w konfiguracji serwera OpenVPN
+// configureOpenVPNServer ustawia certyfikat w konfiguracji serwera OpenVPN.
+// Odpowiedzi RouterOS nie są logowane w całości - loguje się tylko nazwę certyfikatu i ID konfiguracji.
 func (mi *MikrotikIntegration) configureOpenVPNServer(certName string) error {
 	mi.logger.Infof("Konfigurowanie serwera OpenVPN do użycia certyfikatu: %s", certName)
 
 	// Najpierw sprawdź aktualną konfigurację
-	resp, err := mi.client.Run("/interface/ovpn-server/server/print")
+	resp, err := mi.run("/interface/ovpn-server/server/print")
 	if err != nil {
 		mi.logger.Warnf("Nie udało się odczytać konfiguracji OpenVPN: %v", err)
 	} else if len(resp.Re) > 0 {
-		mi.logger.Infof("Aktualna konfiguracja OpenVPN: %v", resp.Re[0].Map)
+		mi.logger.Debugf("Aktualny certyfikat serwera OpenVPN: %s", resp.Re[0].Map["certificate"])
 	}
 
 	// Ustaw certyfikat w `/interface/ovpn-server/server`
 	// RouterOS wymaga numbers=0 aby edytować pierwszy (zazwyczaj jedyny) element
-	resp, err = mi.client.Run(
+	_, err = mi.run(
 		"/interface/ovpn-server/server/set",
 		"=numbers=0",
 		"=certificate="+certName,
@@ -105,14 +182,10 @@ func (mi *MikrotikIntegration) configureOpenVPNServer(certName string) error {
 		return fmt.Errorf("błąd podczas konfiguracji serwera OpenVPN: %w", err)
 	}
 
-	mi.logger.Infof("Polecenie set certificate zwróciło: %v", resp)
-
 	// Pobierz ID konfiguracji
-	resp, err = mi.client.Run("/interface/ovpn-server/server/print")
+	resp, err = mi.run("/interface/ovpn-server/server/print")
 	if err == nil && len(resp.Re) > 0 {
-		configID := resp.Re[0].Map[".id"]
-		mi.logger.Infof("ID konfiguracji OpenVPN: %s", configID)
-		mi.logger.Infof("Nowa konfiguracja OpenVPN: %v", resp.Re[0].Map)
+		mi.logger.Debugf("ID konfiguracji OpenVPN: %s", resp.Re[0].Map[".id"])
 
 		// Sprawdzamy czy certyfikat się zmienił
 		if cert, ok := resp.Re[0].Map["certificate"]; ok {
@@ -131,21 +204,21 @@ func (mi *MikrotikIntegration) configureOpenVPNServer(certName string) error {
 // revokeCertificate usuwa certyfikat z routera
 func (mi *MikrotikIntegration) revokeCertificate(certName string) error {
 	// Pobierz ID certyfikatu
-	reply, err := mi.client.Run("/certificate/print", "?name="+certName)
+	reply, err := mi.run("/certificate/print", "?name="+certName)
 	if err != nil {
-		return fmt.Errorf("błąd podczas pobierania certyfikatu: %w", err)
+		return newError(ErrRouterCommand, err, "błąd podczas pobierania certyfikatu")
 	}
 
 	if len(reply.Re) == 0 {
-		return fmt.Errorf("certyfikat %s nie został znaleziony", certName)
+		return newError(ErrNotFound, nil, "certyfikat %s nie został znaleziony", certName)
 	}
 
 	certID := reply.Re[0].Map[".id"]
 
 	// Usuń certyfikat
-	_, err = mi.client.Run("/certificate/remove", "=.id="+certID)
+	_, err = mi.run("/certificate/remove", "=.id="+certID)
 	if err != nil {
-		return fmt.Errorf("błąd podczas usuwania certyfikatu: %w", err)
+		return newError(ErrRouterCommand, err, "błąd podczas usuwania certyfikatu")
 	}
 
 	mi.logger.Infof("Certyfikat %s został usunięty", certName)
@@ -154,10 +227,27 @@ func (mi *MikrotikIntegration) revokeCertificate(certName string) error {
 
 // uploadFileViaFTP wysyła plik na router przez FTP
 func (mi *MikrotikIntegration) uploadFileViaFTP(remoteFilename, fileContent string) error {
+	if mi.executor.Skip(PlanStep{Target: PlanRouter, Operation: "ftp upload", Detail: mi.ip + " " + remoteFilename}) {
+		return nil
+	}
+
+	start := time.Now()
+	err := mi.storeFileViaFTP(remoteFilename, fileContent)
+	observeCall(mi.logger, SystemRouterOS, "ftp upload", start, err)
+	if err != nil {
+		return err
+	}
+
+	mi.logger.Infof("Plik wysłany na router FTP: %s", remoteFilename)
+	return nil
+}
+
+// storeFileViaFTP łączy się z serwerem FTP routera i zapisuje plik
+func (mi *MikrotikIntegration) storeFileViaFTP(remoteFilename, fileContent string) error {
 	// Połącz FTP
-	ftpClient, err := ftp.Dial(mi.ip + ":21")
+	ftpClient, err := ftp.Dial(net.JoinHostPort(mi.ip, strconv.Itoa(mi.config.FTPPort)))
 	if err != nil {
-		return fmt.Errorf("nie udało się połączyć FTP: %w", err)
+		return newError(ErrRouterUnreachable, err, "nie udało się połączyć FTP")
 	}
 	defer func() {
 		if err := ftpClient.Quit(); err != nil {
@@ -166,18 +256,17 @@ func (mi *MikrotikIntegration) uploadFileViaFTP(remoteFilename, fileContent stri
 	}()
 
 	// Zaloguj się
-	err = ftpClient.Login(mi.username, mi.password)
+	err = ftpClient.Login(mi.config.Username, mi.config.Password)
 	if err != nil {
-		return fmt.Errorf("nie udało się zalogować FTP: %w", err)
+		return newError(ErrRouterCommand, err, "nie udało się zalogować FTP")
 	}
 
 	// Wyślij plik
 	err = ftpClient.Stor(remoteFilename, bytes.NewReader([]byte(fileContent)))
 	if err != nil {
-		return fmt.Errorf("błąd podczas wysyłania pliku FTP: %w", err)
+		return newError(ErrRouterCommand, err, "błąd podczas wysyłania pliku FTP")
 	}
 
-	mi.logger.Infof("Plik wysłany na router FTP: %s", remoteFilename)
 	return nil
 }
 
@@ -201,14 +290,14 @@ func (mi *MikrotikIntegration) importCertificate(certName, certPEM, keyPEM strin
 	mi.logger.Infof("Certyfikat wysłany na router przez FTP - importowanie")
 
 	// Importujemy certyfikat
-	_, err := mi.client.Run(
+	_, err := mi.run(
 		"/certificate/import",
 		"=file-name="+certFileName,
 		"=name="+certName,
 	)
 
 	if err != nil {
-		return fmt.Errorf("błąd podczas importu certyfikatu: %w", err)
+		return newError(ErrRouterCommand, err, "błąd podczas importu certyfikatu")
 	}
 
 	mi.logger.Infof("Certyfikat %s został zaimportowany", certName)
@@ -223,7 +312,7 @@ func (mi *MikrotikIntegration) importCertificate(certName, certPEM, keyPEM strin
 func (mi *MikrotikIntegration) cleanupTempFile(file string) {
 	mi.logger.Debugf("Czyszczenie pliku tymczasowego: %s", file)
 
-	_, err := mi.client.Run("/file/remove", "=.id="+file)
+	_, err := mi.run("/file/remove", "=.id="+file)
 	if err != nil {
 		mi.logger.Warnf("Nie udało się usunąć pliku tymczasowego %s: %v", file, err)
 	}
@@ -232,7 +321,7 @@ func (mi *MikrotikIntegration) cleanupTempFile(file string) {
 // UploadCertificateToMikrotik wysyła certyfikat na router Mikrotik
 func (mi *MikrotikIntegration) UploadCertificateToMikrotik(serverCert *ServerCertificate) error {
 	if serverCert.MikrotikIP == "" {
-		return fmt.Errorf("adres IP Mikrotika nie został skonfigurowany")
+		return newError(ErrInvalidConfig, nil, "adres IP Mikrotika nie został skonfigurowany")
 	}
 
 	// Uzupełniaj nazwę certyfikatu, jeśli jest pusta
@@ -253,13 +342,13 @@ func (mi *MikrotikIntegration) UploadCertificateToMikrotik(serverCert *ServerCer
 
 // GetCertificateStatus pobiera status certyfikatu z routera
 func (mi *MikrotikIntegration) GetCertificateStatus(certName string) (map[string]string, error) {
-	reply, err := mi.client.Run("/certificate/print", "?name="+certName)
+	reply, err := mi.run("/certificate/print", "?name="+certName)
 	if err != nil {
-		return nil, fmt.Errorf("błąd podczas pobierania statusu certyfikatu: %w", err)
+		return nil, newError(ErrRouterCommand, err, "błąd podczas pobierania statusu certyfikatu")
 	}
 
 	if len(reply.Re) == 0 {
-		return nil, fmt.Errorf("certyfikat %s nie został znaleziony", certName)
+		return nil, newError(ErrNotFound, nil, "certyfikat %s nie został znaleziony", certName)
 	}
 
 	return reply.Re[0].Map, nil
@@ -267,9 +356,9 @@ func (mi *MikrotikIntegration) GetCertificateStatus(certName string) (map[string
 
 // ListCertificates listuje wszystkie certyfikaty na routerze
 func (mi *MikrotikIntegration) ListCertificates() ([]map[string]string, error) {
-	reply, err := mi.client.Run("/certificate/print")
+	reply, err := mi.run("/certificate/print")
 	if err != nil {
-		return nil, fmt.Errorf("błąd podczas listy certyfikatów: %w", err)
+		return nil, newError(ErrRouterCommand, err, "błąd podczas listy certyfikatów")
 	}
 
 	var certs []map[string]string
@@ -296,6 +385,105 @@ func (mi *MikrotikIntegration) ValidateCertificate(certName string) (bool, error
 	return true, nil
 }
 
+// OpenVPNServerCertificate zwraca nazwę certyfikatu używanego przez serwer OpenVPN
+func (mi *MikrotikIntegration) OpenVPNServerCertificate() (string, error) {
+	reply, err := mi.run("/interface/ovpn-server/server/print")
+	if err != nil {
+		return "", newError(ErrRouterCommand, err, "błąd podczas odczytu konfiguracji OpenVPN")
+	}
+	if len(reply.Re) == 0 {
+		return "", newError(ErrNotFound, nil, "router nie zwrócił konfiguracji serwera OpenVPN")
+	}
+
+	return reply.Re[0].Map["certificate"], nil
+}
+
+// RemoveCertificate usuwa certyfikat z routera
+func (mi *MikrotikIntegration) RemoveCertificate(certName string) error {
+	return mi.revokeCertificate(certName)
+}
+
+// ImportCA importuje certyfikat CA (bez klucza) jako zaufany obok certyfikatów już obecnych na routerze;
+// certyfikat o tym samym odcisku nie jest importowany ponownie
+func (mi *MikrotikIntegration) ImportCA(certName, certPEM string) error {
+	existing, err := mi.findCertificate(certPEM)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		mi.logger.Infof("Certyfikat CA jest już na routerze jako %s", existing["name"])
+		return nil
+	}
+	return mi.importCertificate(certName, certPEM, "")
+}
+
+// RemoveCA usuwa z routera certyfikat CA o odcisku certyfikatu certPEM (niezależnie od nazwy nadanej przy imporcie)
+// i zwraca jego nazwę; brak certyfikatu na routerze nie jest błędem - zwracana jest pusta nazwa
+func (mi *MikrotikIntegration) RemoveCA(certPEM string) (string, error) {
+	existing, err := mi.findCertificate(certPEM)
+	if err != nil || existing == nil {
+		return "", err
+	}
+	name := existing["name"]
+	if _, err := mi.run("/certificate/remove", "=.id="+existing[".id"]); err != nil {
+		return "", newError(ErrRouterCommand, err, "błąd podczas usuwania certyfikatu %s", name)
+	}
+	mi.logger.Infof("Certyfikat %s został usunięty", name)
+	return name, nil
+}
+
+// findCertificate szuka na routerze certyfikatu o odcisku certyfikatu certPEM; nil - brak certyfikatu
+func (mi *MikrotikIntegration) findCertificate(certPEM string) (map[string]string, error) {
+	cert, err := ParseCertificatePEM(certPEM)
+	if err != nil {
+		return nil, newError(ErrInvalidConfig, err, "nieprawidłowy certyfikat")
+	}
+	certs, err := mi.ListCertificates()
+	if err != nil {
+		return nil, err
+	}
+	expected := CertificateFingerprint(cert)
+	for _, existing := range certs {
+		if strings.ToLower(strings.ReplaceAll(existing["fingerprint"], ":", "")) == expected {
+			return existing, nil
+		}
+	}
+	return nil, nil
+}
+
+// ListFiles listuje pliki na routerze
+func (mi *MikrotikIntegration) ListFiles() ([]map[string]string, error) {
+	reply, err := mi.run("/file/print")
+	if err != nil {
+		return nil, newError(ErrRouterCommand, err, "błąd podczas listy plików")
+	}
+
+	var files []map[string]string
+	for _, re := range reply.Re {
+		files = append(files, re.Map)
+	}
+
+	return files, nil
+}
+
+// RemoveFile usuwa plik z routera
+func (mi *MikrotikIntegration) RemoveFile(fileName string) error {
+	reply, err := mi.run("/file/print", "?name="+fileName)
+	if err != nil {
+		return newError(ErrRouterCommand, err, "błąd podczas pobierania pliku %s", fileName)
+	}
+	if len(reply.Re) == 0 {
+		return newError(ErrNotFound, nil, "plik %s nie został znaleziony", fileName)
+	}
+
+	if _, err := mi.run("/file/remove", "=.id="+reply.Re[0].Map[".id"]); err != nil {
+		return newError(ErrRouterCommand, err, "błąd podczas usuwania pliku %s", fileName)
+	}
+
+	mi.logger.Infof("Plik %s został usunięty", fileName)
+	return nil
+}
+
 // Close zamyka połączenia z routerem
 func (mi *MikrotikIntegration) Close() error {
 	if mi.client != nil {
@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EventType określa rodzaj zdarzenia administracyjnego
+type EventType string
+
+const (
+	EventServerCertRotated  EventType = "server_cert_rotated"
+	EventRouterDeployFailed EventType = "router_deploy_failed"
+	EventRenewalFailed      EventType = "renewal_failed"
+	EventExpiringNoEmail    EventType = "cert_expiring_no_email"
+)
+
+// Severity określa wagę zdarzenia
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+// Event opisuje zdarzenie przeznaczone dla administratorów
+type Event struct {
+	Type       EventType         `json:"type"`
+	Severity   Severity          `json:"severity"`
+	Title      string            `json:"title"`
+	Message    string            `json:"message"`
+	CommonName string            `json:"common_name,omitempty"`
+	Fields     map[string]string `json:"fields,omitempty"`
+	Time       time.Time         `json:"time"`
+}
+
+// NewEvent tworzy zdarzenie z bieżącym czasem
+func NewEvent(eventType EventType, severity Severity, commonName, title, message string) Event {
+	return Event{
+		Type:       eventType,
+		Severity:   severity,
+		Title:      title,
+		Message:    message,
+		CommonName: commonName,
+		Fields:     make(map[string]string),
+		Time:       time.Now(),
+	}
+}
+
+// WithField dodaje dodatkową informację do zdarzenia
+func (e Event) WithField(name, value string) Event {
+	if e.Fields == nil {
+		e.Fields = make(map[string]string)
+	}
+	e.Fields[name] = value
+	return e
+}
+
+// sortedFieldNames zwraca nazwy pól w stałej kolejności, aby wiadomości były powtarzalne
+func (e Event) sortedFieldNames() []string {
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Notifier to kanał dostarczania zdarzeń administracyjnych
+type Notifier interface {
+	Notify(event Event) error
+}
+
+// MultiNotifier rozsyła zdarzenie do wszystkich skonfigurowanych kanałów
+type MultiNotifier struct {
+	notifiers []Notifier
+	// excluded to zdarzenia, których dany kanał nie dostaje, bo są mu dostarczane inną drogą
+	excluded map[Notifier][]EventType
+	logger   *logrus.Logger
+	executor *Executor
+}
+
+// NewMultiNotifier tworzy notifier rozsyłający zdarzenia do podanych kanałów
+func NewMultiNotifier(logger *logrus.Logger, notifiers ...Notifier) *MultiNotifier {
+	return &MultiNotifier{notifiers: notifiers, excluded: make(map[Notifier][]EventType), logger: logger}
+}
+
+// Exclude wyłącza dostarczanie zdarzeń podanych typów do jednego kanału
+func (mn *MultiNotifier) Exclude(notifier Notifier, types ...EventType) {
+	mn.excluded[notifier] = append(mn.excluded[notifier], types...)
+}
+
+// SetExecutor kieruje powiadomienia przez executor trybu dry-run
+func (mn *MultiNotifier) SetExecutor(executor *Executor) {
+	mn.executor = executor
+}
+
+// Notify wysyła zdarzenie do każdego kanału; błąd jednego kanału nie blokuje pozostałych
+func (mn *MultiNotifier) Notify(event Event) error {
+	if mn.executor.Skip(PlanStep{Target: PlanNotify, Operation: string(event.Type), Detail: fmt.Sprintf("%s: %s", event.CommonName, event.Title)}) {
+		return nil
+	}
+
+	var errs []error
+	for _, notifier := range mn.notifiers {
+		if slices.Contains(mn.excluded[notifier], event.Type) {
+			continue
+		}
+		if err := notifier.Notify(event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("nie udało się dostarczyć powiadomienia %s: %w", event.Type, errors.Join(errs...))
+	}
+
+	mn.logger.Debugf("Powiadomienie %s dostarczone do %d kanałów", event.Type, len(mn.notifiers))
+	return nil
+}
+
+// LoadNotifiersFromEnv buduje listę kanałów powiadomień na podstawie zmiennych środowiskowych.
+// Mailer jest zawsze dołączany - sam pomija wysyłkę, gdy brak NOTIFY_EMAIL.
+func LoadNotifiersFromEnv(mailer *Mailer, logger *logrus.Logger) (*MultiNotifier, error) {
+	notifiers := []Notifier{mailer}
+
+	channels := []struct {
+		env     string
+		factory func(string) Notifier
+	}{
+		{"NOTIFY_WEBHOOK_URL", func(u string) Notifier { return NewWebhookNotifier(u) }},
+		{"NOTIFY_SLACK_WEBHOOK_URL", func(u string) Notifier { return NewSlackNotifier(u) }},
+		{"NOTIFY_TEAMS_WEBHOOK_URL", func(u string) Notifier { return NewTeamsNotifier(u) }},
+	}
+
+	for _, channel := range channels {
+		rawURL := strings.TrimSpace(os.Getenv(channel.env))
+		if rawURL == "" {
+			continue
+		}
+		if err := validateWebhookURL(rawURL); err != nil {
+			return nil, fmt.Errorf("nieprawidłowa wartość %s: %w", channel.env, err)
+		}
+		notifiers = append(notifiers, channel.factory(rawURL))
+		logger.Debugf("Włączono kanał powiadomień %s", channel.env)
+	}
+
+	multi := NewMultiNotifier(logger, notifiers...)
+	// Wymiana certyfikatu serwera ma osobną, pełniejszą wiadomość email (SendServerRotation)
+	multi.Exclude(mailer, EventServerCertRotated)
+	return multi, nil
+}
+
+func validateWebhookURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "https" && parsed.Scheme != "http" {
+		return fmt.Errorf("adres musi zaczynać się od http:// lub https://")
+	}
+	if parsed.Host == "" {
+		return fmt.Errorf("brak hosta w adresie")
+	}
+	return nil
+}
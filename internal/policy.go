@@ -0,0 +1,253 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RenewalThreshold to próg odnawiania: stały okres przed wygaśnięciem (Before)
+// albo część czasu życia certyfikatu, po której upływie jest on odnawiany (Fraction, np. 2/3)
+type RenewalThreshold struct {
+	Before   time.Duration
+	Fraction float64
+}
+
+// ParseRenewalThreshold parsuje próg odnawiania: liczbę dni ("30"), okres ("30d", "2w", "720h"),
+// ułamek czasu życia ("2/3") lub procent czasu życia ("66%")
+func ParseRenewalThreshold(value string) (RenewalThreshold, error) {
+	value = strings.TrimSpace(value)
+	invalid := fmt.Errorf("nieprawidłowy próg odnawiania: %q (np. 30d, 2/3 lub 66%%)", value)
+
+	var fraction float64
+	switch {
+	case strings.HasSuffix(value, "%"):
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || math.IsNaN(percent) {
+			return RenewalThreshold{}, invalid
+		}
+		fraction = percent / 100
+	case strings.Contains(value, "/"):
+		numerator, denominator, _ := strings.Cut(value, "/")
+		n, err := strconv.Atoi(numerator)
+		if err != nil {
+			return RenewalThreshold{}, invalid
+		}
+		d, err := strconv.Atoi(denominator)
+		// Ujemne liczby są odrzucane także parami (-2/-3), bo dawałyby poprawny ułamek
+		if err != nil || d <= 0 || n < 0 {
+			return RenewalThreshold{}, invalid
+		}
+		fraction = float64(n) / float64(d)
+	default:
+		if _, err := strconv.Atoi(value); err == nil {
+			value += "d"
+		}
+		before, err := ParseDays(value)
+		if err != nil || before <= 0 {
+			return RenewalThreshold{}, invalid
+		}
+		return RenewalThreshold{Before: before}, nil
+	}
+
+	if fraction <= 0 || fraction >= 1 {
+		return RenewalThreshold{}, fmt.Errorf("część czasu życia w progu odnawiania musi być większa od 0 i mniejsza od 1: %q", value)
+	}
+	return RenewalThreshold{Fraction: fraction}, nil
+}
+
+// String zwraca próg w postaci niezależnej od języka logów: okres przed wygaśnięciem (30d) lub procent czasu życia (66.67%)
+func (t RenewalThreshold) String() string {
+	if t.Fraction > 0 {
+		percent := strings.TrimRight(strconv.FormatFloat(t.Fraction*100, 'f', 2, 64), "0")
+		return strings.TrimSuffix(percent, ".") + "%"
+	}
+	if t.Before%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", int64(t.Before/(24*time.Hour)))
+	}
+	return formatTTL(t.Before)
+}
+
+// RenewAt zwraca moment, od którego certyfikat ważny od issuedAt do expiresAt wymaga odnowienia.
+// Dla progu względnego bez znanego początku ważności używany jest próg domyślny (30 dni przed wygaśnięciem).
+func (t RenewalThreshold) RenewAt(issuedAt, expiresAt time.Time) time.Time {
+	if t.Fraction > 0 {
+		if issuedAt.IsZero() || !issuedAt.Before(expiresAt) {
+			return expiresAt.Add(-DefaultRenewalThresholdDays * 24 * time.Hour)
+		}
+		lifetime := expiresAt.Sub(issuedAt)
+		return issuedAt.Add(time.Duration(float64(lifetime) * t.Fraction))
+	}
+	return expiresAt.Add(-t.Before)
+}
+
+// NeedsRenewal sprawdza, czy certyfikat ważny od issuedAt do expiresAt wymaga odnowienia w chwili now
+func (t RenewalThreshold) NeedsRenewal(issuedAt, expiresAt, now time.Time) bool {
+	return !now.Before(t.RenewAt(issuedAt, expiresAt))
+}
+
+// formatTTL zapisuje czas w postaci akceptowanej przez Vault i --ttl (np. 720h)
+func formatTTL(d time.Duration) string {
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", int64(d/time.Hour))
+	}
+	return fmt.Sprintf("%ds", int64(d/time.Second))
+}
+
+// Policy to ustawienia odnawiania grupy użytkowników lub serwera; puste pola dziedziczą ustawienia domyślne
+type Policy struct {
+	// RenewBefore to próg odnawiania (np. 30d lub 2/3)
+	RenewBefore string `yaml:"renew_before"`
+	// TTL to czas ważności nowych certyfikatów
+	TTL string `yaml:"ttl"`
+	// MaxTTL ogranicza TTL podany w --ttl lub zapisany w bazie
+	MaxTTL string `yaml:"max_ttl"`
+	// AutoRenew równe false wyłącza automatyczne odnawianie - zostają przypomnienia
+	AutoRenew *bool `yaml:"auto_renew"`
+	// Mount to nazwa montowania PKI (pki.mounts), które wydaje nowe certyfikaty; odnowienia zostają w montowaniu zapisanym w bazie
+	Mount string `yaml:"mount"`
+}
+
+// PolicyConfig to sekcja policies pliku konfiguracji: polityki grup użytkowników (pole group) i serwerów (common name)
+type PolicyConfig struct {
+	Groups  map[string]Policy `yaml:"groups"`
+	Servers map[string]Policy `yaml:"servers"`
+}
+
+// AutoRenew sprawdza, czy certyfikat użytkownika jest odnawiany automatycznie:
+// wyłączenie u użytkownika (--auto-renew off) lub w polityce jego grupy wyłącza odnawianie
+func (p PolicyConfig) AutoRenew(user UserCertificate) bool {
+	if user.AutoRenewDisabled {
+		return false
+	}
+	if group, ok := p.Groups[user.Group]; ok && group.AutoRenew != nil {
+		return *group.AutoRenew
+	}
+	return true
+}
+
+// EffectivePolicy to polityka obowiązująca konkretny certyfikat po nałożeniu ustawień domyślnych, grupy lub serwera i użytkownika
+type EffectivePolicy struct {
+	Threshold RenewalThreshold
+	TTL       string
+	// MaxTTL równe 0 oznacza brak limitu poza limitem roli Vault
+	MaxTTL    time.Duration
+	AutoRenew bool
+	// Mount to montowanie PKI nowych certyfikatów; puste - montowanie domyślne
+	Mount string
+}
+
+// overlay nakłada na politykę niepuste pola polityki grupy lub serwera (poprawność sprawdza LoadServiceConfigFromEnv)
+func (e EffectivePolicy) overlay(policy Policy) EffectivePolicy {
+	if policy.RenewBefore != "" {
+		e.Threshold, _ = ParseRenewalThreshold(policy.RenewBefore)
+	}
+	if policy.TTL != "" {
+		e.TTL = policy.TTL
+	}
+	if policy.MaxTTL != "" {
+		e.MaxTTL, _ = ParseDays(policy.MaxTTL)
+	}
+	if policy.AutoRenew != nil {
+		e.AutoRenew = *policy.AutoRenew
+	}
+	if policy.Mount != "" {
+		e.Mount = policy.Mount
+	}
+	return e
+}
+
+// validatePolicy sprawdza pola polityki i to, czy jej TTL mieści się w obowiązującym limicie
+func validatePolicy(policy Policy, defaults EffectivePolicy) error {
+	if policy.RenewBefore != "" {
+		if _, err := ParseRenewalThreshold(policy.RenewBefore); err != nil {
+			return err
+		}
+	}
+	if policy.TTL != "" {
+		if _, err := ParseDays(policy.TTL); err != nil {
+			return fmt.Errorf("ttl: %w", err)
+		}
+	}
+	if policy.MaxTTL != "" {
+		if maxTTL, err := ParseDays(policy.MaxTTL); err != nil || maxTTL == 0 {
+			return fmt.Errorf("max_ttl: nieprawidłowy okres: %q", policy.MaxTTL)
+		}
+	}
+	effective := defaults.overlay(policy)
+	ttl, _ := ParseDays(effective.TTL)
+	if effective.MaxTTL > 0 && ttl > effective.MaxTTL {
+		return fmt.Errorf("TTL %s przekracza max_ttl %s", effective.TTL, formatTTL(effective.MaxTTL))
+	}
+	return nil
+}
+
+// validatePolicies sprawdza polityki grup i serwerów w kolejności alfabetycznej (powtarzalne komunikaty błędów)
+func validatePolicies(kind string, policies map[string]Policy, defaults EffectivePolicy) error {
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := validatePolicy(policies[name], defaults); err != nil {
+			return newError(ErrInvalidConfig, err, "nieprawidłowa polityka %s %q", kind, name)
+		}
+	}
+	return nil
+}
+
+// CheckMounts sprawdza, czy polityki grup i serwerów wskazują montowania PKI zdefiniowane w pliku konfiguracji
+func (p PolicyConfig) CheckMounts(mounts map[string]PKIMount) error {
+	for _, policies := range []struct {
+		kind     string
+		policies map[string]Policy
+	}{{"grupy", p.Groups}, {"serwera", p.Servers}} {
+		names := make([]string, 0, len(policies.policies))
+		for name := range policies.policies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			mount := policies.policies[name].Mount
+			if _, ok := mounts[mount]; ok || mount == "" || mount == DefaultMountName {
+				continue
+			}
+			return newError(ErrInvalidConfig, nil, "polityka %s %q wskazuje nieznane montowanie PKI %q", policies.kind, name, mount)
+		}
+	}
+	return nil
+}
+
+// UserPolicy zwraca politykę użytkownika: ustawienia zapisane w bazie (TTL, próg, auto-renew) mają pierwszeństwo
+// przed polityką grupy, a ta przed ustawieniami domyślnymi
+func (c ServiceConfig) UserPolicy(user UserCertificate) EffectivePolicy {
+	policy := c.defaultPolicy(c.ClientTTL).overlay(c.Policies.Groups[user.Group])
+	if user.TTL != "" {
+		policy.TTL = user.TTL
+	}
+	if user.RenewBefore != "" {
+		if threshold, err := ParseRenewalThreshold(user.RenewBefore); err == nil {
+			policy.Threshold = threshold
+		}
+	}
+	policy.AutoRenew = c.Policies.AutoRenew(user)
+	return policy
+}
+
+// ServerPolicy zwraca politykę serwera; TTL zapisany w bazie przy poprzednim wydaniu ma pierwszeństwo przed polityką
+func (c ServiceConfig) ServerPolicy(commonName, storedTTL string) EffectivePolicy {
+	policy := c.defaultPolicy(c.ServerTTL).overlay(c.Policies.Servers[commonName])
+	if storedTTL != "" {
+		policy.TTL = storedTTL
+	}
+	return policy
+}
+
+// defaultPolicy zwraca politykę z ustawień domyślnych (RENEWAL_THRESHOLD, CLIENT_TTL/SERVER_TTL, MAX_TTL)
+func (c ServiceConfig) defaultPolicy(ttl string) EffectivePolicy {
+	return EffectivePolicy{Threshold: c.RenewalThreshold, TTL: ttl, MaxTTL: c.MaxTTL, AutoRenew: true}
+}
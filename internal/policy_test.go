@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRenewalThreshold(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		value  string
+		want   RenewalThreshold
+		string string
+	}{
+		{"30", RenewalThreshold{Before: 30 * day}, "30d"},
+		{" 30d ", RenewalThreshold{Before: 30 * day}, "30d"},
+		{"2w", RenewalThreshold{Before: 14 * day}, "14d"},
+		{"720h", RenewalThreshold{Before: 30 * day}, "30d"},
+		{"36h", RenewalThreshold{Before: 36 * time.Hour}, "36h"},
+		{"90m", RenewalThreshold{Before: 90 * time.Minute}, "5400s"},
+		{"2/3", RenewalThreshold{Fraction: 2.0 / 3}, "66.67%"},
+		{"1/2", RenewalThreshold{Fraction: 0.5}, "50%"},
+		{"66%", RenewalThreshold{Fraction: 0.66}, "66%"},
+		{"12.5%", RenewalThreshold{Fraction: 0.125}, "12.5%"},
+	}
+	for _, tt := range tests {
+		got, err := ParseRenewalThreshold(tt.value)
+		if err != nil {
+			t.Errorf("ParseRenewalThreshold(%q): %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRenewalThreshold(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+		if got.String() != tt.string {
+			t.Errorf("ParseRenewalThreshold(%q).String() = %q, want %q", tt.value, got.String(), tt.string)
+		}
+	}
+}
+
+func TestParseRenewalThresholdInvalid(t *testing.T) {
+	for _, value := range []string{
+		"", "0", "0d", "-5d", "-1", "abc", "30x",
+		"1/0", "0/3", "3/3", "4/3", "-1/3", "-2/-3", "1/-2", "a/3", "2/b", "1.5/3",
+		"0%", "100%", "150%", "-10%", "NaN%", "Inf%", "%",
+	} {
+		if got, err := ParseRenewalThreshold(value); err == nil {
+			t.Errorf("ParseRenewalThreshold(%q) = %+v, want error", value, got)
+		}
+	}
+}
+
+func TestRenewalThresholdRenewAt(t *testing.T) {
+	issued := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	expires := issued.Add(300 * 24 * time.Hour)
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name      string
+		threshold RenewalThreshold
+		issued    time.Time
+		want      time.Time
+	}{
+		{"before expiry", RenewalThreshold{Before: 30 * day}, issued, expires.Add(-30 * day)},
+		{"fraction of lifetime", RenewalThreshold{Fraction: 2.0 / 3}, issued, issued.Add(200 * day)},
+		// Bez daty wydania próg względny przechodzi na domyślne 30 dni przed wygaśnięciem
+		{"fraction without issue date", RenewalThreshold{Fraction: 0.5}, time.Time{}, expires.Add(-DefaultRenewalThresholdDays * day)},
+		{"fraction with issue after expiry", RenewalThreshold{Fraction: 0.5}, expires.Add(day), expires.Add(-DefaultRenewalThresholdDays * day)},
+	}
+	for _, tt := range tests {
+		renewAt := tt.threshold.RenewAt(tt.issued, expires)
+		if !renewAt.Equal(tt.want) {
+			t.Errorf("%s: RenewAt = %s, want %s", tt.name, renewAt, tt.want)
+		}
+		if tt.threshold.NeedsRenewal(tt.issued, expires, renewAt.Add(-time.Second)) || !tt.threshold.NeedsRenewal(tt.issued, expires, renewAt) {
+			t.Errorf("%s: NeedsRenewal does not switch at %s", tt.name, renewAt)
+		}
+	}
+}
@@ -0,0 +1,580 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Domyślne ustawienia portalu samoobsługowego
+const (
+	DefaultPortalLinkTTL    = 15 * time.Minute
+	DefaultPortalSessionTTL = time.Hour
+	DefaultPortalRateLimit  = 5
+	DefaultPortalAuditLog   = "portal-audit.log"
+	DefaultPortalUsedLinks  = "portal-used-links.json"
+)
+
+// portalAuthPage to strona potwierdzenia logowania z linku - skanery poczty otwierające linki (GET) nie zużywają go
+const portalAuthPage = "portal-auth.html"
+
+// errPortalRateLimit to przekroczenie limitu operacji portalu (HTTP 429)
+var errPortalRateLimit = errors.New("zbyt wiele operacji, spróbuj później")
+
+// portalIPLimitFactor to wielokrotność PORTAL_RATE_LIMIT dozwolona dla jednego adresu IP
+const portalIPLimitFactor = 10
+
+// portalCookie to nazwa ciasteczka sesji portalu
+const portalCookie = "pinpoint_portal"
+
+// Przeznaczenie podpisanych tokenów portalu
+const (
+	portalTokenLogin   = "login"
+	portalTokenSession = "session"
+)
+
+// Akcje zapisywane w dzienniku portalu
+const (
+	PortalActionLoginLink = "login_link"
+	PortalActionLogin     = "login"
+	PortalActionRotate    = "rotate"
+	PortalActionDownload  = "download"
+)
+
+// PortalConfig przechowuje ustawienia portalu samoobsługowego (polecenie serve)
+type PortalConfig struct {
+	// Secret podpisuje linki logowania i sesje (PORTAL_SECRET); pusty wyłącza portal
+	Secret []byte
+	// URL to publiczny adres serwera używany w linkach logowania (PORTAL_URL)
+	URL        string
+	LinkTTL    time.Duration
+	SessionTTL time.Duration
+	// RateLimit to liczba żądań linku logowania oraz operacji na certyfikatach na godzinę dla adresu email
+	RateLimit int
+	// AuditLog to plik dziennika operacji portalu (JSON, jeden wpis na linię)
+	AuditLog string
+	// UsedLinks to plik wykorzystanych linków logowania - jednorazowość przetrwa restart serwera
+	UsedLinks string
+}
+
+// Enabled informuje, czy portal został skonfigurowany
+func (c PortalConfig) Enabled() bool {
+	return len(c.Secret) > 0
+}
+
+// LoadPortalConfigFromEnv wczytuje konfigurację portalu; bez PORTAL_SECRET portal jest wyłączony
+func LoadPortalConfigFromEnv() (PortalConfig, error) {
+	config := PortalConfig{
+		Secret:     []byte(os.Getenv("PORTAL_SECRET")),
+		URL:        strings.TrimSuffix(os.Getenv("PORTAL_URL"), "/"),
+		LinkTTL:    DefaultPortalLinkTTL,
+		SessionTTL: DefaultPortalSessionTTL,
+		RateLimit:  DefaultPortalRateLimit,
+		AuditLog:   firstNonEmpty(os.Getenv("PORTAL_AUDIT_LOG"), DefaultPortalAuditLog),
+		UsedLinks:  firstNonEmpty(os.Getenv("PORTAL_USED_LINKS"), DefaultPortalUsedLinks),
+	}
+	if !config.Enabled() {
+		return config, nil
+	}
+
+	if len(config.Secret) < 32 {
+		return config, newError(ErrInvalidConfig, nil, "PORTAL_SECRET musi mieć co najmniej 32 znaki")
+	}
+	if parsed, err := url.Parse(config.URL); err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return config, newError(ErrInvalidConfig, err, "PORTAL_URL musi być adresem http(s) serwera, np. https://vpn-portal.example.com")
+	}
+	for name, target := range map[string]*time.Duration{"PORTAL_LINK_TTL": &config.LinkTTL, "PORTAL_SESSION_TTL": &config.SessionTTL} {
+		if value := os.Getenv(name); value != "" {
+			duration, err := time.ParseDuration(value)
+			if err != nil || duration <= 0 {
+				return config, newError(ErrInvalidConfig, err, "nieprawidłowa wartość %s %q", name, value)
+			}
+			*target = duration
+		}
+	}
+	if value := os.Getenv("PORTAL_RATE_LIMIT"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return config, newError(ErrInvalidConfig, err, "nieprawidłowa wartość PORTAL_RATE_LIMIT %q", value)
+		}
+		config.RateLimit = limit
+	}
+	return config, nil
+}
+
+// portalToken to podpisana treść linku logowania lub sesji
+type portalToken struct {
+	Purpose string `json:"p"`
+	Email   string `json:"e"`
+	Expires int64  `json:"x"`
+	Nonce   string `json:"n,omitempty"`
+}
+
+// sign zwraca token w postaci <treść>.<podpis HMAC-SHA256> (base64url)
+func (c PortalConfig) sign(token portalToken) string {
+	payload, _ := json.Marshal(token)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, c.Secret)
+	mac.Write([]byte(encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify sprawdza podpis, przeznaczenie i ważność tokena
+func (c PortalConfig) verify(value, purpose string, now time.Time) (portalToken, error) {
+	var token portalToken
+	encoded, signature, found := strings.Cut(value, ".")
+	if !found {
+		return token, fmt.Errorf("nieprawidłowy token")
+	}
+	expected := hmac.New(sha256.New, c.Secret)
+	expected.Write([]byte(encoded))
+	decodedSignature, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(decodedSignature, expected.Sum(nil)) {
+		return token, fmt.Errorf("nieprawidłowy podpis tokena")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || json.Unmarshal(payload, &token) != nil {
+		return token, fmt.Errorf("nieprawidłowy token")
+	}
+	if token.Purpose != purpose {
+		return token, fmt.Errorf("token nie jest przeznaczony do %s", purpose)
+	}
+	if now.Unix() > token.Expires {
+		return token, fmt.Errorf("token wygasł")
+	}
+	return token, nil
+}
+
+// rateLimiter ogranicza liczbę zdarzeń dla klucza w oknie czasowym
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+	mutex  sync.Mutex
+	hits   map[string][]time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, hits: make(map[string][]time.Time)}
+}
+
+// allow rejestruje zdarzenie i zwraca false, jeśli limit dla klucza został przekroczony
+func (l *rateLimiter) allow(key string, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var recent []time.Time
+	for _, hit := range l.hits[key] {
+		if now.Sub(hit) < l.window {
+			recent = append(recent, hit)
+		}
+	}
+	if len(recent) >= l.limit {
+		l.hits[key] = recent
+		return false
+	}
+	l.hits[key] = append(recent, now)
+	return true
+}
+
+// PortalAuditEntry to wpis dziennika operacji portalu
+type PortalAuditEntry struct {
+	Time       time.Time `json:"time"`
+	Actor      string    `json:"actor"`
+	Action     string    `json:"action"`
+	CommonName string    `json:"common_name,omitempty"`
+	RemoteAddr string    `json:"remote_addr"`
+	Result     string    `json:"result"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// portal przechowuje stan portalu samoobsługowego
+type portal struct {
+	config  PortalConfig
+	limiter *rateLimiter
+	// ipLimiter chroni przed wysyłaniem linków na wiele adresów z jednego miejsca; jest luźniejszy,
+	// bo wiele osób może łączyć się zza jednego NAT-u
+	ipLimiter *rateLimiter
+	auditMu   sync.Mutex
+}
+
+// EnablePortal włącza portal samoobsługowy pod /portal/
+func (s *APIServer) EnablePortal(config PortalConfig) {
+	s.portal = &portal{
+		config:    config,
+		limiter:   newRateLimiter(config.RateLimit, time.Hour),
+		ipLimiter: newRateLimiter(config.RateLimit*portalIPLimitFactor, time.Hour),
+	}
+}
+
+// registerPortal rejestruje ścieżki portalu; sesja pochodzi z linku email lub tokena OIDC
+func (s *APIServer) registerPortal(mux *http.ServeMux) {
+	mux.HandleFunc("GET /portal/{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, s.ui, "portal.html")
+	})
+	mux.HandleFunc("POST /portal/login", s.portalLogin)
+	mux.HandleFunc("GET /portal/auth", s.portalAuthConfirm)
+	mux.HandleFunc("POST /portal/auth", s.portalAuth)
+	mux.HandleFunc("POST /portal/logout", s.portalLogout)
+
+	s.handlePortal(mux, "GET /portal/api/certificates", s.portalCertificates)
+	s.handlePortal(mux, "POST /portal/api/certificates/{name}/rotate", s.portalRotate)
+	s.handlePortal(mux, "GET /portal/api/certificates/{name}/profile", s.portalProfile)
+}
+
+// handlePortal rejestruje ścieżkę wymagającą sesji portalu; handler dostaje adres email zalogowanej osoby
+func (s *APIServer) handlePortal(mux *http.ServeMux, pattern string, handler apiHandler) {
+	mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		email, err := s.portalIdentity(r)
+		if err != nil {
+			writeAPIJSON(w, http.StatusUnauthorized, map[string]string{"error": "zaloguj się ponownie"})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, apiMaxBodySize)
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if err := handler(w, r, email); err != nil {
+			s.writeError(w, r, email, err)
+		}
+	})
+}
+
+// portalIdentity zwraca email z ciasteczka sesji lub z tokena OIDC (Authorization: Bearer)
+func (s *APIServer) portalIdentity(r *http.Request) (string, error) {
+	if cookie, err := r.Cookie(portalCookie); err == nil {
+		token, err := s.portal.config.verify(cookie.Value, portalTokenSession, time.Now())
+		if err != nil {
+			return "", err
+		}
+		return token.Email, nil
+	}
+
+	bearer, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || s.auth.config.OIDCIssuer == "" {
+		return "", fmt.Errorf("brak sesji portalu")
+	}
+	return s.auth.oidcEmail(bearer)
+}
+
+// portalLogin wysyła link logowania, jeśli adres należy do użytkownika z aktywnym certyfikatem.
+// Odpowiedź jest zawsze taka sama, aby nie ujawniać, które adresy są w bazie.
+func (s *APIServer) portalLogin(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, apiMaxBodySize)
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := decodeAPIRequest(r, &req); err != nil || !strings.Contains(req.Email, "@") {
+		writeAPIJSON(w, http.StatusBadRequest, map[string]string{"error": "podaj adres email"})
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+
+	now := time.Now()
+	if !s.portal.limiter.allow("login:"+email, now) || !s.portal.ipLimiter.allow(remoteIP(r), now) {
+		s.portalAudit(r, email, PortalActionLoginLink, "", fmt.Errorf("przekroczono limit żądań"))
+		writeAPIJSON(w, http.StatusTooManyRequests, map[string]string{"error": "zbyt wiele prób, spróbuj później"})
+		return
+	}
+
+	s.mutex.Lock()
+	err := s.sendLoginLink(email, now)
+	s.mutex.Unlock()
+	s.portalAudit(r, email, PortalActionLoginLink, "", err)
+	if err != nil {
+		s.logger.Warnf("Portal: nie wysłano linku logowania dla %s: %v", email, err)
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]string{"status": "sent"})
+}
+
+// sendLoginLink wysyła jednorazowy link logowania na adres zapisany przy certyfikacie użytkownika
+func (s *APIServer) sendLoginLink(email string, now time.Time) error {
+	certDB, err := s.backend.Database()
+	if err != nil {
+		return err
+	}
+	var locale string
+	found := false
+	for _, user := range certDB.GetAllUsers() {
+		if strings.EqualFold(user.Email, email) && !user.IsRevoked() {
+			found = true
+			locale = firstNonEmpty(locale, user.Locale)
+		}
+	}
+	if !found {
+		return newError(ErrNotFound, nil, "brak aktywnego certyfikatu dla adresu %s", email)
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	expiresAt := now.Add(s.portal.config.LinkTTL)
+	token := s.portal.config.sign(portalToken{
+		Purpose: portalTokenLogin,
+		Email:   email,
+		Expires: expiresAt.Unix(),
+		Nonce:   hex.EncodeToString(nonce),
+	})
+	link := s.portal.config.URL + "/portal/auth?token=" + url.QueryEscape(token)
+
+	mailer, err := s.backend.Mailer()
+	if err != nil {
+		return err
+	}
+	return mailer.SendLoginLink(email, locale, link, expiresAt)
+}
+
+// portalAuthConfirm wyświetla stronę z przyciskiem logowania; link jest zużywany dopiero przez POST /portal/auth
+func (s *APIServer) portalAuthConfirm(w http.ResponseWriter, r *http.Request) {
+	value := r.URL.Query().Get("token")
+	token, err := s.portal.config.verify(value, portalTokenLogin, time.Now())
+	if err == nil {
+		err = s.checkLink(token)
+	}
+	if err != nil {
+		http.Redirect(w, r, "/portal/?error=link", http.StatusSeeOther)
+		return
+	}
+
+	page, err := htmltemplate.ParseFS(s.ui, portalAuthPage)
+	if err != nil {
+		s.logger.Errorf("Portal: nie udało się wczytać strony %s: %v", portalAuthPage, err)
+		http.Error(w, "błąd serwera", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	page.Execute(w, map[string]string{"Email": token.Email, "Token": value})
+}
+
+// portalAuth zamienia link logowania (pole token formularza) na ciasteczko sesji i przekierowuje do portalu
+func (s *APIServer) portalAuth(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, apiMaxBodySize)
+	now := time.Now()
+	token, err := s.portal.config.verify(r.PostFormValue("token"), portalTokenLogin, now)
+	if err == nil {
+		err = s.useLink(token, now)
+	}
+	s.portalAudit(r, token.Email, PortalActionLogin, "", err)
+	if err != nil {
+		http.Redirect(w, r, "/portal/?error=link", http.StatusSeeOther)
+		return
+	}
+
+	session := s.portal.config.sign(portalToken{
+		Purpose: portalTokenSession,
+		Email:   token.Email,
+		Expires: now.Add(s.portal.config.SessionTTL).Unix(),
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     portalCookie,
+		Value:    session,
+		Path:     "/portal/",
+		MaxAge:   int(s.portal.config.SessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(s.portal.config.URL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/portal/", http.StatusSeeOther)
+}
+
+// checkLink sprawdza, czy link logowania nie został już wykorzystany
+func (s *APIServer) checkLink(token portalToken) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	used, err := loadUsedLinks(s.portal.config.UsedLinks)
+	if err != nil {
+		return err
+	}
+	if _, found := used[token.Nonce]; found {
+		return fmt.Errorf("link logowania został już wykorzystany")
+	}
+	return nil
+}
+
+// useLink oznacza link logowania jako wykorzystany w pliku PORTAL_USED_LINKS; ponowne użycie jest błędem.
+// Błąd odczytu lub zapisu pliku odrzuca logowanie - link nie może zadziałać dwa razy.
+func (s *APIServer) useLink(token portalToken, now time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	used, err := loadUsedLinks(s.portal.config.UsedLinks)
+	if err != nil {
+		return err
+	}
+	for nonce, expires := range used {
+		if now.After(expires) {
+			delete(used, nonce)
+		}
+	}
+	if _, found := used[token.Nonce]; found {
+		return fmt.Errorf("link logowania został już wykorzystany")
+	}
+	used[token.Nonce] = time.Unix(token.Expires, 0)
+	return saveUsedLinks(s.portal.config.UsedLinks, used)
+}
+
+// loadUsedLinks wczytuje wykorzystane linki logowania (nonce -> wygaśnięcie); brak pliku to pusta lista
+func loadUsedLinks(path string) (map[string]time.Time, error) {
+	used := make(map[string]time.Time)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return used, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("nie udało się odczytać %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &used); err != nil {
+		return nil, fmt.Errorf("nieprawidłowy plik %s: %w", path, err)
+	}
+	return used, nil
+}
+
+// saveUsedLinks zapisuje wykorzystane linki logowania (plik tymczasowy i zmiana nazwy)
+func saveUsedLinks(path string, used map[string]time.Time) error {
+	data, err := json.Marshal(used)
+	if err != nil {
+		return err
+	}
+	tempFile := path + ".tmp"
+	if err := os.WriteFile(tempFile, data, 0600); err != nil {
+		return fmt.Errorf("nie udało się zapisać %s: %w", tempFile, err)
+	}
+	if err := os.Rename(tempFile, path); err != nil {
+		return fmt.Errorf("nie udało się zapisać %s: %w", path, err)
+	}
+	return nil
+}
+
+func (s *APIServer) portalLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: portalCookie, Value: "", Path: "/portal/", MaxAge: -1, HttpOnly: true})
+	writeAPIJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// portalCertificates zwraca certyfikaty użytkowników przypisane do adresu zalogowanej osoby
+func (s *APIServer) portalCertificates(w http.ResponseWriter, r *http.Request, email string) error {
+	certDB, err := s.backend.Database()
+	if err != nil {
+		return err
+	}
+	entries := []InventoryEntry{}
+	for _, entry := range BuildInventory(certDB, InventoryFilter{}, time.Now()) {
+		if entry.Type == EntryUser && strings.EqualFold(entry.Email, email) {
+			entries = append(entries, entry)
+		}
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]interface{}{"email": email, "certificates": entries})
+	return nil
+}
+
+// ownedEntry zwraca aktywny certyfikat należący do zalogowanej osoby; cudze certyfikaty są "nieznalezione"
+func (s *APIServer) ownedEntry(commonName, email string) (InventoryEntry, error) {
+	entry, err := s.entry(commonName, EntryUser)
+	if err != nil || !strings.EqualFold(entry.Email, email) || entry.RevokedAt != nil {
+		return InventoryEntry{}, newError(ErrNotFound, nil, "nie masz aktywnego certyfikatu %s", commonName)
+	}
+	return entry, nil
+}
+
+// portalRotate odwołuje bieżący certyfikat i wydaje nowy (jak client issue --force-renew)
+func (s *APIServer) portalRotate(w http.ResponseWriter, r *http.Request, email string) (err error) {
+	commonName := r.PathValue("name")
+	defer func() { s.portalAudit(r, email, PortalActionRotate, commonName, err) }()
+
+	if !s.portal.limiter.allow("action:"+email, time.Now()) {
+		return errPortalRateLimit
+	}
+	entry, err := s.ownedEntry(commonName, email)
+	if err != nil {
+		return err
+	}
+	// Rotacja z portalu to zwykle reakcja na utratę urządzenia - stary certyfikat nie dostaje okresu przejściowego
+	return s.issue(w, ClientRequest{CommonName: entry.CommonName, Force: true, RevokeImmediately: true})
+}
+
+// portalProfile zwraca zapisany profil .ovpn do pobrania
+func (s *APIServer) portalProfile(w http.ResponseWriter, r *http.Request, email string) (err error) {
+	commonName := r.PathValue("name")
+	defer func() { s.portalAudit(r, email, PortalActionDownload, commonName, err) }()
+
+	if !s.portal.limiter.allow("action:"+email, time.Now()) {
+		return errPortalRateLimit
+	}
+	entry, err := s.ownedEntry(commonName, email)
+	if err != nil {
+		return err
+	}
+	service, err := s.backend.Service()
+	if err != nil {
+		return err
+	}
+	profile, err := service.ClientProfile(entry.CommonName)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/x-openvpn-profile")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", entry.CommonName+".ovpn"))
+	_, err = w.Write([]byte(profile))
+	return err
+}
+
+// portalAudit zapisuje operację w dzienniku portalu i w logu programu
+func (s *APIServer) portalAudit(r *http.Request, actor, action, commonName string, err error) {
+	entry := PortalAuditEntry{
+		Time:       time.Now().UTC(),
+		Actor:      actor,
+		Action:     action,
+		CommonName: commonName,
+		RemoteAddr: remoteIP(r),
+		Result:     ResultSuccess,
+	}
+	if err != nil {
+		entry.Result = ResultFailure
+		entry.Error = err.Error()
+	}
+	if err != nil {
+		s.logger.Warnf("Portal: %s %s %s z %s nie powiodło się: %v", actor, action, commonName, entry.RemoteAddr, err)
+	} else {
+		s.logger.Infof("Portal: %s %s %s z %s", actor, action, commonName, entry.RemoteAddr)
+	}
+
+	line, _ := json.Marshal(entry)
+	s.portal.auditMu.Lock()
+	defer s.portal.auditMu.Unlock()
+	file, openErr := os.OpenFile(s.portal.config.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if openErr != nil {
+		s.logger.Errorf("Portal: nie udało się otworzyć dziennika %s: %v", s.portal.config.AuditLog, openErr)
+		return
+	}
+	defer file.Close()
+	if _, writeErr := file.Write(append(line, '\n')); writeErr != nil {
+		s.logger.Errorf("Portal: nie udało się zapisać dziennika %s: %v", s.portal.config.AuditLog, writeErr)
+	}
+}
+
+// remoteIP zwraca adres klienta bez portu
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
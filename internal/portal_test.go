@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestPortal(t *testing.T, dir string) (*APIServer, http.Handler) {
+	t.Helper()
+	certDB := NewCertificateDB(filepath.Join(dir, "certificates.json"), testLogger())
+	ui := os.DirFS("../web")
+	server := NewAPIServer(&testAPIBackend{certDB: certDB}, APIConfig{Token: "admin-token"}, ui, testLogger())
+	server.EnablePortal(PortalConfig{
+		Secret:     []byte(strings.Repeat("s", 32)),
+		URL:        "https://vpn-portal.example.com",
+		LinkTTL:    DefaultPortalLinkTTL,
+		SessionTTL: DefaultPortalSessionTTL,
+		RateLimit:  DefaultPortalRateLimit,
+		AuditLog:   filepath.Join(dir, "portal-audit.log"),
+		UsedLinks:  filepath.Join(dir, "portal-used-links.json"),
+	})
+	return server, server.Handler()
+}
+
+func loginLink(server *APIServer, email, nonce string) string {
+	return server.portal.config.sign(portalToken{
+		Purpose: portalTokenLogin,
+		Email:   email,
+		Expires: time.Now().Add(time.Minute).Unix(),
+		Nonce:   nonce,
+	})
+}
+
+func confirmLogin(handler http.Handler, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/portal/auth", strings.NewReader(url.Values{"token": {token}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func sessionCookie(resp *httptest.ResponseRecorder) bool {
+	for _, cookie := range resp.Result().Cookies() {
+		if cookie.Name == portalCookie && cookie.Value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPortalAuthConfirmDoesNotConsumeLink(t *testing.T) {
+	server, handler := newTestPortal(t, t.TempDir())
+	token := loginLink(server, "jan@example.com", "nonce-1")
+
+	// Otwarcie linku (np. przez skaner poczty) pokazuje tylko stronę potwierdzenia
+	for range 2 {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/portal/auth?token="+url.QueryEscape(token), nil))
+		if recorder.Code != http.StatusOK || sessionCookie(recorder) {
+			t.Fatalf("GET: HTTP %d, session cookie %v", recorder.Code, sessionCookie(recorder))
+		}
+		if body := recorder.Body.String(); !strings.Contains(body, `action="/portal/auth"`) || !strings.Contains(body, "jan@example.com") {
+			t.Fatalf("GET: confirm page missing form:\n%s", body)
+		}
+	}
+
+	if resp := confirmLogin(handler, token); resp.Code != http.StatusSeeOther || !sessionCookie(resp) {
+		t.Fatalf("POST: HTTP %d, session cookie %v", resp.Code, sessionCookie(resp))
+	}
+	if resp := confirmLogin(handler, token); sessionCookie(resp) || resp.Header().Get("Location") != "/portal/?error=link" {
+		t.Errorf("second POST logged in again (Location %q)", resp.Header().Get("Location"))
+	}
+
+	// Wykorzystany link nie pokazuje już strony potwierdzenia
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/portal/auth?token="+url.QueryEscape(token), nil))
+	if recorder.Header().Get("Location") != "/portal/?error=link" {
+		t.Errorf("GET after use: HTTP %d, Location %q", recorder.Code, recorder.Header().Get("Location"))
+	}
+}
+
+func TestPortalUsedLinksSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+	server, handler := newTestPortal(t, dir)
+	token := loginLink(server, "jan@example.com", "nonce-2")
+	if resp := confirmLogin(handler, token); !sessionCookie(resp) {
+		t.Fatalf("first login failed: HTTP %d", resp.Code)
+	}
+
+	_, restarted := newTestPortal(t, dir)
+	if resp := confirmLogin(restarted, token); sessionCookie(resp) {
+		t.Fatalf("link worked again after restart")
+	}
+}
+
+func TestPortalRejectsForgedLink(t *testing.T) {
+	_, handler := newTestPortal(t, t.TempDir())
+	other, _ := newTestPortal(t, t.TempDir())
+	other.portal.config.Secret = []byte(strings.Repeat("x", 32))
+
+	if resp := confirmLogin(handler, loginLink(other, "jan@example.com", "nonce-3")); sessionCookie(resp) {
+		t.Fatalf("link signed with another secret was accepted")
+	}
+}
+
+func TestOIDCEmailRequiresVerifiedEmail(t *testing.T) {
+	tests := []struct {
+		userinfo string
+		ok       bool
+	}{
+		{`{"email": "jan@example.com", "email_verified": true}`, true},
+		{`{"email": "jan@example.com", "email_verified": false}`, false},
+		{`{"email": "jan@example.com"}`, false},
+		{`{"email_verified": true}`, false},
+	}
+	for _, tt := range tests {
+		var provider *httptest.Server
+		provider = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/.well-known/openid-configuration" {
+				fmt.Fprintf(w, `{"userinfo_endpoint": %q}`, provider.URL+"/userinfo")
+				return
+			}
+			fmt.Fprint(w, tt.userinfo)
+		}))
+		auth := newAPIAuthenticator(APIConfig{OIDCIssuer: provider.URL, OIDCAllowed: []string{"@example.com"}})
+
+		email, err := auth.oidcEmail("token")
+		if tt.ok && (err != nil || email != "jan@example.com") {
+			t.Errorf("%s: email %q, err %v", tt.userinfo, email, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: accepted %q", tt.userinfo, email)
+		}
+		provider.Close()
+	}
+}
@@ -0,0 +1,384 @@
+package internal
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Rodzaje rozbieżności między bazą danych a Vault
+const (
+	// FindingOrphan - ważny certyfikat w Vault, którego nie ma w bazie
+	FindingOrphan = "orphan"
+	// FindingMissing - wpis w bazie, którego certyfikatu nie ma w Vault
+	FindingMissing = "missing"
+	// FindingRevokedActive - certyfikat odwołany w Vault, a w bazie nadal aktywny
+	FindingRevokedActive = "revoked_active"
+	// FindingExpiryMismatch - data wygaśnięcia w bazie różni się od certyfikatu w Vault
+	FindingExpiryMismatch = "expiry_mismatch"
+)
+
+// expiryTolerance pomija różnice wynikające z zaokrągleń przy zapisie dat
+const expiryTolerance = time.Minute
+
+// ReconcileFinding to jedna wykryta rozbieżność
+type ReconcileFinding struct {
+	Kind         string `json:"kind"`
+	Type         string `json:"type,omitempty"` // user, server lub pusty dla certyfikatów spoza bazy
+	CommonName   string `json:"common_name"`
+	SerialNumber string `json:"serial_number"`
+	Detail       string `json:"detail"`
+	Fixed        bool   `json:"fixed"`
+}
+
+// ReconcileReport podsumowuje porównanie bazy danych z Vault
+type ReconcileReport struct {
+	VaultCertificates int                `json:"vault_certificates"`
+	DBRecords         int                `json:"db_records"`
+	Findings          []ReconcileFinding `json:"findings"`
+}
+
+// Fixed zwraca liczbę naprawionych rozbieżności
+func (r *ReconcileReport) Fixed() int {
+	fixed := 0
+	for _, finding := range r.Findings {
+		if finding.Fixed {
+			fixed++
+		}
+	}
+	return fixed
+}
+
+// Reconciler porównuje bazę certyfikatów z listą certyfikatów wydanych przez Vault
+type Reconciler struct {
+	certDB *CertificateDB
+	vault  *VaultClient
+	// gracePeriod to RENEWAL_GRACE_PERIOD - certyfikat zastąpiony przy naprawie jest odwoływany jak przy odnowieniu
+	gracePeriod time.Duration
+	logger      *logrus.Logger
+}
+
+// NewReconciler tworzy obiekt uzgadniający bazę danych z Vault
+func NewReconciler(certDB *CertificateDB, vault *VaultClient, gracePeriod time.Duration, logger *logrus.Logger) *Reconciler {
+	return &Reconciler{certDB: certDB, vault: vault, gracePeriod: gracePeriod, logger: logger}
+}
+
+// dbRecord to wpis bazy (użytkownik lub serwer) sprowadzony do pól potrzebnych do porównania
+type dbRecord struct {
+	Type       string
+	CommonName string
+	// Owner to common name użytkownika, do którego należy certyfikat urządzenia
+	Owner        string
+	SerialNumber string
+	ExpiresAt    time.Time
+	Revoked      bool
+	Issuer       *Issuer
+}
+
+// Run pobiera wszystkie certyfikaty ze wszystkich montowań PKI i porównuje je z bazą; przy fix=true naprawia to, co się da
+func (r *Reconciler) Run(fix bool, now time.Time) (*ReconcileReport, error) {
+	records := r.records()
+	vaultCerts := make(map[string]*CertificateInfo)
+	for _, vc := range r.mounts(records) {
+		if err := r.collect(vc, vaultCerts); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &ReconcileReport{VaultCertificates: len(vaultCerts), DBRecords: len(records)}
+	known := make(map[string]bool, len(records))
+
+	for _, record := range records {
+		serial := NormalizeSerial(record.SerialNumber)
+		known[serial] = true
+
+		info, exists := vaultCerts[serial]
+		if !exists {
+			report.Findings = append(report.Findings, ReconcileFinding{
+				Kind: FindingMissing, Type: record.Type, CommonName: record.CommonName, SerialNumber: record.SerialNumber,
+				Detail: "certyfikatu nie ma na liście wydanych certyfikatów Vault",
+			})
+			continue
+		}
+
+		if info.IsRevoked() && !record.Revoked {
+			finding := ReconcileFinding{
+				Kind: FindingRevokedActive, Type: record.Type, CommonName: record.CommonName, SerialNumber: record.SerialNumber,
+				Detail: fmt.Sprintf("odwołany w Vault %s", info.RevokedAt.UTC().Format(time.RFC3339)),
+			}
+			if fix {
+				finding.Fixed = r.fixRevoked(record, info)
+			}
+			report.Findings = append(report.Findings, finding)
+		}
+
+		if diff := record.ExpiresAt.Sub(info.ExpiresAt); diff > expiryTolerance || diff < -expiryTolerance {
+			finding := ReconcileFinding{
+				Kind: FindingExpiryMismatch, Type: record.Type, CommonName: record.CommonName, SerialNumber: record.SerialNumber,
+				Detail: fmt.Sprintf("baza: %s, Vault: %s", record.ExpiresAt.UTC().Format(time.RFC3339), info.ExpiresAt.UTC().Format(time.RFC3339)),
+			}
+			if fix {
+				finding.Fixed = r.fixExpiry(record, info)
+			}
+			report.Findings = append(report.Findings, finding)
+		}
+	}
+
+	// Certyfikaty zastąpione przy odnowieniu działają do odwołania przez revoke-pending - nie są sierotami
+	for _, user := range r.certDB.GetAllUsers() {
+		for _, pending := range user.PendingRevocations {
+			known[NormalizeSerial(pending.SerialNumber)] = true
+		}
+	}
+
+	// Ważne certyfikaty wydane poza narzędziem lub niezapisane po błędzie Save()
+	for serial, info := range vaultCerts {
+		if known[serial] || info.IsRevoked() || info.ExpiresAt.Before(now) {
+			continue
+		}
+		finding := ReconcileFinding{
+			Kind: FindingOrphan, CommonName: info.CommonName, SerialNumber: info.SerialNumber,
+			Detail: fmt.Sprintf("ważny do %s, brak w bazie", info.ExpiresAt.UTC().Format("2006-01-02")),
+		}
+		if fix {
+			finding.Type, finding.Fixed, finding.Detail = r.fixOrphan(info, finding.Detail)
+		}
+		report.Findings = append(report.Findings, finding)
+	}
+
+	sort.Slice(report.Findings, func(i, j int) bool {
+		a, b := report.Findings[i], report.Findings[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.CommonName != b.CommonName {
+			return a.CommonName < b.CommonName
+		}
+		return a.SerialNumber < b.SerialNumber
+	})
+
+	if fix && report.Fixed() > 0 {
+		if err := r.certDB.Save(); err != nil {
+			return report, err
+		}
+	}
+
+	r.logger.Infof("Uzgadnianie z Vault: certyfikatów w Vault %d, wpisów w bazie %d, rozbieżności %d, naprawiono %d",
+		report.VaultCertificates, report.DBRecords, len(report.Findings), report.Fixed())
+	return report, nil
+}
+
+// mounts zwraca klientów skonfigurowanych montowań PKI oraz montowań zapisanych przy certyfikatach w bazie,
+// których nie ma już w konfiguracji - ich certyfikaty nie są wtedy zgłaszane jako brakujące
+func (r *Reconciler) mounts(records []dbRecord) []*VaultClient {
+	clients := r.vault.Mounts()
+	seen := make(map[string]bool, len(clients))
+	for _, vc := range clients {
+		seen[vc.Location()] = true
+	}
+	for _, record := range records {
+		if record.Issuer == nil {
+			continue
+		}
+		if vc := r.vault.ForIssuer(record.Issuer); !seen[vc.Location()] {
+			seen[vc.Location()] = true
+			clients = append(clients, vc)
+		}
+	}
+	return clients
+}
+
+// collect dodaje do vaultCerts certyfikaty wydane przez jedno montowanie PKI (bez certyfikatów CA)
+func (r *Reconciler) collect(vc *VaultClient, vaultCerts map[string]*CertificateInfo) error {
+	serials, err := vc.ListCertificateSerials()
+	if err != nil {
+		return err
+	}
+	for _, serial := range serials {
+		info, err := vc.GetCertificateInfo(serial)
+		if err != nil {
+			if IsFatal(err) {
+				return err
+			}
+			r.logger.WithField(FieldMount, vc.MountName()).Warnf("Nie udało się pobrać certyfikatu %s z Vault: %v", serial, err)
+			continue
+		}
+		if info.IsCA {
+			continue
+		}
+		vaultCerts[NormalizeSerial(serial)] = info
+	}
+	return nil
+}
+
+// records zwraca wszystkie wpisy bazy (użytkowników, ich urządzenia i serwery)
+func (r *Reconciler) records() []dbRecord {
+	var records []dbRecord
+	for _, user := range r.certDB.GetAllUsers() {
+		records = append(records, dbRecord{Type: EntryUser, CommonName: user.CommonName, SerialNumber: user.SerialNumber, ExpiresAt: user.ExpiresAt, Revoked: user.IsRevoked(), Issuer: user.Issuer})
+		for _, device := range user.Devices {
+			records = append(records, dbRecord{Type: EntryDevice, CommonName: device.CommonName, Owner: user.CommonName, SerialNumber: device.SerialNumber, ExpiresAt: device.ExpiresAt, Revoked: device.IsRevoked(), Issuer: device.Issuer})
+		}
+	}
+	for _, server := range r.certDB.GetAllServers() {
+		records = append(records, dbRecord{Type: EntryServer, CommonName: server.CommonName, SerialNumber: server.SerialNumber, ExpiresAt: server.ExpiresAt, Issuer: server.Issuer})
+	}
+	return records
+}
+
+// fixRevoked oznacza użytkownika lub urządzenie jako odwołane; certyfikat serwera wymaga ponownego wdrożenia (server deploy --force-renew)
+func (r *Reconciler) fixRevoked(record dbRecord, info *CertificateInfo) bool {
+	var err error
+	switch record.Type {
+	case EntryServer:
+		r.logger.Warnf("Certyfikat serwera %s jest odwołany w Vault - uruchom server deploy --force-renew", record.CommonName)
+		return false
+	case EntryDevice:
+		_, device, _ := r.certDB.FindDevice(record.CommonName)
+		device.RevokedAt = &info.RevokedAt
+		err = r.certDB.AddOrUpdateDevice(record.Owner, *device)
+	default:
+		err = r.certDB.MarkRevoked(record.CommonName, info.RevokedAt)
+	}
+	if err != nil {
+		r.logger.Warnf("Nie udało się oznaczyć %s jako odwołanego: %v", record.CommonName, err)
+		return false
+	}
+	return true
+}
+
+// fixExpiry przepisuje datę wygaśnięcia z certyfikatu w Vault
+func (r *Reconciler) fixExpiry(record dbRecord, info *CertificateInfo) bool {
+	var err error
+	switch record.Type {
+	case EntryUser:
+		user, _ := r.certDB.GetUser(record.CommonName)
+		user.ExpiresAt = info.ExpiresAt
+		err = r.certDB.AddOrUpdateUser(*user)
+	case EntryDevice:
+		_, device, _ := r.certDB.FindDevice(record.CommonName)
+		device.ExpiresAt = info.ExpiresAt
+		err = r.certDB.AddOrUpdateDevice(record.Owner, *device)
+	case EntryServer:
+		server, _ := r.certDB.GetServerCertificate(record.CommonName)
+		server.ExpiresAt = info.ExpiresAt
+		err = r.certDB.AddOrUpdateServerCertificate(*server)
+	}
+	if err != nil {
+		r.logger.Warnf("Nie udało się poprawić daty wygaśnięcia %s: %v", record.CommonName, err)
+		return false
+	}
+	return true
+}
+
+// fixOrphan importuje certyfikat spoza bazy: nowy CN trafia do użytkowników, a nowszy certyfikat
+// istniejącego wpisu zastępuje zapisany (np. po nieudanym Save() po wydaniu). Zastąpiony certyfikat jest odwoływany
+// jak przy odnowieniu (z RENEWAL_GRACE_PERIOD). Odwołane wpisy nie są przywracane - nowszy certyfikat
+// odwołanego użytkownika lub urządzenia zostaje tylko zgłoszony.
+func (r *Reconciler) fixOrphan(info *CertificateInfo, detail string) (string, bool, string) {
+	log := r.logger.WithFields(logrus.Fields{FieldCommonName: info.CommonName, FieldSerial: info.SerialNumber})
+
+	if user, exists := r.certDB.GetUser(info.CommonName); exists {
+		if user.IsRevoked() {
+			return EntryUser, false, detail + "; użytkownik jest odwołany w bazie - pominięto (odwołaj certyfikat w Vault)"
+		}
+		if !info.ExpiresAt.After(user.ExpiresAt) {
+			return EntryUser, false, detail + "; starszy niż certyfikat zapisany w bazie - pominięto"
+		}
+		if err := r.certDB.UpdateCertificateInfo(info.CommonName, info.SerialNumber, info.ExpiresAt, info.Issuer); err != nil {
+			return EntryUser, false, detail + "; " + err.Error()
+		}
+		retireCertificate(r.certDB, r.vault, r.gracePeriod, log, user.CommonName, "", user.SerialNumber, info.SerialNumber, user.Issuer)
+		return EntryUser, true, detail + "; zastąpiono certyfikat w bazie, poprzedni " + user.SerialNumber + " odwołano lub zapisano do odwołania"
+	}
+
+	if owner, device, exists := r.certDB.FindDevice(info.CommonName); exists {
+		if user, found := r.certDB.GetUser(owner); device.IsRevoked() || (found && user.IsRevoked()) {
+			return EntryDevice, false, detail + "; urządzenie lub jego użytkownik jest odwołany w bazie - pominięto (odwołaj certyfikat w Vault)"
+		}
+		if !info.ExpiresAt.After(device.ExpiresAt) {
+			return EntryDevice, false, detail + "; starszy niż certyfikat zapisany w bazie - pominięto"
+		}
+		previous := *device
+		device.SerialNumber, device.ExpiresAt, device.Issuer = info.SerialNumber, info.ExpiresAt, info.Issuer
+		if err := r.certDB.AddOrUpdateDevice(owner, *device); err != nil {
+			return EntryDevice, false, detail + "; " + err.Error()
+		}
+		retireCertificate(r.certDB, r.vault, r.gracePeriod, log, owner, device.Name, previous.SerialNumber, info.SerialNumber, previous.Issuer)
+		return EntryDevice, true, detail + "; zastąpiono certyfikat w bazie, poprzedni " + previous.SerialNumber + " odwołano lub zapisano do odwołania"
+	}
+
+	if server, exists := r.certDB.GetServerCertificate(info.CommonName); exists {
+		if !info.ExpiresAt.After(server.ExpiresAt) {
+			return EntryServer, false, detail + "; starszy niż certyfikat zapisany w bazie - pominięto"
+		}
+		// Bez klucza prywatnego nowy certyfikat i tak trzeba wdrożyć ponownie
+		return EntryServer, false, detail + "; nowszy certyfikat serwera - wdróż go ponownie (server deploy --force-renew)"
+	}
+
+	err := r.certDB.AddOrUpdateUser(UserCertificate{
+		CommonName:   info.CommonName,
+		SerialNumber: info.SerialNumber,
+		CreatedAt:    info.NotBefore,
+		LastRenewed:  info.NotBefore,
+		ExpiresAt:    info.ExpiresAt,
+		TTL:          certificateTTL(info.NotBefore, info.ExpiresAt),
+		Issuer:       info.Issuer,
+	})
+	if err != nil {
+		return EntryUser, false, detail + "; " + err.Error()
+	}
+	return EntryUser, true, detail + "; zaimportowano jako użytkownika (bez adresu email)"
+}
+
+// reconcileColumns to kolumny raportu w formacie tabeli i CSV
+var reconcileColumns = []string{"kind", "type", "common_name", "serial_number", "fixed", "detail"}
+
+func (f ReconcileFinding) row() []string {
+	fixed := "no"
+	if f.Fixed {
+		fixed = "yes"
+	}
+	return []string{f.Kind, f.Type, f.CommonName, f.SerialNumber, fixed, f.Detail}
+}
+
+// WriteReconcileReport zapisuje raport uzgadniania w wybranym formacie
+func WriteReconcileReport(w io.Writer, report *ReconcileReport, format string) error {
+	switch format {
+	case FormatJSON:
+		if report.Findings == nil {
+			report.Findings = []ReconcileFinding{}
+		}
+		return writeJSON(w, report)
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(reconcileColumns); err != nil {
+			return err
+		}
+		for _, finding := range report.Findings {
+			if err := writer.Write(finding.row()); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case FormatTable, "":
+		if len(report.Findings) == 0 {
+			_, err := fmt.Fprintf(w, "Brak rozbieżności (certyfikatów w Vault: %d, wpisów w bazie: %d)\n", report.VaultCertificates, report.DBRecords)
+			return err
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(reconcileColumns, "\t")))
+		for _, finding := range report.Findings {
+			fmt.Fprintln(tw, strings.Join(finding.row(), "\t"))
+		}
+		return tw.Flush()
+	}
+	return newError(ErrInvalidConfig, nil, "nieznany format raportu: %s", format)
+}
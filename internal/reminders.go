@@ -0,0 +1,252 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EventReminderEscalation - użytkownik nie zareagował na ostatnie przypomnienie
+const EventReminderEscalation EventType = "reminder_escalation"
+
+// DefaultReminderOffsets to domyślny harmonogram przypomnień (dni przed wygaśnięciem)
+var DefaultReminderOffsets = []int{30, 14, 7, 1}
+
+// DefaultEscalationDelay to czas od ostatniego przypomnienia, po którym sprawa trafia do administratora
+const DefaultEscalationDelay = 12 * time.Hour
+
+// ReminderConfig przechowuje harmonogram przypomnień i adresy eskalacji
+type ReminderConfig struct {
+	Offsets              []int
+	EscalationRecipients []string
+	// EscalationDelay to czas, jaki użytkownik ma na reakcję po ostatnim przypomnieniu
+	EscalationDelay time.Duration
+	// Policies określa grupy z wyłączonym automatycznym odnawianiem
+	Policies PolicyConfig
+}
+
+// LoadReminderConfigFromEnv wczytuje REMINDER_OFFSETS, REMINDER_ESCALATION_EMAIL i REMINDER_ESCALATION_DELAY
+func LoadReminderConfigFromEnv() (ReminderConfig, error) {
+	config := ReminderConfig{
+		Offsets:              DefaultReminderOffsets,
+		EscalationRecipients: splitList(os.Getenv("REMINDER_ESCALATION_EMAIL")),
+		EscalationDelay:      DefaultEscalationDelay,
+	}
+
+	if value := os.Getenv("REMINDER_ESCALATION_DELAY"); value != "" {
+		delay, err := ParseDays(value)
+		if err != nil {
+			return config, fmt.Errorf("nieprawidłowa wartość REMINDER_ESCALATION_DELAY: %w", err)
+		}
+		config.EscalationDelay = delay
+	}
+
+	if value := os.Getenv("REMINDER_OFFSETS"); value != "" {
+		offsets, err := ParseReminderOffsets(value)
+		if err != nil {
+			return config, fmt.Errorf("nieprawidłowa wartość REMINDER_OFFSETS: %w", err)
+		}
+		config.Offsets = offsets
+	}
+
+	return config, nil
+}
+
+// ParseReminderOffsets parsuje listę dni, np. "30,14,7,1" lub "30d,14d"
+func ParseReminderOffsets(value string) ([]int, error) {
+	var offsets []int
+	seen := make(map[int]bool)
+	for _, item := range splitList(value) {
+		days, err := strconv.Atoi(strings.TrimSuffix(item, "d"))
+		if err != nil || days < 1 {
+			return nil, fmt.Errorf("nieprawidłowa liczba dni: %q", item)
+		}
+		if !seen[days] {
+			seen[days] = true
+			offsets = append(offsets, days)
+		}
+	}
+	if len(offsets) == 0 {
+		return nil, fmt.Errorf("pusta lista przypomnień")
+	}
+
+	// Od najdalszego do najbliższego terminu - ostatni element to ostatnie przypomnienie
+	sort.Sort(sort.Reverse(sort.IntSlice(offsets)))
+	return offsets, nil
+}
+
+// ReminderSummary podsumowuje przebieg wysyłki przypomnień
+type ReminderSummary struct {
+	Checked     int
+	Sent        int
+	Escalated   int
+	NoEmail     int
+	Failed      int
+	FailedUsers []string
+}
+
+// ReminderService wysyła przypomnienia o wygasających certyfikatach, które nie zostaną odnowione automatycznie
+type ReminderService struct {
+	certDB   *CertificateDB
+	mailer   *Mailer
+	notifier Notifier
+	config   ReminderConfig
+	logger   *logrus.Logger
+}
+
+// NewReminderService tworzy serwis przypomnień
+func NewReminderService(certDB *CertificateDB, mailer *Mailer, notifier Notifier, config ReminderConfig, logger *logrus.Logger) *ReminderService {
+	return &ReminderService{
+		certDB:   certDB,
+		mailer:   mailer,
+		notifier: notifier,
+		config:   config,
+		logger:   logger,
+	}
+}
+
+// needsReminder określa, czy certyfikat użytkownika nie zostanie odnowiony automatycznie
+func (rs *ReminderService) needsReminder(user UserCertificate) bool {
+	if user.IsRevoked() {
+		return false
+	}
+	return !rs.config.Policies.AutoRenew(user) || user.RenewalBlocked != ""
+}
+
+// dueOffset zwraca najbliższy próg przypomnienia, który już minął (0 = żaden)
+func (rs *ReminderService) dueOffset(daysLeft float64) int {
+	due := 0
+	for _, offset := range rs.config.Offsets {
+		if daysLeft <= float64(offset) {
+			due = offset
+		}
+	}
+	return due
+}
+
+// Run sprawdza wszystkich użytkowników i wysyła zaległe przypomnienia oraz eskalacje
+func (rs *ReminderService) Run(now time.Time) ReminderSummary {
+	var summary ReminderSummary
+	lastOffset := rs.config.Offsets[len(rs.config.Offsets)-1]
+
+	for commonName, user := range rs.certDB.GetAllUsers() {
+		if !rs.needsReminder(user) {
+			continue
+		}
+		summary.Checked++
+
+		daysLeft := user.ExpiresAt.Sub(now).Hours() / 24
+		offset := 0
+		if daysLeft >= 0 {
+			// Po wygaśnięciu przypomnienie nic nie zmieni - zostaje tylko zaległa eskalacja
+			offset = rs.dueOffset(daysLeft)
+		}
+
+		// Przy opóźnionym uruchomieniu wysyłamy tylko najbliższy próg, a nie wszystkie zaległe
+		if offset != 0 && !user.ReminderSent(ReminderKindUser, offset) {
+			if err := rs.sendReminder(user, offset, daysLeft); err != nil {
+				rs.logger.Warnf("Błąd podczas wysyłania przypomnienia do %s: %v", commonName, err)
+				summary.Failed++
+				summary.FailedUsers = append(summary.FailedUsers, commonName)
+				continue
+			}
+			if user.Email == "" {
+				summary.NoEmail++
+			} else {
+				summary.Sent++
+			}
+			if err := rs.certDB.RecordReminder(commonName, ReminderKindUser, offset); err != nil {
+				rs.logger.Warnf("Błąd podczas zapisywania przypomnienia: %v", err)
+			}
+			// Wpis z bazy - kopia z GetAllUsers współdzieli z nią listę przypomnień
+			if updated, exists := rs.certDB.GetUser(commonName); exists {
+				user = *updated
+			}
+		}
+
+		// Eskalacja do administratora, gdy od ostatniego przypomnienia minęło EscalationDelay, a certyfikat nadal nie został odnowiony
+		lastSentAt, lastSent := user.ReminderSentAt(ReminderKindUser, lastOffset)
+		if lastSent && now.Sub(lastSentAt) >= rs.config.EscalationDelay && !user.ReminderSent(ReminderKindEscalation, lastOffset) {
+			if err := rs.escalate(user, daysLeft); err != nil {
+				rs.logger.Warnf("Błąd podczas eskalacji dla %s: %v", commonName, err)
+				summary.Failed++
+				summary.FailedUsers = append(summary.FailedUsers, commonName)
+				continue
+			}
+			summary.Escalated++
+			if err := rs.certDB.RecordReminder(commonName, ReminderKindEscalation, lastOffset); err != nil {
+				rs.logger.Warnf("Błąd podczas zapisywania eskalacji: %v", err)
+			}
+		}
+	}
+
+	rs.logger.Infof("Przypomnienia: sprawdzono %d, wysłano %d, eskalowano %d, bez emaila %d, błędy %d",
+		summary.Checked, summary.Sent, summary.Escalated, summary.NoEmail, summary.Failed)
+	return summary
+}
+
+// sendReminder wysyła przypomnienie do użytkownika, a gdy brak adresu - zdarzenie do administratorów
+func (rs *ReminderService) sendReminder(user UserCertificate, offset int, daysLeft float64) error {
+	if user.Email == "" {
+		return rs.notifier.Notify(NewEvent(EventExpiringNoEmail, SeverityWarning, user.CommonName,
+			"Brak adresu email dla wygasającego certyfikatu",
+			fmt.Sprintf("Certyfikat %s wygasa za %d dni i nie zostanie odnowiony automatycznie, a w bazie nie ma adresu email użytkownika", user.CommonName, int(daysLeft))).
+			WithField("expires_at", user.ExpiresAt.Format("2006-01-02")))
+	}
+
+	rs.logger.Infof("Wysyłanie przypomnienia (%d dni) do %s <%s>", offset, user.CommonName, user.Email)
+	return rs.mailer.SendReminder(user.CommonName, user.Email, user.Locale, user.ExpiresAt)
+}
+
+// escalate powiadamia administratorów, że mimo przypomnień certyfikat nadal nie został odnowiony
+func (rs *ReminderService) escalate(user UserCertificate, daysLeft float64) error {
+	reason := "automatyczne odnawianie wyłączone"
+	if user.RenewalBlocked != "" {
+		reason = "odnowienie zablokowane: " + user.RenewalBlocked
+	}
+
+	message := fmt.Sprintf("Certyfikat %s wygasa za %d dni, wysłano już wszystkie przypomnienia (%s)", user.CommonName, int(daysLeft), reason)
+	if daysLeft < 0 {
+		message = fmt.Sprintf("Certyfikat %s wygasł %s mimo wszystkich przypomnień (%s)", user.CommonName, user.ExpiresAt.Format("2006-01-02"), reason)
+	}
+	event := NewEvent(EventReminderEscalation, SeverityCritical, user.CommonName,
+		"Certyfikat wygasa mimo przypomnień", message).
+		WithField("expires_at", user.ExpiresAt.Format("2006-01-02")).
+		WithField("email", user.Email)
+
+	rs.logger.Warnf("Eskalacja: certyfikat %s wygasa za %.1f dni", user.CommonName, daysLeft)
+
+	var errs []error
+	if err := rs.notifier.Notify(event); err != nil {
+		errs = append(errs, err)
+	}
+	// Adresy z NOTIFY_EMAIL dostały już zdarzenie przez kanał email
+	if recipients := excludeRecipients(rs.config.EscalationRecipients, rs.mailer.AdminRecipients()); len(recipients) > 0 {
+		if err := rs.mailer.NotifyAddresses(event, recipients); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// excludeRecipients zwraca adresy bez powtórzeń i bez adresów z exclude (bez rozróżniania wielkości liter)
+func excludeRecipients(recipients, exclude []string) []string {
+	seen := make(map[string]bool, len(recipients)+len(exclude))
+	for _, address := range exclude {
+		seen[strings.ToLower(address)] = true
+	}
+	var result []string
+	for _, address := range recipients {
+		if key := strings.ToLower(address); !seen[key] {
+			seen[key] = true
+			result = append(result, address)
+		}
+	}
+	return result
+}
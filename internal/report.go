@@ -0,0 +1,371 @@
+package internal
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Formaty raportów
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatCSV   = "csv"
+)
+
+// ReportFormats to formaty obsługiwane przez polecenia list i show
+var ReportFormats = []string{FormatTable, FormatJSON, FormatCSV}
+
+// Rodzaje wpisów w inwentarzu
+const (
+	EntryUser   = "user"
+	EntryDevice = "device"
+	EntryServer = "server"
+)
+
+// InventoryEntry to jeden certyfikat (użytkownika lub serwera) w raporcie
+type InventoryEntry struct {
+	Type           string     `json:"type"`
+	CommonName     string     `json:"common_name"`
+	SerialNumber   string     `json:"serial_number"`
+	Owner          string     `json:"owner,omitempty"`  // tylko urządzenia
+	Device         string     `json:"device,omitempty"` // tylko urządzenia
+	Email          string     `json:"email,omitempty"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	DaysLeft       int        `json:"days_left"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastRenewed    time.Time  `json:"last_renewed"`
+	TTL            string     `json:"ttl,omitempty"`
+	RouterIP       string     `json:"router_ip,omitempty"`
+	Locale         string     `json:"locale,omitempty"`
+	Group          string     `json:"group,omitempty"`
+	Source         string     `json:"source,omitempty"` // tylko użytkownicy
+	RenewBefore    string     `json:"renew_before,omitempty"`
+	AutoRenew      *bool      `json:"auto_renew,omitempty"` // tylko użytkownicy
+	RenewalBlocked string     `json:"renewal_blocked,omitempty"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+	// Devices to nazwy urządzeń użytkownika (szczegóły - device list)
+	Devices []string `json:"devices,omitempty"`
+	// PendingRevocations to poprzednie certyfikaty użytkownika działające w okresie przejściowym
+	PendingRevocations []PendingRevocation `json:"pending_revocations,omitempty"`
+	// Issuer to montowanie PKI, które wydało certyfikat; brak - montowanie domyślne
+	Issuer *Issuer `json:"issuer,omitempty"`
+}
+
+// InventoryFilter ogranicza wpisy raportu; puste pola nie filtrują
+type InventoryFilter struct {
+	// ExpiringWithin wybiera ważne certyfikaty wygasające w podanym czasie
+	ExpiringWithin time.Duration
+	// Expired wybiera certyfikaty, które już wygasły (łączone z ExpiringWithin przez "lub")
+	Expired bool
+	// NoEmail wybiera użytkowników bez adresu email
+	NoEmail bool
+	// ServersOnly wybiera tylko certyfikaty serwerów
+	ServersOnly bool
+}
+
+// matches sprawdza, czy wpis spełnia kryteria filtra
+func (f InventoryFilter) matches(entry InventoryEntry, now time.Time) bool {
+	if f.ServersOnly && entry.Type != EntryServer {
+		return false
+	}
+	if f.NoEmail && (entry.Type != EntryUser || entry.Email != "") {
+		return false
+	}
+	if f.ExpiringWithin > 0 || f.Expired {
+		remaining := entry.ExpiresAt.Sub(now)
+		expiring := f.ExpiringWithin > 0 && remaining >= 0 && remaining <= f.ExpiringWithin
+		expired := f.Expired && remaining < 0
+		if !expiring && !expired {
+			return false
+		}
+	}
+	return true
+}
+
+// BuildInventory zbiera certyfikaty z bazy danych posortowane po dacie wygaśnięcia
+func BuildInventory(certDB *CertificateDB, filter InventoryFilter, now time.Time) []InventoryEntry {
+	var entries []InventoryEntry
+
+	for _, user := range certDB.GetAllUsers() {
+		entry := userEntry(user, now)
+		if filter.matches(entry, now) {
+			entries = append(entries, entry)
+		}
+		for _, device := range user.Devices {
+			entry := deviceEntry(user, device, now)
+			if filter.matches(entry, now) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	for _, server := range certDB.GetAllServers() {
+		entry := serverEntry(server, now)
+		if filter.matches(entry, now) {
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].ExpiresAt.Equal(entries[j].ExpiresAt) {
+			return entries[i].ExpiresAt.Before(entries[j].ExpiresAt)
+		}
+		return entries[i].CommonName < entries[j].CommonName
+	})
+	return entries
+}
+
+// FindInventoryEntry zwraca wpis dla podanego CN (najpierw użytkownicy, potem urządzenia i serwery)
+func FindInventoryEntry(certDB *CertificateDB, commonName string, now time.Time) (InventoryEntry, error) {
+	if user, exists := certDB.GetUser(commonName); exists {
+		return userEntry(*user, now), nil
+	}
+	if owner, device, exists := certDB.FindDevice(commonName); exists {
+		user, _ := certDB.GetUser(owner)
+		return deviceEntry(*user, *device, now), nil
+	}
+	if server, exists := certDB.GetServerCertificate(commonName); exists {
+		return serverEntry(*server, now), nil
+	}
+	return InventoryEntry{}, newError(ErrNotFound, nil, "certyfikat %s nie istnieje w bazie danych", commonName)
+}
+
+func userEntry(user UserCertificate, now time.Time) InventoryEntry {
+	autoRenew := !user.AutoRenewDisabled
+	var pending []PendingRevocation
+	for _, entry := range user.PendingRevocations {
+		if entry.Device == "" {
+			pending = append(pending, entry)
+		}
+	}
+	return InventoryEntry{
+		Type:               EntryUser,
+		CommonName:         user.CommonName,
+		SerialNumber:       user.SerialNumber,
+		Email:              user.Email,
+		ExpiresAt:          user.ExpiresAt,
+		DaysLeft:           daysBetween(now, user.ExpiresAt),
+		CreatedAt:          user.CreatedAt,
+		LastRenewed:        user.LastRenewed,
+		TTL:                user.TTL,
+		Locale:             user.Locale,
+		Group:              user.Group,
+		Source:             user.Source,
+		RenewBefore:        user.RenewBefore,
+		AutoRenew:          &autoRenew,
+		RenewalBlocked:     user.RenewalBlocked,
+		RevokedAt:          user.RevokedAt,
+		Devices:            user.DeviceNames(),
+		PendingRevocations: pending,
+		Issuer:             user.Issuer,
+	}
+}
+
+// deviceEntry zwraca wpis urządzenia z emailem, językiem i grupą właściciela
+func deviceEntry(user UserCertificate, device DeviceCertificate, now time.Time) InventoryEntry {
+	var pending []PendingRevocation
+	for _, entry := range user.PendingRevocations {
+		if entry.Device == device.Name {
+			pending = append(pending, entry)
+		}
+	}
+	return InventoryEntry{
+		Type:               EntryDevice,
+		CommonName:         device.CommonName,
+		SerialNumber:       device.SerialNumber,
+		Owner:              user.CommonName,
+		Device:             device.Name,
+		Email:              user.Email,
+		ExpiresAt:          device.ExpiresAt,
+		DaysLeft:           daysBetween(now, device.ExpiresAt),
+		CreatedAt:          device.CreatedAt,
+		LastRenewed:        device.LastRenewed,
+		TTL:                device.TTL,
+		Locale:             user.Locale,
+		Group:              user.Group,
+		RevokedAt:          device.RevokedAt,
+		PendingRevocations: pending,
+		Issuer:             device.Issuer,
+	}
+}
+
+// DeviceInventory zwraca urządzenia użytkownika w kolejności nazw
+func DeviceInventory(certDB *CertificateDB, commonName string, now time.Time) ([]InventoryEntry, error) {
+	user, exists := certDB.GetUser(commonName)
+	if !exists {
+		return nil, newError(ErrNotFound, nil, "użytkownik %s nie istnieje w bazie danych", commonName)
+	}
+	entries := make([]InventoryEntry, 0, len(user.Devices))
+	for _, name := range user.DeviceNames() {
+		entries = append(entries, deviceEntry(*user, user.Devices[name], now))
+	}
+	return entries, nil
+}
+
+func serverEntry(server ServerCertificate, now time.Time) InventoryEntry {
+	return InventoryEntry{
+		Type:         EntryServer,
+		CommonName:   server.CommonName,
+		SerialNumber: server.SerialNumber,
+		ExpiresAt:    server.ExpiresAt,
+		DaysLeft:     daysBetween(now, server.ExpiresAt),
+		CreatedAt:    server.CreatedAt,
+		LastRenewed:  server.LastRenewed,
+		TTL:          server.TTL,
+		RouterIP:     server.MikrotikIP,
+		Issuer:       server.Issuer,
+	}
+}
+
+// daysBetween zwraca pełne dni do podanej daty (ujemne po wygaśnięciu)
+func daysBetween(now, t time.Time) int {
+	return int(t.Sub(now).Hours() / 24)
+}
+
+// ParseDays parsuje okres w postaci "30d", "2w" lub czasu Go, np. "720h"
+func ParseDays(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("pusty okres")
+	}
+
+	unit := value[len(value)-1]
+	if unit == 'd' || unit == 'w' {
+		number, err := strconv.Atoi(value[:len(value)-1])
+		if err != nil || number < 0 {
+			return 0, fmt.Errorf("nieprawidłowy okres: %q", value)
+		}
+		days := number
+		if unit == 'w' {
+			days *= 7
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("nieprawidłowy okres: %q", value)
+	}
+	return duration, nil
+}
+
+// inventoryColumns to kolumny listy w formacie tabeli i CSV
+var inventoryColumns = []string{"type", "common_name", "serial_number", "email", "expires_at", "days_left", "last_renewed", "router_ip"}
+
+func (e InventoryEntry) row() []string {
+	return []string{
+		e.Type,
+		e.CommonName,
+		e.SerialNumber,
+		e.Email,
+		e.ExpiresAt.Format("2006-01-02"),
+		strconv.Itoa(e.DaysLeft),
+		e.LastRenewed.Format("2006-01-02"),
+		e.RouterIP,
+	}
+}
+
+// WriteInventory zapisuje listę certyfikatów w wybranym formacie
+func WriteInventory(w io.Writer, entries []InventoryEntry, format string) error {
+	switch format {
+	case FormatJSON:
+		if entries == nil {
+			entries = []InventoryEntry{}
+		}
+		return writeJSON(w, entries)
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(inventoryColumns); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := writer.Write(entry.row()); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case FormatTable, "":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(inventoryColumns, "\t")))
+		for _, entry := range entries {
+			fmt.Fprintln(tw, strings.Join(entry.row(), "\t"))
+		}
+		return tw.Flush()
+	}
+	return newError(ErrInvalidConfig, nil, "nieznany format raportu: %s", format)
+}
+
+// WriteInventoryEntry zapisuje szczegóły jednego certyfikatu w wybranym formacie
+func WriteInventoryEntry(w io.Writer, entry InventoryEntry, format string) error {
+	switch format {
+	case FormatJSON:
+		return writeJSON(w, entry)
+	case FormatCSV:
+		return WriteInventory(w, []InventoryEntry{entry}, FormatCSV)
+	case FormatTable, "":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "Type:\t%s\n", entry.Type)
+		fmt.Fprintf(tw, "Common name:\t%s\n", entry.CommonName)
+		fmt.Fprintf(tw, "Serial:\t%s\n", entry.SerialNumber)
+		if entry.Type == EntryDevice {
+			fmt.Fprintf(tw, "Owner:\t%s\n", entry.Owner)
+			fmt.Fprintf(tw, "Device:\t%s\n", entry.Device)
+		}
+		if entry.Type != EntryServer {
+			fmt.Fprintf(tw, "Email:\t%s\n", entry.Email)
+			fmt.Fprintf(tw, "Locale:\t%s\n", entry.Locale)
+			if entry.Group != "" {
+				fmt.Fprintf(tw, "Group:\t%s\n", entry.Group)
+			}
+			if entry.Source != "" {
+				fmt.Fprintf(tw, "Source:\t%s\n", entry.Source)
+			}
+		}
+		fmt.Fprintf(tw, "Created:\t%s\n", entry.CreatedAt.Format(time.RFC3339))
+		fmt.Fprintf(tw, "Last renewed:\t%s\n", entry.LastRenewed.Format(time.RFC3339))
+		fmt.Fprintf(tw, "Expires:\t%s (%d days)\n", entry.ExpiresAt.Format(time.RFC3339), entry.DaysLeft)
+		fmt.Fprintf(tw, "TTL:\t%s\n", entry.TTL)
+		if entry.Issuer != nil {
+			fmt.Fprintf(tw, "Issuer:\t%s\n", entry.Issuer)
+		}
+		if entry.Type == EntryServer {
+			fmt.Fprintf(tw, "Router:\t%s\n", entry.RouterIP)
+		}
+		if entry.RenewBefore != "" {
+			fmt.Fprintf(tw, "Renew before:\t%s\n", entry.RenewBefore)
+		}
+		if entry.AutoRenew != nil {
+			fmt.Fprintf(tw, "Auto-renew:\t%t\n", *entry.AutoRenew)
+		}
+		if entry.RenewalBlocked != "" {
+			fmt.Fprintf(tw, "Renewal blocked:\t%s\n", entry.RenewalBlocked)
+		}
+		if entry.RevokedAt != nil {
+			fmt.Fprintf(tw, "Revoked:\t%s\n", entry.RevokedAt.Format(time.RFC3339))
+		}
+		if len(entry.Devices) > 0 {
+			fmt.Fprintf(tw, "Devices:\t%s\n", strings.Join(entry.Devices, ", "))
+		}
+		for _, pending := range entry.PendingRevocations {
+			fmt.Fprintf(tw, "Pending revocation:\t%s (revoke after %s)\n", pending.SerialNumber, pending.RevokeAfter.Format(time.RFC3339))
+			if pending.LastError != "" {
+				fmt.Fprintf(tw, "  last error:\t%s\n", pending.LastError)
+			}
+		}
+		return tw.Flush()
+	}
+	return newError(ErrInvalidConfig, nil, "nieznany format raportu: %s", format)
+}
+
+func writeJSON(w io.Writer, value interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
@@ -0,0 +1,256 @@
+package internal
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Rodzaje problemów wykrywanych przez audyt routera
+const (
+	// AuditUnreachable - nie udało się połączyć z routerem
+	AuditUnreachable = "unreachable"
+	// AuditNoServerCert - serwer OpenVPN nie ma ustawionego certyfikatu
+	AuditNoServerCert = "no_server_cert"
+	// AuditUnknownServerCert - serwer OpenVPN używa certyfikatu spoza bazy
+	AuditUnknownServerCert = "unknown_server_cert"
+	// AuditCertMissing - certyfikatu z bazy (lub wskazanego przez serwer OpenVPN) nie ma na routerze
+	AuditCertMissing = "cert_missing"
+	// AuditFingerprintMismatch - certyfikat na routerze jest inny niż zapisany w bazie
+	AuditFingerprintMismatch = "fingerprint_mismatch"
+	// AuditExpiryMismatch - data wygaśnięcia na routerze różni się od zapisanej w bazie
+	AuditExpiryMismatch = "expiry_mismatch"
+	// AuditInvalid - router oznacza certyfikat serwera jako nieważny lub wygasły
+	AuditInvalid = "invalid"
+	// AuditStaleRevoked - pozostałość "<nazwa>-revoked-<data>" po CertManager.RenewCert
+	AuditStaleRevoked = "stale_revoked"
+	// AuditTempFile - pozostawiony plik tymczasowy importu certyfikatu
+	AuditTempFile = "temp_file"
+)
+
+// routerExpiryTolerance - router pokazuje daty w swojej strefie czasowej, więc różnice poniżej doby są pomijane
+const routerExpiryTolerance = 24 * time.Hour
+
+// tempCertFilePattern dopasowuje pliki tworzone przez importCertificate: flash/<nazwa>_<YYYYMMDDhhmmss>.pem
+var tempCertFilePattern = regexp.MustCompile(`^flash/.+_\d{14}\.pem$`)
+
+// routerTimeLayouts to formaty daty invalid-after (RouterOS 6 i RouterOS 7.10+)
+var routerTimeLayouts = []string{"Jan/02/2006 15:04:05", "2006-01-02 15:04:05"}
+
+// RouterAuditFinding to jeden problem wykryty na routerze
+type RouterAuditFinding struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Detail  string `json:"detail"`
+	Cleaned bool   `json:"cleaned"`
+}
+
+// RouterAudit to wynik audytu jednego routera
+type RouterAudit struct {
+	Router string `json:"router"`
+	// OpenVPNCertificate to nazwa certyfikatu ustawiona w /interface/ovpn-server/server
+	OpenVPNCertificate string               `json:"openvpn_certificate,omitempty"`
+	Certificates       int                  `json:"certificates"`
+	Findings           []RouterAuditFinding `json:"findings"`
+}
+
+func (a *RouterAudit) add(kind, name, detail string) *RouterAuditFinding {
+	a.Findings = append(a.Findings, RouterAuditFinding{Kind: kind, Name: name, Detail: detail})
+	return &a.Findings[len(a.Findings)-1]
+}
+
+// UnreachableRouterAudit zwraca wynik audytu dla routera, z którym nie udało się połączyć
+func UnreachableRouterAudit(router string, err error) RouterAudit {
+	audit := RouterAudit{Router: router}
+	audit.add(AuditUnreachable, "", err.Error())
+	return audit
+}
+
+// Audit porównuje certyfikaty na routerze z certyfikatami serwerów zapisanymi w bazie;
+// przy clean=true usuwa pozostałości "-revoked-" i pliki tymczasowe
+func (mi *MikrotikIntegration) Audit(servers []ServerCertificate, clean bool) (*RouterAudit, error) {
+	audit := &RouterAudit{Router: mi.ip}
+
+	certs, err := mi.ListCertificates()
+	if err != nil {
+		return nil, err
+	}
+	audit.Certificates = len(certs)
+	byName := make(map[string]map[string]string, len(certs))
+	for _, cert := range certs {
+		byName[cert["name"]] = cert
+	}
+
+	audit.OpenVPNCertificate, err = mi.OpenVPNServerCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	expected := make(map[string]ServerCertificate, len(servers))
+	for _, server := range servers {
+		expected[server.CommonName] = server
+	}
+
+	switch name := audit.OpenVPNCertificate; {
+	case name == "" || name == "none":
+		audit.add(AuditNoServerCert, "", "serwer OpenVPN nie ma ustawionego certyfikatu")
+	case byName[name] == nil:
+		audit.add(AuditCertMissing, name, "serwer OpenVPN wskazuje certyfikat, którego nie ma na routerze")
+	default:
+		if _, known := expected[name]; !known {
+			audit.add(AuditUnknownServerCert, name, fmt.Sprintf("serwer OpenVPN używa certyfikatu spoza bazy (oczekiwano: %s)", strings.Join(sortedServerNames(expected), ", ")))
+		}
+		if cert := byName[name]; cert["invalid"] == "true" || cert["expired"] == "true" {
+			audit.add(AuditInvalid, name, fmt.Sprintf("router oznacza certyfikat jako nieważny (invalid-after: %s)", cert["invalid-after"]))
+		}
+	}
+
+	for _, name := range sortedServerNames(expected) {
+		cert, exists := byName[name]
+		if !exists {
+			audit.add(AuditCertMissing, name, "certyfikatu serwera z bazy nie ma na routerze")
+			continue
+		}
+		mi.compareServerCertificate(audit, expected[name], cert)
+	}
+
+	for _, cert := range certs {
+		name := cert["name"]
+		if !strings.Contains(name, "-revoked-") {
+			continue
+		}
+		finding := audit.add(AuditStaleRevoked, name, "pozostałość po odnowieniu certyfikatu na routerze")
+		if name == audit.OpenVPNCertificate {
+			finding.Detail += "; używany przez serwer OpenVPN - pominięto"
+			continue
+		}
+		if clean {
+			if err := mi.RemoveCertificate(name); err != nil {
+				finding.Detail += "; " + err.Error()
+			} else {
+				finding.Cleaned = true
+			}
+		}
+	}
+
+	files, err := mi.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		name := file["name"]
+		if !tempCertFilePattern.MatchString(name) {
+			continue
+		}
+		finding := audit.add(AuditTempFile, name, "plik tymczasowy importu certyfikatu")
+		if clean {
+			if err := mi.RemoveFile(name); err != nil {
+				finding.Detail += "; " + err.Error()
+			} else {
+				finding.Cleaned = true
+			}
+		}
+	}
+
+	mi.logger.Infof("Audyt routera %s: certyfikatów %d, problemów %d", mi.ip, audit.Certificates, len(audit.Findings))
+	return audit, nil
+}
+
+// compareServerCertificate porównuje odcisk i datę wygaśnięcia certyfikatu na routerze z bazą
+func (mi *MikrotikIntegration) compareServerCertificate(audit *RouterAudit, server ServerCertificate, cert map[string]string) {
+	dbCert, err := ParseCertificatePEM(server.Certificate)
+	if err != nil {
+		mi.logger.Warnf("Nie udało się odczytać certyfikatu %s z bazy: %v", server.CommonName, err)
+		return
+	}
+
+	expectedFingerprint := CertificateFingerprint(dbCert)
+	if fingerprint := strings.ToLower(strings.ReplaceAll(cert["fingerprint"], ":", "")); fingerprint != "" && fingerprint != expectedFingerprint {
+		audit.add(AuditFingerprintMismatch, server.CommonName, fmt.Sprintf("router: %s, baza: %s", FormatFingerprint(fingerprint), FormatFingerprint(expectedFingerprint)))
+	}
+
+	invalidAfter, ok := parseRouterTime(cert["invalid-after"])
+	if !ok {
+		return
+	}
+	if diff := invalidAfter.Sub(server.ExpiresAt); diff > routerExpiryTolerance || diff < -routerExpiryTolerance {
+		audit.add(AuditExpiryMismatch, server.CommonName, fmt.Sprintf("router: %s, baza: %s", cert["invalid-after"], server.ExpiresAt.UTC().Format("2006-01-02 15:04:05")))
+	}
+}
+
+// parseRouterTime parsuje datę w formacie RouterOS (bez strefy czasowej)
+func parseRouterTime(value string) (time.Time, bool) {
+	for _, layout := range routerTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func sortedServerNames(servers map[string]ServerCertificate) []string {
+	names := make([]string, 0, len(servers))
+	for name := range servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// routerAuditColumns to kolumny raportu audytu w formacie tabeli i CSV
+var routerAuditColumns = []string{"router", "kind", "name", "cleaned", "detail"}
+
+// WriteRouterAudits zapisuje wyniki audytu routerów w wybranym formacie
+func WriteRouterAudits(w io.Writer, audits []RouterAudit, format string) error {
+	var rows [][]string
+	for _, audit := range audits {
+		for _, finding := range audit.Findings {
+			cleaned := "no"
+			if finding.Cleaned {
+				cleaned = "yes"
+			}
+			rows = append(rows, []string{audit.Router, finding.Kind, finding.Name, cleaned, finding.Detail})
+		}
+	}
+
+	switch format {
+	case FormatJSON:
+		for i := range audits {
+			if audits[i].Findings == nil {
+				audits[i].Findings = []RouterAuditFinding{}
+			}
+		}
+		if audits == nil {
+			audits = []RouterAudit{}
+		}
+		return writeJSON(w, audits)
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(routerAuditColumns); err != nil {
+			return err
+		}
+		if err := writer.WriteAll(rows); err != nil {
+			return err
+		}
+		return writer.Error()
+	case FormatTable, "":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, audit := range audits {
+			fmt.Fprintf(tw, "Router %s:\tOpenVPN: %s\tcertyfikatów: %d\tproblemów: %d\n", audit.Router, firstNonEmpty(audit.OpenVPNCertificate, "-"), audit.Certificates, len(audit.Findings))
+		}
+		if len(rows) > 0 {
+			fmt.Fprintln(tw)
+			fmt.Fprintln(tw, strings.ToUpper(strings.Join(routerAuditColumns, "\t")))
+			for _, row := range rows {
+				fmt.Fprintln(tw, strings.Join(row, "\t"))
+			}
+		}
+		return tw.Flush()
+	}
+	return newError(ErrInvalidConfig, nil, "nieznany format raportu: %s", format)
+}
@@ -9,15 +9,15 @@ import (
 
 // ServerManager zarządza certyfikatami serwera OpenVPN
 type ServerManager struct {
-	certDB  *CertificateDB
+	certDB      *CertificateDB
 	vaultClient *VaultClient
-	logger    *logrus.Logger
+	logger      *logrus.Logger
 }
 
 // NewServerManager tworzy nowy menedżer serwera
 func NewServerManager(certDB *CertificateDB, vaultClient *VaultClient, logger *logrus.Logger) *ServerManager {
 	return &ServerManager{
-		certDB:     certDB,
+		certDB:      certDB,
 		vaultClient: vaultClient,
 		logger:      logger,
 	}
@@ -57,7 +57,7 @@ func (sm *ServerManager) RenewServerCertificate(commonName string, ttl string) (
 	// Pobierz istniejący certyfikat serwera
 	_, exists := sm.certDB.GetServerCertificate(commonName)
 	if !exists {
-		return nil, fmt.Errorf("certyfikat serwera dla %s nie istnieje", commonName)
+		return nil, newError(ErrNotFound, nil, "certyfikat serwera dla %s nie istnieje", commonName)
 	}
 
 	// Tutaj byłaby logika odnawiania certyfikatu serwera, ale na razie generujemy nowy
@@ -85,15 +85,15 @@ func (sm *ServerManager) GetServerCertificate(commonName string) (*ServerCertifi
 	return sm.certDB.GetServerCertificate(commonName)
 }
 
-// CheckServerCertificateExpiry sprawdza ważność certyfikatu serwera
-func (sm *ServerManager) CheckServerCertificateExpiry(commonName string, daysThreshold int) (bool, float64, error) {
+// CheckServerCertificateExpiry sprawdza ważność certyfikatu serwera według progu odnawiania
+func (sm *ServerManager) CheckServerCertificateExpiry(commonName string, threshold RenewalThreshold) (bool, float64, error) {
 	serverCert, exists := sm.certDB.GetServerCertificate(commonName)
 	if !exists {
-		return false, 0, fmt.Errorf("certyfikat serwera dla %s nie istnieje", commonName)
+		return false, 0, newError(ErrNotFound, nil, "certyfikat serwera dla %s nie istnieje", commonName)
 	}
 
 	daysUntilExpiry := time.Until(serverCert.ExpiresAt).Hours() / 24
-	needsRenewal := daysUntilExpiry < float64(daysThreshold)
+	needsRenewal := threshold.NeedsRenewal(serverCert.LastRenewed, serverCert.ExpiresAt, time.Now())
 
 	return needsRenewal, daysUntilExpiry, nil
 }
@@ -106,4 +106,4 @@ func (sm *ServerManager) GetAllServers() map[string]ServerCertificate {
 // DeleteServerCertificate usuwa certyfikat serwera
 func (sm *ServerManager) DeleteServerCertificate(commonName string) error {
 	return sm.certDB.DeleteServerCertificate(commonName)
-}
\ No newline at end of file
+}
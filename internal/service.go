@@ -0,0 +1,965 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultRenewalThresholdDays - certyfikat jest odnawiany, gdy do wygaśnięcia zostało mniej dni
+const DefaultRenewalThresholdDays = 30
+
+// DefaultClientTTL to domyślny czas ważności certyfikatu klienta i serwera (1 rok)
+const DefaultClientTTL = "8760h"
+
+// ServiceConfig przechowuje ustawienia wspólne dla operacji na certyfikatach
+type ServiceConfig struct {
+	// OvpnTemplate to szablon konfiguracji klienta (CA, certyfikat, klucz)
+	OvpnTemplate string
+	OutputDir    string
+	// RenewalThreshold, ClientTTL, ServerTTL i MaxTTL to ustawienia domyślne;
+	// polityki grup i serwerów (Policies) oraz ustawienia użytkownika mogą je nadpisać
+	RenewalThreshold RenewalThreshold
+	ClientTTL        string
+	ServerTTL        string
+	MaxTTL           time.Duration
+	Policies         PolicyConfig
+	// GracePeriod to czas, przez który poprzedni certyfikat użytkownika działa po odnowieniu;
+	// 0 - odwołanie zaraz po wydaniu nowego certyfikatu
+	GracePeriod time.Duration
+	Mikrotik    MikrotikConfig
+}
+
+// LoadServiceConfigFromEnv wczytuje próg odnawiania (RENEWAL_THRESHOLD), okres przejściowy (RENEWAL_GRACE_PERIOD),
+// domyślne TTL (CLIENT_TTL, SERVER_TTL) i limit TTL (MAX_TTL), a następnie sprawdza z nimi polityki grup i serwerów z pliku konfiguracji
+func LoadServiceConfigFromEnv(policies PolicyConfig) (ServiceConfig, error) {
+	config := ServiceConfig{
+		RenewalThreshold: RenewalThreshold{Before: DefaultRenewalThresholdDays * 24 * time.Hour},
+		ClientTTL:        firstNonEmpty(os.Getenv("CLIENT_TTL"), DefaultClientTTL),
+		ServerTTL:        firstNonEmpty(os.Getenv("SERVER_TTL"), DefaultClientTTL),
+		Policies:         policies,
+	}
+	if value := os.Getenv("RENEWAL_THRESHOLD"); value != "" {
+		threshold, err := ParseRenewalThreshold(value)
+		if err != nil {
+			return config, newError(ErrInvalidConfig, err, "nieprawidłowa wartość RENEWAL_THRESHOLD")
+		}
+		config.RenewalThreshold = threshold
+	}
+	if value := os.Getenv("RENEWAL_GRACE_PERIOD"); value != "" {
+		gracePeriod, err := ParseDays(value)
+		if err != nil {
+			return config, newError(ErrInvalidConfig, err, "nieprawidłowa wartość RENEWAL_GRACE_PERIOD")
+		}
+		config.GracePeriod = gracePeriod
+	}
+	if value := os.Getenv("MAX_TTL"); value != "" {
+		maxTTL, err := ParseDays(value)
+		if err != nil || maxTTL == 0 {
+			return config, newError(ErrInvalidConfig, err, "nieprawidłowa wartość MAX_TTL %q", value)
+		}
+		config.MaxTTL = maxTTL
+	}
+	for _, ttl := range []struct{ name, value string }{{"CLIENT_TTL", config.ClientTTL}, {"SERVER_TTL", config.ServerTTL}} {
+		duration, err := ParseDays(ttl.value)
+		if err != nil {
+			return config, newError(ErrInvalidConfig, err, "nieprawidłowa wartość %s", ttl.name)
+		}
+		if config.MaxTTL > 0 && duration > config.MaxTTL {
+			return config, newError(ErrInvalidConfig, nil, "%s %s przekracza MAX_TTL %s", ttl.name, ttl.value, formatTTL(config.MaxTTL))
+		}
+	}
+	if err := validatePolicies("grupy", policies.Groups, config.defaultPolicy(config.ClientTTL)); err != nil {
+		return config, err
+	}
+	if err := validatePolicies("serwera", policies.Servers, config.defaultPolicy(config.ServerTTL)); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// CertService realizuje operacje na certyfikatach klientów i serwerów wywoływane przez polecenia CLI
+type CertService struct {
+	certDB   *CertificateDB
+	vault    *VaultClient
+	mailer   *Mailer
+	notifier Notifier
+	config   ServiceConfig
+	logger   *logrus.Logger
+	executor *Executor
+}
+
+// NewCertService tworzy serwis certyfikatów
+func NewCertService(certDB *CertificateDB, vault *VaultClient, mailer *Mailer, notifier Notifier, config ServiceConfig, logger *logrus.Logger) *CertService {
+	if config.RenewalThreshold == (RenewalThreshold{}) {
+		config.RenewalThreshold = RenewalThreshold{Before: DefaultRenewalThresholdDays * 24 * time.Hour}
+	}
+	config.ClientTTL = firstNonEmpty(config.ClientTTL, DefaultClientTTL)
+	config.ServerTTL = firstNonEmpty(config.ServerTTL, DefaultClientTTL)
+	return &CertService{
+		certDB:   certDB,
+		vault:    vault,
+		mailer:   mailer,
+		notifier: notifier,
+		config:   config,
+		logger:   logger,
+	}
+}
+
+// log zwraca logger z polami cn i operation wspólnymi dla komunikatów jednej operacji
+func (s *CertService) log(commonName, operation string) *logrus.Entry {
+	return s.logger.WithFields(logrus.Fields{FieldCommonName: commonName, FieldOperation: operation})
+}
+
+// SetExecutor kieruje zapis profili .ovpn i polecenia na routerach przez executor trybu dry-run
+func (s *CertService) SetExecutor(executor *Executor) {
+	s.executor = executor
+}
+
+// HasLocale sprawdza, czy istnieją szablony email dla podanego języka
+func (s *CertService) HasLocale(locale string) bool {
+	return s.mailer.HasLocale(locale)
+}
+
+// ClientRequest opisuje wydanie lub odnowienie certyfikatu klienta
+type ClientRequest struct {
+	CommonName string
+	Email      string
+	// TTL nadpisuje TTL zapisany w bazie i polityki; pusty - TTL z polityki użytkownika
+	TTL         string
+	RenewBefore string // próg odnawiania użytkownika (np. 30d lub 2/3); pusty - bez zmian
+	Locale      string
+	Group       string
+	AutoRenew   string // "on", "off" lub pusty (bez zmian)
+	Source      string // źródło synchronizacji (users import/sync); puste - bez zmian
+	Force       bool
+	// Mount to nazwa montowania PKI; pusta - nowy certyfikat z polityki grupy, odnowienie w montowaniu zapisanym w bazie
+	Mount string
+	// RevokeImmediately odwołuje zastąpiony certyfikat od razu, z pominięciem RENEWAL_GRACE_PERIOD
+	// (rotacja z portalu, podejrzenie wycieku klucza)
+	RevokeImmediately bool
+}
+
+// ClientResult opisuje wynik operacji na certyfikacie klienta
+type ClientResult struct {
+	Certificate *CertificateInfo
+	Renewed     bool
+	Emailed     bool
+	DaysLeft    float64
+}
+
+// IssueClient wydaje certyfikat nowemu użytkownikowi, a istniejącemu odnawia go, jeśli zbliża się wygaśnięcie
+func (s *CertService) IssueClient(req ClientRequest) (*ClientResult, error) {
+	log := s.log(req.CommonName, "issue")
+	if req.TTL != "" {
+		if _, err := ParseDays(req.TTL); err != nil {
+			return nil, newError(ErrInvalidConfig, err, "nieprawidłowy TTL")
+		}
+	}
+	if req.RenewBefore != "" {
+		if _, err := ParseRenewalThreshold(req.RenewBefore); err != nil {
+			return nil, newError(ErrInvalidConfig, err, "nieprawidłowy próg odnawiania użytkownika %s", req.CommonName)
+		}
+	}
+
+	result := &ClientResult{}
+	var needsRenewal bool
+
+	// Sprawdź czy użytkownik istnieje w bazie danych
+	userCert, userExists := s.certDB.GetUser(req.CommonName)
+
+	if userExists {
+		log.WithField(FieldSerial, userCert.SerialNumber).Infof("Znaleziono użytkownika w bazie: %s, serial: %s", req.CommonName, userCert.SerialNumber)
+		s.updateUserSettings(userCert, req)
+		policy := s.config.UserPolicy(*userCert)
+
+		// Sprawdź ważność istniejącego certyfikatu
+		var err error
+		needsRenewal, result.DaysLeft, err = s.certDB.CheckCertificateExpiry(req.CommonName, policy.Threshold)
+		if err != nil {
+			return nil, fmt.Errorf("błąd podczas sprawdzania ważności certyfikatu: %w", err)
+		}
+		log.Infof("Certyfikat wygasa za %.1f dni", result.DaysLeft)
+
+		if needsRenewal && !policy.AutoRenew && !req.Force {
+			// Użytkownik dostaje tylko przypomnienia (polecenie reminders)
+			log.Warnf("Certyfikat wymaga odnowienia, ale automatyczne odnawianie jest wyłączone dla %s", req.CommonName)
+			needsRenewal = false
+		}
+
+		if userCert.IsRevoked() {
+			log.Warnf("Certyfikat %s został odwołany - generuję nowy", req.CommonName)
+			needsRenewal = true
+		}
+
+		if needsRenewal || req.Force {
+			if req.Force && !needsRenewal {
+				log.Warnf("Wymuszono odnowienie certyfikatu (opcja --force-renew)")
+			} else {
+				log.Warnf("Certyfikat wymaga odnowienia (próg: %s)", policy.Threshold)
+			}
+
+			vc, err := s.renewalClient(req.Mount, userCert.Issuer)
+			if err != nil {
+				return nil, err
+			}
+			ttl, err := s.resolveTTL(log, vc, req.TTL, policy, false)
+			if err != nil {
+				return nil, err
+			}
+			// Nowy certyfikat jest wydawany przed odwołaniem starego - błąd wydania nie odcina użytkownika od VPN
+			certInfo, err := vc.IssueCertificate(req.CommonName, ttl)
+			countOperation("renew", "user", err)
+			if err != nil {
+				s.notify(NewEvent(EventRenewalFailed, SeverityCritical, req.CommonName,
+					"Odnowienie certyfikatu nie powiodło się",
+					fmt.Sprintf("Nie udało się odnowić certyfikatu klienta %s: %v", req.CommonName, err)).
+					WithField("serial", userCert.SerialNumber))
+				// Blokada odnawiania musi trafić do bazy, aby użytkownik dostawał przypomnienia
+				if blockErr := s.certDB.MarkRenewalBlocked(req.CommonName, err.Error()); blockErr == nil {
+					if saveErr := s.certDB.Save(); saveErr != nil {
+						log.Warnf("Błąd podczas zapisywania bazy danych: %v", saveErr)
+					}
+				}
+				return nil, fmt.Errorf("błąd podczas odnawiania certyfikatu: %w", err)
+			}
+			result.Certificate = certInfo
+			result.Renewed = true
+
+			// Zaktualizuj bazę danych z nowym numerem seryjnym
+			if err := s.certDB.UpdateCertificateInfo(req.CommonName, certInfo.SerialNumber, certInfo.ExpiresAt, certInfo.Issuer); err != nil {
+				log.Warnf("Błąd podczas aktualizacji bazy danych: %v", err)
+			}
+			if !userCert.IsRevoked() {
+				s.retireCertificate(log, req.CommonName, "", userCert.SerialNumber, certInfo.SerialNumber, userCert.Issuer, req.RevokeImmediately)
+			}
+		} else {
+			log.Infof("Certyfikat nie wymaga odnowienia - jest jeszcze ważny przez %.1f dni", result.DaysLeft)
+
+			// Pobierz informacje o istniejącym certyfikacie z Vault
+			certInfo, err := s.vault.ForIssuer(userCert.Issuer).GetCertificateInfo(userCert.SerialNumber)
+			if err != nil {
+				return nil, fmt.Errorf("błąd podczas pobierania informacji o certyfikacie: %w", err)
+			}
+			// Ustawiamy datę wygaśnięcia z bazy danych, ponieważ certInfo z Vault nie ma tej informacji
+			certInfo.ExpiresAt = userCert.ExpiresAt
+			result.Certificate = certInfo
+		}
+	} else {
+		// Użytkownik nie istnieje - wygeneruj nowy certyfikat, o ile common name nie należy do urządzenia innego użytkownika
+		if owner, device, exists := s.certDB.FindDevice(req.CommonName); exists {
+			return nil, newError(ErrInvalidConfig, nil, "common name %s jest już używany przez urządzenie %s użytkownika %s", req.CommonName, device.Name, owner)
+		}
+		log.Infof("Generowanie nowego certyfikatu dla nowego użytkownika %s", req.CommonName)
+		policy := s.config.UserPolicy(UserCertificate{Group: req.Group})
+		vc, err := s.vault.Mount(firstNonEmpty(req.Mount, policy.Mount))
+		if err != nil {
+			return nil, err
+		}
+		ttl, err := s.resolveTTL(log, vc, req.TTL, policy, false)
+		if err != nil {
+			return nil, err
+		}
+		certInfo, err := vc.IssueCertificate(req.CommonName, ttl)
+		countOperation("issue", "user", err)
+		if err != nil {
+			return nil, fmt.Errorf("błąd podczas generowania certyfikatu: %w", err)
+		}
+		result.Certificate = certInfo
+		result.Renewed = true
+
+		err = s.certDB.AddOrUpdateUser(UserCertificate{
+			CommonName:        req.CommonName,
+			SerialNumber:      certInfo.SerialNumber,
+			Email:             req.Email,
+			CreatedAt:         time.Now(),
+			LastRenewed:       time.Now(),
+			ExpiresAt:         certInfo.ExpiresAt,
+			TTL:               ttl,
+			RenewBefore:       req.RenewBefore,
+			AutoRenewDisabled: req.AutoRenew == "off",
+			Locale:            req.Locale,
+			Group:             req.Group,
+			Source:            req.Source,
+			Issuer:            certInfo.Issuer,
+		})
+		if err != nil {
+			log.Warnf("Błąd podczas dodawania użytkownika do bazy danych: %v", err)
+		}
+
+		result.DaysLeft = time.Until(certInfo.ExpiresAt).Hours() / 24
+		userCert = &UserCertificate{CommonName: req.CommonName, Email: req.Email, Locale: req.Locale}
+	}
+
+	ovpnConfig, err := s.clientConfig(req.CommonName, result)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pobierz email i język z bazy danych, jeśli nie podano w parametrze
+	userEmail := firstNonEmpty(req.Email, userCert.Email)
+	userLocale := firstNonEmpty(req.Locale, userCert.Locale)
+
+	// Administrator musi wiedzieć o certyfikatach, których nie ma komu dostarczyć
+	if (result.Renewed || needsRenewal) && userEmail == "" {
+		s.notify(NewEvent(EventExpiringNoEmail, SeverityWarning, req.CommonName,
+			"Brak adresu email dla wygasającego certyfikatu",
+			fmt.Sprintf("Certyfikat %s wygasa lub został odnowiony, ale w bazie nie ma adresu email użytkownika", req.CommonName)).
+			WithField("expires_at", result.Certificate.ExpiresAt.Format("2006-01-02")))
+	}
+
+	// Wysyłaj email tylko jeśli certyfikat został odnowiony (ponowna wysyłka - ResendClient)
+	if result.Renewed && userEmail != "" {
+		if err := s.mailer.SendProfile(req.CommonName, userEmail, userLocale, result.Certificate.ExpiresAt, ovpnConfig); err != nil {
+			return result, fmt.Errorf("błąd podczas wysyłania e-maila: %w", err)
+		}
+		result.Emailed = true
+		log.Infof("Konfiguracja OpenVPN została wysłana na e-mail: %s", userEmail)
+	} else if !result.Renewed {
+		log.Infof("Nie wysyłano emaila - certyfikat nie został odnowiony (użyj client resend aby wysłać ponownie)")
+	} else {
+		log.Warnf("Nie podano adresu e-mail, konfiguracja nie została wysłana")
+	}
+
+	if err := s.certDB.Save(); err != nil {
+		return result, fmt.Errorf("błąd podczas zapisywania bazy danych: %w", err)
+	}
+
+	log.WithField(FieldSerial, result.Certificate.SerialNumber).Infof("Serial number certyfikatu: %s", result.Certificate.SerialNumber)
+	return result, nil
+}
+
+// updateUserSettings zapisuje zmiany emaila, języka, grupy, TTL i ustawień odnawiania przekazane w żądaniu
+func (s *CertService) updateUserSettings(userCert *UserCertificate, req ClientRequest) {
+	log := s.log(req.CommonName, "issue")
+	changed := false
+	if req.Email != "" && req.Email != userCert.Email {
+		userCert.Email = req.Email
+		changed = true
+		log.Infof("Zaktualizowano email dla użytkownika %s: %s", req.CommonName, req.Email)
+	}
+	if req.Locale != "" && req.Locale != userCert.Locale {
+		userCert.Locale = req.Locale
+		changed = true
+	}
+	if req.Group != "" && req.Group != userCert.Group {
+		userCert.Group = req.Group
+		changed = true
+	}
+	if req.Source != "" && req.Source != userCert.Source {
+		userCert.Source = req.Source
+		changed = true
+	}
+	if req.TTL != "" && req.TTL != userCert.TTL {
+		// Jawnie podany TTL zostaje zapamiętany i jest używany przy kolejnych odnowieniach
+		userCert.TTL = req.TTL
+		changed = true
+	}
+	if req.RenewBefore != "" && req.RenewBefore != userCert.RenewBefore {
+		userCert.RenewBefore = req.RenewBefore
+		changed = true
+		log.Infof("Próg odnawiania dla %s: %s", req.CommonName, req.RenewBefore)
+	}
+	if req.AutoRenew != "" {
+		userCert.AutoRenewDisabled = req.AutoRenew == "off"
+		changed = true
+		log.Infof("Automatyczne odnawianie dla %s: %s", req.CommonName, req.AutoRenew)
+	}
+	if !changed {
+		return
+	}
+	if err := s.certDB.AddOrUpdateUser(*userCert); err != nil {
+		log.Warnf("Błąd podczas aktualizacji ustawień użytkownika: %v", err)
+	}
+}
+
+// clientConfigPath zwraca ścieżkę pliku .ovpn użytkownika
+func (s *CertService) clientConfigPath(commonName string) string {
+	return filepath.Join(s.config.OutputDir, commonName+".ovpn")
+}
+
+// clientConfig generuje konfigurację OpenVPN dla nowego klucza lub odczytuje zapisaną wcześniej
+func (s *CertService) clientConfig(commonName string, result *ClientResult) (string, error) {
+	log := s.log(commonName, "issue")
+	certInfo := result.Certificate
+	if certInfo.PrivateKey != "" {
+		// Mamy klucz prywatny - generujemy nową konfigurację (w trakcie rotacji CA ze starym i nowym CA)
+		ovpnConfig := fmt.Sprintf(s.config.OvpnTemplate, s.profileCAChain(certInfo), certInfo.Certificate, certInfo.PrivateKey)
+		configPath := s.clientConfigPath(commonName)
+		if s.executor.Skip(PlanStep{Target: PlanFile, Operation: "write", Detail: configPath}) {
+			return ovpnConfig, nil
+		}
+		if err := os.WriteFile(configPath, []byte(ovpnConfig), 0644); err != nil {
+			return "", fmt.Errorf("błąd podczas zapisywania konfiguracji OVPN: %w", err)
+		}
+		log.Infof("Wygenerowano nową konfigurację OpenVPN")
+		return ovpnConfig, nil
+	}
+
+	// Nie mamy klucza prywatnego - sprawdzamy czy istnieje plik konfiguracyjny
+	configPath := s.clientConfigPath(commonName)
+	configData, err := os.ReadFile(configPath)
+	if err != nil {
+		// Nie mamy konfiguracji i nie możemy jej wygenerować bez klucza prywatnego
+		log.Warnf("Nie można wygenerować konfiguracji OpenVPN - brak klucza prywatnego dla istniejącego certyfikatu")
+		log.Warnf("Aby wygenerować nową konfigurację, użyj opcji --force-renew")
+		log.Infof("Certyfikat jest jeszcze ważny przez %.1f dni", result.DaysLeft)
+		return "", nil
+	}
+	log.Infof("Użyto istniejącej konfiguracji OpenVPN z pliku: %s", configPath)
+	return string(configData), nil
+}
+
+// ResendClient ponownie wysyła zapisaną konfigurację OpenVPN bez generowania nowego certyfikatu
+func (s *CertService) ResendClient(commonName, email, locale string) error {
+	log := s.log(commonName, "resend")
+	userCert, exists := s.certDB.GetUser(commonName)
+	if !exists {
+		return newError(ErrNotFound, nil, "użytkownik %s nie istnieje w bazie danych", commonName)
+	}
+	if userCert.IsRevoked() {
+		return newError(ErrNotFound, nil, "certyfikat użytkownika %s został odwołany", commonName)
+	}
+
+	profile, err := s.ClientProfile(commonName)
+	if err != nil {
+		return err
+	}
+
+	userEmail := firstNonEmpty(email, userCert.Email)
+	if userEmail == "" {
+		return newError(ErrInvalidConfig, nil, "brak adresu email dla użytkownika %s (podaj --email)", commonName)
+	}
+
+	if err := s.mailer.SendProfile(commonName, userEmail, firstNonEmpty(locale, userCert.Locale), userCert.ExpiresAt, profile); err != nil {
+		return fmt.Errorf("błąd podczas wysyłania e-maila: %w", err)
+	}
+
+	log.Infof("Konfiguracja OpenVPN została ponownie wysłana na e-mail: %s", userEmail)
+	return nil
+}
+
+// ClientProfile zwraca zapisaną konfigurację OpenVPN użytkownika
+func (s *CertService) ClientProfile(commonName string) (string, error) {
+	configPath := s.clientConfigPath(commonName)
+	configData, err := os.ReadFile(configPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", newError(ErrNotFound, err, "brak zapisanej konfiguracji %s (użyj client issue --force-renew)", configPath)
+		}
+		return "", fmt.Errorf("błąd podczas odczytu konfiguracji OpenVPN: %w", err)
+	}
+	return string(configData), nil
+}
+
+// RenewAllSummary podsumowuje odnawianie wszystkich certyfikatów klientów
+type RenewAllSummary struct {
+	Checked     int
+	Renewed     int
+	Skipped     int
+	Failed      int
+	FailedUsers []string
+}
+
+// RenewAll odnawia wszystkie certyfikaty klientów zbliżające się do wygaśnięcia.
+// Błędy dotyczące pojedynczego użytkownika są zliczane, a błąd krytyczny (IsFatal) przerywa całą partię.
+func (s *CertService) RenewAll() (RenewAllSummary, error) {
+	var summary RenewAllSummary
+	users := s.certDB.GetAllUsers()
+
+	names := make([]string, 0, len(users))
+	for commonName := range users {
+		names = append(names, commonName)
+	}
+	sort.Strings(names)
+
+	for _, commonName := range names {
+		user := users[commonName]
+		policy := s.config.UserPolicy(user)
+		now := time.Now()
+
+		summary.Checked++
+		if user.IsRevoked() || !policy.AutoRenew || !policy.Threshold.NeedsRenewal(user.LastRenewed, user.ExpiresAt, now) {
+			summary.Skipped++
+		} else {
+			// Bez TTL w żądaniu - odnowienie używa TTL zapisanego w bazie (z limitem polityki)
+			s.log(commonName, "renew").Infof("Odnawianie certyfikatu %s (wygasa za %.1f dni)", commonName, time.Until(user.ExpiresAt).Hours()/24)
+			_, err := s.IssueClient(ClientRequest{CommonName: commonName})
+			if err := s.countRenewal(&summary, commonName, err); err != nil {
+				return summary, err
+			}
+		}
+
+		// Urządzenia podlegają polityce użytkownika, ale każde ma własną datę wygaśnięcia
+		for _, name := range user.DeviceNames() {
+			device := user.Devices[name]
+			summary.Checked++
+			if user.IsRevoked() || device.IsRevoked() || !policy.AutoRenew || !policy.Threshold.NeedsRenewal(device.LastRenewed, device.ExpiresAt, now) {
+				summary.Skipped++
+				continue
+			}
+			s.log(device.CommonName, "renew").Infof("Odnawianie certyfikatu %s (wygasa za %.1f dni)", device.CommonName, time.Until(device.ExpiresAt).Hours()/24)
+			_, err := s.RenewDevice(DeviceRequest{CommonName: commonName, Device: name})
+			if err := s.countRenewal(&summary, device.CommonName, err); err != nil {
+				return summary, err
+			}
+		}
+	}
+
+	s.logger.WithField(FieldOperation, "renew").Infof("Odnawianie: sprawdzono %d, odnowiono %d, pominięto %d, błędy %d",
+		summary.Checked, summary.Renewed, summary.Skipped, summary.Failed)
+	return summary, nil
+}
+
+// retireCertificate odwołuje certyfikat użytkownika lub jego urządzenia zastąpiony przy odnowieniu albo - przy RENEWAL_GRACE_PERIOD -
+// zapisuje go do odwołania po okresie przejściowym. Nieudane natychmiastowe odwołanie trafia na listę oczekujących i jest ponawiane.
+// Odwołanie trafia do montowania, które wydało stary certyfikat (issuer), także gdy nowy pochodzi z innego montowania.
+// Przy immediate okres przejściowy jest pomijany.
+func (s *CertService) retireCertificate(log *logrus.Entry, commonName, device, oldSerial, newSerial string, issuer *Issuer, immediate bool) {
+	gracePeriod := s.config.GracePeriod
+	if immediate {
+		gracePeriod = 0
+	}
+	retireCertificate(s.certDB, s.vault, gracePeriod, log, commonName, device, oldSerial, newSerial, issuer)
+}
+
+// retireCertificate realizuje CertService.retireCertificate dla poleceń bez serwisu (reconcile --fix)
+func retireCertificate(certDB *CertificateDB, vault *VaultClient, gracePeriod time.Duration, log *logrus.Entry, commonName, device, oldSerial, newSerial string, issuer *Issuer) {
+	pending := PendingRevocation{SerialNumber: oldSerial, Device: device, ReplacedBy: newSerial, RevokeAfter: time.Now().Add(gracePeriod), Issuer: issuer}
+	if gracePeriod == 0 {
+		err := vault.ForIssuer(issuer).RevokeCertificate(oldSerial)
+		countOperation("revoke", "user", err)
+		if err == nil {
+			return
+		}
+		log.WithField(FieldSerial, oldSerial).Warnf("Nie udało się odwołać starego certyfikatu %s - odwołanie ponowi revoke-pending: %v", oldSerial, err)
+		pending.LastError = err.Error()
+	}
+	if err := certDB.AddPendingRevocation(commonName, pending); err != nil {
+		log.Warnf("Błąd podczas aktualizacji bazy danych: %v", err)
+	}
+}
+
+// RevokePendingSummary podsumowuje odwoływanie certyfikatów zastąpionych przy odnowieniu
+type RevokePendingSummary struct {
+	Pending       int
+	Revoked       int
+	Waiting       int
+	Failed        int
+	FailedSerials []string
+}
+
+// RevokePending odwołuje zastąpione certyfikaty, których okres przejściowy minął lub których następca już się połączył.
+// Błąd pojedynczego odwołania jest zapisywany w bazie i ponawiany przy kolejnym uruchomieniu, a błąd krytyczny przerywa partię.
+func (s *CertService) RevokePending(now time.Time) (RevokePendingSummary, error) {
+	var summary RevokePendingSummary
+	users := s.certDB.GetAllUsers()
+
+	names := make([]string, 0, len(users))
+	for commonName := range users {
+		names = append(names, commonName)
+	}
+	sort.Strings(names)
+
+	var runErr error
+users:
+	for _, commonName := range names {
+		for _, pending := range users[commonName].PendingRevocations {
+			summary.Pending++
+			if !pending.IsDue(now) {
+				summary.Waiting++
+				continue
+			}
+			log := s.log(commonName, "revoke").WithField(FieldSerial, pending.SerialNumber)
+			err := s.vault.ForIssuer(pending.Issuer).RevokeCertificate(pending.SerialNumber)
+			countOperation("revoke", "user", err)
+			if resolveErr := s.certDB.ResolvePendingRevocation(commonName, pending.SerialNumber, err); resolveErr != nil {
+				log.Warnf("Błąd podczas aktualizacji bazy danych: %v", resolveErr)
+			}
+			if err != nil {
+				summary.Failed++
+				summary.FailedSerials = append(summary.FailedSerials, pending.SerialNumber)
+				if IsFatal(err) {
+					runErr = fmt.Errorf("przerwano odwoływanie na certyfikacie %s użytkownika %s: %w", pending.SerialNumber, commonName, err)
+					break users
+				}
+				log.Warnf("Błąd podczas odwoływania certyfikatu %s: %v", pending.SerialNumber, err)
+				continue
+			}
+			summary.Revoked++
+		}
+	}
+
+	// Zapis także po błędzie krytycznym - odwołane już certyfikaty nie mogą wrócić na listę
+	if err := s.certDB.Save(); err != nil {
+		return summary, fmt.Errorf("błąd podczas zapisywania bazy danych: %w", err)
+	}
+	if runErr != nil {
+		return summary, runErr
+	}
+
+	s.logger.WithField(FieldOperation, "revoke").Infof("Odwoływanie zastąpionych certyfikatów: oczekujących %d, odwołano %d, w okresie przejściowym %d, błędy %d",
+		summary.Pending, summary.Revoked, summary.Waiting, summary.Failed)
+	return summary, nil
+}
+
+// countRenewal zlicza wynik odnowienia jednego certyfikatu; zwraca błąd tylko wtedy, gdy przerywa on całą partię (IsFatal)
+func (s *CertService) countRenewal(summary *RenewAllSummary, commonName string, err error) error {
+	if err == nil {
+		summary.Renewed++
+		return nil
+	}
+	summary.Failed++
+	summary.FailedUsers = append(summary.FailedUsers, commonName)
+	if IsFatal(err) {
+		return fmt.Errorf("przerwano odnawianie na użytkowniku %s: %w", commonName, err)
+	}
+	s.log(commonName, "renew").Warnf("Błąd podczas odnawiania certyfikatu %s: %v", commonName, err)
+	return nil
+}
+
+// RevokeClient odwołuje certyfikat użytkownika w Vault i oznacza go w bazie; certyfikaty urządzeń użytkownika
+// i certyfikaty czekające na odwołanie po odnowieniu są odwoływane od razu
+func (s *CertService) RevokeClient(commonName string) error {
+	log := s.log(commonName, "revoke")
+	userCert, exists := s.certDB.GetUser(commonName)
+	if !exists {
+		return newError(ErrNotFound, nil, "użytkownik %s nie istnieje w bazie danych", commonName)
+	}
+	if userCert.IsRevoked() {
+		log.Infof("Certyfikat %s jest już odwołany", commonName)
+		return nil
+	}
+
+	err := s.vault.ForIssuer(userCert.Issuer).RevokeCertificate(userCert.SerialNumber)
+	countOperation("revoke", "user", err)
+	if err != nil {
+		return err
+	}
+	if err := s.certDB.MarkRevoked(commonName, time.Now()); err != nil {
+		return err
+	}
+
+	var deviceErr error
+	for _, name := range userCert.DeviceNames() {
+		device := userCert.Devices[name]
+		if device.IsRevoked() {
+			continue
+		}
+		if err := s.revokeDeviceCertificate(commonName, device); err != nil && deviceErr == nil {
+			deviceErr = fmt.Errorf("nie udało się odwołać certyfikatu urządzenia %s: %w", device.CommonName, err)
+		}
+	}
+	pendingErr := s.revokePendingNow(log, commonName, userCert.PendingRevocations)
+
+	if err := s.certDB.Save(); err != nil {
+		return err
+	}
+	if deviceErr != nil {
+		return deviceErr
+	}
+	return pendingErr
+}
+
+// revokePendingNow odwołuje od razu podane certyfikaty czekające na odwołanie; nieudane zostają na liście dla revoke-pending
+func (s *CertService) revokePendingNow(log *logrus.Entry, commonName string, pending []PendingRevocation) error {
+	var firstErr error
+	for _, entry := range pending {
+		err := s.vault.ForIssuer(entry.Issuer).RevokeCertificate(entry.SerialNumber)
+		countOperation("revoke", "user", err)
+		if resolveErr := s.certDB.ResolvePendingRevocation(commonName, entry.SerialNumber, err); resolveErr != nil {
+			log.Warnf("Błąd podczas aktualizacji bazy danych: %v", resolveErr)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("nie udało się odwołać poprzedniego certyfikatu %s (ponowi revoke-pending): %w", entry.SerialNumber, err)
+		}
+	}
+	return firstErr
+}
+
+// RevokeServer odwołuje certyfikat serwera w Vault i usuwa go z bazy
+func (s *CertService) RevokeServer(commonName string) error {
+	serverCert, exists := s.certDB.GetServerCertificate(commonName)
+	if !exists {
+		return newError(ErrNotFound, nil, "certyfikat serwera %s nie istnieje w bazie danych", commonName)
+	}
+
+	err := s.vault.ForIssuer(serverCert.Issuer).RevokeCertificate(serverCert.SerialNumber)
+	countOperation("revoke", "server", err)
+	if err != nil {
+		return err
+	}
+	if err := s.certDB.DeleteServerCertificate(commonName); err != nil {
+		return err
+	}
+	return s.certDB.Save()
+}
+
+// ServerRequest opisuje wydanie certyfikatu serwera i jego wdrożenie na router
+type ServerRequest struct {
+	CommonName string
+	Email      string
+	// TTL nadpisuje TTL zapisany w bazie i politykę serwera
+	TTL        string
+	MikrotikIP string
+	Force      bool
+	// Resend wysyła powiadomienie o certyfikacie, nawet jeśli nie został wymieniony
+	Resend bool
+	// Mount to nazwa montowania PKI; pusta - nowy certyfikat z polityki serwera, odnowienie w montowaniu zapisanym w bazie
+	Mount string
+}
+
+// ServerResult opisuje wynik operacji na certyfikacie serwera
+type ServerResult struct {
+	Certificate *ServerCertificate
+	Renewed     bool
+	Deployments []RouterDeployment
+}
+
+// DeployServer wydaje lub odnawia certyfikat serwera i wdraża go na router Mikrotik
+func (s *CertService) DeployServer(req ServerRequest) (*ServerResult, error) {
+	log := s.log(req.CommonName, "deploy")
+	if req.MikrotikIP == "" {
+		return nil, newError(ErrInvalidConfig, nil, "wymagany jest adres IP Mikrotika")
+	}
+
+	result := &ServerResult{}
+
+	serverCert, exists := s.certDB.GetServerCertificate(req.CommonName)
+	if exists {
+		log.Infof("Znaleziono certyfikat serwera dla %s", req.CommonName)
+		policy := s.config.ServerPolicy(req.CommonName, serverCert.TTL)
+		vc, err := s.renewalClient(req.Mount, serverCert.Issuer)
+		if err != nil {
+			return nil, err
+		}
+		serverManager := NewServerManager(s.certDB, vc, s.logger)
+
+		needsRenewal, daysUntil, err := serverManager.CheckServerCertificateExpiry(req.CommonName, policy.Threshold)
+		if err != nil {
+			return nil, fmt.Errorf("błąd podczas sprawdzania ważności certyfikatu serwera: %w", err)
+		}
+		log.Infof("Certyfikat serwera wygasa za %.1f dni", daysUntil)
+
+		if needsRenewal && !policy.AutoRenew && !req.Force {
+			log.Warnf("Certyfikat serwera wymaga odnowienia, ale automatyczne odnawianie jest wyłączone w polityce serwera %s", req.CommonName)
+			needsRenewal = false
+		}
+
+		if needsRenewal || req.Force {
+			if req.Force && !needsRenewal {
+				log.Warnf("Wymuszono odnowienie certyfikatu serwera (opcja --force-renew)")
+			} else {
+				log.Warnf("Certyfikat serwera wymaga odnowienia (próg: %s)", policy.Threshold)
+			}
+
+			ttl, err := s.resolveTTL(log, vc, req.TTL, policy, true)
+			if err != nil {
+				return nil, err
+			}
+			serverCert, err = serverManager.RenewServerCertificate(req.CommonName, ttl)
+			countOperation("renew", "server", err)
+			if err != nil {
+				s.notify(NewEvent(EventRenewalFailed, SeverityCritical, req.CommonName,
+					"Odnowienie certyfikatu serwera nie powiodło się",
+					fmt.Sprintf("Nie udało się odnowić certyfikatu serwera %s: %v", req.CommonName, err)))
+				return nil, fmt.Errorf("błąd podczas odnawiania certyfikatu serwera: %w", err)
+			}
+
+			result.Renewed = true
+			log.WithField(FieldSerial, serverCert.SerialNumber).Infof("Certyfikat serwera odnowiony, serial: %s", serverCert.SerialNumber)
+		} else {
+			log.Infof("Certyfikat serwera nie wymaga odnowienia")
+		}
+	} else {
+		log.Infof("Generowanie nowego certyfikatu serwera dla %s", req.CommonName)
+
+		policy := s.config.ServerPolicy(req.CommonName, "")
+		vc, err := s.vault.Mount(firstNonEmpty(req.Mount, policy.Mount))
+		if err != nil {
+			return nil, err
+		}
+		ttl, err := s.resolveTTL(log, vc, req.TTL, policy, true)
+		if err != nil {
+			return nil, err
+		}
+		serverCert, err = NewServerManager(s.certDB, vc, s.logger).SetupServerCertificate(req.CommonName, ttl)
+		countOperation("issue", "server", err)
+		if err != nil {
+			return nil, fmt.Errorf("błąd podczas generowania certyfikatu serwera: %w", err)
+		}
+
+		result.Renewed = true
+		log.WithField(FieldSerial, serverCert.SerialNumber).Infof("Nowy certyfikat serwera wygenerowany, serial: %s", serverCert.SerialNumber)
+	}
+	result.Certificate = serverCert
+
+	serverCert.MikrotikIP = req.MikrotikIP
+	if err := s.certDB.AddOrUpdateServerCertificate(*serverCert); err != nil {
+		log.Warnf("Błąd podczas aktualizacji adresu IP Mikrotika: %v", err)
+	}
+
+	// Wysyłaj certyfikat na Mikrotika TYLKO jeśli został wygenerowany/odnowiony
+	if result.Renewed {
+		result.Deployments = append(result.Deployments, s.deployToRouter(serverCert))
+	} else {
+		log.Infof("Certyfikat serwera nie wymaga odnowienia - pomijam wysyłkę na Mikrotika")
+	}
+
+	if result.Renewed {
+		s.notify(NewEvent(EventServerCertRotated, SeverityInfo, req.CommonName,
+			"Certyfikat serwera został wymieniony",
+			fmt.Sprintf("Wygenerowano nowy certyfikat serwera %s", req.CommonName)).
+			WithField("serial", serverCert.SerialNumber).
+			WithField("expires_at", serverCert.ExpiresAt.Format("2006-01-02")).
+			WithField("router", serverCert.MikrotikIP))
+	}
+
+	// Powiadomienie dla administratorów: adres z --email oraz NOTIFY_EMAIL
+	recipients := s.mailer.AdminRecipients()
+	if req.Email != "" {
+		recipients = append([]string{req.Email}, recipients...)
+	}
+
+	if (result.Renewed || req.Resend) && len(recipients) > 0 {
+		recipients = uniqueStrings(recipients)
+		if err := s.mailer.SendServerRotation(recipients, "", serverCert, result.Deployments); err != nil {
+			return result, fmt.Errorf("błąd podczas wysyłania e-maila: %w", err)
+		}
+		log.Infof("Powiadomienie o wymianie certyfikatu serwera wysłane na: %v", recipients)
+	} else if result.Renewed || req.Resend {
+		log.Warnf("Nie podano adresu e-mail (--email ani NOTIFY_EMAIL), powiadomienie o certyfikacie serwera nie zostało wysłane")
+	}
+
+	if err := s.certDB.Save(); err != nil {
+		return result, fmt.Errorf("błąd podczas zapisywania bazy danych: %w", err)
+	}
+
+	log.Infof("Konfiguracja serwera zakończona")
+	return result, nil
+}
+
+// resolveTTL wybiera TTL certyfikatu - podany w żądaniu albo z polityki - i sprawdza go z limitem polityki (max_ttl, MAX_TTL)
+// oraz maksymalnym TTL roli Vault w montowaniu klienta vc. Jawnie podany TTL ponad limitem jest błędem, a TTL z polityki
+// (np. zapisany w bazie przy wcześniejszym wydaniu) jest przycinany do limitu.
+func (s *CertService) resolveTTL(log *logrus.Entry, vc *VaultClient, requested string, policy EffectivePolicy, server bool) (string, error) {
+	ttl := firstNonEmpty(requested, policy.TTL)
+	duration, err := ParseDays(ttl)
+	if err != nil {
+		return "", newError(ErrInvalidConfig, err, "nieprawidłowy TTL")
+	}
+
+	limit, roleLimit := policy.MaxTTL, false
+	roleMaxTTL, err := vc.RoleMaxTTL(server)
+	if err != nil {
+		// Vault i tak nie wyda certyfikatu dłuższego niż pozwala rola - brak odczytu nie blokuje wydania
+		log.Warnf("Nie udało się odczytać maksymalnego TTL roli Vault, pomijam sprawdzenie: %v", err)
+	} else if roleMaxTTL > 0 && (limit == 0 || roleMaxTTL < limit) {
+		limit, roleLimit = roleMaxTTL, true
+	}
+	if limit == 0 || duration <= limit {
+		return ttl, nil
+	}
+
+	capped := formatTTL(limit)
+	switch {
+	case requested != "" && roleLimit:
+		return "", newError(ErrInvalidConfig, nil, "TTL %s przekracza maksymalny TTL roli Vault (%s)", ttl, capped)
+	case requested != "":
+		return "", newError(ErrInvalidConfig, nil, "TTL %s przekracza maksymalny TTL polityki (%s)", ttl, capped)
+	case roleLimit:
+		log.Warnf("TTL %s przekracza maksymalny TTL roli Vault - certyfikat zostanie wydany na %s", ttl, capped)
+	default:
+		log.Warnf("TTL %s przekracza maksymalny TTL polityki - certyfikat zostanie wydany na %s", ttl, capped)
+	}
+	return capped, nil
+}
+
+// renewalClient zwraca klienta Vault odnowienia: jawnie wybrane montowanie (--mount) przenosi certyfikat,
+// w przeciwnym razie nowy certyfikat wydaje montowanie, które wydało poprzedni
+func (s *CertService) renewalClient(requested string, issuer *Issuer) (*VaultClient, error) {
+	if requested != "" {
+		return s.vault.Mount(requested)
+	}
+	return s.vault.ForIssuer(issuer), nil
+}
+
+// deployToRouter wysyła certyfikat serwera na router i zwraca wynik wdrożenia
+func (s *CertService) deployToRouter(serverCert *ServerCertificate) RouterDeployment {
+	log := s.log(serverCert.CommonName, "deploy").WithField(FieldRouter, serverCert.MikrotikIP)
+	router := serverCert.MikrotikIP
+	if !s.config.Mikrotik.HasCredentials() {
+		log.Warnf("Brak danych dostępowych Mikrotika (MIKROTIK_USERNAME, MIKROTIK_PASSWORD)")
+		log.Infof("Certyfikat serwera wymaga ręcznej aktualizacji na routerze Mikrotik (%s)", router)
+		return RouterDeployment{Router: router, Status: DeploymentSkipped, Error: "brak danych dostępowych Mikrotika"}
+	}
+
+	mikrotikClient, err := NewMikrotikIntegration(router, s.config.Mikrotik, s.logger)
+	if err != nil {
+		log.Warnf("Nie udało się połączyć z Mikrotikiem: %v", err)
+		log.Warnf("Certyfikat zostanie zaktualizowany ręcznie na routerze")
+		s.notifyRouterDeployFailed(serverCert, err)
+		countOperation("deploy", "server", err)
+		return RouterDeployment{Router: router, Status: DeploymentFailed, Error: err.Error()}
+	}
+	defer mikrotikClient.Close()
+	mikrotikClient.SetExecutor(s.executor)
+
+	if err := mikrotikClient.UploadCertificateToMikrotik(serverCert); err != nil {
+		log.Warnf("Błąd podczas wysyłania certyfikatu na Mikrotik: %v", err)
+		s.notifyRouterDeployFailed(serverCert, err)
+		countOperation("deploy", "server", err)
+		return RouterDeployment{Router: router, Status: DeploymentFailed, Error: err.Error()}
+	}
+
+	log.Infof("Certyfikat serwera został pomyślnie wysłany na router Mikrotik")
+	countOperation("deploy", "server", nil)
+	return RouterDeployment{Router: router, Status: DeploymentOK}
+}
+
+// notify wysyła zdarzenie administracyjne; błąd dostarczenia nie przerywa operacji
+func (s *CertService) notify(event Event) {
+	if s.notifier == nil {
+		return
+	}
+	if err := s.notifier.Notify(event); err != nil {
+		s.logger.Warnf("Błąd podczas wysyłania powiadomienia: %v", err)
+	}
+}
+
+// notifyRouterDeployFailed informuje administratorów o nieudanym wdrożeniu certyfikatu na router
+func (s *CertService) notifyRouterDeployFailed(serverCert *ServerCertificate, err error) {
+	s.notify(NewEvent(EventRouterDeployFailed, SeverityCritical, serverCert.CommonName,
+		"Wdrożenie certyfikatu na router nie powiodło się",
+		fmt.Sprintf("Nie udało się wdrożyć certyfikatu %s na router %s: %v", serverCert.CommonName, serverCert.MikrotikIP, err)).
+		WithField("serial", serverCert.SerialNumber).
+		WithField("router", serverCert.MikrotikIP))
+}
+
+// firstNonEmpty zwraca pierwszą niepustą wartość
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// uniqueStrings usuwa powtórzenia z listy, zachowując kolejność
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIssueClientRevokeImmediately(t *testing.T) {
+	for _, immediate := range []bool{false, true} {
+		service, certDB, tv := newTestService(t)
+		service.config.GracePeriod = 7 * 24 * time.Hour
+		addTestUser(t, certDB, UserCertificate{CommonName: "jan.client.vpn", Email: "jan@example.com"})
+
+		if _, err := service.IssueClient(ClientRequest{CommonName: "jan.client.vpn", Force: true, RevokeImmediately: immediate}); err != nil {
+			t.Fatalf("immediate=%v: IssueClient: %v", immediate, err)
+		}
+		user, _ := certDB.GetUser("jan.client.vpn")
+		revoked := slices.Contains(tv.revoked, "aa:janclientvpn")
+		if immediate && (!revoked || len(user.PendingRevocations) != 0) {
+			t.Errorf("immediate: revoked %v, pending %+v - want revoked without grace period", tv.revoked, user.PendingRevocations)
+		}
+		if !immediate && (revoked || len(user.PendingRevocations) != 1) {
+			t.Errorf("grace period: revoked %v, pending %+v - want one pending revocation", tv.revoked, user.PendingRevocations)
+		}
+	}
+}
+
+func TestRenewDeviceRevokeImmediately(t *testing.T) {
+	service, certDB, tv := newTestService(t)
+	service.config.GracePeriod = 7 * 24 * time.Hour
+	addTestUser(t, certDB, UserCertificate{CommonName: "jan.client.vpn", Email: "jan@example.com"})
+	now := time.Now()
+	device := DeviceCertificate{Name: "phone", CommonName: "jan-phone.client.vpn", SerialNumber: "bb:phone", CreatedAt: now, ExpiresAt: now.Add(365 * 24 * time.Hour)}
+	if err := certDB.AddOrUpdateDevice("jan.client.vpn", device); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.RenewDevice(DeviceRequest{CommonName: "jan.client.vpn", Device: "phone", RevokeImmediately: true}); err != nil {
+		t.Fatalf("RenewDevice: %v", err)
+	}
+	user, _ := certDB.GetUser("jan.client.vpn")
+	if !slices.Contains(tv.revoked, "bb:phone") || len(user.PendingRevocations) != 0 {
+		t.Errorf("revoked %v, pending %+v - want the old device certificate revoked right away", tv.revoked, user.PendingRevocations)
+	}
+}
+
+// TestLockCertificateDB symuluje równoległe skrypty client-connect: każdy wczytuje bazę pod blokadą, zmienia ją i zapisuje
+func TestLockCertificateDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db", "certificates.json")
+	if err := NewCertificateDB(path, testLogger()).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := LockCertificateDB(path)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer unlock()
+			certDB, err := LoadCertificateDB(path, testLogger())
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := certDB.AddOrUpdateUser(UserCertificate{CommonName: fmt.Sprintf("user%d.client.vpn", i)}); err != nil {
+				errs <- err
+				return
+			}
+			errs <- certDB.Save()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	certDB, err := LoadCertificateDB(path, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users := len(certDB.GetAllUsers()); users != writers {
+		t.Errorf("database has %d users, want %d - concurrent writers lost updates", users, writers)
+	}
+}
+
+func TestIssueClientRejectsDeviceCommonName(t *testing.T) {
+	service, certDB, tv := newTestService(t)
+	addTestUser(t, certDB, UserCertificate{CommonName: "jan.client.vpn", Email: "jan@example.com"})
+	if _, err := service.AddDevice(DeviceRequest{CommonName: "jan.client.vpn", Device: "phone"}); err != nil {
+		t.Fatalf("AddDevice: %v", err)
+	}
+	deviceCommonName := DeviceCommonName("jan.client.vpn", "phone")
+	issued := len(tv.issued)
+
+	_, err := service.IssueClient(ClientRequest{CommonName: deviceCommonName, Email: "other@example.com"})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("err = %v, want ErrInvalidConfig", err)
+	}
+	if _, exists := certDB.GetUser(deviceCommonName); exists || len(tv.issued) != issued {
+		t.Errorf("device common name was issued as a user (issued %v)", tv.issued)
+	}
+}
+
+// addTestPendingRevocations zapisuje użytkownikowi oczekujące odwołania 01, 02 i 03; 02 jeszcze nie jest do odwołania
+func addTestPendingRevocations(t *testing.T, certDB *CertificateDB, commonName string) {
+	t.Helper()
+	now := time.Now()
+	for _, pending := range []PendingRevocation{
+		{SerialNumber: "01", RevokeAfter: now.Add(-time.Hour)},
+		{SerialNumber: "02", RevokeAfter: now.Add(24 * time.Hour)},
+		{SerialNumber: "03", RevokeAfter: now.Add(-time.Hour)},
+	} {
+		if err := certDB.AddPendingRevocation(commonName, pending); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRevokeClientRevokesEveryPending(t *testing.T) {
+	service, certDB, tv := newTestService(t)
+	addTestUser(t, certDB, UserCertificate{CommonName: "jan.client.vpn", Email: "jan@example.com"})
+	addTestPendingRevocations(t, certDB, "jan.client.vpn")
+
+	if err := service.RevokeClient("jan.client.vpn"); err != nil {
+		t.Fatalf("RevokeClient: %v", err)
+	}
+	for _, serial := range []string{"01", "02", "03"} {
+		if count := len(slices.DeleteFunc(slices.Clone(tv.revoked), func(s string) bool { return s != serial })); count != 1 {
+			t.Errorf("serial %s revoked %d times (revoked %v), want once", serial, count, tv.revoked)
+		}
+	}
+}
+
+func TestRevokePendingRevokesEachDueOnce(t *testing.T) {
+	service, certDB, tv := newTestService(t)
+	addTestUser(t, certDB, UserCertificate{CommonName: "jan.client.vpn", Email: "jan@example.com"})
+	addTestPendingRevocations(t, certDB, "jan.client.vpn")
+
+	summary, err := service.RevokePending(time.Now())
+	if err != nil {
+		t.Fatalf("RevokePending: %v", err)
+	}
+	if summary.Pending != 3 || summary.Revoked != 2 || summary.Waiting != 1 {
+		t.Errorf("summary %+v, want 3 pending, 2 revoked, 1 waiting", summary)
+	}
+	if !slices.Equal(tv.revoked, []string{"01", "03"}) {
+		t.Errorf("revoked %v, want [01 03]", tv.revoked)
+	}
+	user, _ := certDB.GetUser("jan.client.vpn")
+	if len(user.PendingRevocations) != 1 || user.PendingRevocations[0].SerialNumber != "02" {
+		t.Errorf("pending %+v, want only 02", user.PendingRevocations)
+	}
+}
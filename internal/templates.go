@@ -0,0 +1,275 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Nazwy szablonów emaili
+const (
+	TemplateProfile       = "profile"
+	TemplateReminder      = "reminder"
+	TemplateServerRotated = "server_rotated"
+	TemplateLogin         = "login"
+)
+
+// DefaultLocale to język używany, gdy użytkownik nie ma ustawionego własnego
+const DefaultLocale = "pl"
+
+// templateNames to szablony, które muszą istnieć dla każdego języka
+var templateNames = []string{TemplateProfile, TemplateReminder, TemplateServerRotated, TemplateLogin}
+
+// TemplateData to zmienne dostępne w szablonach emaili
+type TemplateData struct {
+	Name         string
+	Days         int
+	ExpiresAt    time.Time
+	ServerName   string
+	Organization string
+	Year         int
+	// Link to jednorazowy adres logowania do portalu (tylko szablon login)
+	Link string
+}
+
+// RouterDeployment opisuje wynik wdrożenia certyfikatu serwera na jeden router
+type RouterDeployment struct {
+	Router string
+	Status string // ok, failed, skipped
+	Error  string
+}
+
+// Statusy wdrożenia certyfikatu na router
+const (
+	DeploymentOK      = "ok"
+	DeploymentFailed  = "failed"
+	DeploymentSkipped = "skipped"
+)
+
+// ServerRotationData to zmienne szablonu powiadomienia o wymianie certyfikatu serwera
+type ServerRotationData struct {
+	CommonName   string
+	SerialNumber string
+	Fingerprint  string
+	NotBefore    time.Time
+	NotAfter     time.Time
+	Routers      []RouterDeployment
+	Organization string
+	Year         int
+}
+
+// RenderedEmail to gotowa treść wiadomości
+type RenderedEmail struct {
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// emailTemplate to komplet szablonów jednej wiadomości: temat, HTML i wersja tekstowa
+type emailTemplate struct {
+	subject *texttemplate.Template
+	html    *htmltemplate.Template
+	text    *texttemplate.Template
+}
+
+var templateFuncs = map[string]interface{}{
+	"date":     func(t time.Time) string { return t.Format("2006-01-02") },
+	"datetime": func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04 MST") },
+}
+
+// overlayFS odczytuje pliki najpierw z katalogu nadpisań, a w razie braku - z wbudowanych szablonów
+type overlayFS struct {
+	primary  fs.FS
+	fallback fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if o.primary != nil {
+		file, err := o.primary.Open(name)
+		if err == nil {
+			return file, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return o.fallback.Open(name)
+}
+
+// TemplateStore przechowuje sparsowane szablony emaili dla wszystkich języków
+type TemplateStore struct {
+	templates       map[string]map[string]*emailTemplate
+	defaultLocale   string
+	subjectOverride *texttemplate.Template
+}
+
+// NewTemplateStore wczytuje szablony z katalogu overrideDir (jeśli podano), uzupełniając je wbudowanymi.
+// Wszystkie szablony są parsowane od razu, aby błędy wyszły przy starcie programu.
+func NewTemplateStore(embedded fs.FS, overrideDir, defaultLocale, subjectOverride string) (*TemplateStore, error) {
+	fsys := overlayFS{fallback: embedded}
+	if overrideDir != "" {
+		info, err := os.Stat(overrideDir)
+		if err != nil {
+			return nil, fmt.Errorf("nie udało się odczytać katalogu szablonów: %w", err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s nie jest katalogiem", overrideDir)
+		}
+		fsys.primary = os.DirFS(overrideDir)
+	}
+
+	store := &TemplateStore{
+		templates:     make(map[string]map[string]*emailTemplate),
+		defaultLocale: NormalizeLocale(defaultLocale),
+	}
+	if store.defaultLocale == "" {
+		store.defaultLocale = DefaultLocale
+	}
+
+	locales, err := listLocales(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, locale := range locales {
+		store.templates[locale] = make(map[string]*emailTemplate)
+		for _, name := range templateNames {
+			tmpl, err := parseEmailTemplate(fsys, locale, name)
+			if err != nil {
+				return nil, err
+			}
+			store.templates[locale][name] = tmpl
+		}
+	}
+
+	if _, ok := store.templates[store.defaultLocale]; !ok {
+		return nil, fmt.Errorf("brak szablonów dla domyślnego języka %q", store.defaultLocale)
+	}
+
+	if subjectOverride != "" {
+		store.subjectOverride, err = texttemplate.New("subject").Funcs(templateFuncs).Parse(subjectOverride)
+		if err != nil {
+			return nil, fmt.Errorf("nieprawidłowy szablon tematu MAIL_SUBJECT: %w", err)
+		}
+	}
+
+	return store, nil
+}
+
+// listLocales zwraca katalogi języków dostępne we wbudowanych szablonach i katalogu nadpisań
+func listLocales(fsys overlayFS) ([]string, error) {
+	seen := make(map[string]bool)
+	var locales []string
+
+	for _, source := range []fs.FS{fsys.primary, fsys.fallback} {
+		if source == nil {
+			continue
+		}
+		entries, err := fs.ReadDir(source, ".")
+		if err != nil {
+			return nil, fmt.Errorf("nie udało się odczytać katalogu szablonów: %w", err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && !seen[entry.Name()] {
+				seen[entry.Name()] = true
+				locales = append(locales, entry.Name())
+			}
+		}
+	}
+
+	return locales, nil
+}
+
+// parseEmailTemplate parsuje pliki <locale>/<name>.subject.tmpl, .html.tmpl i .txt.tmpl
+func parseEmailTemplate(fsys fs.FS, locale, name string) (*emailTemplate, error) {
+	read := func(suffix string) (string, error) {
+		file := path.Join(locale, name+suffix)
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return "", fmt.Errorf("brak szablonu %s: %w", file, err)
+		}
+		return string(data), nil
+	}
+
+	subject, err := read(".subject.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	html, err := read(".html.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	text, err := read(".txt.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := &emailTemplate{}
+	if tmpl.subject, err = texttemplate.New("subject").Funcs(templateFuncs).Parse(strings.TrimSpace(subject)); err != nil {
+		return nil, fmt.Errorf("błąd w szablonie %s/%s.subject.tmpl: %w", locale, name, err)
+	}
+	if tmpl.html, err = htmltemplate.New("html").Funcs(templateFuncs).Parse(html); err != nil {
+		return nil, fmt.Errorf("błąd w szablonie %s/%s.html.tmpl: %w", locale, name, err)
+	}
+	if tmpl.text, err = texttemplate.New("text").Funcs(templateFuncs).Parse(text); err != nil {
+		return nil, fmt.Errorf("błąd w szablonie %s/%s.txt.tmpl: %w", locale, name, err)
+	}
+
+	return tmpl, nil
+}
+
+// NormalizeLocale sprowadza oznaczenie języka do postaci katalogu, np. "en-US" -> "en"
+func NormalizeLocale(locale string) string {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if i := strings.IndexAny(locale, "-_"); i > 0 {
+		locale = locale[:i]
+	}
+	return locale
+}
+
+// HasLocale sprawdza, czy istnieją szablony dla podanego języka
+func (ts *TemplateStore) HasLocale(locale string) bool {
+	_, ok := ts.templates[NormalizeLocale(locale)]
+	return ok
+}
+
+// Render generuje wiadomość w języku użytkownika (lub domyślnym, jeśli brak tłumaczenia)
+func (ts *TemplateStore) Render(name, locale string, data interface{}) (*RenderedEmail, error) {
+	templates, ok := ts.templates[NormalizeLocale(locale)]
+	if !ok {
+		templates = ts.templates[ts.defaultLocale]
+	}
+
+	tmpl, ok := templates[name]
+	if !ok {
+		return nil, fmt.Errorf("nieznany szablon emaila: %s", name)
+	}
+
+	var subject, html, text bytes.Buffer
+	subjectTemplate := tmpl.subject
+	if ts.subjectOverride != nil && name == TemplateProfile {
+		subjectTemplate = ts.subjectOverride
+	}
+	if err := subjectTemplate.Execute(&subject, data); err != nil {
+		return nil, fmt.Errorf("błąd podczas generowania tematu %s: %w", name, err)
+	}
+	if err := tmpl.html.Execute(&html, data); err != nil {
+		return nil, fmt.Errorf("błąd podczas generowania treści HTML %s: %w", name, err)
+	}
+	if err := tmpl.text.Execute(&text, data); err != nil {
+		return nil, fmt.Errorf("błąd podczas generowania treści tekstowej %s: %w", name, err)
+	}
+
+	return &RenderedEmail{
+		Subject: strings.TrimSpace(subject.String()),
+		HTML:    html.String(),
+		Text:    text.String(),
+	}, nil
+}
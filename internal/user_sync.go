@@ -0,0 +1,257 @@
+package internal
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Akcje synchronizacji listy użytkowników z bazą
+const (
+	SyncIssue     = "issue"
+	SyncUpdate    = "update"
+	SyncRevoke    = "revoke"
+	SyncUnchanged = "unchanged"
+	SyncFailed    = "failed"
+)
+
+// DefaultSyncMaxRevocations to domyślny limit odwołań w jednej synchronizacji
+const DefaultSyncMaxRevocations = 10
+
+// SyncOptions steruje synchronizacją użytkowników
+type SyncOptions struct {
+	// RevokeMissing odwołuje certyfikaty użytkowników, których nie ma na liście (tryb --sync)
+	RevokeMissing bool
+	// Source to nazwa źródła listy (DirectorySource.Name) zapisywana u użytkowników;
+	// RevokeMissing odwołuje tylko użytkowników z tego samego źródła
+	Source string
+	// MaxRevocations przerywa synchronizację, która odwołałaby więcej użytkowników (0 - bez limitu)
+	MaxRevocations int
+	// Force pozwala przekroczyć MaxRevocations (opcja --force)
+	Force bool
+}
+
+// LoadSyncMaxRevocationsFromEnv wczytuje limit odwołań synchronizacji (SYNC_MAX_REVOCATIONS)
+func LoadSyncMaxRevocationsFromEnv() (int, error) {
+	value := os.Getenv("SYNC_MAX_REVOCATIONS")
+	if value == "" {
+		return DefaultSyncMaxRevocations, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return 0, newError(ErrInvalidConfig, err, "nieprawidłowa wartość SYNC_MAX_REVOCATIONS: %s", value)
+	}
+	return limit, nil
+}
+
+// SyncAction to operacja wykonana dla jednego użytkownika
+type SyncAction struct {
+	Action     string `json:"action"`
+	CommonName string `json:"common_name"`
+	Email      string `json:"email,omitempty"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+// SyncReport podsumowuje synchronizację użytkowników
+type SyncReport struct {
+	DryRun  bool         `json:"dry_run"`
+	Actions []SyncAction `json:"actions"`
+}
+
+// Count zwraca liczbę akcji danego rodzaju
+func (r *SyncReport) Count(action string) int {
+	count := 0
+	for _, a := range r.Actions {
+		if a.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// FailedUsers zwraca CN użytkowników, dla których synchronizacja się nie powiodła
+func (r *SyncReport) FailedUsers() []string {
+	var failed []string
+	for _, a := range r.Actions {
+		if a.Action == SyncFailed {
+			failed = append(failed, a.CommonName)
+		}
+	}
+	return failed
+}
+
+// SyncUsers uzgadnia bazę z listą uprawnionych użytkowników (z pliku lub katalogu LDAP): nowym (i odwołanym)
+// wydaje certyfikaty, istniejącym aktualizuje email, TTL, grupę i język, a w trybie RevokeMissing odwołuje nieobecnych
+// pochodzących z tego samego źródła. Więcej odwołań niż MaxRevocations przerywa synchronizację przed zmianami (bez Force).
+// Błąd dotyczący jednego użytkownika jest raportowany, a błąd krytyczny (IsFatal) przerywa synchronizację.
+// W trybie dry-run executor zamienia wydania, odwołania, wysyłkę i zapis bazy w plan operacji.
+func (s *CertService) SyncUsers(users []DirectoryUser, options SyncOptions) (*SyncReport, error) {
+	if options.RevokeMissing && len(users) == 0 {
+		return nil, newError(ErrInvalidConfig, nil, "lista użytkowników jest pusta - odmowa odwołania wszystkich certyfikatów")
+	}
+	if options.RevokeMissing && options.Source == "" {
+		return nil, newError(ErrInvalidConfig, nil, "synchronizacja z odwoływaniem wymaga nazwy źródła")
+	}
+
+	listed := make(map[string]bool, len(users))
+	for _, user := range users {
+		listed[user.CommonName] = true
+	}
+	var revocations []string
+	if options.RevokeMissing {
+		revocations = s.revocationCandidates(listed, options.Source)
+		if options.MaxRevocations > 0 && len(revocations) > options.MaxRevocations && !options.Force {
+			return nil, newError(ErrInvalidConfig, nil, "synchronizacja odwołałaby %d użytkowników źródła %s (limit SYNC_MAX_REVOCATIONS: %d) - sprawdź źródło lub użyj --force",
+				len(revocations), options.Source, options.MaxRevocations)
+		}
+	}
+
+	report := &SyncReport{DryRun: s.executor.DryRun()}
+	updated := false
+
+	for _, user := range users {
+		existing, exists := s.certDB.GetUser(user.CommonName)
+
+		if !exists || existing.IsRevoked() {
+			action := SyncAction{Action: SyncIssue, CommonName: user.CommonName, Email: user.Email}
+			if exists {
+				action.Detail = "ponowne wydanie po odwołaniu"
+			}
+			_, err := s.IssueClient(ClientRequest{
+				CommonName: user.CommonName,
+				Email:      user.Email,
+				TTL:        user.TTL,
+				Locale:     user.Locale,
+				Group:      user.Group,
+				Source:     options.Source,
+			})
+			if err != nil {
+				if IsFatal(err) {
+					return report, fmt.Errorf("przerwano synchronizację na użytkowniku %s: %w", user.CommonName, err)
+				}
+				s.logger.Warnf("Błąd podczas wydawania certyfikatu %s: %v", user.CommonName, err)
+				action.Action = SyncFailed
+				action.Detail = err.Error()
+			}
+			report.Actions = append(report.Actions, action)
+			continue
+		}
+
+		changes := userChanges(existing, user, options.Source)
+		if len(changes) == 0 {
+			report.Actions = append(report.Actions, SyncAction{Action: SyncUnchanged, CommonName: user.CommonName, Email: existing.Email})
+			continue
+		}
+
+		action := SyncAction{Action: SyncUpdate, CommonName: user.CommonName, Email: firstNonEmpty(user.Email, existing.Email), Detail: strings.Join(changes, ", ")}
+		applyDirectoryUser(existing, user, options.Source)
+		if err := s.certDB.AddOrUpdateUser(*existing); err != nil {
+			report.Actions = append(report.Actions, SyncAction{Action: SyncFailed, CommonName: user.CommonName, Detail: err.Error()})
+			continue
+		}
+		updated = true
+		report.Actions = append(report.Actions, action)
+	}
+
+	if updated {
+		if err := s.certDB.Save(); err != nil {
+			return report, fmt.Errorf("błąd podczas zapisywania bazy danych: %w", err)
+		}
+	}
+
+	for _, commonName := range revocations {
+		action := SyncAction{Action: SyncRevoke, CommonName: commonName, Detail: "brak na liście uprawnionych"}
+		if err := s.RevokeClient(commonName); err != nil {
+			if IsFatal(err) {
+				return report, fmt.Errorf("przerwano synchronizację na użytkowniku %s: %w", commonName, err)
+			}
+			s.logger.Warnf("Błąd podczas odwoływania certyfikatu %s: %v", commonName, err)
+			action.Action = SyncFailed
+			action.Detail = err.Error()
+		}
+		report.Actions = append(report.Actions, action)
+	}
+
+	s.logger.Infof("Synchronizacja użytkowników: wydano %d, zaktualizowano %d, odwołano %d, bez zmian %d, błędy %d",
+		report.Count(SyncIssue), report.Count(SyncUpdate), report.Count(SyncRevoke), report.Count(SyncUnchanged), report.Count(SyncFailed))
+	return report, nil
+}
+
+// revocationCandidates zwraca aktywnych użytkowników danego źródła, których nie ma na liście
+func (s *CertService) revocationCandidates(listed map[string]bool, source string) []string {
+	var names []string
+	for commonName, user := range s.certDB.GetAllUsers() {
+		if !listed[commonName] && !user.IsRevoked() && user.Source == source {
+			names = append(names, commonName)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// userChanges opisuje różnice między wpisem w bazie a użytkownikiem ze źródła; puste pola źródła nie nadpisują bazy
+func userChanges(existing *UserCertificate, user DirectoryUser, source string) []string {
+	var changes []string
+	diff := func(field, current, wanted string) {
+		if wanted != "" && wanted != current {
+			changes = append(changes, fmt.Sprintf("%s: %q -> %q", field, current, wanted))
+		}
+	}
+	diff("email", existing.Email, user.Email)
+	diff("ttl", existing.TTL, user.TTL)
+	diff("group", existing.Group, user.Group)
+	diff("locale", existing.Locale, user.Locale)
+	diff("source", existing.Source, source)
+	return changes
+}
+
+// applyDirectoryUser przepisuje niepuste pola użytkownika ze źródła do wpisu w bazie; użytkownik przechodzi do źródła
+func applyDirectoryUser(existing *UserCertificate, user DirectoryUser, source string) {
+	existing.Email = firstNonEmpty(user.Email, existing.Email)
+	existing.TTL = firstNonEmpty(user.TTL, existing.TTL)
+	existing.Group = firstNonEmpty(user.Group, existing.Group)
+	existing.Locale = firstNonEmpty(user.Locale, existing.Locale)
+	existing.Source = firstNonEmpty(source, existing.Source)
+}
+
+// syncColumns to kolumny raportu synchronizacji w formacie tabeli i CSV
+var syncColumns = []string{"action", "common_name", "email", "detail"}
+
+// WriteSyncReport zapisuje raport synchronizacji w wybranym formacie
+func WriteSyncReport(w io.Writer, report *SyncReport, format string) error {
+	switch format {
+	case FormatJSON:
+		if report.Actions == nil {
+			report.Actions = []SyncAction{}
+		}
+		return writeJSON(w, report)
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(syncColumns); err != nil {
+			return err
+		}
+		for _, a := range report.Actions {
+			if err := writer.Write([]string{a.Action, a.CommonName, a.Email, a.Detail}); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case FormatTable, "":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		if report.DryRun {
+			fmt.Fprintln(tw, "DRY-RUN: planowane akcje, żadne zmiany nie zostały wprowadzone")
+		}
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(syncColumns, "\t")))
+		for _, a := range report.Actions {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", a.Action, a.CommonName, a.Email, a.Detail)
+		}
+		return tw.Flush()
+	}
+	return newError(ErrInvalidConfig, nil, "nieznany format raportu: %s", format)
+}
@@ -0,0 +1,313 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testVault to serwer HTTP udający Vault: logowanie AppRole, odczyt roli, wydawanie i odwoływanie certyfikatów z montowania pki
+type testVault struct {
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	caPEM  string
+
+	mutex   sync.Mutex
+	serial  int64
+	issued  []string // CN wydanych certyfikatów
+	revoked []string // numery seryjne odwołanych certyfikatów
+}
+
+func newTestVault(t *testing.T) (*testVault, *VaultClient) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(der)
+	tv := &testVault{caCert: caCert, caKey: key, caPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), serial: 100}
+
+	server := httptest.NewServer(http.HandlerFunc(tv.serveHTTP))
+	t.Cleanup(server.Close)
+
+	vc, err := NewVaultClient(VaultConfig{Address: server.URL, RoleID: "role", SecretID: "secret", PKIPath: "pki", Role: "client"}, testLogger())
+	if err != nil {
+		t.Fatalf("NewVaultClient: %v", err)
+	}
+	return tv, vc
+}
+
+func (tv *testVault) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	switch {
+	case r.URL.Path == "/v1/auth/approle/login":
+		writeVaultJSON(w, map[string]interface{}{"auth": map[string]interface{}{"client_token": "s.test-vault-token", "lease_duration": 3600}})
+	case r.URL.Path == "/v1/pki/roles/client":
+		writeVaultJSON(w, map[string]interface{}{"data": map[string]interface{}{"max_ttl": 10 * 365 * 24 * 3600}})
+	case r.URL.Path == "/v1/pki/issue/client":
+		commonName, _ := body["common_name"].(string)
+		data, err := tv.issue(commonName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeVaultJSON(w, map[string]interface{}{"data": data})
+	case r.URL.Path == "/v1/pki/revoke":
+		serial, _ := body["serial_number"].(string)
+		tv.mutex.Lock()
+		tv.revoked = append(tv.revoked, serial)
+		tv.mutex.Unlock()
+		writeVaultJSON(w, map[string]interface{}{"data": map[string]interface{}{"revocation_time": time.Now().Unix()}})
+	default:
+		http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+	}
+}
+
+// issue podpisuje certyfikat klienta testowym CA i zwraca dane w formacie odpowiedzi pki/issue
+func (tv *testVault) issue(commonName string) (map[string]interface{}, error) {
+	tv.mutex.Lock()
+	tv.serial++
+	serial := tv.serial
+	tv.issued = append(tv.issued, commonName)
+	tv.mutex.Unlock()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, tv.caCert, &key.PublicKey, tv.caKey)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"certificate":   string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		"private_key":   string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+		"ca_chain":      []string{tv.caPEM},
+		"serial_number": FormatSerial(template.SerialNumber),
+	}, nil
+}
+
+func writeVaultJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// newTestService tworzy serwis z bazą w katalogu tymczasowym, testowym Vault i wyłączoną wysyłką emaili
+func newTestService(t *testing.T) (*CertService, *CertificateDB, *testVault) {
+	t.Helper()
+	dir := t.TempDir()
+	tv, vc := newTestVault(t)
+	certDB := NewCertificateDB(filepath.Join(dir, "certificates.json"), testLogger())
+
+	templates, err := NewTemplateStore(os.DirFS("../templates"), "", DefaultLocale, "")
+	if err != nil {
+		t.Fatalf("NewTemplateStore: %v", err)
+	}
+	mailer, err := NewMailer(MailerConfig{Disabled: true}, templates, testLogger())
+	if err != nil {
+		t.Fatalf("NewMailer: %v", err)
+	}
+
+	service := NewCertService(certDB, vc, mailer, nil, ServiceConfig{OvpnTemplate: "%s%s%s", OutputDir: dir}, testLogger())
+	return service, certDB, tv
+}
+
+// addTestUser zapisuje w bazie aktywnego użytkownika z certyfikatem ważnym jeszcze rok
+func addTestUser(t *testing.T, certDB *CertificateDB, user UserCertificate) {
+	t.Helper()
+	now := time.Now()
+	user.CreatedAt, user.LastRenewed, user.ExpiresAt = now, now, now.Add(365*24*time.Hour)
+	if user.SerialNumber == "" {
+		user.SerialNumber = "aa:" + strings.ReplaceAll(user.CommonName, ".", "")
+	}
+	if err := certDB.AddOrUpdateUser(user); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func syncActions(report *SyncReport) map[string]string {
+	actions := make(map[string]string, len(report.Actions))
+	for _, action := range report.Actions {
+		actions[action.CommonName] = action.Action
+	}
+	return actions
+}
+
+func TestSyncUsersIssuesUpdatesAndRevokes(t *testing.T) {
+	service, certDB, tv := newTestService(t)
+	const source = "file:users.csv"
+	addTestUser(t, certDB, UserCertificate{CommonName: "alice", Email: "alice@old.example.com", Source: source})
+	addTestUser(t, certDB, UserCertificate{CommonName: "bob", Email: "bob@example.com", Source: source})
+	addTestUser(t, certDB, UserCertificate{CommonName: "carol", Email: "carol@example.com", Source: source, SerialNumber: "aa:01"})
+	// Użytkownicy dodani ręcznie i z innego źródła nie są odwoływani
+	addTestUser(t, certDB, UserCertificate{CommonName: "dave"})
+	addTestUser(t, certDB, UserCertificate{CommonName: "erin", Source: "ldap"})
+	// Odwołany użytkownik z listy dostaje nowy certyfikat
+	revokedAt := time.Now().Add(-time.Hour)
+	addTestUser(t, certDB, UserCertificate{CommonName: "gina", Source: source, RevokedAt: &revokedAt})
+
+	users := []DirectoryUser{
+		{CommonName: "alice", Email: "alice@example.com", Group: "dev"},
+		{CommonName: "bob", Email: "bob@example.com"},
+		{CommonName: "frank", TTL: "720h", Locale: "en"},
+		{CommonName: "gina"},
+	}
+	report, err := service.SyncUsers(users, SyncOptions{RevokeMissing: true, Source: source})
+	if err != nil {
+		t.Fatalf("SyncUsers: %v", err)
+	}
+
+	want := map[string]string{"alice": SyncUpdate, "bob": SyncUnchanged, "frank": SyncIssue, "gina": SyncIssue, "carol": SyncRevoke}
+	got := syncActions(report)
+	if len(got) != len(want) {
+		t.Errorf("actions = %v, want %v", got, want)
+	}
+	for commonName, action := range want {
+		if got[commonName] != action {
+			t.Errorf("%s: action %q, want %q", commonName, got[commonName], action)
+		}
+	}
+
+	alice, _ := certDB.GetUser("alice")
+	if alice.Email != "alice@example.com" || alice.Group != "dev" {
+		t.Errorf("alice = %+v, want updated email and group", alice)
+	}
+	frank, exists := certDB.GetUser("frank")
+	if !exists || frank.Source != source || frank.TTL != "720h" || frank.Locale != "en" {
+		t.Errorf("frank = %+v, want new user from %s", frank, source)
+	}
+	if _, err := os.Stat(service.clientConfigPath("frank")); err != nil {
+		t.Errorf("profile for frank: %v", err)
+	}
+	if gina, _ := certDB.GetUser("gina"); gina.IsRevoked() {
+		t.Errorf("gina is still revoked after reissue")
+	}
+	if carol, _ := certDB.GetUser("carol"); !carol.IsRevoked() {
+		t.Errorf("carol was not revoked")
+	}
+	for _, commonName := range []string{"dave", "erin"} {
+		if user, _ := certDB.GetUser(commonName); user.IsRevoked() {
+			t.Errorf("%s from another source was revoked", commonName)
+		}
+	}
+	if strings.Join(tv.revoked, ",") != "aa:01" {
+		t.Errorf("revoked in Vault: %v, want [aa:01]", tv.revoked)
+	}
+
+	// Zmiany trafiają do pliku bazy
+	saved, err := LoadCertificateDB(certDB.filePath, testLogger())
+	if err != nil {
+		t.Fatalf("LoadCertificateDB: %v", err)
+	}
+	if user, _ := saved.GetUser("alice"); user.Email != "alice@example.com" {
+		t.Errorf("saved alice email = %q", user.Email)
+	}
+}
+
+func TestSyncUsersKeepsMissingWithoutRevokeMissing(t *testing.T) {
+	service, certDB, tv := newTestService(t)
+	addTestUser(t, certDB, UserCertificate{CommonName: "alice", Source: "file:users.csv"})
+
+	report, err := service.SyncUsers([]DirectoryUser{{CommonName: "bob"}}, SyncOptions{Source: "file:users.csv"})
+	if err != nil {
+		t.Fatalf("SyncUsers: %v", err)
+	}
+	if got := syncActions(report); len(got) != 1 || got["bob"] != SyncIssue {
+		t.Errorf("actions = %v, want only issue for bob", got)
+	}
+	if len(tv.revoked) != 0 {
+		t.Errorf("revoked in Vault: %v", tv.revoked)
+	}
+}
+
+func TestSyncUsersRejectsEmptyList(t *testing.T) {
+	service, certDB, tv := newTestService(t)
+	addTestUser(t, certDB, UserCertificate{CommonName: "alice", Source: "ldap"})
+
+	_, err := service.SyncUsers(nil, SyncOptions{RevokeMissing: true, Source: "ldap"})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("err = %v, want ErrInvalidConfig", err)
+	}
+	if user, _ := certDB.GetUser("alice"); user.IsRevoked() || len(tv.revoked) != 0 {
+		t.Errorf("empty list revoked users")
+	}
+}
+
+func TestSyncUsersRevocationLimit(t *testing.T) {
+	service, certDB, tv := newTestService(t)
+	for _, commonName := range []string{"alice", "bob", "carol"} {
+		addTestUser(t, certDB, UserCertificate{CommonName: commonName, Source: "ldap"})
+	}
+	users := []DirectoryUser{{CommonName: "dave"}}
+	options := SyncOptions{RevokeMissing: true, Source: "ldap", MaxRevocations: 2}
+
+	if _, err := service.SyncUsers(users, options); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("err = %v, want ErrInvalidConfig", err)
+	}
+	// Przekroczenie limitu przerywa synchronizację przed jakąkolwiek zmianą
+	if len(tv.issued) != 0 || len(tv.revoked) != 0 {
+		t.Fatalf("issued %v, revoked %v before the limit check", tv.issued, tv.revoked)
+	}
+
+	options.Force = true
+	report, err := service.SyncUsers(users, options)
+	if err != nil {
+		t.Fatalf("SyncUsers with Force: %v", err)
+	}
+	if report.Count(SyncRevoke) != 3 || report.Count(SyncIssue) != 1 {
+		t.Errorf("actions = %v", syncActions(report))
+	}
+}
+
+// TestParseUsersTTL sprawdza, że kolumna ttl przyjmuje te same wartości co client issue --ttl (np. 365d)
+func TestParseUsersTTL(t *testing.T) {
+	users, err := parseUsersCSV(strings.NewReader("cn,ttl\nalice,365d\nbob,52w\ncarol,720h\n"))
+	if err != nil {
+		t.Fatalf("parseUsersCSV: %v", err)
+	}
+	if len(users) != 3 || users[0].TTL != "365d" || users[1].TTL != "52w" {
+		t.Errorf("users = %+v, want TTLs kept as given", users)
+	}
+	if _, err := parseUsersCSV(strings.NewReader("cn,ttl\nalice,365x\n")); err == nil {
+		t.Error("parseUsersCSV accepted ttl 365x")
+	}
+}
@@ -3,9 +3,15 @@ package internal
 import (
 	"context"
 	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"sort"
+	"strings"
 	"time"
 
 	vault "github.com/hashicorp/vault/api"
@@ -13,129 +19,432 @@ import (
 )
 
 type VaultClient struct {
-	client     *vault.Client
-	logger     *logrus.Logger
-	pkiPath    string
-	role       string
-	serverRole string
+	// base to klient zalogowany przez AppRole; client to jego kopia z przestrzenią nazw bieżącego montowania
+	base   *vault.Client
+	client *vault.Client
+	logger *logrus.Logger
+	// mountName i mount to montowanie PKI, na którym działa klient (pusta nazwa - montowanie domyślne);
+	// defaultMount i mounts są wspólne dla wszystkich widoków zwracanych przez Mount i ForIssuer
+	mountName    string
+	mount        PKIMount
+	defaultMount PKIMount
+	mounts       map[string]PKIMount
+	executor     *Executor
+	// maxTTL przechowuje odczytane limity TTL ról, aby nie pytać Vault przy każdym certyfikacie
+	maxTTL map[string]time.Duration
+}
+
+// DefaultMountName to nazwa montowania PKI z VAULT_PKI_PATH, VAULT_ROLE i VAULT_SERVER_ROLE
+const DefaultMountName = "default"
+
+// PKIMount to montowanie PKI w Vault z rolami certyfikatów klientów i serwerów.
+// Namespace to przestrzeń nazw Vault Enterprise (pełna ścieżka); pusta - przestrzeń nazw logowania (VAULT_NAMESPACE).
+type PKIMount struct {
+	Path       string `yaml:"path"`
+	Namespace  string `yaml:"namespace"`
+	ClientRole string `yaml:"client_role"`
+	ServerRole string `yaml:"server_role"`
+}
+
+// location zwraca ścieżkę montowania poprzedzoną przestrzenią nazw (np. team-a/pki-site-b)
+func (m PKIMount) location() string {
+	if m.Namespace == "" {
+		return m.Path
+	}
+	return strings.Trim(m.Namespace, "/") + "/" + m.Path
+}
+
+// Issuer zapisuje w bazie, które montowanie PKI, rola i przestrzeń nazw wydały certyfikat -
+// odnowienia i odwołania trafiają do tego samego montowania. Brak wpisu oznacza montowanie domyślne.
+type Issuer struct {
+	// Mount to nazwa montowania z pliku konfiguracji; pusta dla montowania domyślnego
+	Mount     string `json:"mount,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Path      string `json:"path"`
+	Role      string `json:"role,omitempty"`
+	// AuthorityKeyID to AuthorityKeyId certyfikatu (hex) - wskazuje wystawcę montowania, który go podpisał (check)
+	AuthorityKeyID string `json:"authority_key_id,omitempty"`
+}
+
+// String opisuje wystawcę w raportach, np. "site-b (team-a/pki-site-b, role ovpn-client)"
+func (i *Issuer) String() string {
+	location := PKIMount{Path: i.Path, Namespace: i.Namespace}.location()
+	if i.Role == "" {
+		return fmt.Sprintf("%s (%s)", firstNonEmpty(i.Mount, DefaultMountName), location)
+	}
+	return fmt.Sprintf("%s (%s, role %s)", firstNonEmpty(i.Mount, DefaultMountName), location, i.Role)
 }
 
 type CertificateInfo struct {
-	Certificate   string
-	PrivateKey    string
-	CAChain       string
-	SerialNumber  string
-	ExpiresAt     time.Time
-	CommonName    string
+	Certificate  string
+	PrivateKey   string
+	CAChain      string
+	SerialNumber string
+	ExpiresAt    time.Time
+	CommonName   string
+	// Pola uzupełniane przez GetCertificateInfo
+	NotBefore time.Time
+	IsCA      bool
+	RevokedAt time.Time // zerowa data, jeśli certyfikat nie został odwołany
+	// Issuer to montowanie PKI, które wydało certyfikat
+	Issuer *Issuer
+}
+
+// IsRevoked sprawdza, czy Vault zwrócił datę odwołania certyfikatu
+func (ci *CertificateInfo) IsRevoked() bool {
+	return !ci.RevokedAt.IsZero()
+}
+
+// VaultConfig przechowuje dane dostępowe do Vault
+type VaultConfig struct {
+	Address  string
+	RoleID   string
+	SecretID string
+	// Namespace to przestrzeń nazw Vault Enterprise, w której następuje logowanie AppRole
+	Namespace  string
+	PKIPath    string
+	Role       string
+	ServerRole string
+	// Mounts to dodatkowe nazwane montowania PKI z pliku konfiguracji (sekcja pki.mounts)
+	Mounts map[string]PKIMount
+}
+
+// LoadVaultConfigFromEnv wczytuje konfigurację Vault ze zmiennych środowiskowych i sprawdza dodatkowe
+// montowania PKI z pliku konfiguracji; montowanie bez ról dziedziczy VAULT_ROLE i VAULT_SERVER_ROLE
+func LoadVaultConfigFromEnv(mounts map[string]PKIMount) (VaultConfig, error) {
+	config := VaultConfig{
+		Address:    os.Getenv("VAULT_ADDR"),
+		RoleID:     os.Getenv("VAULT_ROLE_ID"),
+		SecretID:   os.Getenv("VAULT_SECRET_ID"),
+		Namespace:  os.Getenv("VAULT_NAMESPACE"),
+		PKIPath:    os.Getenv("VAULT_PKI_PATH"),
+		Role:       os.Getenv("VAULT_ROLE"),
+		ServerRole: os.Getenv("VAULT_SERVER_ROLE"),
+	}
+	if config.ServerRole == "" {
+		config.ServerRole = "ovpn-server" // Domyślna rola serwera
+	}
+
+	if config.Address == "" || config.RoleID == "" || config.SecretID == "" || config.PKIPath == "" || config.Role == "" {
+		return config, newError(ErrInvalidConfig, nil, "brak wymaganej konfiguracji Vault. Sprawdź zmienne: VAULT_ADDR, VAULT_ROLE_ID, VAULT_SECRET_ID, VAULT_PKI_PATH, VAULT_ROLE")
+	}
+
+	config.Mounts = make(map[string]PKIMount, len(mounts))
+	for name, mount := range mounts {
+		if name == DefaultMountName || !deviceNamePattern.MatchString(name) {
+			return config, newError(ErrInvalidConfig, nil, "nieprawidłowa nazwa montowania PKI %q (małe litery, cyfry i myślniki; %q jest zarezerwowane)", name, DefaultMountName)
+		}
+		if mount.Path == "" {
+			return config, newError(ErrInvalidConfig, nil, "montowanie PKI %q nie ma ścieżki (path)", name)
+		}
+		mount.Path = strings.Trim(mount.Path, "/")
+		mount.ClientRole = firstNonEmpty(mount.ClientRole, config.Role)
+		mount.ServerRole = firstNonEmpty(mount.ServerRole, config.ServerRole)
+		config.Mounts[name] = mount
+	}
+	return config, nil
+}
+
+// DefaultMount zwraca montowanie PKI ze zmiennych VAULT_PKI_PATH, VAULT_ROLE i VAULT_SERVER_ROLE
+func (c VaultConfig) DefaultMount() PKIMount {
+	return PKIMount{Path: c.PKIPath, ClientRole: c.Role, ServerRole: c.ServerRole}
 }
 
-func NewVaultClient(address, roleID, secretID, pkiPath, role, serverRole string, logger *logrus.Logger) (*VaultClient, error) {
-	config := vault.DefaultConfig()
-	config.Address = address
+// NewVaultClient loguje się do Vault przez AppRole (w przestrzeni nazw config.Namespace, jeśli podano);
+// jeden token obsługuje wszystkie montowania PKI z konfiguracji
+func NewVaultClient(config VaultConfig, logger *logrus.Logger) (*VaultClient, error) {
+	clientConfig := vault.DefaultConfig()
+	clientConfig.Address = config.Address
 
-	client, err := vault.NewClient(config)
+	client, err := vault.NewClient(clientConfig)
 	if err != nil {
-		return nil, fmt.Errorf("nie udało się utworzyć klienta Vault: %w", err)
+		return nil, newError(ErrInvalidConfig, err, "nie udało się utworzyć klienta Vault")
+	}
+	if config.Namespace != "" {
+		client.SetNamespace(config.Namespace)
 	}
 
 	// Autoryzacja przez AppRole
 	logger.Info("Autoryzacja do Vault przez AppRole...")
 	data := map[string]interface{}{
-		"role_id":   roleID,
-		"secret_id": secretID,
+		"role_id":   config.RoleID,
+		"secret_id": config.SecretID,
 	}
 
+	start := time.Now()
 	resp, err := client.Logical().Write("auth/approle/login", data)
+	observeCall(logger, SystemVault, "login", start, err)
 	if err != nil {
-		return nil, fmt.Errorf("nie udało się zalogować przez AppRole: %w", err)
+		// AppRole odpowiada 400 na nieprawidłowy role_id lub secret_id; błędy sieci, TLS i 5xx nie są błędami uwierzytelniania
+		var respErr *vault.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == 400 {
+			return nil, newError(ErrVaultAuth, err, "nie udało się zalogować przez AppRole")
+		}
+		return nil, vaultError(err, "nie udało się zalogować przez AppRole")
 	}
 
 	if resp == nil || resp.Auth == nil || resp.Auth.ClientToken == "" {
-		return nil, fmt.Errorf("nie otrzymano tokena z Vault")
+		return nil, newError(ErrVaultAuth, nil, "nie otrzymano tokena z Vault")
 	}
 
 	client.SetToken(resp.Auth.ClientToken)
+	RedactSecret(resp.Auth.ClientToken)
 	logger.Infof("Pomyślnie zalogowano do Vault (token expires in: %ds)", resp.Auth.LeaseDuration)
 
 	return &VaultClient{
-		client:     client,
-		logger:     logger,
-		pkiPath:    pkiPath,
-		role:       role,
-		serverRole: serverRole,
+		base:         client,
+		client:       client,
+		logger:       logger,
+		mount:        config.DefaultMount(),
+		defaultMount: config.DefaultMount(),
+		mounts:       config.Mounts,
+		maxTTL:       make(map[string]time.Duration),
 	}, nil
 }
 
+// withMount zwraca widok klienta działający na podanym montowaniu; widok współdzieli token, executor i pamięć limitów TTL
+func (vc *VaultClient) withMount(name string, mount PKIMount) *VaultClient {
+	view := *vc
+	view.mountName = name
+	view.mount = mount
+	view.client = vc.base
+	if mount.Namespace != "" {
+		view.client = vc.base.WithNamespace(mount.Namespace)
+	}
+	return &view
+}
+
+// Mount zwraca klienta nazwanego montowania PKI z pliku konfiguracji; pusta nazwa lub "default" - montowanie domyślne
+func (vc *VaultClient) Mount(name string) (*VaultClient, error) {
+	if name == "" || name == DefaultMountName {
+		return vc.withMount("", vc.defaultMount), nil
+	}
+	mount, ok := vc.mounts[name]
+	if !ok {
+		names := append([]string{DefaultMountName}, sortedMountNames(vc.mounts)...)
+		return nil, newError(ErrInvalidConfig, nil, "nieznane montowanie PKI %q (dostępne: %s)", name, strings.Join(names, ", "))
+	}
+	return vc.withMount(name, mount), nil
+}
+
+// ForIssuer zwraca klienta montowania, które wydało certyfikat. Ścieżka, przestrzeń nazw i rola zapisane w bazie
+// mają pierwszeństwo przed konfiguracją, więc zmiana pliku konfiguracji nie przenosi istniejących certyfikatów.
+// Rola zapisana przy certyfikacie klienta jest też rolą odnowienia certyfikatu serwera - rekord ma tylko jeden rodzaj certyfikatu.
+func (vc *VaultClient) ForIssuer(issuer *Issuer) *VaultClient {
+	if issuer == nil {
+		return vc.withMount("", vc.defaultMount)
+	}
+	mount, ok := vc.mounts[issuer.Mount]
+	if !ok {
+		mount = vc.defaultMount
+	}
+	mount.Path = firstNonEmpty(issuer.Path, mount.Path)
+	mount.Namespace = issuer.Namespace
+	if issuer.Role != "" {
+		mount.ClientRole, mount.ServerRole = issuer.Role, issuer.Role
+	}
+	return vc.withMount(issuer.Mount, mount)
+}
+
+// Mounts zwraca klientów wszystkich skonfigurowanych montowań PKI: najpierw domyślne, potem nazwane w kolejności alfabetycznej
+func (vc *VaultClient) Mounts() []*VaultClient {
+	clients := []*VaultClient{vc.withMount("", vc.defaultMount)}
+	for _, name := range sortedMountNames(vc.mounts) {
+		clients = append(clients, vc.withMount(name, vc.mounts[name]))
+	}
+	return clients
+}
+
+// MountName zwraca nazwę montowania klienta ("default" dla montowania domyślnego)
+func (vc *VaultClient) MountName() string {
+	return firstNonEmpty(vc.mountName, DefaultMountName)
+}
+
+// Location zwraca ścieżkę montowania klienta poprzedzoną przestrzenią nazw
+func (vc *VaultClient) Location() string {
+	return vc.mount.location()
+}
+
+// log zwraca logger z polem mount - operacje zapisu są widoczne w logach razem z montowaniem, którego dotyczą
+func (vc *VaultClient) log() *logrus.Entry {
+	return vc.logger.WithField(FieldMount, vc.MountName())
+}
+
+// issuer zwraca wpis wystawcy zapisywany przy certyfikacie wydanym przez ten klient z podaną rolą
+func (vc *VaultClient) issuer(role string) *Issuer {
+	return &Issuer{Mount: vc.mountName, Namespace: vc.mount.Namespace, Path: vc.mount.Path, Role: role}
+}
+
+// issuerOf zwraca wpis wystawcy certyfikatu cert razem z jego AuthorityKeyId
+func (vc *VaultClient) issuerOf(role string, cert *x509.Certificate) *Issuer {
+	issuer := vc.issuer(role)
+	issuer.AuthorityKeyID = hex.EncodeToString(cert.AuthorityKeyId)
+	return issuer
+}
+
+// sortedMountNames zwraca nazwy montowań w kolejności alfabetycznej
+func sortedMountNames(mounts map[string]PKIMount) []string {
+	names := make([]string, 0, len(mounts))
+	for name := range mounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetExecutor kieruje operacje zapisu w Vault (wydanie, odwołanie) przez executor trybu dry-run
+func (vc *VaultClient) SetExecutor(executor *Executor) {
+	vc.executor = executor
+}
+
 // GetCertificateInfo pobiera informacje o certyfikacie o podanym serial number
 func (vc *VaultClient) GetCertificateInfo(serialNumber string) (*CertificateInfo, error) {
-	path := fmt.Sprintf("%s/cert/%s", vc.pkiPath, serialNumber)
+	path := fmt.Sprintf("%s/cert/%s", vc.mount.Path, serialNumber)
 
+	start := time.Now()
 	secret, err := vc.client.Logical().Read(path)
+	observeCall(vc.logger, SystemVault, "read_cert", start, err)
 	if err != nil {
-		return nil, fmt.Errorf("nie udało się pobrać certyfikatu: %w", err)
+		return nil, vaultError(err, "nie udało się pobrać certyfikatu")
 	}
 
 	if secret == nil || secret.Data == nil {
-		return nil, fmt.Errorf("certyfikat o serial number %s nie został znaleziony", serialNumber)
+		return nil, newError(ErrNotFound, nil, "certyfikat o serial number %s nie został znaleziony", serialNumber)
 	}
 
 	certPEM, ok := secret.Data["certificate"].(string)
 	if !ok {
-		return nil, fmt.Errorf("nieprawidłowy format certyfikatu")
+		return nil, newError(ErrVault, nil, "nieprawidłowy format certyfikatu")
 	}
 
 	// Parsowanie certyfikatu, aby uzyskać datę wygaśnięcia
 	block, _ := pem.Decode([]byte(certPEM))
 	if block == nil {
-		return nil, fmt.Errorf("nie udało się zdekodować certyfikatu PEM")
+		return nil, newError(ErrVault, nil, "nie udało się zdekodować certyfikatu PEM")
 	}
 
 	cert, err := x509.ParseCertificate(block.Bytes)
 	if err != nil {
-		return nil, fmt.Errorf("nie udało się sparsować certyfikatu: %w", err)
+		return nil, newError(ErrVault, err, "nie udało się sparsować certyfikatu")
 	}
 
-	return &CertificateInfo{
+	info := &CertificateInfo{
 		Certificate:  certPEM,
 		SerialNumber: serialNumber,
 		ExpiresAt:    cert.NotAfter,
 		CommonName:   cert.Subject.CommonName,
-	}, nil
+		NotBefore:    cert.NotBefore,
+		IsCA:         cert.IsCA,
+		Issuer:       vc.issuerOf("", cert),
+	}
+
+	// revocation_time to znacznik czasu Unix; 0 oznacza certyfikat nieodwołany
+	if revokedAt := unixTime(secret.Data["revocation_time"]); revokedAt > 0 {
+		info.RevokedAt = time.Unix(revokedAt, 0)
+	}
+
+	return info, nil
+}
+
+// ListCertificateSerials zwraca numery seryjne wszystkich certyfikatów wydanych przez PKI (LIST <pki>/certs)
+func (vc *VaultClient) ListCertificateSerials() ([]string, error) {
+	path := fmt.Sprintf("%s/certs", vc.mount.Path)
+
+	start := time.Now()
+	secret, err := vc.client.Logical().List(path)
+	observeCall(vc.logger, SystemVault, "list_certs", start, err)
+	if err != nil {
+		return nil, vaultError(err, "nie udało się pobrać listy certyfikatów")
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil
+	}
+
+	keys, ok := secret.Data["keys"].([]interface{})
+	if !ok {
+		return nil, newError(ErrVault, nil, "nieprawidłowy format listy certyfikatów w odpowiedzi")
+	}
+
+	serials := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if serial, ok := key.(string); ok {
+			serials = append(serials, serial)
+		}
+	}
+	return serials, nil
+}
+
+// NormalizeSerial sprowadza numer seryjny do postaci używanej przez Vault przy wydawaniu (aa:bb:cc)
+func NormalizeSerial(serial string) string {
+	return strings.ToLower(strings.ReplaceAll(serial, "-", ":"))
+}
+
+// unixTime odczytuje liczbę z odpowiedzi Vault (json.Number lub liczba)
+func unixTime(value interface{}) int64 {
+	switch v := value.(type) {
+	case json.Number:
+		n, _ := v.Int64()
+		return n
+	case float64:
+		return int64(v)
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	}
+	return 0
 }
 
 // IssueCertificate generuje nowy certyfikat w Vault
 func (vc *VaultClient) IssueCertificate(commonName string, ttl string) (*CertificateInfo, error) {
-	path := fmt.Sprintf("%s/issue/%s", vc.pkiPath, vc.role)
+	path := fmt.Sprintf("%s/issue/%s", vc.mount.Path, vc.mount.ClientRole)
 
 	data := map[string]interface{}{
 		"common_name": commonName,
 		"ttl":         ttl,
 	}
 
-	vc.logger.Infof("Generowanie nowego certyfikatu dla %s w Vault", commonName)
+	if vc.executor.Skip(PlanStep{Target: PlanVault, Operation: "issue", Detail: fmt.Sprintf("%s (%s, rola %s, ttl %s)", commonName, vc.Location(), vc.mount.ClientRole, ttl)}) {
+		certificate, privateKey, expiresAt, err := plannedCertificate(commonName, ttl)
+		if err != nil {
+			return nil, err
+		}
+		return &CertificateInfo{
+			Certificate:  certificate,
+			PrivateKey:   privateKey,
+			SerialNumber: PlannedSerial,
+			ExpiresAt:    expiresAt,
+			CommonName:   commonName,
+			Issuer:       vc.issuer(vc.mount.ClientRole),
+		}, nil
+	}
 
+	vc.log().WithField(FieldCommonName, commonName).Infof("Generowanie nowego certyfikatu dla %s w Vault", commonName)
+
+	start := time.Now()
 	secret, err := vc.client.Logical().Write(path, data)
+	observeCall(vc.logger, SystemVault, "issue", start, err)
 	if err != nil {
-		return nil, fmt.Errorf("nie udało się wygenerować certyfikatu: %w", err)
+		return nil, vaultError(err, "nie udało się wygenerować certyfikatu")
 	}
 
 	if secret == nil || secret.Data == nil {
-		return nil, fmt.Errorf("Vault nie zwrócił danych certyfikatu")
+		return nil, newError(ErrVault, nil, "Vault nie zwrócił danych certyfikatu")
 	}
 
 	certificate, ok := secret.Data["certificate"].(string)
 	if !ok {
-		return nil, fmt.Errorf("nieprawidłowy format certyfikatu w odpowiedzi")
+		return nil, newError(ErrVault, nil, "nieprawidłowy format certyfikatu w odpowiedzi")
 	}
 
 	privateKey, ok := secret.Data["private_key"].(string)
 	if !ok {
-		return nil, fmt.Errorf("nieprawidłowy format klucza prywatnego w odpowiedzi")
+		return nil, newError(ErrVault, nil, "nieprawidłowy format klucza prywatnego w odpowiedzi")
 	}
 
 	caChain, ok := secret.Data["ca_chain"].([]interface{})
 	if !ok {
-		return nil, fmt.Errorf("nieprawidłowy format ca_chain w odpowiedzi")
+		return nil, newError(ErrVault, nil, "nieprawidłowy format ca_chain w odpowiedzi")
 	}
 
 	// Konwersja ca_chain do stringa
@@ -148,21 +457,21 @@ func (vc *VaultClient) IssueCertificate(commonName string, ttl string) (*Certifi
 
 	serialNumber, ok := secret.Data["serial_number"].(string)
 	if !ok {
-		return nil, fmt.Errorf("nieprawidłowy format serial_number w odpowiedzi")
+		return nil, newError(ErrVault, nil, "nieprawidłowy format serial_number w odpowiedzi")
 	}
 
 	// Parsowanie certyfikatu dla daty wygaśnięcia
 	block, _ := pem.Decode([]byte(certificate))
 	if block == nil {
-		return nil, fmt.Errorf("nie udało się zdekodować certyfikatu PEM")
+		return nil, newError(ErrVault, nil, "nie udało się zdekodować certyfikatu PEM")
 	}
 
 	cert, err := x509.ParseCertificate(block.Bytes)
 	if err != nil {
-		return nil, fmt.Errorf("nie udało się sparsować certyfikatu: %w", err)
+		return nil, newError(ErrVault, err, "nie udało się sparsować certyfikatu")
 	}
 
-	vc.logger.Infof("Nowy certyfikat wygenerowany: serial=%s, expires=%s", serialNumber, cert.NotAfter.Format("2006-01-02"))
+	vc.log().WithFields(logrus.Fields{FieldCommonName: commonName, FieldSerial: serialNumber}).Infof("Nowy certyfikat wygenerowany: serial=%s, expires=%s", serialNumber, cert.NotAfter.Format("2006-01-02"))
 
 	return &CertificateInfo{
 		Certificate:  certificate,
@@ -171,25 +480,73 @@ func (vc *VaultClient) IssueCertificate(commonName string, ttl string) (*Certifi
 		SerialNumber: serialNumber,
 		ExpiresAt:    cert.NotAfter,
 		CommonName:   commonName,
+		Issuer:       vc.issuerOf(vc.mount.ClientRole, cert),
 	}, nil
 }
 
+// RoleMaxTTL zwraca maksymalny TTL roli klienta lub serwera. Rola bez max_ttl dziedziczy limit
+// montowania PKI (max_lease_ttl); 0 oznacza, że Vault nie zwrócił żadnego limitu.
+func (vc *VaultClient) RoleMaxTTL(server bool) (time.Duration, error) {
+	role := vc.mount.ClientRole
+	if server {
+		role = vc.mount.ServerRole
+	}
+	// Ta sama nazwa roli może mieć różne limity w różnych montowaniach
+	key := vc.Location() + "/" + role
+	if maxTTL, ok := vc.maxTTL[key]; ok {
+		return maxTTL, nil
+	}
+
+	start := time.Now()
+	secret, err := vc.client.Logical().Read(fmt.Sprintf("%s/roles/%s", vc.mount.Path, role))
+	observeCall(vc.logger, SystemVault, "read_role", start, err)
+	if err != nil {
+		return 0, vaultError(err, "nie udało się odczytać roli %s", role)
+	}
+	if secret == nil || secret.Data == nil {
+		return 0, newError(ErrNotFound, nil, "rola %s nie istnieje w %s", role, vc.Location())
+	}
+	seconds := unixTime(secret.Data["max_ttl"])
+
+	if seconds == 0 {
+		start = time.Now()
+		tune, err := vc.client.Logical().Read(fmt.Sprintf("sys/mounts/%s/tune", vc.mount.Path))
+		observeCall(vc.logger, SystemVault, "read_mount", start, err)
+		if err != nil {
+			return 0, vaultError(err, "nie udało się odczytać ustawień montowania %s", vc.Location())
+		}
+		if tune != nil {
+			seconds = unixTime(tune.Data["max_lease_ttl"])
+		}
+	}
+
+	maxTTL := time.Duration(seconds) * time.Second
+	vc.maxTTL[key] = maxTTL
+	return maxTTL, nil
+}
+
 // RevokeCertificate odwołuje certyfikat w Vault
 func (vc *VaultClient) RevokeCertificate(serialNumber string) error {
-	path := fmt.Sprintf("%s/revoke", vc.pkiPath)
+	path := fmt.Sprintf("%s/revoke", vc.mount.Path)
 
 	data := map[string]interface{}{
 		"serial_number": serialNumber,
 	}
 
-	vc.logger.Infof("Odwoływanie certyfikatu %s w Vault", serialNumber)
+	if vc.executor.Skip(PlanStep{Target: PlanVault, Operation: "revoke", Detail: fmt.Sprintf("%s (%s)", serialNumber, vc.Location())}) {
+		return nil
+	}
 
+	vc.log().WithField(FieldSerial, serialNumber).Infof("Odwoływanie certyfikatu %s w Vault", serialNumber)
+
+	start := time.Now()
 	_, err := vc.client.Logical().Write(path, data)
+	observeCall(vc.logger, SystemVault, "revoke", start, err)
 	if err != nil {
-		return fmt.Errorf("nie udało się odwołać certyfikatu: %w", err)
+		return vaultError(err, "nie udało się odwołać certyfikatu")
 	}
 
-	vc.logger.Infof("Certyfikat %s został odwołany", serialNumber)
+	vc.log().WithField(FieldSerial, serialNumber).Infof("Certyfikat %s został odwołany", serialNumber)
 	return nil
 }
 
@@ -201,70 +558,174 @@ func (vc *VaultClient) CheckCertificateExpiration(certInfo *CertificateInfo, day
 	return needsRenewal, daysUntilExpiry
 }
 
-// RenewCertificate odwołuje stary certyfikat i generuje nowy
-func (vc *VaultClient) RenewCertificate(oldSerialNumber, commonName, ttl string) (*CertificateInfo, error) {
-	// Odwołaj stary certyfikat
-	if err := vc.RevokeCertificate(oldSerialNumber); err != nil {
-		vc.logger.Warnf("Nie udało się odwołać starego certyfikatu: %v", err)
-		// Kontynuujemy mimo błędu
-	}
-
-	// Wygeneruj nowy certyfikat
-	return vc.IssueCertificate(commonName, ttl)
-}
-
 // GetCACertificate pobiera certyfikat CA
 func (vc *VaultClient) GetCACertificate() (string, error) {
-	path := fmt.Sprintf("%s/ca/pem", vc.pkiPath)
+	path := fmt.Sprintf("%s/ca/pem", vc.mount.Path)
 	ctx := context.Background()
 
+	start := time.Now()
 	secret, err := vc.client.Logical().ReadRawWithContext(ctx, path)
+	observeCall(vc.logger, SystemVault, "read_ca", start, err)
 	if err != nil {
-		return "", fmt.Errorf("nie udało się pobrać certyfikatu CA: %w", err)
+		return "", vaultError(err, "nie udało się pobrać certyfikatu CA")
 	}
 	defer secret.Body.Close()
 
 	caCert, err := io.ReadAll(secret.Body)
 	if err != nil {
-		return "", fmt.Errorf("nie udało się odczytać certyfikatu CA: %w", err)
+		return "", newError(ErrVault, err, "nie udało się odczytać certyfikatu CA")
 	}
 
 	return string(caCert), nil
 }
 
+// CAIssuer to wystawca montowania PKI (certyfikat CA z kluczem w Vault) odczytany z <pki>/issuer/<id>
+type CAIssuer struct {
+	ID          string
+	Name        string
+	Certificate string
+	// CAChain to łańcuch wystawcy w postaci PEM (od certyfikatu wystawcy do głównego CA)
+	CAChain string
+	// Default oznacza wystawcę domyślnego (<pki>/config/issuers) - to on podpisuje nowe certyfikaty ról bez issuer_ref
+	Default  bool
+	NotAfter time.Time
+	// SubjectKeyID pozwala dopasować wystawcę do podpisanych przez niego certyfikatów (AuthorityKeyId)
+	SubjectKeyID []byte
+}
+
+// Label zwraca nazwę wystawcy, a bez nazwy jego identyfikator
+func (i CAIssuer) Label() string {
+	return firstNonEmpty(i.Name, i.ID)
+}
+
+// ListIssuers zwraca wystawców montowania PKI (LIST <pki>/issuers) z oznaczeniem wystawcy domyślnego
+func (vc *VaultClient) ListIssuers() ([]CAIssuer, error) {
+	start := time.Now()
+	secret, err := vc.client.Logical().List(fmt.Sprintf("%s/issuers", vc.mount.Path))
+	observeCall(vc.logger, SystemVault, "list_issuers", start, err)
+	if err != nil {
+		return nil, vaultError(err, "nie udało się pobrać listy wystawców %s", vc.Location())
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, newError(ErrNotFound, nil, "montowanie %s nie ma wystawców (Vault starszy niż 1.11?)", vc.Location())
+	}
+	keys, ok := secret.Data["keys"].([]interface{})
+	if !ok {
+		return nil, newError(ErrVault, nil, "nieprawidłowy format listy wystawców w odpowiedzi")
+	}
+
+	start = time.Now()
+	config, err := vc.client.Logical().Read(fmt.Sprintf("%s/config/issuers", vc.mount.Path))
+	observeCall(vc.logger, SystemVault, "read_issuers_config", start, err)
+	if err != nil {
+		return nil, vaultError(err, "nie udało się odczytać domyślnego wystawcy %s", vc.Location())
+	}
+	var defaultID string
+	if config != nil && config.Data != nil {
+		defaultID, _ = config.Data["default"].(string)
+	}
+
+	issuers := make([]CAIssuer, 0, len(keys))
+	for _, key := range keys {
+		id, ok := key.(string)
+		if !ok {
+			continue
+		}
+		issuer, err := vc.readIssuer(id)
+		if err != nil {
+			return nil, err
+		}
+		issuer.Default = id == defaultID
+		issuers = append(issuers, *issuer)
+	}
+	return issuers, nil
+}
+
+// readIssuer odczytuje certyfikat i łańcuch jednego wystawcy (GET <pki>/issuer/<id>)
+func (vc *VaultClient) readIssuer(id string) (*CAIssuer, error) {
+	start := time.Now()
+	secret, err := vc.client.Logical().Read(fmt.Sprintf("%s/issuer/%s", vc.mount.Path, id))
+	observeCall(vc.logger, SystemVault, "read_issuer", start, err)
+	if err != nil {
+		return nil, vaultError(err, "nie udało się odczytać wystawcy %s", id)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, newError(ErrNotFound, nil, "wystawca %s nie został znaleziony", id)
+	}
+
+	certificate, ok := secret.Data["certificate"].(string)
+	if !ok {
+		return nil, newError(ErrVault, nil, "nieprawidłowy format certyfikatu wystawcy %s w odpowiedzi", id)
+	}
+	cert, err := ParseCertificatePEM(certificate)
+	if err != nil {
+		return nil, newError(ErrVault, err, "nie udało się odczytać certyfikatu wystawcy %s", id)
+	}
+	issuer := &CAIssuer{ID: id, Certificate: certificate, NotAfter: cert.NotAfter, SubjectKeyID: cert.SubjectKeyId}
+	issuer.Name, _ = secret.Data["issuer_name"].(string)
+	if chain, ok := secret.Data["ca_chain"].([]interface{}); ok {
+		for _, ca := range chain {
+			if caStr, ok := ca.(string); ok {
+				issuer.CAChain += strings.TrimSpace(caStr) + "\n"
+			}
+		}
+	}
+	return issuer, nil
+}
+
 // IssueServerCertificate generuje nowy certyfikat serwera
 func (vc *VaultClient) IssueServerCertificate(commonName string, ttl string) (*ServerCertificate, error) {
-	path := fmt.Sprintf("%s/issue/%s", vc.pkiPath, vc.serverRole)
+	path := fmt.Sprintf("%s/issue/%s", vc.mount.Path, vc.mount.ServerRole)
 
 	data := map[string]interface{}{
 		"common_name": commonName,
 		"ttl":         ttl,
 	}
 
-	vc.logger.Infof("Generowanie nowego certyfikatu serwera dla %s w Vault", commonName)
-
+	if vc.executor.Skip(PlanStep{Target: PlanVault, Operation: "issue", Detail: fmt.Sprintf("%s (%s, rola %s, ttl %s)", commonName, vc.Location(), vc.mount.ServerRole, ttl)}) {
+		certificate, privateKey, expiresAt, err := plannedCertificate(commonName, ttl)
+		if err != nil {
+			return nil, err
+		}
+		return &ServerCertificate{
+			CommonName:   commonName,
+			SerialNumber: PlannedSerial,
+			Certificate:  certificate,
+			PrivateKey:   privateKey,
+			CreatedAt:    time.Now(),
+			LastRenewed:  time.Now(),
+			ExpiresAt:    expiresAt,
+			TTL:          ttl,
+			Issuer:       vc.issuer(vc.mount.ServerRole),
+		}, nil
+	}
+
+	vc.log().WithField(FieldCommonName, commonName).Infof("Generowanie nowego certyfikatu serwera dla %s w Vault", commonName)
+
+	start := time.Now()
 	secret, err := vc.client.Logical().Write(path, data)
+	observeCall(vc.logger, SystemVault, "issue", start, err)
 	if err != nil {
-		return nil, fmt.Errorf("nie udało się wygenerować certyfikatu serwera: %w", err)
+		return nil, vaultError(err, "nie udało się wygenerować certyfikatu serwera")
 	}
 
 	if secret == nil || secret.Data == nil {
-		return nil, fmt.Errorf("Vault nie zwrócił danych certyfikatu serwera")
+		return nil, newError(ErrVault, nil, "Vault nie zwrócił danych certyfikatu serwera")
 	}
 
 	certificate, ok := secret.Data["certificate"].(string)
 	if !ok {
-		return nil, fmt.Errorf("nieprawidłowy format certyfikatu serwera w odpowiedzi")
+		return nil, newError(ErrVault, nil, "nieprawidłowy format certyfikatu serwera w odpowiedzi")
 	}
 
 	privateKey, ok := secret.Data["private_key"].(string)
 	if !ok {
-		return nil, fmt.Errorf("nieprawidłowy format klucza prywatnego serwera w odpowiedzi")
+		return nil, newError(ErrVault, nil, "nieprawidłowy format klucza prywatnego serwera w odpowiedzi")
 	}
 
 	caChain, ok := secret.Data["ca_chain"].([]interface{})
 	if !ok {
-		return nil, fmt.Errorf("nieprawidłowy format ca_chain w odpowiedzi")
+		return nil, newError(ErrVault, nil, "nieprawidłowy format ca_chain w odpowiedzi")
 	}
 
 	// Konwersja ca_chain do stringa
@@ -277,62 +738,65 @@ func (vc *VaultClient) IssueServerCertificate(commonName string, ttl string) (*S
 
 	serialNumber, ok := secret.Data["serial_number"].(string)
 	if !ok {
-		return nil, fmt.Errorf("nieprawidłowy format numeru seryjnego w odpowiedzi")
+		return nil, newError(ErrVault, nil, "nieprawidłowy format numeru seryjnego w odpowiedzi")
 	}
 
 	// Parsowanie certyfikatu, aby uzyskać datę wygaśnięcia
 	block, _ := pem.Decode([]byte(certificate))
 	if block == nil {
-		return nil, fmt.Errorf("nie udało się zdekodować certyfikatu serwera PEM")
+		return nil, newError(ErrVault, nil, "nie udało się zdekodować certyfikatu serwera PEM")
 	}
 
 	cert, err := x509.ParseCertificate(block.Bytes)
 	if err != nil {
-		return nil, fmt.Errorf("nie udało się sparsować certyfikatu serwera: %w", err)
+		return nil, newError(ErrVault, err, "nie udało się sparsować certyfikatu serwera")
 	}
 
-	vc.logger.Infof("Nowy certyfikat serwera wygenerowany: serial=%s, expires=%s", serialNumber, cert.NotAfter.Format("2006-01-02"))
+	vc.log().WithFields(logrus.Fields{FieldCommonName: commonName, FieldSerial: serialNumber}).Infof("Nowy certyfikat serwera wygenerowany: serial=%s, expires=%s", serialNumber, cert.NotAfter.Format("2006-01-02"))
 
 	return &ServerCertificate{
 		CommonName:   commonName,
-		SerialNumber:  serialNumber,
+		SerialNumber: serialNumber,
 		Certificate:  certificate,
 		PrivateKey:   privateKey,
-		IssuingCA:   caChainStr,
+		IssuingCA:    caChainStr,
 		CreatedAt:    time.Now(),
 		LastRenewed:  time.Now(),
 		ExpiresAt:    cert.NotAfter,
 		TTL:          ttl,
+		Issuer:       vc.issuerOf(vc.mount.ServerRole, cert),
 	}, nil
 }
 
 // GetServerCertificate pobiera informacje o certyfikacie serwera z Vault
 func (vc *VaultClient) GetServerCertificate(serialNumber string) (*ServerCertificate, error) {
-	path := fmt.Sprintf("%s/cert/%s", vc.pkiPath, serialNumber)
+	path := fmt.Sprintf("%s/cert/%s", vc.mount.Path, serialNumber)
 
+	start := time.Now()
 	secret, err := vc.client.Logical().Read(path)
+	observeCall(vc.logger, SystemVault, "read_cert", start, err)
 	if err != nil {
-		return nil, fmt.Errorf("nie udało się pobrać certyfikatu serwera: %w", err)
+		return nil, vaultError(err, "nie udało się pobrać certyfikatu serwera")
 	}
 
 	if secret == nil || secret.Data == nil {
-		return nil, fmt.Errorf("certyfikat serwera o numerze seryjnym %s nie został znaleziony", serialNumber)
+		return nil, newError(ErrNotFound, nil, "certyfikat serwera o numerze seryjnym %s nie został znaleziony", serialNumber)
 	}
 
 	certificate, ok := secret.Data["certificate"].(string)
 	if !ok {
-		return nil, fmt.Errorf("nieprawidłowy format certyfikatu serwera w odpowiedzi")
+		return nil, newError(ErrVault, nil, "nieprawidłowy format certyfikatu serwera w odpowiedzi")
 	}
 
 	// Parsowanie certyfikatu, aby uzyskać datę wygaśnięcia
 	block, _ := pem.Decode([]byte(certificate))
 	if block == nil {
-		return nil, fmt.Errorf("nie udało się zdekodować certyfikatu serwera PEM")
+		return nil, newError(ErrVault, nil, "nie udało się zdekodować certyfikatu serwera PEM")
 	}
 
 	cert, err := x509.ParseCertificate(block.Bytes)
 	if err != nil {
-		return nil, fmt.Errorf("nie udało się sparsować certyfikatu serwera: %w", err)
+		return nil, newError(ErrVault, err, "nie udało się sparsować certyfikatu serwera")
 	}
 
 	var issuingCA string
@@ -344,11 +808,11 @@ func (vc *VaultClient) GetServerCertificate(serialNumber string) (*ServerCertifi
 
 	return &ServerCertificate{
 		Certificate:  certificate,
-		SerialNumber:  serialNumber,
+		SerialNumber: serialNumber,
 		CommonName:   cert.Subject.CommonName,
 		ExpiresAt:    cert.NotAfter,
 		CreatedAt:    time.Now(), // Ta informacja nie jest dostępna w Vault
 		LastRenewed:  time.Now(), // Ta informacja nie jest dostępna w Vault
-		IssuingCA:   issuingCA,
+		IssuingCA:    issuingCA,
 	}, nil
 }
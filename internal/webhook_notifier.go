@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// webhookTimeout ogranicza czas oczekiwania na odpowiedź kanałów HTTP
+const webhookTimeout = 10 * time.Second
+
+// postJSON wysyła dokument JSON metodą POST i sprawdza kod odpowiedzi
+func postJSON(client *http.Client, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("nie udało się zserializować powiadomienia: %w", err)
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("nie udało się wysłać powiadomienia: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		reply, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("odbiorca powiadomienia zwrócił %s: %s", resp.Status, strings.TrimSpace(string(reply)))
+	}
+
+	return nil
+}
+
+// WebhookNotifier wysyła zdarzenie jako surowy JSON pod wskazany adres
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookNotifier tworzy ogólny kanał webhook
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// Notify wysyła zdarzenie do webhooka
+func (wn *WebhookNotifier) Notify(event Event) error {
+	if err := postJSON(wn.client, wn.url, event); err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	return nil
+}
+
+// SlackNotifier wysyła zdarzenie przez Slack incoming webhook
+type SlackNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewSlackNotifier tworzy kanał Slack
+func NewSlackNotifier(url string) *SlackNotifier {
+	return &SlackNotifier{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// Notify wysyła zdarzenie na kanał Slack
+func (sn *SlackNotifier) Notify(event Event) error {
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s *%s*\n%s", slackSeverityIcon(event.Severity), event.Title, event.Message)
+	if event.CommonName != "" {
+		fmt.Fprintf(&text, "\n• CN: `%s`", event.CommonName)
+	}
+	for _, name := range event.sortedFieldNames() {
+		fmt.Fprintf(&text, "\n• %s: %s", name, event.Fields[name])
+	}
+
+	if err := postJSON(sn.client, sn.url, map[string]string{"text": text.String()}); err != nil {
+		return fmt.Errorf("slack: %w", err)
+	}
+	return nil
+}
+
+func slackSeverityIcon(severity Severity) string {
+	switch severity {
+	case SeverityCritical:
+		return ":rotating_light:"
+	case SeverityWarning:
+		return ":warning:"
+	default:
+		return ":information_source:"
+	}
+}
+
+// TeamsNotifier wysyła zdarzenie przez webhook Microsoft Teams (format MessageCard)
+type TeamsNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewTeamsNotifier tworzy kanał Microsoft Teams
+func NewTeamsNotifier(url string) *TeamsNotifier {
+	return &TeamsNotifier{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+type teamsFact struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Notify wysyła zdarzenie na kanał Teams
+func (tn *TeamsNotifier) Notify(event Event) error {
+	facts := []teamsFact{{Name: "Typ", Value: string(event.Type)}}
+	if event.CommonName != "" {
+		facts = append(facts, teamsFact{Name: "CN", Value: event.CommonName})
+	}
+	for _, name := range event.sortedFieldNames() {
+		facts = append(facts, teamsFact{Name: name, Value: event.Fields[name]})
+	}
+
+	card := map[string]interface{}{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    event.Title,
+		"themeColor": teamsSeverityColor(event.Severity),
+		"title":      event.Title,
+		"text":       event.Message,
+		"sections":   []map[string]interface{}{{"facts": facts}},
+	}
+
+	if err := postJSON(tn.client, tn.url, card); err != nil {
+		return fmt.Errorf("teams: %w", err)
+	}
+	return nil
+}
+
+func teamsSeverityColor(severity Severity) string {
+	switch severity {
+	case SeverityCritical:
+		return "D70000"
+	case SeverityWarning:
+		return "FFA500"
+	default:
+		return "1D72B8"
+	}
+}
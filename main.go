@@ -2,38 +2,21 @@ package main
 
 import (
 	"embed"
+	"errors"
 	"fmt"
-	"log"
+	"io/fs"
 	"os"
-	"time"
 
-	"github.com/pbabilas/pinpoint/internal"
 	"github.com/akamensky/argparse"
 	"github.com/joho/godotenv"
+	"github.com/pbabilas/pinpoint/internal"
 	"github.com/sirupsen/logrus"
 )
 
-//go:embed user.ovpn.template mail.template.html
+//go:embed user.ovpn.template templates web
 var config embed.FS
 
 func main() {
-
-	err := godotenv.Load(".env")
-	if err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
-	}
-
-	parser := argparse.NewParser("vault-ovpn-renew", "Renew OpenVPN certificates from HashiCorp Vault")
-	commonName := parser.String("n", "name", &argparse.Options{Required: false, Help: "Certificate common name", Default: "ovpn-pbabilas"})
-	email := parser.String("e", "email", &argparse.Options{Required: false, Help: "Recipient address", Default: nil})
-	ttl := parser.String("t", "ttl", &argparse.Options{Required: false, Help: "Certificate TTL", Default: "8760h"}) // 1 year
-	outputDir := parser.String("o", "output-dir", &argparse.Options{Required: false, Help: "Relative config output directory", Default: "conf"})
-	certDBPath := parser.String("d", "cert-db", &argparse.Options{Required: false, Help: "Certificate database file path", Default: "certificates.json"})
-	forceRenew := parser.Flag("f", "force-renew", &argparse.Options{Required: false, Help: "Force certificate renewal even if not expired"})
-	resendEmail := parser.Flag("r", "resend", &argparse.Options{Required: false, Help: "Resend email even if certificate was not renewed"})
-	mode := parser.String("m", "mode", &argparse.Options{Required: false, Help: "Operation mode: client or server", Default: "client"})
-	mikrotikIP := parser.String("i", "mikrotik-ip", &argparse.Options{Required: false, Help: "Mikrotik router IP address (server mode only)"})
-
 	logger := &logrus.Logger{
 		Out:          os.Stderr,
 		Formatter:    new(logrus.JSONFormatter),
@@ -43,362 +26,321 @@ func main() {
 		ReportCaller: false,
 	}
 
-	if err = parser.Parse(os.Args); err != nil {
-		logger.Fatalf("Parse error: %s", parser.Usage(err))
+	if err := run(logger); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintln(os.Stderr, err)
+		} else {
+			logger.Error(err)
+		}
+		os.Exit(exitCode(err))
 	}
+}
 
-	info, err := os.Stat(*outputDir)
-	if err != nil {
-		log.Fatalf("Error: %v", err)
-	}
-	if !info.IsDir() {
-		log.Fatalf("Output directory '%s' should be a directory", *outputDir)
+// run wykonuje program i zwraca błąd, którego rodzaj decyduje o kodzie wyjścia
+func run(logger *logrus.Logger) error {
+	parser := argparse.NewParser("pinpoint", "Manage OpenVPN certificates issued by HashiCorp Vault")
+	configPath := parser.String("", "config", &argparse.Options{Required: false, Help: "Configuration file (YAML); defaults to PINPOINT_CONFIG or pinpoint.yaml if present"})
+	envName := parser.String("", "env", &argparse.Options{Required: false, Help: "Named environment from the configuration file (overrides PINPOINT_ENV)"})
+	certDBPath := parser.String("d", "cert-db", &argparse.Options{Required: false, Help: "Certificate database file path (defaults to CERT_DB or certificates.json)"})
+	dryRun := parser.Flag("", "dry-run", &argparse.Options{Required: false, Help: "Show planned Vault, router, email and database changes without performing them"})
+	logLevel := parser.Selector("", "log-level", internal.LogLevels, &argparse.Options{Required: false, Help: "Log level (overrides LOG_LEVEL, default info)"})
+	logFormat := parser.Selector("", "log-format", []string{internal.LogFormatJSON, internal.LogFormatText}, &argparse.Options{Required: false, Help: "Log format (overrides LOG_FORMAT, default json)"})
+	logLanguage := parser.Selector("", "log-language", []string{internal.LogLanguagePolish, internal.LogLanguageEnglish}, &argparse.Options{Required: false, Help: "Log message language (overrides LOG_LANGUAGE, default pl)"})
+
+	// client issue / client resend / client connected
+	clientCmd := parser.NewCommand("client", "Manage client certificates")
+	issueCmd := clientCmd.NewCommand("issue", "Issue a client certificate or renew it when it is about to expire")
+	issueName := issueCmd.String("n", "name", &argparse.Options{Required: true, Help: "Certificate common name"})
+	issueEmail := issueCmd.String("e", "email", &argparse.Options{Required: false, Help: "Recipient address"})
+	issueTTL := issueCmd.String("t", "ttl", &argparse.Options{Required: false, Help: "Certificate TTL, remembered for later renewals (defaults to the stored TTL, group policy or CLIENT_TTL)"})
+	issueOutputDir := issueCmd.String("o", "output-dir", &argparse.Options{Required: false, Help: "Relative config output directory (defaults to OUTPUT_DIR or conf)"})
+	issueRenewBefore := issueCmd.String("", "renew-before", &argparse.Options{Required: false, Help: "Renewal threshold for the user: days before expiry (30d) or fraction of lifetime (2/3, 66%)"})
+	issueForce := issueCmd.Flag("f", "force-renew", &argparse.Options{Required: false, Help: "Force certificate renewal even if not expired"})
+	issueLocale := issueCmd.String("l", "locale", &argparse.Options{Required: false, Help: "Email language for the user, e.g. pl or en"})
+	issueGroup := issueCmd.String("g", "group", &argparse.Options{Required: false, Help: "User group or profile"})
+	issueAutoRenew := issueCmd.Selector("", "auto-renew", []string{"on", "off"}, &argparse.Options{Required: false, Help: "Enable or disable automatic renewal for the user"})
+	issueMount := issueCmd.String("", "mount", &argparse.Options{Required: false, Help: "PKI mount from pki.mounts for a new certificate (defaults to the group policy); with --force-renew moves the user"})
+	issueRevokeImmediately := issueCmd.Flag("", "revoke-immediately", &argparse.Options{Required: false, Help: "Revoke the replaced certificate right away, without RENEWAL_GRACE_PERIOD (e.g. lost device)"})
+
+	resendCmd := clientCmd.NewCommand("resend", "Resend the stored OpenVPN profile without issuing a new certificate")
+	resendName := resendCmd.String("n", "name", &argparse.Options{Required: true, Help: "Certificate common name"})
+	resendEmail := resendCmd.String("e", "email", &argparse.Options{Required: false, Help: "Recipient address (defaults to the stored one)"})
+	resendOutputDir := resendCmd.String("o", "output-dir", &argparse.Options{Required: false, Help: "Relative config output directory (defaults to OUTPUT_DIR or conf)"})
+	resendLocale := resendCmd.String("l", "locale", &argparse.Options{Required: false, Help: "Email language, e.g. pl or en"})
+
+	connectedCmd := clientCmd.NewCommand("connected", "Record a client connection (OpenVPN client-connect hook); ends the grace period of replaced certificates")
+	connectedName := connectedCmd.String("n", "name", &argparse.Options{Required: true, Help: "Certificate common name (common_name in the hook)"})
+	connectedSerial := connectedCmd.String("s", "serial", &argparse.Options{Required: true, Help: "Serial number of the connecting certificate (tls_serial_hex_0 in the hook)"})
+
+	// device add / device list / device renew / device revoke
+	deviceCmd := parser.NewCommand("device", "Manage additional device certificates of a user (laptop, phone)")
+	deviceAddCmd := deviceCmd.NewCommand("add", "Issue a certificate for a new device and email its profile to the user")
+	deviceAddName := deviceAddCmd.String("n", "name", &argparse.Options{Required: true, Help: "User common name"})
+	deviceAddDevice := deviceAddCmd.String("", "device", &argparse.Options{Required: true, Help: "Device name (lowercase letters, digits and dashes), e.g. phone"})
+	deviceAddTTL := deviceAddCmd.String("t", "ttl", &argparse.Options{Required: false, Help: "Certificate TTL, remembered for later renewals (defaults to the user policy)"})
+	deviceAddOutputDir := deviceAddCmd.String("o", "output-dir", &argparse.Options{Required: false, Help: "Relative config output directory (defaults to OUTPUT_DIR or conf)"})
+	deviceAddMount := deviceAddCmd.String("", "mount", &argparse.Options{Required: false, Help: "PKI mount from pki.mounts (defaults to the mount of the user certificate)"})
+	deviceListCmd := deviceCmd.NewCommand("list", "List device certificates of a user")
+	deviceListName := deviceListCmd.String("n", "name", &argparse.Options{Required: true, Help: "User common name"})
+	deviceListFormat := deviceListCmd.Selector("", "format", internal.ReportFormats, &argparse.Options{Required: false, Help: "Output format", Default: internal.FormatTable})
+	deviceRenewCmd := deviceCmd.NewCommand("renew", "Issue a new certificate for a device and email its profile to the user")
+	deviceRenewName := deviceRenewCmd.String("n", "name", &argparse.Options{Required: true, Help: "User common name"})
+	deviceRenewDevice := deviceRenewCmd.String("", "device", &argparse.Options{Required: true, Help: "Device name"})
+	deviceRenewTTL := deviceRenewCmd.String("t", "ttl", &argparse.Options{Required: false, Help: "Certificate TTL, remembered for later renewals (defaults to the stored TTL)"})
+	deviceRenewOutputDir := deviceRenewCmd.String("o", "output-dir", &argparse.Options{Required: false, Help: "Relative config output directory (defaults to OUTPUT_DIR or conf)"})
+	deviceRenewMount := deviceRenewCmd.String("", "mount", &argparse.Options{Required: false, Help: "Move the device to another PKI mount from pki.mounts (defaults to the stored mount)"})
+	deviceRenewRevokeImmediately := deviceRenewCmd.Flag("", "revoke-immediately", &argparse.Options{Required: false, Help: "Revoke the replaced certificate right away, without RENEWAL_GRACE_PERIOD (e.g. lost device)"})
+	deviceRevokeCmd := deviceCmd.NewCommand("revoke", "Revoke the certificate of a single device")
+	deviceRevokeName := deviceRevokeCmd.String("n", "name", &argparse.Options{Required: true, Help: "User common name"})
+	deviceRevokeDevice := deviceRevokeCmd.String("", "device", &argparse.Options{Required: true, Help: "Device name"})
+
+	// server deploy
+	serverCmd := parser.NewCommand("server", "Manage server certificates")
+	deployCmd := serverCmd.NewCommand("deploy", "Issue or renew a server certificate and deploy it to a Mikrotik router")
+	deployName := deployCmd.String("n", "name", &argparse.Options{Required: true, Help: "Server certificate common name"})
+	deployIP := deployCmd.String("i", "mikrotik-ip", &argparse.Options{Required: true, Help: "Mikrotik router IP address"})
+	deployEmail := deployCmd.String("e", "email", &argparse.Options{Required: false, Help: "Additional notification address (besides NOTIFY_EMAIL)"})
+	deployTTL := deployCmd.String("t", "ttl", &argparse.Options{Required: false, Help: "Certificate TTL (defaults to the stored TTL, server policy or SERVER_TTL)"})
+	deployForce := deployCmd.Flag("f", "force-renew", &argparse.Options{Required: false, Help: "Force certificate renewal even if not expired"})
+	deployResend := deployCmd.Flag("r", "resend", &argparse.Options{Required: false, Help: "Send the notification even if the certificate was not renewed"})
+	deployMount := deployCmd.String("", "mount", &argparse.Options{Required: false, Help: "PKI mount from pki.mounts for a new certificate (defaults to the server policy); with --force-renew moves the server"})
+
+	// list / show
+	listCmd := parser.NewCommand("list", "List certificates stored in the database")
+	listExpiringWithin := listCmd.String("", "expiring-within", &argparse.Options{Required: false, Help: "Only certificates expiring within the given period, e.g. 30d, 2w or 720h"})
+	listExpired := listCmd.Flag("", "expired", &argparse.Options{Required: false, Help: "Only expired certificates (combined with --expiring-within as OR)"})
+	listNoEmail := listCmd.Flag("", "no-email", &argparse.Options{Required: false, Help: "Only users without an email address"})
+	listServer := listCmd.Flag("s", "server", &argparse.Options{Required: false, Help: "Only server certificates"})
+	listFormat := listCmd.Selector("", "format", internal.ReportFormats, &argparse.Options{Required: false, Help: "Output format", Default: internal.FormatTable})
+
+	showCmd := parser.NewCommand("show", "Show a single user, device or server certificate")
+	showName := showCmd.String("n", "name", &argparse.Options{Required: true, Help: "Certificate common name"})
+	showFormat := showCmd.Selector("", "format", internal.ReportFormats, &argparse.Options{Required: false, Help: "Output format", Default: internal.FormatTable})
+
+	// reconcile
+	reconcileCmd := parser.NewCommand("reconcile", "Compare the database with certificates issued by Vault")
+	reconcileFix := reconcileCmd.Flag("", "fix", &argparse.Options{Required: false, Help: "Import orphaned certificates and update revoked or mismatched records"})
+	reconcileFormat := reconcileCmd.Selector("", "format", internal.ReportFormats, &argparse.Options{Required: false, Help: "Output format", Default: internal.FormatTable})
+
+	// users import / users sync
+	usersCmd := parser.NewCommand("users", "Manage users in bulk")
+	usersImportCmd := usersCmd.NewCommand("import", "Issue, update and optionally revoke users from a CSV file or LDIF export")
+	usersImportFile := usersImportCmd.StringPositional(&argparse.Options{Help: "CSV (cn,email,ttl,group,locale) or .ldif file"})
+	usersImportSync := usersImportCmd.Flag("", "sync", &argparse.Options{Required: false, Help: "Revoke users missing from the file"})
+	usersImportForce := usersImportCmd.Flag("", "force", &argparse.Options{Required: false, Help: "Allow --sync to revoke more users than SYNC_MAX_REVOCATIONS"})
+	usersImportOutputDir := usersImportCmd.String("o", "output-dir", &argparse.Options{Required: false, Help: "Relative config output directory (defaults to OUTPUT_DIR or conf)"})
+	usersImportFormat := usersImportCmd.Selector("", "format", internal.ReportFormats, &argparse.Options{Required: false, Help: "Output format", Default: internal.FormatTable})
+
+	usersSyncCmd := usersCmd.NewCommand("sync", "Synchronize users with the LDAP directory: issue new members, revoke removed or disabled ones")
+	usersSyncForce := usersSyncCmd.Flag("", "force", &argparse.Options{Required: false, Help: "Allow revoking more users than SYNC_MAX_REVOCATIONS"})
+	usersSyncOutputDir := usersSyncCmd.String("o", "output-dir", &argparse.Options{Required: false, Help: "Relative config output directory (defaults to OUTPUT_DIR or conf)"})
+	usersSyncFormat := usersSyncCmd.Selector("", "format", internal.ReportFormats, &argparse.Options{Required: false, Help: "Output format", Default: internal.FormatTable})
+
+	// import
+	importCmd := parser.NewCommand("import", "Import existing .ovpn or PEM client certificates into the database")
+	importPaths := importCmd.StringList("p", "path", &argparse.Options{Required: true, Help: "File or directory with .ovpn, .pem or .crt files (repeatable)"})
+	importEmails := importCmd.String("e", "emails", &argparse.Options{Required: false, Help: "CSV file mapping common_name to email"})
+	importFormat := importCmd.Selector("", "format", internal.ReportFormats, &argparse.Options{Required: false, Help: "Output format", Default: internal.FormatTable})
+
+	// revoke
+	revokeCmd := parser.NewCommand("revoke", "Revoke a certificate in Vault and mark it in the database")
+	revokeName := revokeCmd.String("n", "name", &argparse.Options{Required: true, Help: "Certificate common name"})
+	revokeServer := revokeCmd.Flag("s", "server", &argparse.Options{Required: false, Help: "Revoke a server certificate instead of a client one"})
+
+	// renew-all
+	renewAllCmd := parser.NewCommand("renew-all", "Renew every client certificate that is about to expire")
+	renewAllOutputDir := renewAllCmd.String("o", "output-dir", &argparse.Options{Required: false, Help: "Relative config output directory (defaults to OUTPUT_DIR or conf)"})
+
+	// revoke-pending
+	revokePendingCmd := parser.NewCommand("revoke-pending", "Revoke replaced client certificates whose grace period ended or whose successor has connected")
+
+	// reminders
+	remindersCmd := parser.NewCommand("reminders", "Send reminders for certificates that will not be renewed automatically")
+
+	// db info / db remove
+	dbCmd := parser.NewCommand("db", "Inspect and maintain the certificate database")
+	dbInfoCmd := dbCmd.NewCommand("info", "Show database statistics")
+	dbRemoveCmd := dbCmd.NewCommand("remove", "Remove a record from the database without touching Vault")
+	dbRemoveName := dbRemoveCmd.String("n", "name", &argparse.Options{Required: true, Help: "Certificate common name"})
+	dbRemoveServer := dbRemoveCmd.Flag("s", "server", &argparse.Options{Required: false, Help: "Remove a server record instead of a user"})
+
+	// router list / router status / router audit
+	routerCmd := parser.NewCommand("router", "Inspect certificates on a Mikrotik router")
+	routerListCmd := routerCmd.NewCommand("list", "List certificates installed on the router")
+	routerListIP := routerListCmd.String("i", "mikrotik-ip", &argparse.Options{Required: true, Help: "Mikrotik router IP address"})
+	routerStatusCmd := routerCmd.NewCommand("status", "Show the status of a single certificate on the router")
+	routerStatusIP := routerStatusCmd.String("i", "mikrotik-ip", &argparse.Options{Required: true, Help: "Mikrotik router IP address"})
+	routerStatusName := routerStatusCmd.String("n", "name", &argparse.Options{Required: true, Help: "Certificate name on the router"})
+	routerAuditCmd := routerCmd.NewCommand("audit", "Compare certificates on routers with the database and find leftovers")
+	routerAuditIP := routerAuditCmd.String("i", "mikrotik-ip", &argparse.Options{Required: false, Help: "Audit only this router (defaults to every router in the database)"})
+	routerAuditClean := routerAuditCmd.Flag("", "clean", &argparse.Options{Required: false, Help: "Remove stale -revoked- certificates and temporary .pem files"})
+	routerAuditFormat := routerAuditCmd.Selector("", "format", internal.ReportFormats, &argparse.Options{Required: false, Help: "Output format", Default: internal.FormatTable})
+
+	// ca rotate / ca status
+	caCmd := parser.NewCommand("ca", "Rotate the issuing CA of a PKI mount")
+	caRotateCmd := caCmd.NewCommand("rotate", "Start or resume the CA rotation: push the new default issuer to routers, reissue client profiles and server certificates, then remove the old CA")
+	caRotateMount := caRotateCmd.String("", "mount", &argparse.Options{Required: false, Help: "PKI mount from pki.mounts (defaults to the default mount)"})
+	caRotateOldIssuer := caRotateCmd.String("", "old-issuer", &argparse.Options{Required: false, Help: "Issuer ID or name being replaced (defaults to the issuer of the stored server certificates)"})
+	caRotateForce := caRotateCmd.Flag("", "force", &argparse.Options{Required: false, Help: "Continue to the next phase even if some certificates or routers failed"})
+	caRotateOutputDir := caRotateCmd.String("o", "output-dir", &argparse.Options{Required: false, Help: "Relative config output directory (defaults to OUTPUT_DIR or conf)"})
+	caRotateFormat := caRotateCmd.Selector("", "format", internal.ReportFormats, &argparse.Options{Required: false, Help: "Output format", Default: internal.FormatTable})
+	caStatusCmd := caCmd.NewCommand("status", "Show the CA rotation progress stored in the database")
+	caStatusMount := caStatusCmd.String("", "mount", &argparse.Options{Required: false, Help: "Only this PKI mount (default for the default mount)"})
+	caStatusFormat := caStatusCmd.Selector("", "format", internal.ReportFormats, &argparse.Options{Required: false, Help: "Output format", Default: internal.FormatTable})
+
+	// check
+	checkCmd := parser.NewCommand("check", "Check expiry of the PKI mount CAs and of the CA chains of stored certificates")
+	checkFormat := checkCmd.Selector("", "format", internal.ReportFormats, &argparse.Options{Required: false, Help: "Output format", Default: internal.FormatTable})
+
+	// serve
+	serveCmd := parser.NewCommand("serve", "Run the REST API and web dashboard")
+	serveListen := serveCmd.String("", "listen", &argparse.Options{Required: false, Help: "Listen address", Default: ":8080"})
+	serveOutputDir := serveCmd.String("o", "output-dir", &argparse.Options{Required: false, Help: "Relative config output directory (defaults to OUTPUT_DIR or conf)"})
+	serveTLSCert := serveCmd.String("", "tls-cert", &argparse.Options{Required: false, Help: "TLS certificate file (PEM)"})
+	serveTLSKey := serveCmd.String("", "tls-key", &argparse.Options{Required: false, Help: "TLS private key file (PEM)"})
+
+	// config validate
+	configCmd := parser.NewCommand("config", "Inspect the configuration")
+	configValidateCmd := configCmd.NewCommand("validate", "Check the configuration file, environment variables and templates without contacting any service")
+	configValidateFormat := configValidateCmd.Selector("", "format", internal.ReportFormats, &argparse.Options{Required: false, Help: "Output format", Default: internal.FormatTable})
+
+	if err := parser.Parse(os.Args); err != nil {
+		return fmt.Errorf("%w: %s", errUsage, parser.Usage(err))
 	}
 
-	// Pobierz konfigurację z ENV
-	vaultAddr := os.Getenv("VAULT_ADDR")
-	vaultRoleID := os.Getenv("VAULT_ROLE_ID")
-	vaultSecretID := os.Getenv("VAULT_SECRET_ID")
-	vaultPKIPath := os.Getenv("VAULT_PKI_PATH")
-	vaultRole := os.Getenv("VAULT_ROLE")
-	vaultServerRole := os.Getenv("VAULT_SERVER_ROLE")
-	if vaultServerRole == "" {
-		vaultServerRole = "ovpn-server" // Domyślna rola serwera
+	// Plik .env jest opcjonalny - konfigurację można podać w pliku YAML lub w zmiennych środowiskowych
+	if err := godotenv.Load(".env"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return configError("błąd podczas wczytywania pliku .env", err)
 	}
 
-	if vaultAddr == "" || vaultRoleID == "" || vaultSecretID == "" || vaultPKIPath == "" || vaultRole == "" {
-		log.Fatalf("Brak wymaganej konfiguracji Vault. Sprawdź zmienne: VAULT_ADDR, VAULT_ROLE_ID, VAULT_SECRET_ID, VAULT_PKI_PATH, VAULT_ROLE")
+	// Wartości z pliku konfiguracji uzupełniają tylko zmienne nieustawione w środowisku ani w .env
+	environment := *envName
+	if environment == "" {
+		environment = os.Getenv("PINPOINT_ENV")
 	}
-
-	// Utwórz klienta Vault
-	vaultClient, err := internal.NewVaultClient(vaultAddr, vaultRoleID, vaultSecretID, vaultPKIPath, vaultRole, vaultServerRole, logger)
+	fileConfig, err := internal.LoadConfigFile(*configPath, environment)
 	if err != nil {
-		log.Fatalf("Błąd podczas tworzenia klienta Vault: %v", err)
+		return err
 	}
-
-	logger.Infof("Połączono z Vault: %s", vaultAddr)
-
-	// Wczytaj bazę danych certyfikatów
-	certDB, err := internal.LoadCertificateDB(*certDBPath, logger)
-	if err != nil {
-		log.Fatalf("Błąd podczas wczytywania bazy danych certyfikatów: %v", err)
+	if _, err := fileConfig.Apply(); err != nil {
+		return err
 	}
 
-	var certInfo *internal.CertificateInfo
-	var needsRenewal bool
-	var daysUntilExpiry float64
-	var userCert *internal.UserCertificate
-	var userExists bool
-	var certificateRenewed bool
-
-	// Sprawdź tryb pracy
-	if *mode == "server" {
-		logger.Infof("Uruchomiono w trybie serwera")
-		handleServerMode(certDB, vaultClient, logger, *commonName, *email, *ttl, *outputDir, *mikrotikIP, *forceRenew, *resendEmail)
-		return
+	logConfig := internal.LoadLogConfigFromEnv()
+	if *logLevel != "" {
+		logConfig.Level = *logLevel
 	}
-
-	// Tryb klienta (domyślny)
-	logger.Infof("Uruchomiono w trybie klienta")
-
-	// Sprawdź czy użytkownik istnieje w bazie danych
-	userCert, userExists = certDB.GetUser(*commonName)
-
-	if userExists {
-		logger.Infof("Znaleziono użytkownika w bazie: %s, serial: %s", *commonName, userCert.SerialNumber)
-
-		// Aktualizuj email w bazie danych, jeśli podano nowy
-		if *email != "" && *email != userCert.Email {
-			userCert.Email = *email
-			err = certDB.AddOrUpdateUser(*userCert)
-			if err != nil {
-				logger.Warnf("Błąd podczas aktualizacji emaila w bazie danych: %v", err)
-			} else {
-				logger.Infof("Zaktualizowano email dla użytkownika %s: %s", *commonName, *email)
-			}
-		}
-
-		// Sprawdź ważność istniejącego certyfikatu
-		var err error
-		needsRenewal, daysUntilExpiry, err = certDB.CheckCertificateExpiry(*commonName, 30)
-		if err != nil {
-			log.Fatalf("Błąd podczas sprawdzania ważności certyfikatu: %v", err)
-		}
-		logger.Infof("Certyfikat wygasa za %.1f dni", daysUntilExpiry)
-
-		if needsRenewal || *forceRenew {
-			certificateRenewed = true
-			if *forceRenew && !needsRenewal {
-				logger.Warnf("Wymuszono odnowienie certyfikatu (opcja --force-renew)")
-			} else {
-				logger.Warnf("Certyfikat wymaga odnowienia (< 30 dni do wygaśnięcia)")
-			}
-			// Odnów certyfikat
-			certInfo, err = vaultClient.RenewCertificate(userCert.SerialNumber, *commonName, *ttl)
-			if err != nil {
-				log.Fatalf("Błąd podczas odnawiania certyfikatu: %v", err)
-			}
-
-			// Zaktualizuj bazę danych z nowym numerem seryjnym
-			err = certDB.UpdateCertificateInfo(*commonName, certInfo.SerialNumber, certInfo.ExpiresAt)
-			if err != nil {
-				logger.Warnf("Błąd podczas aktualizacji bazy danych: %v", err)
-			}
-		} else {
-			certificateRenewed = false
-			logger.Infof("Certyfikat nie wymaga odnowienia - jest jeszcze ważny przez %.1f dni", daysUntilExpiry)
-			logger.Infof("Nie generuję nowego certyfikatu, ponieważ obecny jest jeszcze ważny")
-
-			// Pobierz informacje o istniejącym certyfikacie z Vault
-			certInfo, err = vaultClient.GetCertificateInfo(userCert.SerialNumber)
-			if err != nil {
-				log.Fatalf("Błąd podczas pobierania informacji o certyfikacie: %v", err)
-			}
-
-			// Ustawiamy datę wygaśnięcia z bazy danych, ponieważ certInfo z Vault nie ma tej informacji
-			certInfo.ExpiresAt = userCert.ExpiresAt
-		}
-	} else {
-		// Użytkownik nie istnieje - wygeneruj nowy certyfikat
-		logger.Infof("Generowanie nowego certyfikatu dla nowego użytkownika %s", *commonName)
-		certInfo, err = vaultClient.IssueCertificate(*commonName, *ttl)
-		if err != nil {
-			log.Fatalf("Błąd podczas generowania certyfikatu: %v", err)
-		}
-
-		// Dodaj użytkownika do bazy danych
-		newUserCert := internal.UserCertificate{
-			CommonName:   *commonName,
-			SerialNumber: certInfo.SerialNumber,
-			Email:        *email,
-			CreatedAt:    time.Now(),
-			LastRenewed:  time.Now(),
-			ExpiresAt:    certInfo.ExpiresAt,
-			TTL:          *ttl,
-		}
-
-		err = certDB.AddOrUpdateUser(newUserCert)
-		if err != nil {
-			logger.Warnf("Błąd podczas dodawania użytkownika do bazy danych: %v", err)
-		}
-
-		daysUntilExpiry = time.Until(certInfo.ExpiresAt).Hours() / 24
-
-		// Sprawdź czy istnieje certyfikat serwera dla tego użytkownika
-		if _, exists := certDB.GetServerCertificate(*commonName); exists {
-			logger.Infof("Znaleziono certyfikat serwera dla %s, aktualizuję konfigurację Mikrotika", *commonName)
-
-			// Tutaj byłaby logika aktualizacji certyfikatu serwera na Mikrotiku
-			// W przyszłości można zaimplementować API do Mikrotika
-			logger.Infof("Certyfikat serwera dla %s wymaga aktualizacji na routerze Mikrotik", *commonName)
-		}
+	if *logFormat != "" {
+		logConfig.Format = *logFormat
 	}
-
-	// Wczytaj szablon konfiguracji OpenVPN
-	var ovpnTemplate []byte
-	if ovpnTemplate, err = config.ReadFile("user.ovpn.template"); err != nil {
-		log.Fatalf("Błąd podczas odczytu pliku szablonu: %v", err)
+	if *logLanguage != "" {
+		logConfig.Language = *logLanguage
 	}
-
-	// Sprawdź czy mamy klucz prywatny (tylko dla nowo wygenerowanych certyfikatów)
-	var ovpnConfig string
-	if certInfo.PrivateKey != "" {
-		// Mamy klucz prywatny - generujemy nową konfigurację
-		ovpnConfig = fmt.Sprintf(string(ovpnTemplate), certInfo.CAChain, certInfo.Certificate, certInfo.PrivateKey)
-		err = os.WriteFile(fmt.Sprintf("%s/%s.ovpn", *outputDir, *commonName), []byte(ovpnConfig), 0644)
-		if err != nil {
-			log.Fatalf("Error writing OVPN config: %v", err)
-		}
-		logger.Infof("Wygenerowano nową konfigurację OpenVPN")
-	} else {
-		// Nie mamy klucza prywatnego - sprawdzamy czy istnieje plik konfiguracyjny
-		configPath := fmt.Sprintf("%s/%s.ovpn", *outputDir, *commonName)
-		if configData, err := os.ReadFile(configPath); err == nil {
-			ovpnConfig = string(configData)
-			logger.Infof("Użyto istniejącej konfiguracji OpenVPN z pliku: %s", configPath)
-		} else {
-			// Nie mamy konfiguracji i nie możemy jej wygenerować bez klucza prywatnego
-			logger.Warnf("Nie można wygenerować konfiguracji OpenVPN - brak klucza prywatnego dla istniejącego certyfikatu")
-			logger.Warnf("Aby wygenerować nową konfigurację, użyj opcji --force-renew")
-			logger.Infof("Certyfikat jest jeszcze ważny przez %.1f dni", daysUntilExpiry)
-		}
+	if err := internal.ConfigureLogger(logger, logConfig); err != nil {
+		return err
 	}
 
-	// Pobierz email z bazy danych, jeśli nie podano w parametrze
-	userEmail := *email
-	if userEmail == "" && userExists {
-		userEmail = userCert.Email
+	storage := internal.LoadStorageConfigFromEnv()
+	if *certDBPath != "" {
+		storage.CertDB = *certDBPath
 	}
-
-	// Wysyłaj email tylko jeśli certyfikat został odnowiony lub użyto flagi --resend
-	if (certificateRenewed || *resendEmail) && userEmail != "" {
-		var emailTemplate []byte
-		if emailTemplate, err = config.ReadFile("mail.template.html"); err != nil {
-			log.Fatalf("Błąd podczas odczytu szablonu email: %v", err)
-		}
-
-		mailer := internal.NewMailer(logger)
-		// Zaokrąglaj dni do pełnych liczb całkowitych dla czytelności
-		daysUntilExpiryInt := int(daysUntilExpiry)
-		if err = mailer.SendEmail(ovpnConfig, daysUntilExpiryInt, string(emailTemplate), *commonName, userEmail); err != nil {
-			log.Fatalf("Błąd podczas wysyłania e-maila: %v", err)
-		}
-
-		if certificateRenewed {
-			logger.Infof("Konfiguracja OpenVPN została wysłana na e-mail: %s", userEmail)
-		} else {
-			logger.Infof("Konfiguracja OpenVPN została ponownie wysłana na e-mail: %s", userEmail)
+	outputDir := func(flag string) string {
+		if flag != "" {
+			return flag
 		}
-	} else if !certificateRenewed && !*resendEmail {
-		logger.Infof("Nie wysyłano emaila - certyfikat nie został odnowiony (użyj --resend aby wymusić wysłanie)")
-	} else {
-		logger.Warnf("Nie podano adresu e-mail, konfiguracja nie została wysłana")
-	}
-
-	// Zapisz bazę danych
-	if err := certDB.Save(); err != nil {
-		logger.Warnf("Błąd podczas zapisywania bazy danych: %v", err)
-	}
-
-	logger.Infof("Serial number nowego certyfikatu: %s", certInfo.SerialNumber)
-	logger.Infof("Informacje o certyfikacie zostały zapisane w bazie danych")
-}
-
-// handleServerMode obsługuje tryb serwera
-func handleServerMode(certDB *internal.CertificateDB, vaultClient *internal.VaultClient, logger *logrus.Logger, commonName, email, ttl, outputDir, mikrotikIP string, forceRenew, resendEmail bool) {
-	// Walidacja parametrów dla trymu serwera
-	if mikrotikIP == "" {
-		log.Fatalf("W trybie serwera wymagany jest adres IP Mikrotika (parametr -i)")
+		return storage.OutputDir
 	}
 
-	// Utwórz menedżer serwera
-	serverManager := internal.NewServerManager(certDB, vaultClient, logger)
-
-	var serverCert *internal.ServerCertificate
-	var err error
-	var daysUntilExpiry float64
-	var serverCertificateRenewed bool
-
-	// Sprawdź czy certyfikat serwera istnieje
-	serverCert, exists := certDB.GetServerCertificate(commonName)
-
-	if exists {
-		logger.Infof("Znaleziono certyfikat serwera dla %s", commonName)
-
-		// Sprawdź ważność certyfikatu serwera
-		needsRenewal, daysUntil, err := serverManager.CheckServerCertificateExpiry(commonName, 30)
-		if err != nil {
-			log.Fatalf("Błąd podczas sprawdzania ważności certyfikatu serwera: %v", err)
-		}
-
-		daysUntilExpiry = daysUntil
-		logger.Infof("Certyfikat serwera wygasa za %.1f dni", daysUntilExpiry)
-
-		if needsRenewal || forceRenew {
-			if forceRenew && !needsRenewal {
-				logger.Warnf("Wymuszono odnowienie certyfikatu serwera (opcja --force-renew)")
-			} else {
-				logger.Warnf("Certyfikat serwera wymaga odnowienia (< 30 dni do wygaśnięcia)")
-			}
-
-			// Odnów certyfikat serwera
-			serverCert, err = serverManager.RenewServerCertificate(commonName, ttl)
+	app := newApp(logger, storage.CertDB, fileConfig.RenewalPolicies(), fileConfig.PKIMounts(), *dryRun)
+	defer app.writePlan()
+
+	// Polecenia zmieniające stan kończą się przez app.finish, który zapisuje metryki uruchomienia
+
+	switch {
+	case issueCmd.Happened():
+		return app.finish("client issue", app.clientIssue(outputDir(*issueOutputDir), internal.ClientRequest{
+			CommonName:        *issueName,
+			Email:             *issueEmail,
+			TTL:               *issueTTL,
+			RenewBefore:       *issueRenewBefore,
+			Locale:            *issueLocale,
+			Group:             *issueGroup,
+			AutoRenew:         *issueAutoRenew,
+			Force:             *issueForce,
+			Mount:             *issueMount,
+			RevokeImmediately: *issueRevokeImmediately,
+		}))
+	case resendCmd.Happened():
+		return app.finish("client resend", app.clientResend(outputDir(*resendOutputDir), *resendName, *resendEmail, *resendLocale))
+	case connectedCmd.Happened():
+		return app.finish("client connected", app.clientConnected(*connectedName, *connectedSerial))
+	case deviceAddCmd.Happened():
+		return app.finish("device add", app.deviceAdd(outputDir(*deviceAddOutputDir), internal.DeviceRequest{CommonName: *deviceAddName, Device: *deviceAddDevice, TTL: *deviceAddTTL, Mount: *deviceAddMount}))
+	case deviceListCmd.Happened():
+		return app.deviceList(*deviceListName, *deviceListFormat)
+	case deviceRenewCmd.Happened():
+		return app.finish("device renew", app.deviceRenew(outputDir(*deviceRenewOutputDir), internal.DeviceRequest{CommonName: *deviceRenewName, Device: *deviceRenewDevice, TTL: *deviceRenewTTL, Mount: *deviceRenewMount, RevokeImmediately: *deviceRenewRevokeImmediately}))
+	case deviceRevokeCmd.Happened():
+		return app.finish("device revoke", app.deviceRevoke(*deviceRevokeName, *deviceRevokeDevice))
+	case deployCmd.Happened():
+		return app.finish("server deploy", app.serverDeploy(internal.ServerRequest{
+			CommonName: *deployName,
+			Email:      *deployEmail,
+			TTL:        *deployTTL,
+			MikrotikIP: *deployIP,
+			Force:      *deployForce,
+			Resend:     *deployResend,
+			Mount:      *deployMount,
+		}))
+	case listCmd.Happened():
+		filter := internal.InventoryFilter{Expired: *listExpired, NoEmail: *listNoEmail, ServersOnly: *listServer}
+		if *listExpiringWithin != "" {
+			within, err := internal.ParseDays(*listExpiringWithin)
 			if err != nil {
-				log.Fatalf("Błąd podczas odnawiania certyfikatu serwera: %v", err)
-			}
-
-			serverCertificateRenewed = true
-			logger.Infof("Certyfikat serwera odnowiony, serial: %s", serverCert.SerialNumber)
-		} else {
-			logger.Infof("Certyfikat serwera nie wymaga odnowienia")
-		}
-	} else {
-		logger.Infof("Generowanie nowego certyfikatu serwera dla %s", commonName)
-
-		// Generuj nowy certyfikat serwera
-		serverCert, err = serverManager.SetupServerCertificate(commonName, ttl)
-		if err != nil {
-			log.Fatalf("Błąd podczas generowania certyfikatu serwera: %v", err)
-		}
-
-		serverCertificateRenewed = true
-		logger.Infof("Nowy certyfikat serwera wygenerowany, serial: %s", serverCert.SerialNumber)
-
-		// Oblicz dni do wygaśnięcia dla nowo wygenerowanego certyfikatu
-		daysUntilExpiry = time.Until(serverCert.ExpiresAt).Hours() / 24
-	}
-
-	// Aktualizuj adres IP Mikrotika w certyfikacie serwera
-	if mikrotikIP != "" {
-		serverCert.MikrotikIP = mikrotikIP
-		err = certDB.AddOrUpdateServerCertificate(*serverCert)
-		if err != nil {
-			logger.Warnf("Błąd podczas aktualizacji adresu IP Mikrotika: %v", err)
-		}
-
-		// Wysyłaj certyfikat na Mikrotika TYLKO jeśli został wygenerowany/odnowiony
-		if serverCertificateRenewed {
-			// Integracja z API Mikrotika - wysłanie certyfikatu
-			if os.Getenv("MIKROTIK_USERNAME") != "" && os.Getenv("MIKROTIK_PASSWORD") != "" {
-				// Pobierz dane dostępowe Mikrotika ze zmiennych środowiskowych
-				mikrotikUsername := os.Getenv("MIKROTIK_USERNAME")
-				mikrotikPassword := os.Getenv("MIKROTIK_PASSWORD")
-
-				// Utwórz klienta integracji Mikrotik
-				mikrotikClient, err := internal.NewMikrotikIntegration(mikrotikIP, mikrotikUsername, mikrotikPassword, logger)
-				if err != nil {
-					logger.Warnf("Nie udało się połączyć z Mikrotikiem: %v", err)
-					logger.Warnf("Certyfikat zostanie zaktualizowany ręcznie na routerze")
-				} else {
-					defer mikrotikClient.Close()
-
-					// Wysłij certyfikat na Mikrotik
-					if err := mikrotikClient.UploadCertificateToMikrotik(serverCert); err != nil {
-						logger.Warnf("Błąd podczas wysyłania certyfikatu na Mikrotik: %v", err)
-					} else {
-						logger.Infof("Certyfikat serwera został pomyślnie wysłany na routery Mikrotik")
-					}
-				}
-			} else {
-				logger.Warnf("Brak danych dostępowych Mikrotika (MIKROTIK_USERNAME, MIKROTIK_PASSWORD)")
-				logger.Infof("Certyfikat serwera wymaga ręcznej aktualizacji na routerze Mikrotik (%s)", mikrotikIP)
+				return fmt.Errorf("%w: --expiring-within: %v", errUsage, err)
 			}
-		} else {
-			logger.Infof("Certyfikat serwera nie wymaga odnowienia - pomijam wysyłkę na Mikrotika")
+			filter.ExpiringWithin = within
 		}
+		return app.list(filter, *listFormat)
+	case showCmd.Happened():
+		return app.show(*showName, *showFormat)
+	case reconcileCmd.Happened():
+		return app.finish("reconcile", app.reconcile(*reconcileFix, *reconcileFormat))
+	case usersImportCmd.Happened():
+		return app.finish("users import", app.usersImport(*usersImportFile, outputDir(*usersImportOutputDir), internal.SyncOptions{RevokeMissing: *usersImportSync, Force: *usersImportForce}, *usersImportFormat))
+	case usersSyncCmd.Happened():
+		return app.finish("users sync", app.usersSync(outputDir(*usersSyncOutputDir), *usersSyncForce, *usersSyncFormat))
+	case importCmd.Happened():
+		return app.finish("import", app.importCertificates(*importPaths, *importEmails, *importFormat))
+	case revokeCmd.Happened():
+		return app.finish("revoke", app.revoke(*revokeName, *revokeServer))
+	case renewAllCmd.Happened():
+		return app.finish("renew-all", app.renewAll(outputDir(*renewAllOutputDir)))
+	case revokePendingCmd.Happened():
+		return app.finish("revoke-pending", app.revokePending())
+	case remindersCmd.Happened():
+		return app.finish("reminders", app.reminders())
+	case dbInfoCmd.Happened():
+		return app.dbInfo()
+	case dbRemoveCmd.Happened():
+		return app.finish("db remove", app.dbRemove(*dbRemoveName, *dbRemoveServer))
+	case routerListCmd.Happened():
+		return app.routerList(*routerListIP)
+	case routerStatusCmd.Happened():
+		return app.routerStatus(*routerStatusIP, *routerStatusName)
+	case routerAuditCmd.Happened():
+		return app.finish("router audit", app.routerAudit(*routerAuditIP, *routerAuditClean, *routerAuditFormat))
+	case caRotateCmd.Happened():
+		return app.finish("ca rotate", app.caRotate(outputDir(*caRotateOutputDir), internal.RotationRequest{Mount: *caRotateMount, OldIssuer: *caRotateOldIssuer, Force: *caRotateForce}, *caRotateFormat))
+	case caStatusCmd.Happened():
+		return app.caStatus(*caStatusMount, *caStatusFormat)
+	case configValidateCmd.Happened():
+		return app.configValidate(fileConfig, *configValidateFormat)
+	case checkCmd.Happened():
+		return app.finish("check", app.check(*checkFormat))
+	case serveCmd.Happened():
+		return app.serve(*serveListen, outputDir(*serveOutputDir), *serveTLSCert, *serveTLSKey)
 	}
 
-	// Wysyłanie emaila z certyfikatem serwera
-	userEmail := email
-	if userEmail == "" {
-		// Pobierz email z bazy danych użytkownika
-		if userCert, exists := certDB.GetUser(commonName); exists {
-			userEmail = userCert.Email
-		}
-	}
-
-	if userEmail != "" {
-		var emailTemplate []byte
-		if emailTemplate, err = config.ReadFile("mail.template.html"); err != nil {
-			log.Fatalf("Błąd podczas odczytu szablonu email: %v", err)
-		}
-
-		mailer := internal.NewMailer(logger)
-		// Zaokrąglaj dni do pełnych liczb całkowitych dla czytelności
-		daysUntilExpiryInt := int(daysUntilExpiry)
-		if err = mailer.SendEmail(serverCert.Certificate, daysUntilExpiryInt, string(emailTemplate), commonName, userEmail); err != nil {
-			log.Fatalf("Błąd podczas wysyłania e-maila: %v", err)
-		}
-
-		logger.Infof("Certyfikat serwera wysłany na e-mail: %s", userEmail)
-	} else {
-		logger.Warnf("Nie podano adresu e-mail, certyfikat serwera nie został wysłany")
-	}
-
-	// Zapisz bazę danych
-	if err := certDB.Save(); err != nil {
-		logger.Warnf("Błąd podczas zapisywania bazy danych: %v", err)
-	}
-
-	logger.Infof("Konfiguracja serwera zakończona")
-}
\ No newline at end of file
+	return fmt.Errorf("%w: %s", errUsage, parser.Usage(nil))
+}